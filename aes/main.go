@@ -4,16 +4,30 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+
+	"ecdsa/internal/config"
 )
 
 func main() {
+	cfg, profile, err := config.LoadProfile("aes")
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag.String("in", profile.Input, "file to encrypt")
+	flag.String("out", profile.Output, "file to write the ciphertext to")
+	flag.Parse()
+	if profile, err = cfg.ResolveFlags(); err != nil {
+		log.Fatal(err)
+	}
+
 	//crypto.Hash.String()
 	b, _ := aes.NewCipher([]byte("Test1234Test1234"))
-	data, err := ioutil.ReadFile("input.pdf")
+	data, err := ioutil.ReadFile(profile.Input)
 	if err != nil {
 		fmt.Println("Error :", err.Error())
 	}
@@ -32,7 +46,7 @@ func main() {
 
 	ciphertext := gcm.Seal(nonce, nonce, data, nil)
 	// Save back to file
-	err = ioutil.WriteFile("ciphertext.pdf", ciphertext, 0777)
+	err = ioutil.WriteFile(profile.Output, ciphertext, 0777)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -46,7 +60,7 @@ func main() {
 		log.Panic(err)
 	}
 
-	err = ioutil.WriteFile("input.pdf", plaintext, 0777)
+	err = ioutil.WriteFile(profile.Input, plaintext, 0777)
 	if err != nil {
 		log.Panic(err)
 	}
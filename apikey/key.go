@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// An API key is
+//
+//	<prefix>_<id><secret><checksum>
+//
+// where id (8 random bytes) and secret (32 random bytes) are base62
+// encoded to 11 and 43 characters and checksum is the CRC-32 of
+// everything before it, as 6 base62 characters. The prefix names the
+// issuer for secret scanners, and the checksum lets a scanner or a
+// server reject mistyped and made-up keys without a database lookup.
+// The id is not secret; only the secret is hashed for storage.
+
+const (
+	base62   = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	idLen    = 11
+	secLen   = 43
+	crcLen   = 6
+	idBytes  = 8
+	secBytes = 32
+)
+
+var ErrMalformed = errors.New("malformed API key")
+
+var validPrefix = regexp.MustCompile(`^[a-z][a-z0-9]{1,15}$`)
+
+func encode62(b []byte, width int) string {
+	n := new(big.Int).SetBytes(b)
+	out := make([]byte, width)
+	base := big.NewInt(62)
+	mod := new(big.Int)
+	for i := width - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		out[i] = base62[mod.Int64()]
+	}
+	return string(out)
+}
+
+func checksum(s string) string {
+	sum := crc32.ChecksumIEEE([]byte(s))
+	return encode62([]byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}, crcLen)
+}
+
+// generate returns a new key with prefix and its id and secret parts.
+func generate(prefix string) (key, id, secret string, err error) {
+	if !validPrefix.MatchString(prefix) {
+		return "", "", "", fmt.Errorf("invalid prefix %q", prefix)
+	}
+	b := make([]byte, idBytes+secBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	id = encode62(b[:idBytes], idLen)
+	secret = encode62(b[idBytes:], secLen)
+	body := prefix + "_" + id + secret
+	return body + checksum(body), id, secret, nil
+}
+
+// parse splits key into its parts and checks the checksum.
+func parse(key string) (prefix, id, secret string, err error) {
+	prefix, rest, ok := strings.Cut(key, "_")
+	if !ok || !validPrefix.MatchString(prefix) || len(rest) != idLen+secLen+crcLen {
+		return "", "", "", ErrMalformed
+	}
+	for i := 0; i < len(rest); i++ {
+		if strings.IndexByte(base62, rest[i]) < 0 {
+			return "", "", "", ErrMalformed
+		}
+	}
+	body := key[:len(key)-crcLen]
+	if checksum(body) != key[len(key)-crcLen:] {
+		return "", "", "", fmt.Errorf("%w: bad checksum", ErrMalformed)
+	}
+	return prefix, rest[:idLen], rest[idLen : idLen+secLen], nil
+}
+
+// Scan finds API keys with the given prefix in text. Candidates with a
+// wrong checksum are dropped, which keeps false positives rare.
+func Scan(text, prefix string) []string {
+	re := regexp.MustCompile(`\b` + regexp.QuoteMeta(prefix) + `_[0-9A-Za-z]{` + fmt.Sprint(idLen+secLen+crcLen) + `}\b`)
+	var found []string
+	for _, m := range re.FindAllString(text, -1) {
+		if _, _, _, err := parse(m); err == nil {
+			found = append(found, m)
+		}
+	}
+	return found
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: apikey [flags] <command> [flags] [args]
+
+commands:
+  issue          print a new key for -name with -scopes
+  verify <key>   check a key, and -scope if set
+  rotate <id>    replace a key; the old one works for -overlap more
+  revoke <id>    stop accepting a key
+  list           print the stored records
+  scan <file>... report keys with -prefix found in files
+
+The store holds only HMAC-SHA256 hashes of key secrets under the pepper
+in $CRYPTO_APIKEY_PEPPER (hex, at least 16 bytes). Without a command, a
+demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	storePath := flag.String("store", "apikeys.json", "key record file")
+	prefix := flag.String("prefix", "cx", "key prefix identifying the issuer")
+	name := flag.String("name", "", "description of the key's owner or use")
+	scopes := flag.String("scopes", "read", "comma-separated scopes granted to a new key, * for all")
+	scope := flag.String("scope", "", "scope to require when verifying")
+	ttl := flag.Duration("ttl", 90*24*time.Hour, "lifetime of a new key, 0 for none")
+	overlap := flag.Duration("overlap", 24*time.Hour, "how long a rotated key keeps working")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	if cmd == "scan" {
+		found := false
+		for _, path := range flag.Args() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, key := range Scan(string(data), *prefix) {
+				fmt.Printf("%s: %s...\n", path, key[:len(*prefix)+1+idLen])
+				found = true
+			}
+		}
+		if found {
+			os.Exit(1)
+		}
+		return
+	}
+
+	pepper, err := hex.DecodeString(os.Getenv("CRYPTO_APIKEY_PEPPER"))
+	if err != nil {
+		log.Fatal("CRYPTO_APIKEY_PEPPER: ", err)
+	}
+	s, err := OpenStore(*storePath, pepper)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch cmd {
+	case "issue":
+		key, r, err := s.Issue(*prefix, *name, strings.Split(*scopes, ","), *ttl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintln(os.Stderr, "id:", r.ID)
+		fmt.Println(key)
+	case "verify":
+		r, err := s.Verify(strings.TrimSpace(flag.Arg(0)), *scope)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("valid: id %s, name %q, scopes %s\n", r.ID, r.Name, strings.Join(r.Scopes, ","))
+	case "rotate":
+		key, r, err := s.Rotate(flag.Arg(0), *overlap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintln(os.Stderr, "id:", r.ID)
+		fmt.Println(key)
+	case "revoke":
+		if err := s.Revoke(flag.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+	case "list":
+		for _, r := range s.List() {
+			state := "active"
+			switch {
+			case r.Revoked:
+				state = "revoked"
+			case !r.Expires.IsZero() && !time.Now().Before(r.Expires):
+				state = "expired"
+			case !r.Expires.IsZero():
+				state = "expires " + r.Expires.Format(time.RFC3339)
+			}
+			fmt.Printf("%s  %-12s %-20s %s\n", r.ID, r.Name, strings.Join(r.Scopes, ","), state)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func demo() {
+	pepper := make([]byte, 32)
+	rand.Read(pepper)
+	s := NewStore(pepper)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	key, r, err := s.Issue("cx", "ci-deploy", []string{"read", "deploy"}, 30*24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("issued:", key)
+	fmt.Println("stored hash:", r.Hash)
+
+	check := func(label, key, scope string) {
+		_, err := s.Verify(key, scope)
+		if err == nil {
+			fmt.Printf("%-26s ok\n", label+":")
+			return
+		}
+		fmt.Printf("%-26s %v\n", label+":", err)
+	}
+	check("verify deploy", key, "deploy")
+	check("verify admin", key, "admin")
+
+	typo := []byte(key)
+	typo[10] ^= 'a' ^ 'b'
+	check("mistyped key", string(typo), "")
+	forged, _, _, _ := generate("cx")
+	check("well-formed unknown key", forged, "")
+	check("other issuer's prefix", "gh"+key[2:], "")
+
+	text := "config:\n  token: " + key + "\n  sample: cx_" + strings.Repeat("A", idLen+secLen+crcLen) + "\n"
+	fmt.Println("scan finds:", len(Scan(text, "cx")), "of 2 candidates")
+
+	newKey, _, err := s.Rotate(r.ID, time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now = now.Add(30 * time.Minute)
+	check("old key during overlap", key, "deploy")
+	check("new key", newKey, "deploy")
+	now = now.Add(time.Hour)
+	check("old key after overlap", key, "deploy")
+	check("new key", newKey, "deploy")
+
+	now = now.Add(31 * 24 * time.Hour)
+	check("new key after 31 days", newKey, "deploy")
+
+	other, r2, _ := s.Issue("cx", "dashboard", []string{"*"}, 0)
+	check("wildcard key, admin", other, "admin")
+	s.Revoke(r2.ID)
+	if _, err := s.Verify(other, ""); !errors.Is(err, ErrRevoked) {
+		log.Fatal("revoked key accepted")
+	}
+	check("revoked key", other, "")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown API key")
+	ErrExpired    = errors.New("API key has expired")
+	ErrRevoked    = errors.New("API key has been revoked")
+	ErrScope      = errors.New("API key lacks the required scope")
+)
+
+// Record is what is stored for an issued key. Hash is an HMAC-SHA256 of
+// the key's secret under the store's pepper, so a leaked store does not
+// reveal usable keys and cannot be brute-forced offline without the
+// pepper.
+type Record struct {
+	ID        string    `json:"id"`
+	Prefix    string    `json:"prefix"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires,omitzero"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	RotatedTo string    `json:"rotated_to,omitempty"`
+}
+
+func (r *Record) HasScope(scope string) bool {
+	return slices.Contains(r.Scopes, scope) || slices.Contains(r.Scopes, "*")
+}
+
+// clone returns a copy the caller can use without holding the store's
+// lock.
+func (r *Record) clone() *Record {
+	c := *r
+	c.Scopes = slices.Clone(r.Scopes)
+	return &c
+}
+
+// Store keeps key records in memory and, if Path is set, in a JSON file.
+type Store struct {
+	Path    string
+	pepper  []byte
+	mu      sync.Mutex
+	records map[string]*Record
+	now     func() time.Time
+}
+
+func NewStore(pepper []byte) *Store {
+	return &Store{pepper: pepper, records: map[string]*Record{}, now: time.Now}
+}
+
+// OpenStore loads the records at path, creating an empty store if the
+// file does not exist.
+func OpenStore(path string, pepper []byte) (*Store, error) {
+	if len(pepper) < 16 {
+		return nil, errors.New("pepper must be at least 16 bytes")
+	}
+	s := NewStore(pepper)
+	s.Path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		s.records[r.ID] = r
+	}
+	return s, nil
+}
+
+func (s *Store) save() error {
+	if s.Path == "" {
+		return nil
+	}
+	records := s.list()
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+func (s *Store) list() []*Record {
+	records := make([]*Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Created.Before(records[j].Created) })
+	return records
+}
+
+func (s *Store) List() []*Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := s.list()
+	for i, r := range records {
+		records[i] = r.clone()
+	}
+	return records
+}
+
+func (s *Store) hash(secret string) string {
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Issue creates a key valid for ttl (forever if zero) with scopes. The
+// key itself is returned once and never stored.
+func (s *Store) Issue(prefix, name string, scopes []string, ttl time.Duration) (string, *Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, r, err := s.newRecord(prefix, name, scopes, ttl)
+	if err != nil {
+		return "", nil, err
+	}
+	s.records[r.ID] = r
+	if err := s.save(); err != nil {
+		delete(s.records, r.ID)
+		return "", nil, err
+	}
+	return key, r.clone(), nil
+}
+
+func (s *Store) newRecord(prefix, name string, scopes []string, ttl time.Duration) (string, *Record, error) {
+	key, id, secret, err := generate(prefix)
+	if err != nil {
+		return "", nil, err
+	}
+	r := &Record{ID: id, Prefix: prefix, Name: name, Hash: s.hash(secret), Scopes: slices.Clone(scopes), Created: s.now().UTC()}
+	if ttl > 0 {
+		r.Expires = r.Created.Add(ttl)
+	}
+	return key, r, nil
+}
+
+// Verify checks key and that it grants scope (any scope if empty). The
+// id selects the record; the secret is compared in constant time.
+func (s *Store) Verify(key, scope string) (*Record, error) {
+	prefix, id, secret, err := parse(key)
+	if err != nil {
+		return nil, err
+	}
+	// Check a copy: the record may be rotated or revoked once the lock
+	// is released.
+	var r *Record
+	s.mu.Lock()
+	if rec, ok := s.records[id]; ok {
+		r = rec.clone()
+	}
+	s.mu.Unlock()
+	// Hash even for unknown ids so timing does not reveal which exist.
+	h := s.hash(secret)
+	if r == nil || r.Prefix != prefix || !hmac.Equal([]byte(h), []byte(r.Hash)) {
+		return nil, ErrUnknownKey
+	}
+	if r.Revoked {
+		return nil, ErrRevoked
+	}
+	if !r.Expires.IsZero() && !s.now().Before(r.Expires) {
+		return nil, ErrExpired
+	}
+	if scope != "" && !r.HasScope(scope) {
+		return nil, fmt.Errorf("%w %q", ErrScope, scope)
+	}
+	return r, nil
+}
+
+// Rotate issues a replacement for the key with id, with the same name,
+// scopes and lifetime, and lets the old key keep working for overlap so
+// clients can switch over.
+func (s *Store) Rotate(id string, overlap time.Duration) (string, *Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.records[id]
+	if !ok || old.Revoked {
+		return "", nil, ErrUnknownKey
+	}
+	var ttl time.Duration
+	if !old.Expires.IsZero() {
+		ttl = old.Expires.Sub(old.Created)
+	}
+	key, r, err := s.newRecord(old.Prefix, old.Name, old.Scopes, ttl)
+	if err != nil {
+		return "", nil, err
+	}
+	prev := *old
+	s.records[r.ID] = r
+	if end := s.now().UTC().Add(overlap); old.Expires.IsZero() || end.Before(old.Expires) {
+		old.Expires = end
+	}
+	old.RotatedTo = r.ID
+	if err := s.save(); err != nil {
+		delete(s.records, r.ID)
+		*old = prev
+		return "", nil, err
+	}
+	return key, r.clone(), nil
+}
+
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	r.Revoked = true
+	if err := s.save(); err != nil {
+		r.Revoked = false
+		return err
+	}
+	return nil
+}
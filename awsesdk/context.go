@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EncryptionContext is authenticated, non-secret data stored in the
+// message header. Keys starting with "aws-crypto-" are reserved.
+type EncryptionContext map[string]string
+
+const (
+	reservedPrefix = "aws-crypto-"
+	publicKeyField = "aws-crypto-public-key"
+)
+
+// serialize is the header form: a pair count and length-prefixed keys
+// and values, sorted by key. An empty context serializes to nothing.
+func (ec EncryptionContext) serialize() ([]byte, error) {
+	if len(ec) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(ec))
+	for k := range ec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(keys)))
+	for _, k := range keys {
+		for _, s := range []string{k, ec[k]} {
+			if len(s) > 0xffff {
+				return nil, fmt.Errorf("encryption context entry %q is too long", k)
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+			b = append(b, s...)
+		}
+	}
+	if len(b) > 0xffff {
+		return nil, errors.New("encryption context is too large")
+	}
+	return b, nil
+}
+
+func parseContext(b []byte) (EncryptionContext, error) {
+	ec := EncryptionContext{}
+	if len(b) == 0 {
+		return ec, nil
+	}
+	p := &parser{b: b}
+	n := p.u16()
+	for range n {
+		k, v := string(p.lv16()), string(p.lv16())
+		if _, dup := ec[k]; dup {
+			return nil, fmt.Errorf("duplicate encryption context key %q", k)
+		}
+		ec[k] = v
+	}
+	if p.err != nil || len(p.b) != 0 {
+		return nil, errors.New("malformed encryption context")
+	}
+	return ec, nil
+}
+
+func (ec EncryptionContext) checkReserved() error {
+	for k := range ec {
+		if strings.HasPrefix(k, reservedPrefix) {
+			return fmt.Errorf("encryption context key %q uses the reserved prefix %q", k, reservedPrefix)
+		}
+	}
+	return nil
+}
+
+var errTruncated = errors.New("message is truncated or malformed")
+
+// parser reads big-endian fields, recording the first error.
+type parser struct {
+	b   []byte
+	n   int // bytes consumed
+	err error
+}
+
+func (p *parser) take(n int) []byte {
+	if p.err != nil || n < 0 || len(p.b) < n {
+		p.err = errTruncated
+		return nil
+	}
+	v := p.b[:n]
+	p.b = p.b[n:]
+	p.n += n
+	return v
+}
+
+func (p *parser) u8() byte {
+	if v := p.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (p *parser) u16() uint16 {
+	if v := p.take(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (p *parser) u32() uint32 {
+	if v := p.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (p *parser) u64() uint64 {
+	if v := p.take(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (p *parser) lv16() []byte { return p.take(int(p.u16())) }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// EncryptedDataKey is one wrapped copy of the message's data key.
+type EncryptedDataKey struct {
+	ProviderID   string
+	ProviderInfo []byte
+	Ciphertext   []byte
+}
+
+// Keyring wraps data keys on encryption and unwraps them on decryption.
+// UnwrapKey returns errNotOurs for keys it did not produce.
+type Keyring interface {
+	WrapKey(dataKey []byte, ec EncryptionContext) (EncryptedDataKey, error)
+	UnwrapKey(edk EncryptedDataKey, ec EncryptionContext) ([]byte, error)
+}
+
+var errNotOurs = errors.New("encrypted data key belongs to another keyring")
+
+// RawAESKeyring wraps data keys with a local AES-GCM key, laid out as
+// the SDKs document their raw AES keyring. The provider id is the
+// namespace and the provider info is
+//
+//	key name || tag length in bits (4) || IV length (4) || IV
+//
+// The ciphertext is the wrapped key followed by the tag, and the
+// serialized encryption context is the additional data.
+type RawAESKeyring struct {
+	Namespace, Name string
+	gcm             cipher.AEAD
+}
+
+func NewRawAESKeyring(namespace, name string, key []byte) (*RawAESKeyring, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("raw AES wrapping key must be 16, 24 or 32 bytes")
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	return &RawAESKeyring{Namespace: namespace, Name: name, gcm: gcm}, nil
+}
+
+func (k *RawAESKeyring) WrapKey(dataKey []byte, ec EncryptionContext) (EncryptedDataKey, error) {
+	aad, err := ec.serialize()
+	if err != nil {
+		return EncryptedDataKey{}, err
+	}
+	iv := make([]byte, ivLen)
+	if _, err := rand.Read(iv); err != nil {
+		return EncryptedDataKey{}, err
+	}
+	info := append([]byte(k.Name), 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(info[len(k.Name):], tagLen*8)
+	binary.BigEndian.PutUint32(info[len(k.Name)+4:], ivLen)
+	info = append(info, iv...)
+	return EncryptedDataKey{
+		ProviderID:   k.Namespace,
+		ProviderInfo: info,
+		Ciphertext:   k.gcm.Seal(nil, iv, dataKey, aad),
+	}, nil
+}
+
+func (k *RawAESKeyring) UnwrapKey(edk EncryptedDataKey, ec EncryptionContext) ([]byte, error) {
+	info := edk.ProviderInfo
+	if edk.ProviderID != k.Namespace || len(info) != len(k.Name)+8+ivLen || !bytes.HasPrefix(info, []byte(k.Name)) {
+		return nil, errNotOurs
+	}
+	params := info[len(k.Name):]
+	if binary.BigEndian.Uint32(params) != tagLen*8 || binary.BigEndian.Uint32(params[4:]) != ivLen {
+		return nil, errNotOurs
+	}
+	aad, err := ec.serialize()
+	if err != nil {
+		return nil, err
+	}
+	return k.gcm.Open(nil, params[8:], edk.Ciphertext, aad)
+}
+
+// RSA padding schemes of the raw RSA keyring.
+const (
+	PaddingOAEPSHA256 = "OAEP_SHA256_MGF1"
+	PaddingOAEPSHA384 = "OAEP_SHA384_MGF1"
+	PaddingOAEPSHA512 = "OAEP_SHA512_MGF1"
+	PaddingOAEPSHA1   = "OAEP_SHA1_MGF1"
+	PaddingPKCS1      = "PKCS1"
+)
+
+// RawRSAKeyring encrypts data keys to an RSA public key, laid out as the
+// SDKs document their raw RSA keyring: the provider id is the namespace
+// and the provider info the key name. The encryption context is not
+// bound to the wrapped key; the header authentication tag covers it. A
+// keyring with only a public key can encrypt but not decrypt.
+type RawRSAKeyring struct {
+	Namespace, Name string
+	Padding         string
+	Public          *rsa.PublicKey
+	Private         *rsa.PrivateKey
+}
+
+func (k *RawRSAKeyring) oaepHash() (hash.Hash, error) {
+	switch k.Padding {
+	case PaddingOAEPSHA256, "":
+		return sha256.New(), nil
+	case PaddingOAEPSHA384:
+		return sha512.New384(), nil
+	case PaddingOAEPSHA512:
+		return sha512.New(), nil
+	case PaddingOAEPSHA1:
+		return sha1.New(), nil
+	case PaddingPKCS1:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown RSA padding %q", k.Padding)
+}
+
+func (k *RawRSAKeyring) WrapKey(dataKey []byte, ec EncryptionContext) (EncryptedDataKey, error) {
+	pub := k.Public
+	if pub == nil && k.Private != nil {
+		pub = &k.Private.PublicKey
+	}
+	if pub == nil {
+		return EncryptedDataKey{}, errors.New("raw RSA keyring has no key")
+	}
+	h, err := k.oaepHash()
+	if err != nil {
+		return EncryptedDataKey{}, err
+	}
+	var ct []byte
+	if h == nil {
+		ct, err = rsa.EncryptPKCS1v15(rand.Reader, pub, dataKey)
+	} else {
+		ct, err = rsa.EncryptOAEP(h, rand.Reader, pub, dataKey, nil)
+	}
+	if err != nil {
+		return EncryptedDataKey{}, err
+	}
+	return EncryptedDataKey{ProviderID: k.Namespace, ProviderInfo: []byte(k.Name), Ciphertext: ct}, nil
+}
+
+func (k *RawRSAKeyring) UnwrapKey(edk EncryptedDataKey, ec EncryptionContext) ([]byte, error) {
+	if k.Private == nil || edk.ProviderID != k.Namespace || string(edk.ProviderInfo) != k.Name {
+		return nil, errNotOurs
+	}
+	h, err := k.oaepHash()
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return rsa.DecryptPKCS1v15(nil, k.Private, edk.Ciphertext)
+	}
+	return rsa.DecryptOAEP(h, nil, k.Private, edk.Ciphertext, nil)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RSA keys are PEM files in the rsa/ format: PKCS #1 in an
+// "RSA PRIVATE KEY" block and PKIX in an "RSA PUBLIC KEY" block.
+
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("key type is not RSA")
+	}
+	return rsaPub, nil
+}
+
+func parseContextFlag(s string) (EncryptionContext, error) {
+	ec := EncryptionContext{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("context entry %q is not key=value", kv)
+		}
+		ec[k] = v
+	}
+	return ec, nil
+}
+
+func parseSuite(s string) (*Suite, error) {
+	for _, suite := range suites {
+		if strings.EqualFold(s, suite.Name) {
+			return suite, nil
+		}
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("unknown algorithm suite %q", s)
+	}
+	return suiteByID(uint16(id))
+}
+
+func (ec EncryptionContext) String() string {
+	keys := make([]string, 0, len(ec))
+	for k := range ec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		v := ec[k]
+		if len(v) > 24 {
+			v = v[:21] + "..."
+		}
+		parts[i] = k + "=" + v
+	}
+	return strings.Join(parts, ",")
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: awsesdk [flags] <encrypt|decrypt> [flags]
+       awsesdk vectors manifest.json
+
+Reads and writes messages in the AWS Encryption SDK message format
+with raw keyrings: an AES key from the hex in $CRYPTO_ESDK_AES_KEY
+(-namespace, -key-name), and/or an RSA key in the rsa/ format (-rsa-pub
+to encrypt, -rsa-key to decrypt). vectors runs a decrypt manifest from
+aws-encryption-sdk-test-vectors; until the published manifests pass,
+messages written by the AWS SDKs are not known to decrypt. Without a
+command, a demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	namespace := flag.String("namespace", "local", "raw keyring key namespace")
+	keyName := flag.String("key-name", "default", "raw keyring key name")
+	rsaKey := flag.String("rsa-key", "", "RSA private key for a raw RSA keyring")
+	rsaPub := flag.String("rsa-pub", "", "RSA public key for a raw RSA keyring")
+	padding := flag.String("padding", PaddingOAEPSHA256, "RSA padding: OAEP_SHA256_MGF1, OAEP_SHA384_MGF1, OAEP_SHA512_MGF1, OAEP_SHA1_MGF1 or PKCS1")
+	contextFlag := flag.String("context", "", "encryption context as key=value,... (decrypt: required entries)")
+	suiteFlag := flag.String("suite", SuiteCommitKeyECDSAP384.Name, "algorithm suite name or hex id")
+	frame := flag.Int("frame", DefaultFrameLength, "frame length, -1 for a non-framed body")
+	legacy := flag.Bool("legacy", false, "decrypt messages with non-committing suites")
+	in := flag.String("in", "", "input file (default stdin)")
+	out := flag.String("out", "", "output file (default stdout)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	if cmd == "vectors" {
+		if flag.NArg() != 1 {
+			usage()
+			os.Exit(2)
+		}
+		results, err := RunDecryptManifest(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if printVectorResults(results) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var keyrings []Keyring
+	if v := os.Getenv("CRYPTO_ESDK_AES_KEY"); v != "" {
+		key, err := hex.DecodeString(v)
+		if err != nil {
+			log.Fatal("CRYPTO_ESDK_AES_KEY: ", err)
+		}
+		kr, err := NewRawAESKeyring(*namespace, *keyName, key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keyrings = append(keyrings, kr)
+	}
+	if *rsaKey != "" || *rsaPub != "" {
+		kr := &RawRSAKeyring{Namespace: *namespace, Name: *keyName, Padding: *padding}
+		var err error
+		if *rsaKey != "" {
+			kr.Private, err = loadRSAPrivateKey(*rsaKey)
+		} else {
+			kr.Public, err = loadRSAPublicKey(*rsaPub)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		keyrings = append(keyrings, kr)
+	}
+	if len(keyrings) == 0 {
+		log.Fatal("no keyring: set $CRYPTO_ESDK_AES_KEY or -rsa-key/-rsa-pub")
+	}
+	ec, err := parseContextFlag(*contextFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var input []byte
+	if *in == "" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var output []byte
+	switch cmd {
+	case "encrypt":
+		suite, err := parseSuite(*suiteFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		output, err = Encrypt(input, EncryptOptions{Suite: suite, FrameLength: *frame, Context: ec, Keyrings: keyrings})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "decrypt":
+		var m *Message
+		output, m, err = Decrypt(input, DecryptOptions{Keyrings: keyrings, AllowLegacy: *legacy})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for k, v := range ec {
+			if m.Context[k] != v {
+				log.Fatalf("encryption context %s=%q, want %q", k, m.Context[k], v)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s, context %v\n", m.Suite.Name, m.Context)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if *out == "" {
+		os.Stdout.Write(output)
+	} else if err := os.WriteFile(*out, output, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printVectorResults prints one line per test and a summary, and
+// returns the number of failures.
+func printVectorResults(results []VectorResult) int {
+	var passed, skipped, failed int
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			skipped++
+			fmt.Printf("  skip %s: %v\n", r.ID, r.Err)
+		case r.Err != nil:
+			failed++
+			fmt.Printf("  FAIL %s: %v\n", r.ID, r.Err)
+		default:
+			passed++
+			suite := r.Suite
+			if suite == "" {
+				suite = "rejected as expected"
+			}
+			fmt.Printf("  ok   %-32s %s\n", r.ID, suite)
+		}
+	}
+	fmt.Printf("  %d passed, %d skipped, %d failed\n", passed, skipped, failed)
+	return failed
+}
+
+// testdataPath names a file in the testdata directory next to this
+// source file, so the demo finds it from any working directory.
+func testdataPath(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata", name)
+}
+
+func demo() {
+	aesKey := make([]byte, 32)
+	rand.Read(aesKey)
+	aesKeyring, err := NewRawAESKeyring("acme-keys", "orders-2026", aesKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rsaEncrypt := &RawRSAKeyring{Namespace: "acme-escrow", Name: "escrow-1", Public: &rsaPriv.PublicKey}
+	rsaDecrypt := &RawRSAKeyring{Namespace: "acme-escrow", Name: "escrow-1", Private: rsaPriv}
+
+	plaintext := bytes.Repeat([]byte("order #1001 shipped\n"), 500)
+	ec := EncryptionContext{"purpose": "orders", "tenant": "acme"}
+	for _, o := range []EncryptOptions{
+		{Suite: SuiteCommitKeyECDSAP384, FrameLength: 4096},
+		{Suite: SuiteCommitKeyECDSAP384, FrameLength: 1000}, // plaintext is exactly 10 frames
+		{Suite: SuiteCommitKey, FrameLength: -1},
+		{Suite: SuiteHKDFSHA384ECDSAP384, FrameLength: 4096},
+		{Suite: SuiteHKDFSHA256, FrameLength: -1},
+	} {
+		o.Context, o.Keyrings = ec, []Keyring{aesKeyring, rsaEncrypt}
+		msg, err := Encrypt(plaintext, o)
+		if errors.Is(err, ErrLegacySuite) {
+			// Write it the way an older SDK would, to read it back.
+			msg, err = encrypt(plaintext, o)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		body := "non-framed"
+		if o.FrameLength > 0 {
+			body = fmt.Sprintf("%d-byte frames", o.FrameLength)
+		}
+		fmt.Printf("%s, %s: %d bytes\n", o.Suite.Name, body, len(msg))
+		for _, kr := range []struct {
+			name string
+			k    Keyring
+		}{{"raw AES", aesKeyring}, {"raw RSA", rsaDecrypt}} {
+			for _, legacy := range []bool{false, true} {
+				pt, m, err := Decrypt(msg, DecryptOptions{Keyrings: []Keyring{kr.k}, AllowLegacy: legacy})
+				if errors.Is(err, ErrLegacySuite) {
+					fmt.Printf("  %s, default policy: %v\n", kr.name, err)
+					continue
+				}
+				if err != nil {
+					log.Fatal(err)
+				}
+				if legacy && o.Suite.Committing {
+					continue
+				}
+				fmt.Printf("  %s: %v, context %v\n", kr.name, bytes.Equal(pt, plaintext), m.Context)
+			}
+		}
+	}
+
+	msg, _ := Encrypt(plaintext, EncryptOptions{Context: ec, Keyrings: []Keyring{aesKeyring}})
+	opts := DecryptOptions{Keyrings: []Keyring{aesKeyring}}
+	report := func(label string, msg []byte) {
+		_, _, err := Decrypt(msg, opts)
+		fmt.Printf("%-28s %v\n", label+":", err)
+	}
+	fmt.Println()
+	tampered := bytes.Clone(msg)
+	tampered[len(tampered)-200] ^= 1
+	report("modified body", tampered)
+	report("truncated", msg[:len(msg)-120])
+	p := &parser{b: msg}
+	parseHeader(p)
+	badCommit := bytes.Clone(msg)
+	badCommit[p.n-1] ^= 1 // last byte of the commitment key
+	report("modified commitment", badCommit)
+	otherKey := make([]byte, 32)
+	rand.Read(otherKey)
+	other, _ := NewRawAESKeyring("acme-keys", "orders-2026", otherKey)
+	opts.Keyrings = []Keyring{other}
+	report("wrong wrapping key", msg)
+	_, err = Encrypt(plaintext, EncryptOptions{Context: EncryptionContext{"aws-crypto-x": "1"}, Keyrings: []Keyring{aesKeyring}})
+	fmt.Printf("%-28s %v\n", "reserved context key:", err)
+
+	// testdata/ is a decrypt manifest in the test vector format, written
+	// by this package rather than by an AWS SDK, so it pins the wire
+	// format but does not show interoperability; TestPublishedVectors
+	// and `awsesdk vectors` run the published manifests for that.
+	fmt.Println("\ntestdata/manifest.json:")
+	results, err := RunDecryptManifest(testdataPath("manifest.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printVectorResults(results) > 0 {
+		log.Fatal("test vectors failed")
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Message format (https://docs.aws.amazon.com/encryption-sdk/latest/developer-guide/message-format.html):
+//
+//	header:  v2: version (2) || suite (2) || message id (32) || AAD ||
+//	             EDKs || content type (1) || frame length (4) ||
+//	             commitment key (32) || tag (16)
+//	         v1: version (1) || type 0x80 || suite (2) || message id (16) ||
+//	             AAD || EDKs || content type (1) || reserved (4) ||
+//	             IV length (1) || frame length (4) || IV (12) || tag (16)
+//	body:    non-framed: IV || length (8) || ciphertext || tag
+//	         framed: seq (4) || IV || ciphertext || tag, ...,
+//	                 0xffffffff || seq (4) || IV || length (4) || ciphertext || tag
+//	footer:  signature length (2) || DER ECDSA P-384 signature over
+//	         SHA-384 of header and body (signed suites only)
+//
+// The header tag is AES-GCM over nothing with the header as additional
+// data and a zero IV. Body IVs are the sequence number, left-padded
+// with zeros, and each part's additional data is
+//
+//	message id || content string || seq (4) || content length (8)
+const (
+	contentNonFramed = 1
+	contentFramed    = 2
+
+	DefaultFrameLength = 4096
+	finalFrameMarker   = 0xffffffff
+
+	bodySingle = "AWSKMSEncryptionClient Single Block"
+	bodyFrame  = "AWSKMSEncryptionClient Frame"
+	bodyFinal  = "AWSKMSEncryptionClient Final Frame"
+
+	defaultMaxEDKs = 10
+)
+
+var (
+	ErrNoKeyring        = errors.New("no keyring could decrypt any data key")
+	ErrCommitment       = errors.New("key commitment does not match")
+	ErrAuth             = errors.New("message authentication failed")
+	ErrSignature        = errors.New("message signature is invalid")
+	ErrLegacySuite      = errors.New("message uses a non-committing algorithm suite")
+	ErrTooManyEDKs      = errors.New("message has too many encrypted data keys")
+	errMissingPublicKey = errors.New("signed message has no public key in its encryption context")
+)
+
+type header struct {
+	suite       *Suite
+	messageID   []byte
+	context     EncryptionContext
+	edks        []EncryptedDataKey
+	contentType byte
+	frameLength uint32
+	commitment  []byte
+}
+
+func (h *header) marshalBody() ([]byte, error) {
+	aad, err := h.context.serialize()
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	if h.suite.Version == 1 {
+		b = []byte{1, 0x80}
+	} else {
+		b = []byte{2}
+	}
+	b = binary.BigEndian.AppendUint16(b, h.suite.ID)
+	b = append(b, h.messageID...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(aad)))
+	b = append(b, aad...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.edks)))
+	for _, edk := range h.edks {
+		for _, f := range [][]byte{[]byte(edk.ProviderID), edk.ProviderInfo, edk.Ciphertext} {
+			if len(f) > 0xffff {
+				return nil, errors.New("encrypted data key field is too long")
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+			b = append(b, f...)
+		}
+	}
+	b = append(b, h.contentType)
+	if h.suite.Version == 1 {
+		b = append(b, 0, 0, 0, 0, ivLen)
+	}
+	b = binary.BigEndian.AppendUint32(b, h.frameLength)
+	if h.suite.Version == 2 {
+		b = append(b, h.commitment...)
+	}
+	return b, nil
+}
+
+func parseHeader(p *parser) (*header, error) {
+	h := &header{}
+	version := p.u8()
+	switch {
+	case p.err != nil:
+		return nil, p.err
+	case version == 1:
+		if p.u8() != 0x80 {
+			return nil, errors.New("unknown message type")
+		}
+	case version != 2:
+		return nil, fmt.Errorf("unsupported message format version %d", version)
+	}
+	suite, err := suiteByID(p.u16())
+	if p.err != nil {
+		return nil, p.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if suite.Version != version {
+		return nil, fmt.Errorf("suite %s is not valid in a version %d message", suite.Name, version)
+	}
+	h.suite = suite
+	h.messageID = p.take(suite.messageIDLen())
+	if h.context, err = parseContext(p.lv16()); p.err == nil && err != nil {
+		return nil, err
+	}
+	n := p.u16()
+	if p.err == nil && n == 0 {
+		return nil, errors.New("message has no encrypted data keys")
+	}
+	for range n {
+		h.edks = append(h.edks, EncryptedDataKey{ProviderID: string(p.lv16()), ProviderInfo: p.lv16(), Ciphertext: p.lv16()})
+	}
+	h.contentType = p.u8()
+	if version == 1 {
+		if reserved := p.u32(); reserved != 0 {
+			return nil, errors.New("reserved header field is not zero")
+		}
+		if p.u8() != ivLen {
+			return nil, errors.New("unsupported IV length")
+		}
+	}
+	h.frameLength = p.u32()
+	if version == 2 {
+		h.commitment = p.take(commitKeyLen)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch {
+	case h.contentType == contentFramed && h.frameLength == 0:
+		return nil, errors.New("framed message has frame length 0")
+	case h.contentType == contentNonFramed && h.frameLength != 0:
+		return nil, errors.New("non-framed message has a frame length")
+	case h.contentType != contentFramed && h.contentType != contentNonFramed:
+		return nil, fmt.Errorf("unknown content type %d", h.contentType)
+	}
+	return h, nil
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	gcm, err := cipher.NewGCM(b)
+	if err != nil {
+		panic(err)
+	}
+	return gcm
+}
+
+func seqIV(seq uint32) []byte {
+	iv := make([]byte, ivLen)
+	binary.BigEndian.PutUint32(iv[ivLen-4:], seq)
+	return iv
+}
+
+func bodyAAD(messageID []byte, content string, seq uint32, length uint64) []byte {
+	b := append([]byte(nil), messageID...)
+	b = append(b, content...)
+	b = binary.BigEndian.AppendUint32(b, seq)
+	return binary.BigEndian.AppendUint64(b, length)
+}
+
+// EncryptOptions configure Encrypt. Suite defaults to
+// SuiteCommitKeyECDSAP384 and FrameLength to DefaultFrameLength; a
+// negative FrameLength writes a non-framed body.
+type EncryptOptions struct {
+	Suite       *Suite
+	FrameLength int
+	Context     EncryptionContext
+	Keyrings    []Keyring
+}
+
+// Encrypt produces an AWS Encryption SDK message. The data key is
+// wrapped by every keyring, so any of them can decrypt.
+func Encrypt(plaintext []byte, o EncryptOptions) ([]byte, error) {
+	suite := o.Suite
+	if suite == nil {
+		suite = SuiteCommitKeyECDSAP384
+	}
+	if !suite.Committing {
+		return nil, fmt.Errorf("%w: %s", ErrLegacySuite, suite.Name)
+	}
+	o.Suite = suite
+	return encrypt(plaintext, o)
+}
+
+// encrypt writes a message with any suite, including the legacy ones
+// Encrypt refuses.
+func encrypt(plaintext []byte, o EncryptOptions) ([]byte, error) {
+	suite := o.Suite
+	if len(o.Keyrings) == 0 {
+		return nil, errors.New("at least one keyring is required")
+	}
+	if err := o.Context.checkReserved(); err != nil {
+		return nil, err
+	}
+	ec := EncryptionContext{}
+	for k, v := range o.Context {
+		ec[k] = v
+	}
+	var signer *ecdsa.PrivateKey
+	if suite.Signed {
+		var err error
+		if signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader); err != nil {
+			return nil, err
+		}
+		pub, err := compressP384(&signer.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		ec[publicKeyField] = base64.StdEncoding.EncodeToString(pub)
+	}
+
+	dataKey := make([]byte, dataKeyLen)
+	messageID := make([]byte, suite.messageIDLen())
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(messageID); err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	h := &header{suite: suite, messageID: messageID, context: ec, contentType: contentFramed}
+	switch {
+	case o.FrameLength < 0:
+		h.contentType = contentNonFramed
+	case o.FrameLength == 0:
+		h.frameLength = DefaultFrameLength
+	case uint64(o.FrameLength) > finalFrameMarker:
+		return nil, errors.New("frame length is too large")
+	default:
+		h.frameLength = uint32(o.FrameLength)
+	}
+	for _, kr := range o.Keyrings {
+		edk, err := kr.WrapKey(dataKey, ec)
+		if err != nil {
+			return nil, err
+		}
+		h.edks = append(h.edks, edk)
+	}
+	key, commit, err := suite.deriveKeys(dataKey, messageID)
+	if err != nil {
+		return nil, err
+	}
+	h.commitment = commit
+	gcm := newGCM(key)
+
+	out, err := h.marshalBody()
+	if err != nil {
+		return nil, err
+	}
+	tag := gcm.Seal(nil, make([]byte, ivLen), nil, out)
+	if suite.Version == 1 {
+		out = append(out, make([]byte, ivLen)...)
+	}
+	out = append(out, tag...)
+
+	if h.contentType == contentNonFramed {
+		out = append(out, seqIV(1)...)
+		out = binary.BigEndian.AppendUint64(out, uint64(len(plaintext)))
+		out = gcm.Seal(out, seqIV(1), plaintext, bodyAAD(messageID, bodySingle, 1, uint64(len(plaintext))))
+	} else {
+		frame := int(h.frameLength)
+		seq := uint32(1)
+		// The final frame holds the last 1 to frame bytes, or nothing
+		// for empty plaintext, as the SDKs write it.
+		for ; len(plaintext) > frame; seq++ {
+			if seq == finalFrameMarker {
+				return nil, errors.New("message needs too many frames")
+			}
+			out = binary.BigEndian.AppendUint32(out, seq)
+			out = append(out, seqIV(seq)...)
+			out = gcm.Seal(out, seqIV(seq), plaintext[:frame], bodyAAD(messageID, bodyFrame, seq, uint64(frame)))
+			plaintext = plaintext[frame:]
+		}
+		out = binary.BigEndian.AppendUint32(out, finalFrameMarker)
+		out = binary.BigEndian.AppendUint32(out, seq)
+		out = append(out, seqIV(seq)...)
+		out = binary.BigEndian.AppendUint32(out, uint32(len(plaintext)))
+		out = gcm.Seal(out, seqIV(seq), plaintext, bodyAAD(messageID, bodyFinal, seq, uint64(len(plaintext))))
+	}
+
+	if signer != nil {
+		digest := sha512.Sum384(out)
+		sig, err := ecdsa.SignASN1(rand.Reader, signer, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		out = binary.BigEndian.AppendUint16(out, uint16(len(sig)))
+		out = append(out, sig...)
+	}
+	return out, nil
+}
+
+// DecryptOptions configure Decrypt. By default only committing suites
+// are accepted, matching the SDKs' REQUIRE_ENCRYPT_REQUIRE_DECRYPT
+// commitment policy; AllowLegacy accepts messages written by older SDKs.
+type DecryptOptions struct {
+	Keyrings             []Keyring
+	AllowLegacy          bool
+	MaxEncryptedDataKeys int
+}
+
+// Message describes a decrypted message.
+type Message struct {
+	Suite       *Suite
+	Context     EncryptionContext
+	FrameLength uint32
+	DataKeys    []EncryptedDataKey
+}
+
+// Decrypt authenticates and decrypts message. Nothing is returned
+// unless the whole message, including its signature, is valid.
+func Decrypt(message []byte, o DecryptOptions) ([]byte, *Message, error) {
+	p := &parser{b: message}
+	h, err := parseHeader(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !h.suite.Committing && !o.AllowLegacy {
+		return nil, nil, fmt.Errorf("%w: %s", ErrLegacySuite, h.suite.Name)
+	}
+	maxEDKs := o.MaxEncryptedDataKeys
+	if maxEDKs == 0 {
+		maxEDKs = defaultMaxEDKs
+	}
+	if len(h.edks) > maxEDKs {
+		return nil, nil, ErrTooManyEDKs
+	}
+	headerBody := message[:p.n]
+
+	var dataKey []byte
+	for _, edk := range h.edks {
+		for _, kr := range o.Keyrings {
+			if dk, err := kr.UnwrapKey(edk, h.context); err == nil && len(dk) == dataKeyLen {
+				dataKey = dk
+				break
+			}
+		}
+		if dataKey != nil {
+			break
+		}
+	}
+	if dataKey == nil {
+		return nil, nil, ErrNoKeyring
+	}
+	defer clear(dataKey)
+	key, commit, err := h.suite.deriveKeys(dataKey, h.messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.suite.Committing && !hmac.Equal(commit, h.commitment) {
+		return nil, nil, ErrCommitment
+	}
+	gcm := newGCM(key)
+
+	var headerIV []byte
+	if h.suite.Version == 1 {
+		headerIV = p.take(ivLen)
+	} else {
+		headerIV = make([]byte, ivLen)
+	}
+	tag := p.take(tagLen)
+	if p.err != nil {
+		return nil, nil, p.err
+	}
+	if _, err := gcm.Open(nil, headerIV, tag, headerBody); err != nil {
+		return nil, nil, fmt.Errorf("header: %w", ErrAuth)
+	}
+
+	var plaintext []byte
+	open := func(iv, ct, tag, aad []byte) error {
+		pt, err := gcm.Open(nil, iv, append(append([]byte(nil), ct...), tag...), aad)
+		if err != nil {
+			return ErrAuth
+		}
+		plaintext = append(plaintext, pt...)
+		return nil
+	}
+	if h.contentType == contentNonFramed {
+		iv := p.take(ivLen)
+		n := p.u64()
+		if n > 1<<36-32 {
+			return nil, nil, errTruncated
+		}
+		ct := p.take(int(n))
+		tag := p.take(tagLen)
+		if p.err != nil {
+			return nil, nil, p.err
+		}
+		if !hmac.Equal(iv, seqIV(1)) {
+			return nil, nil, ErrAuth
+		}
+		if err := open(iv, ct, tag, bodyAAD(h.messageID, bodySingle, 1, n)); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		for seq := uint32(1); ; seq++ {
+			marker := p.u32()
+			final := marker == finalFrameMarker
+			if final {
+				marker = p.u32()
+			}
+			if p.err != nil {
+				return nil, nil, p.err
+			}
+			if marker != seq {
+				return nil, nil, fmt.Errorf("frame %d: %w: out of sequence", seq, ErrAuth)
+			}
+			iv := p.take(ivLen)
+			n, content := h.frameLength, bodyFrame
+			if final {
+				n, content = p.u32(), bodyFinal
+				if n > h.frameLength {
+					return nil, nil, errTruncated
+				}
+			}
+			ct := p.take(int(n))
+			tag := p.take(tagLen)
+			if p.err != nil {
+				return nil, nil, p.err
+			}
+			if !hmac.Equal(iv, seqIV(seq)) {
+				return nil, nil, fmt.Errorf("frame %d: %w", seq, ErrAuth)
+			}
+			if err := open(iv, ct, tag, bodyAAD(h.messageID, content, seq, uint64(n))); err != nil {
+				return nil, nil, fmt.Errorf("frame %d: %w", seq, err)
+			}
+			if final {
+				break
+			}
+		}
+	}
+
+	if h.suite.Signed {
+		signed := message[:p.n]
+		sig := p.lv16()
+		if p.err != nil {
+			return nil, nil, p.err
+		}
+		encoded, ok := h.context[publicKeyField]
+		if !ok {
+			return nil, nil, errMissingPublicKey
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, errMissingPublicKey
+		}
+		pub, err := decompressP384(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		digest := sha512.Sum384(signed)
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return nil, nil, ErrSignature
+		}
+	}
+	if len(p.b) != 0 {
+		return nil, nil, errors.New("trailing data after message")
+	}
+	return plaintext, &Message{Suite: h.suite, Context: h.context, FrameLength: h.frameLength, DataKeys: h.edks}, nil
+}
+
+// The signing public key is stored in the encryption context as a
+// base64 compressed point.
+
+func compressP384(pub *ecdsa.PublicKey) ([]byte, error) {
+	raw, err := pub.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	x, y := raw[1:49], raw[49:]
+	return append([]byte{2 | y[len(y)-1]&1}, x...), nil
+}
+
+func decompressP384(b []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P384(), b)
+	if x == nil {
+		return nil, errors.New("invalid signing public key")
+	}
+	raw := []byte{4}
+	raw = append(raw, x.FillBytes(make([]byte, 48))...)
+	raw = append(raw, y.FillBytes(make([]byte, 48))...)
+	return ecdsa.ParseUncompressedPublicKey(elliptic.P384(), raw)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// Suite is an AWS Encryption SDK algorithm suite. All supported suites
+// use AES-256-GCM with 12-byte IVs and 16-byte tags; they differ in the
+// message format version, the KDF, key commitment and whether messages
+// carry an ECDSA P-384 signature.
+type Suite struct {
+	ID         uint16
+	Name       string
+	Version    byte
+	kdf        func() hash.Hash
+	Signed     bool
+	Committing bool
+}
+
+var (
+	SuiteCommitKeyECDSAP384 = &Suite{0x0578, "AES_256_GCM_HKDF_SHA512_COMMIT_KEY_ECDSA_P384", 2, sha512.New, true, true}
+	SuiteCommitKey          = &Suite{0x0478, "AES_256_GCM_HKDF_SHA512_COMMIT_KEY", 2, sha512.New, false, true}
+	// Legacy, non-committing suites, accepted only for decryption and
+	// only when DecryptOptions.AllowLegacy is set.
+	SuiteHKDFSHA384ECDSAP384 = &Suite{0x0378, "AES_256_GCM_IV12_TAG16_HKDF_SHA384_ECDSA_P384", 1, sha512.New384, true, false}
+	SuiteHKDFSHA256          = &Suite{0x0178, "AES_256_GCM_IV12_TAG16_HKDF_SHA256", 1, sha256.New, false, false}
+)
+
+var suites = []*Suite{SuiteCommitKeyECDSAP384, SuiteCommitKey, SuiteHKDFSHA384ECDSAP384, SuiteHKDFSHA256}
+
+func suiteByID(id uint16) (*Suite, error) {
+	for _, s := range suites {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported algorithm suite 0x%04x", id)
+}
+
+const (
+	dataKeyLen   = 32
+	ivLen        = 12
+	tagLen       = 16
+	commitKeyLen = 32
+)
+
+func (s *Suite) messageIDLen() int {
+	if s.Version == 2 {
+		return 32
+	}
+	return 16
+}
+
+// deriveKeys returns the message's content encryption key and, for
+// committing suites, the commitment key:
+//
+//	v2: HKDF(data key, salt = message id, info = suite id || "DERIVEKEY")
+//	    HKDF(data key, salt = message id, info = "COMMITKEY")
+//	v1: HKDF(data key, salt = none, info = suite id || message id)
+func (s *Suite) deriveKeys(dataKey, messageID []byte) (key, commit []byte, err error) {
+	id := binary.BigEndian.AppendUint16(nil, s.ID)
+	if s.Version == 1 {
+		key, err = hkdf.Key(s.kdf, dataKey, nil, string(id)+string(messageID), dataKeyLen)
+		return key, nil, err
+	}
+	if key, err = hkdf.Key(s.kdf, dataKey, messageID, string(id)+"DERIVEKEY", dataKeyLen); err != nil {
+		return nil, nil, err
+	}
+	commit, err = hkdf.Key(s.kdf, dataKey, messageID, "COMMITKEY", commitKeyLen)
+	return key, commit, err
+}
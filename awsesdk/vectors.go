@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Decrypt manifests in the aws-encryption-sdk-test-vectors format
+// ("awses-decrypt", versions 1 to 3). Each test names a ciphertext, the
+// master keys to decrypt it with and either the expected plaintext or
+// an expected error. Raw AES and raw RSA master keys are supported;
+// tests that can only be decrypted with AWS KMS, or that ask for a
+// particular decryption method, are skipped.
+
+type decryptManifest struct {
+	Manifest struct {
+		Type    string `json:"type"`
+		Version int    `json:"version"`
+	} `json:"manifest"`
+	Keys  string                 `json:"keys"`
+	Tests map[string]decryptTest `json:"tests"`
+}
+
+type decryptTest struct {
+	Description      string            `json:"description"`
+	Ciphertext       string            `json:"ciphertext"`
+	Plaintext        string            `json:"plaintext"` // version 1
+	MasterKeys       []vectorMasterKey `json:"master-keys"`
+	DecryptionMethod string            `json:"decryption-method"`
+	Result           *struct {
+		Output *struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"output"`
+		Error *struct {
+			Description string `json:"error-description"`
+		} `json:"error"`
+	} `json:"result"`
+}
+
+type vectorMasterKey struct {
+	Type                string `json:"type"`
+	Key                 string `json:"key"`
+	ProviderID          string `json:"provider-id"`
+	EncryptionAlgorithm string `json:"encryption-algorithm"`
+	PaddingAlgorithm    string `json:"padding-algorithm"`
+	PaddingHash         string `json:"padding-hash"`
+}
+
+type vectorKey struct {
+	Algorithm string `json:"algorithm"`
+	Type      string `json:"type"`
+	Encoding  string `json:"encoding"`
+	Material  string `json:"material"`
+	KeyID     string `json:"key-id"`
+}
+
+var errSkipVector = errors.New("unsupported master key")
+
+// VectorResult is the outcome of one test of a manifest.
+type VectorResult struct {
+	ID          string
+	Description string
+	Suite       string
+	Skipped     bool
+	Err         error
+}
+
+// RunDecryptManifest decrypts every test of the manifest at path and
+// returns the results sorted by test id. It fails only if the manifest
+// itself cannot be read.
+func RunDecryptManifest(path string) ([]VectorResult, error) {
+	var m decryptManifest
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	if m.Manifest.Type != "awses-decrypt" || m.Manifest.Version < 1 || m.Manifest.Version > 3 {
+		return nil, fmt.Errorf("%s: unsupported manifest %s version %d", path, m.Manifest.Type, m.Manifest.Version)
+	}
+	dir := filepath.Dir(path)
+	var keys struct {
+		Keys map[string]vectorKey `json:"keys"`
+	}
+	if err := readJSON(resolveURI(dir, m.Keys), &keys); err != nil {
+		return nil, err
+	}
+	var results []VectorResult
+	for id, t := range m.Tests {
+		r := VectorResult{ID: id, Description: t.Description}
+		r.Suite, r.Err = t.run(dir, keys.Keys)
+		if errors.Is(r.Err, errSkipVector) {
+			r.Skipped = true
+		}
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+func (t *decryptTest) run(dir string, keys map[string]vectorKey) (string, error) {
+	if t.DecryptionMethod != "" {
+		return "", fmt.Errorf("%w: decryption method %s", errSkipVector, t.DecryptionMethod)
+	}
+	// Master keys of unsupported types are left out; any one of the
+	// others must be able to decrypt.
+	var keyrings []Keyring
+	var skipped error
+	for _, mk := range t.MasterKeys {
+		kr, err := mk.keyring(keys)
+		if errors.Is(err, errSkipVector) {
+			skipped = err
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		keyrings = append(keyrings, kr)
+	}
+	if len(keyrings) == 0 {
+		return "", cmp.Or(skipped, errors.New("test has no master keys"))
+	}
+	ct, err := os.ReadFile(resolveURI(dir, t.Ciphertext))
+	if err != nil {
+		return "", err
+	}
+	want := t.Plaintext
+	if t.Result != nil && t.Result.Output != nil {
+		want = t.Result.Output.Plaintext
+	}
+	pt, m, err := Decrypt(ct, DecryptOptions{Keyrings: keyrings, AllowLegacy: true})
+	if t.Result != nil && t.Result.Error != nil {
+		if err == nil {
+			return m.Suite.Name, fmt.Errorf("decrypted, want error %q", t.Result.Error.Description)
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	expected, err := os.ReadFile(resolveURI(dir, want))
+	if err != nil {
+		return m.Suite.Name, err
+	}
+	if !bytes.Equal(pt, expected) {
+		return m.Suite.Name, errors.New("plaintext does not match")
+	}
+	return m.Suite.Name, nil
+}
+
+func (mk *vectorMasterKey) keyring(keys map[string]vectorKey) (Keyring, error) {
+	if mk.Type != "raw" {
+		return nil, fmt.Errorf("%w: %s", errSkipVector, mk.Type)
+	}
+	k, ok := keys[mk.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %q is not in the keys manifest", mk.Key)
+	}
+	switch mk.EncryptionAlgorithm {
+	case "aes":
+		if k.Encoding != "base64" {
+			return nil, fmt.Errorf("key %q: unsupported encoding %q", mk.Key, k.Encoding)
+		}
+		material, err := base64.StdEncoding.DecodeString(k.Material)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", mk.Key, err)
+		}
+		return NewRawAESKeyring(mk.ProviderID, k.KeyID, material)
+	case "rsa":
+		kr := &RawRSAKeyring{Namespace: mk.ProviderID, Name: k.KeyID}
+		switch mk.PaddingAlgorithm + "/" + mk.PaddingHash {
+		case "pkcs1/", "pkcs1/sha1":
+			kr.Padding = PaddingPKCS1
+		case "oaep-mgf1/sha1":
+			kr.Padding = PaddingOAEPSHA1
+		case "oaep-mgf1/sha256":
+			kr.Padding = PaddingOAEPSHA256
+		case "oaep-mgf1/sha384":
+			kr.Padding = PaddingOAEPSHA384
+		case "oaep-mgf1/sha512":
+			kr.Padding = PaddingOAEPSHA512
+		default:
+			return nil, fmt.Errorf("%w: RSA padding %s %s", errSkipVector, mk.PaddingAlgorithm, mk.PaddingHash)
+		}
+		block, _ := pem.Decode([]byte(k.Material))
+		if block == nil {
+			return nil, fmt.Errorf("key %q: no PEM block", mk.Key)
+		}
+		if k.Type != "private" {
+			// Only the private half decrypts; keep the keyring so the
+			// test fails the way the SDKs do when no key matches.
+			return kr, nil
+		}
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", mk.Key, err)
+		}
+		var isRSA bool
+		if kr.Private, isRSA = priv.(*rsa.PrivateKey); !isRSA {
+			return nil, fmt.Errorf("key %q is not an RSA key", mk.Key)
+		}
+		return kr, nil
+	}
+	return nil, fmt.Errorf("%w: encryption algorithm %q", errSkipVector, mk.EncryptionAlgorithm)
+}
+
+// resolveURI turns a manifest's file:// reference into a path relative
+// to the manifest's directory.
+func resolveURI(dir, uri string) string {
+	return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(uri, "file://")))
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func checkManifest(t *testing.T, path string) {
+	t.Helper()
+	results, err := RunDecryptManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var passed, skipped int
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.Err != nil:
+			t.Errorf("%s (%s): %v", r.ID, r.Description, r.Err)
+		default:
+			passed++
+		}
+	}
+	if passed == 0 {
+		t.Fatalf("%s: no test passed (%d skipped)", path, skipped)
+	}
+	t.Logf("%s: %d passed, %d skipped, %d failed", path, passed, skipped, len(results)-passed-skipped)
+}
+
+// testdata/manifest.json was written by this package, so it only pins
+// the wire format against accidental changes.
+func TestOwnManifest(t *testing.T) {
+	checkManifest(t, "testdata/manifest.json")
+}
+
+// The published vectors are a decrypt manifest of
+// github.com/awslabs/aws-encryption-sdk-test-vectors: the manifest.json
+// of one of the zipped vector sets under vectors/awses-decrypt, unzipped.
+// It is read from testdata/aws-encryption-sdk-test-vectors, or from the
+// path in $ESDK_TEST_VECTORS. The test is skipped if neither exists:
+// until it has passed, messages written by the AWS SDKs are not known
+// to decrypt.
+func TestPublishedVectors(t *testing.T) {
+	path := os.Getenv("ESDK_TEST_VECTORS")
+	if path == "" {
+		path = filepath.Join("testdata", "aws-encryption-sdk-test-vectors", "manifest.json")
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("%s is missing: unzip a decrypt manifest from aws-encryption-sdk-test-vectors there or set $ESDK_TEST_VECTORS", path)
+	}
+	checkManifest(t, path)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The suite as standard benchmarks, one per algorithm family, with the
+// operations as sub-benchmarks:
+//
+//	go test -bench . -benchmem
+//	go test -bench 'RSA/RSA-2048'
+//
+// Outside a module, name the files: go test -bench . *.go. This
+// measures every RSA size main does by default, 8192 included; use
+// -bench to narrow it down.
+
+func benchmarkFamily(b *testing.B, prefix string) {
+	for _, bm := range Suite(RsaSizes) {
+		if strings.HasPrefix(bm.Algorithm, prefix) {
+			b.Run(bm.Name(), bm.F)
+		}
+	}
+}
+
+func BenchmarkECDSA(b *testing.B)   { benchmarkFamily(b, "ECDSA") }
+func BenchmarkEd25519(b *testing.B) { benchmarkFamily(b, "Ed25519") }
+func BenchmarkECDH(b *testing.B)    { benchmarkFamily(b, "ECDH") }
+func BenchmarkRSA(b *testing.B)     { benchmarkFamily(b, "RSA") }
+func BenchmarkAESGCM(b *testing.B)  { benchmarkFamily(b, "AES") }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA size %q", f)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
+
+func main() {
+	format := flag.String("o", "markdown", "report format: markdown or json")
+	out := flag.String("out", "", "write the report to this file instead of stdout")
+	filter := flag.String("run", "", "only run benchmarks whose name matches this regular expression, e.g. 'RSA-2048|GCM'")
+	benchtime := flag.Duration("benchtime", time.Second, "minimum time to run each benchmark, and to sample its latency")
+	rsaSizes := flag.String("rsa", "2048,3072,4096,8192", "RSA key sizes to measure")
+	list := flag.Bool("list", false, "list benchmark names and exit")
+	flag.Parse()
+
+	sizes, err := parseSizes(*rsaSizes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	re, err := regexp.Compile(*filter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	// testing.Benchmark reads its settings from the test flags.
+	testing.Init()
+	flag.Set("test.benchtime", benchtime.String())
+	flag.Set("test.benchmem", "true")
+
+	report := &Report{Date: time.Now(), Machine: currentMachine()}
+	for _, bm := range Suite(sizes) {
+		if !re.MatchString(bm.Name()) {
+			continue
+		}
+		if *list {
+			fmt.Println(bm.Name())
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s ", bm.Name())
+		r := testing.Benchmark(bm.F)
+		if r.N == 0 {
+			log.Fatalf("%s failed", bm.Name())
+		}
+		lat, err := sampleLatency(bm, *benchtime)
+		if err != nil {
+			log.Fatalf("%s: %v", bm.Name(), err)
+		}
+		fmt.Fprintf(os.Stderr, "%s\tp50 %s\tp99 %s\n", r.String(), formatDuration(lat.P50Ns), formatDuration(lat.P99Ns))
+		report.Results = append(report.Results, newResult(bm, r, lat))
+	}
+	if *list {
+		return
+	}
+
+	switch *format {
+	case "markdown", "md":
+		err = report.WriteMarkdown(w)
+	case "json":
+		err = report.WriteJSON(w)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
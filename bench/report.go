@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Result struct {
+	Algorithm   string  `json:"algorithm"`
+	Op          string  `json:"op"`
+	Bytes       int     `json:"bytes,omitempty"`
+	Iterations  int     `json:"iterations"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	OpsPerSec   float64 `json:"ops_per_sec"`
+	MBPerSec    float64 `json:"mb_per_sec,omitempty"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	Latency     Latency `json:"latency"`
+}
+
+// Latency is the distribution of single-operation times, sampled apart
+// from the benchmark loop. Each sample includes the cost of reading the
+// clock, a few tens of nanoseconds.
+type Latency struct {
+	Samples int     `json:"samples"`
+	P50Ns   float64 `json:"p50_ns"`
+	P90Ns   float64 `json:"p90_ns"`
+	P99Ns   float64 `json:"p99_ns"`
+	MaxNs   float64 `json:"max_ns"`
+}
+
+const maxSamples = 1 << 20
+
+// sampleLatency times single runs of bm for about d: at least one run
+// and at most maxSamples.
+func sampleLatency(bm Benchmark, d time.Duration) (Latency, error) {
+	var samples []time.Duration
+	for start := time.Now(); len(samples) == 0 || (time.Since(start) < d && len(samples) < maxSamples); {
+		t := time.Now()
+		if err := bm.Run(); err != nil {
+			return Latency{}, err
+		}
+		samples = append(samples, time.Since(t))
+	}
+	slices.Sort(samples)
+	// Nearest-rank percentile.
+	pct := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(samples)))) - 1
+		return float64(samples[max(i, 0)].Nanoseconds())
+	}
+	return Latency{
+		Samples: len(samples),
+		P50Ns:   pct(0.50),
+		P90Ns:   pct(0.90),
+		P99Ns:   pct(0.99),
+		MaxNs:   float64(samples[len(samples)-1].Nanoseconds()),
+	}, nil
+}
+
+type Machine struct {
+	CPU       string `json:"cpu"`
+	CPUs      int    `json:"cpus"`
+	GOOS      string `json:"goos"`
+	GOARCH    string `json:"goarch"`
+	GoVersion string `json:"go_version"`
+}
+
+type Report struct {
+	Date    time.Time `json:"date"`
+	Machine Machine   `json:"machine"`
+	Results []Result  `json:"results"`
+}
+
+func newResult(bm Benchmark, r testing.BenchmarkResult, lat Latency) Result {
+	res := Result{
+		Algorithm:   bm.Algorithm,
+		Op:          bm.Op,
+		Bytes:       bm.Bytes,
+		Iterations:  r.N,
+		NsPerOp:     float64(r.T.Nanoseconds()) / float64(r.N),
+		AllocsPerOp: r.AllocsPerOp(),
+		BytesPerOp:  r.AllocedBytesPerOp(),
+		Latency:     lat,
+	}
+	if res.NsPerOp > 0 {
+		res.OpsPerSec = 1e9 / res.NsPerOp
+	}
+	if bm.Bytes > 0 && r.T > 0 {
+		res.MBPerSec = float64(bm.Bytes) * float64(r.N) / 1e6 / r.T.Seconds()
+	}
+	return res
+}
+
+func currentMachine() Machine {
+	return Machine{
+		CPU:       cpuModel(),
+		CPUs:      runtime.NumCPU(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// cpuModel reads the processor name on Linux and is empty elsewhere.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if k, v, ok := strings.Cut(s.Text(), ":"); ok && strings.TrimSpace(k) == "model name" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteMarkdown writes one table per algorithm in the order measured.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Crypto benchmark\n\n")
+	fmt.Fprintf(bw, "%s, %d CPUs, %s/%s, %s, %s\n", orUnknown(r.Machine.CPU), r.Machine.CPUs,
+		r.Machine.GOOS, r.Machine.GOARCH, r.Machine.GoVersion, r.Date.Format(time.RFC3339))
+	alg := ""
+	for _, res := range r.Results {
+		if res.Algorithm != alg {
+			alg = res.Algorithm
+			fmt.Fprintf(bw, "\n## %s\n\n", alg)
+			fmt.Fprintln(bw, "| operation | payload | time/op | p50 | p90 | p99 | ops/s | MB/s | allocs/op | B/op |")
+			fmt.Fprintln(bw, "|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|")
+		}
+		payload, mbs := "", ""
+		if res.Bytes > 0 {
+			payload = sizeName(res.Bytes)
+			mbs = fmt.Sprintf("%.1f", res.MBPerSec)
+		}
+		lat := res.Latency
+		fmt.Fprintf(bw, "| %s | %s | %s | %s | %s | %s | %.1f | %s | %d | %d |\n", res.Op, payload,
+			formatDuration(res.NsPerOp), formatDuration(lat.P50Ns), formatDuration(lat.P90Ns), formatDuration(lat.P99Ns),
+			res.OpsPerSec, mbs, res.AllocsPerOp, res.BytesPerOp)
+	}
+	return bw.Flush()
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown CPU"
+	}
+	return s
+}
+
+func formatDuration(ns float64) string {
+	switch {
+	case ns >= 1e9:
+		return fmt.Sprintf("%.2f s", ns/1e9)
+	case ns >= 1e6:
+		return fmt.Sprintf("%.2f ms", ns/1e6)
+	case ns >= 1e3:
+		return fmt.Sprintf("%.2f µs", ns/1e3)
+	}
+	return fmt.Sprintf("%.0f ns", ns)
+}
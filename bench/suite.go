@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"ecdsa/internal/keyfile"
+)
+
+// Benchmark is one measurement: an operation of an algorithm, optionally
+// on a payload of Bytes bytes. Setup, if not nil, prepares what Run needs
+// and is not timed. Run performs the operation once.
+type Benchmark struct {
+	Algorithm string
+	Op        string
+	Bytes     int
+	Setup     func()
+	Run       func() error
+}
+
+// F runs the operation in a benchmark loop.
+func (bm Benchmark) F(b *testing.B) {
+	if bm.Bytes > 0 {
+		b.SetBytes(int64(bm.Bytes))
+	}
+	if bm.Setup != nil {
+		bm.Setup()
+	}
+	for b.Loop() {
+		if err := bm.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func (bm Benchmark) Name() string {
+	if bm.Bytes > 0 {
+		return fmt.Sprintf("%s/%s/%s", bm.Algorithm, bm.Op, sizeName(bm.Bytes))
+	}
+	return bm.Algorithm + "/" + bm.Op
+}
+
+func sizeName(n int) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+var (
+	curves = []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()}
+	// The sizes rsa/ accepts; 8192-bit key generation alone can take
+	// tens of seconds per key.
+	RsaSizes     = []int{2048, 3072, 4096, 8192}
+	PayloadSizes = []int{64, 1 << 10, 16 << 10, 1 << 20}
+	aesKeySizes  = []int{16, 32}
+)
+
+var digest = sha256.Sum256([]byte("hello, world"))
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// Suite returns every benchmark for the given RSA key sizes.
+func Suite(rsaSizes []int) []Benchmark {
+	var s []Benchmark
+	for _, curve := range curves {
+		s = append(s, ecdsaBenchmarks(curve)...)
+	}
+	s = append(s, ed25519Benchmarks()...)
+	for _, curve := range []ecdh.Curve{ecdh.X25519(), ecdh.P256(), ecdh.P384(), ecdh.P521()} {
+		s = append(s, ecdhBenchmarks(curve)...)
+	}
+	for _, bits := range rsaSizes {
+		s = append(s, rsaBenchmarks(bits)...)
+	}
+	for _, size := range aesKeySizes {
+		s = append(s, gcmBenchmarks(size)...)
+	}
+	return s
+}
+
+func ecdsaBenchmarks(curve elliptic.Curve) []Benchmark {
+	alg := "ECDSA " + curve.Params().Name
+	key, _ := ecdsa.GenerateKey(curve, rand.Reader)
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	return []Benchmark{
+		{alg, "keygen", 0, nil, func() error {
+			_, err := ecdsa.GenerateKey(curve, rand.Reader)
+			return err
+		}},
+		{alg, "sign", 0, nil, func() error {
+			_, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			return err
+		}},
+		{alg, "verify", 0, nil, func() error {
+			if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+				return errInvalidSignature
+			}
+			return nil
+		}},
+	}
+}
+
+func ed25519Benchmarks() []Benchmark {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	msg := []byte("hello, world")
+	sig := ed25519.Sign(priv, msg)
+	return []Benchmark{
+		{"Ed25519", "keygen", 0, nil, func() error {
+			_, _, err := ed25519.GenerateKey(rand.Reader)
+			return err
+		}},
+		{"Ed25519", "sign", 0, nil, func() error {
+			ed25519.Sign(priv, msg)
+			return nil
+		}},
+		{"Ed25519", "verify", 0, nil, func() error {
+			if !ed25519.Verify(pub, msg, sig) {
+				return errInvalidSignature
+			}
+			return nil
+		}},
+	}
+}
+
+func ecdhBenchmarks(curve ecdh.Curve) []Benchmark {
+	alg := fmt.Sprintf("ECDH %v", curve)
+	key, _ := curve.GenerateKey(rand.Reader)
+	peer, _ := curve.GenerateKey(rand.Reader)
+	return []Benchmark{
+		{alg, "keygen", 0, nil, func() error {
+			_, err := curve.GenerateKey(rand.Reader)
+			return err
+		}},
+		{alg, "derive", 0, nil, func() error {
+			_, err := key.ECDH(peer.PublicKey())
+			return err
+		}},
+	}
+}
+
+func rsaBenchmarks(bits int) []Benchmark {
+	alg := fmt.Sprintf("RSA-%d", bits)
+	msg := make([]byte, 32)
+	// Keys are only generated for benchmarks that are selected, before
+	// their timer starts.
+	var (
+		priv    *rsa.PrivateKey
+		pub     *rsa.PublicKey
+		sig, ct []byte
+	)
+	setup := sync.OnceFunc(func() {
+		priv, pub = keyfile.GenerateRsaKeyPair(bits)
+		sig, _ = rsa.SignPSS(rand.Reader, priv, crypto.SHA256, digest[:], nil)
+		ct, _ = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, msg, nil)
+	})
+	return []Benchmark{
+		{alg, "keygen", 0, nil, func() error {
+			keyfile.GenerateRsaKeyPair(bits)
+			return nil
+		}},
+		{alg, "sign PSS", 0, setup, func() error {
+			_, err := rsa.SignPSS(rand.Reader, priv, crypto.SHA256, digest[:], nil)
+			return err
+		}},
+		{alg, "verify PSS", 0, setup, func() error {
+			return rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, nil)
+		}},
+		{alg, "encrypt OAEP", 0, setup, func() error {
+			_, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, msg, nil)
+			return err
+		}},
+		{alg, "decrypt OAEP", 0, setup, func() error {
+			_, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, ct, nil)
+			return err
+		}},
+	}
+}
+
+func gcmBenchmarks(keySize int) []Benchmark {
+	alg := fmt.Sprintf("AES-%d-GCM", keySize*8)
+	key := make([]byte, keySize)
+	rand.Read(key)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	var s []Benchmark
+	for _, size := range PayloadSizes {
+		plaintext := make([]byte, size)
+		ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+		sealed := make([]byte, 0, len(ciphertext))
+		opened := make([]byte, 0, size)
+		s = append(s,
+			Benchmark{alg, "encrypt", size, nil, func() error {
+				gcm.Seal(sealed, nonce, plaintext, nil)
+				return nil
+			}},
+			Benchmark{alg, "decrypt", size, nil, func() error {
+				_, err := gcm.Open(opened, nonce, ciphertext, nil)
+				return err
+			}},
+		)
+	}
+	return s
+}
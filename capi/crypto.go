@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+)
+
+// The Go side of the C API. Errors carry the ECX_ERR_* code they are
+// reported as; see ecx.h.
+
+const (
+	codeOK = iota
+	codeArgument
+	codeParse
+	codeKeyType
+	codeCrypto
+	codeVerify
+	codeDecrypt
+)
+
+var messages = []string{
+	codeOK:       "success",
+	codeArgument: "invalid argument",
+	codeParse:    "malformed PEM or key encoding",
+	codeKeyType:  "unsupported key type",
+	codeCrypto:   "cryptographic operation failed",
+	codeVerify:   "signature verification failed",
+	codeDecrypt:  "message authentication failed",
+}
+
+type codeError struct {
+	code int
+	err  error
+}
+
+func (e *codeError) Error() string { return messages[e.code] + ": " + e.err.Error() }
+
+func failure(code int, err error) error { return &codeError{code, err} }
+
+func errorCode(err error) int {
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return codeCrypto
+}
+
+func generateEcKey(curveBits int) (string, string, error) {
+	var curve elliptic.Curve
+	switch curveBits {
+	case 256:
+		curve = elliptic.P256()
+	case 384:
+		curve = elliptic.P384()
+	case 521:
+		curve = elliptic.P521()
+	default:
+		return "", "", failure(codeArgument, errors.New("curve must be 256, 384 or 521"))
+	}
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return "", "", failure(codeCrypto, err)
+	}
+	return encodePrivateKey(privateKey)
+}
+
+func generateRsaKey(bits int) (string, string, error) {
+	if bits < 2048 || bits > 8192 {
+		return "", "", failure(codeArgument, errors.New("RSA size must be between 2048 and 8192 bits"))
+	}
+	privkey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", "", failure(codeCrypto, err)
+	}
+	return encodePrivateKey(privkey)
+}
+
+// encodePrivateKey returns the private and public PEM blocks in the
+// formats keys/keys.go and rsa/main.go use.
+func encodePrivateKey(key crypto.Signer) (string, string, error) {
+	var privBlock, pubBlock pem.Block
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", "", failure(codeCrypto, err)
+		}
+		privBlock = pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		pubBlock.Type = "PUBLIC KEY"
+	case *rsa.PrivateKey:
+		privBlock = pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		pubBlock.Type = "RSA PUBLIC KEY"
+	default:
+		return "", "", failure(codeKeyType, errors.New("key is not EC or RSA"))
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", "", failure(codeCrypto, err)
+	}
+	pubBlock.Bytes = der
+	return string(pem.EncodeToMemory(&privBlock)), string(pem.EncodeToMemory(&pubBlock)), nil
+}
+
+func decodePem(data []byte) (*pem.Block, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, failure(codeParse, errors.New("failed to parse PEM block containing the key"))
+	}
+	return block, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, err := decodePem(data)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		// keys/keys.go stores SEC 1 keys under this type; accept PKCS #8 too.
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+	default:
+		return nil, failure(codeKeyType, errors.New("unsupported PEM type "+block.Type))
+	}
+	if err != nil {
+		return nil, failure(codeParse, err)
+	}
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	}
+	return nil, failure(codeKeyType, errors.New("key is not EC or RSA"))
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, err := decodePem(data)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, failure(codeParse, err)
+	}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return pub, nil
+	}
+	return nil, failure(codeKeyType, errors.New("key is not EC or RSA"))
+}
+
+func sign(privPEM, msg []byte) ([]byte, error) {
+	key, err := parsePrivateKey(privPEM)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(msg)
+	var sig []byte
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		sig, err = ecdsa.SignASN1(rand.Reader, key, hash[:])
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	}
+	if err != nil {
+		return nil, failure(codeCrypto, err)
+	}
+	return sig, nil
+}
+
+func verify(pubPEM, msg, sig []byte) error {
+	pub, err := parsePublicKey(pubPEM)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(msg)
+	valid := false
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, hash[:], sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) == nil
+	}
+	if !valid {
+		return failure(codeVerify, errors.New("invalid signature"))
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, failure(codeArgument, err)
+	}
+	gcm, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, failure(codeCrypto, err)
+	}
+	return gcm, nil
+}
+
+func encrypt(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, failure(codeCrypto, err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func decrypt(key, ciphertext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, failure(codeDecrypt, errors.New("ciphertext too short"))
+	}
+	nonce, data := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, data, aad)
+	if err != nil {
+		return nil, failure(codeDecrypt, err)
+	}
+	return plaintext, nil
+}
+
+func pemEncode(typ string, der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+}
@@ -0,0 +1,238 @@
+// Command ecx builds the C shared library declared in ecx.h:
+//
+//	go build -buildmode=c-shared -o libecx.so ecx.go crypto.go
+//
+// The Makefile in this directory does this and runs the C test program.
+package main
+
+/*
+#cgo CFLAGS: -I${SRCDIR}
+#include <stdlib.h>
+#define ECX_NO_PROTOTYPES
+#include "ecx.h"
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"unsafe"
+)
+
+var cMessages = func() []*C.char {
+	m := make([]*C.char, len(messages))
+	for i, s := range messages {
+		m[i] = C.CString(s)
+	}
+	return m
+}()
+
+var unknownCode = C.CString("unknown error code")
+
+var errArgument = failure(codeArgument, errors.New("NULL pointer"))
+
+func main() {}
+
+//export ecx_strerror
+func ecx_strerror(code C.int) *C.char {
+	if code < 0 || int(code) >= len(cMessages) {
+		return unknownCode
+	}
+	return cMessages[code]
+}
+
+//export ecx_free
+func ecx_free(p unsafe.Pointer) {
+	C.free(p)
+}
+
+//export ecx_generate_ec_key
+func ecx_generate_ec_key(curveBits C.int, privPEM **C.char, privLen *C.size_t, pubPEM **C.char, pubLen *C.size_t) C.int {
+	return keyPair(privPEM, privLen, pubPEM, pubLen, func() (string, string, error) {
+		return generateEcKey(int(curveBits))
+	})
+}
+
+//export ecx_generate_rsa_key
+func ecx_generate_rsa_key(bits C.int, privPEM **C.char, privLen *C.size_t, pubPEM **C.char, pubLen *C.size_t) C.int {
+	return keyPair(privPEM, privLen, pubPEM, pubLen, func() (string, string, error) {
+		return generateRsaKey(int(bits))
+	})
+}
+
+//export ecx_public_key
+func ecx_public_key(privPEM *C.char, privLen C.size_t, pubPEM **C.char, pubLen *C.size_t) C.int {
+	if pubPEM == nil || pubLen == nil {
+		return result(errArgument)
+	}
+	*pubPEM, *pubLen = nil, 0
+	priv, err := goBytes(unsafe.Pointer(privPEM), privLen)
+	if err != nil {
+		return result(err)
+	}
+	key, err := parsePrivateKey(priv)
+	if err != nil {
+		return result(err)
+	}
+	_, pub, err := encodePrivateKey(key)
+	if err != nil {
+		return result(err)
+	}
+	*pubPEM, *pubLen = cString(pub)
+	return C.ECX_OK
+}
+
+//export ecx_pem_encode
+func ecx_pem_encode(typ *C.char, der *C.uint8_t, derLen C.size_t, out **C.char, outLen *C.size_t) C.int {
+	if typ == nil || out == nil || outLen == nil {
+		return result(errArgument)
+	}
+	*out, *outLen = nil, 0
+	data, err := goBytes(unsafe.Pointer(der), derLen)
+	if err != nil {
+		return result(err)
+	}
+	encoded := pemEncode(C.GoString(typ), data)
+	*out, *outLen = cString(encoded)
+	return C.ECX_OK
+}
+
+//export ecx_pem_decode
+func ecx_pem_decode(data *C.char, dataLen C.size_t, typ **C.char, typLen *C.size_t, der **C.uint8_t, derLen *C.size_t) C.int {
+	if typ == nil || typLen == nil || der == nil || derLen == nil {
+		return result(errArgument)
+	}
+	*typ, *typLen, *der, *derLen = nil, 0, nil, 0
+	in, err := goBytes(unsafe.Pointer(data), dataLen)
+	if err != nil {
+		return result(err)
+	}
+	block, err := decodePem(in)
+	if err != nil {
+		return result(err)
+	}
+	*typ, *typLen = cString(block.Type)
+	*der, *derLen = cBytes(block.Bytes)
+	return C.ECX_OK
+}
+
+//export ecx_sign
+func ecx_sign(privPEM *C.char, privLen C.size_t, msg *C.uint8_t, msgLen C.size_t, sig **C.uint8_t, sigLen *C.size_t) C.int {
+	if sig == nil || sigLen == nil {
+		return result(errArgument)
+	}
+	*sig, *sigLen = nil, 0
+	priv, err := goBytes(unsafe.Pointer(privPEM), privLen)
+	if err != nil {
+		return result(err)
+	}
+	m, err := goBytes(unsafe.Pointer(msg), msgLen)
+	if err != nil {
+		return result(err)
+	}
+	s, err := sign(priv, m)
+	if err != nil {
+		return result(err)
+	}
+	*sig, *sigLen = cBytes(s)
+	return C.ECX_OK
+}
+
+//export ecx_verify
+func ecx_verify(pubPEM *C.char, pubLen C.size_t, msg *C.uint8_t, msgLen C.size_t, sig *C.uint8_t, sigLen C.size_t) C.int {
+	pub, err := goBytes(unsafe.Pointer(pubPEM), pubLen)
+	if err != nil {
+		return result(err)
+	}
+	m, err := goBytes(unsafe.Pointer(msg), msgLen)
+	if err != nil {
+		return result(err)
+	}
+	s, err := goBytes(unsafe.Pointer(sig), sigLen)
+	if err != nil {
+		return result(err)
+	}
+	return result(verify(pub, m, s))
+}
+
+//export ecx_aes_gcm_encrypt
+func ecx_aes_gcm_encrypt(key *C.uint8_t, keyLen C.size_t, plaintext *C.uint8_t, plaintextLen C.size_t, aad *C.uint8_t, aadLen C.size_t, out **C.uint8_t, outLen *C.size_t) C.int {
+	return aead(encrypt, key, keyLen, plaintext, plaintextLen, aad, aadLen, out, outLen)
+}
+
+//export ecx_aes_gcm_decrypt
+func ecx_aes_gcm_decrypt(key *C.uint8_t, keyLen C.size_t, ciphertext *C.uint8_t, ciphertextLen C.size_t, aad *C.uint8_t, aadLen C.size_t, out **C.uint8_t, outLen *C.size_t) C.int {
+	return aead(decrypt, key, keyLen, ciphertext, ciphertextLen, aad, aadLen, out, outLen)
+}
+
+func keyPair(privPEM **C.char, privLen *C.size_t, pubPEM **C.char, pubLen *C.size_t, generate func() (string, string, error)) C.int {
+	if privPEM == nil || privLen == nil || pubPEM == nil || pubLen == nil {
+		return result(errArgument)
+	}
+	*privPEM, *privLen, *pubPEM, *pubLen = nil, 0, nil, 0
+	priv, pub, err := generate()
+	if err != nil {
+		return result(err)
+	}
+	*privPEM, *privLen = cString(priv)
+	*pubPEM, *pubLen = cString(pub)
+	return C.ECX_OK
+}
+
+func aead(op func(key, in, aad []byte) ([]byte, error), key *C.uint8_t, keyLen C.size_t, in *C.uint8_t, inLen C.size_t, aad *C.uint8_t, aadLen C.size_t, out **C.uint8_t, outLen *C.size_t) C.int {
+	if out == nil || outLen == nil {
+		return result(errArgument)
+	}
+	*out, *outLen = nil, 0
+	k, err := goBytes(unsafe.Pointer(key), keyLen)
+	if err != nil {
+		return result(err)
+	}
+	data, err := goBytes(unsafe.Pointer(in), inLen)
+	if err != nil {
+		return result(err)
+	}
+	ad, err := goBytes(unsafe.Pointer(aad), aadLen)
+	if err != nil {
+		return result(err)
+	}
+	res, err := op(k, data, ad)
+	if err != nil {
+		return result(err)
+	}
+	*out, *outLen = cBytes(res)
+	return C.ECX_OK
+}
+
+func result(err error) C.int {
+	if err == nil {
+		return C.ECX_OK
+	}
+	return C.int(errorCode(err))
+}
+
+// goBytes copies a caller-owned buffer so nothing is retained past the call.
+func goBytes(p unsafe.Pointer, n C.size_t) ([]byte, error) {
+	if p == nil {
+		if n != 0 {
+			return nil, errArgument
+		}
+		return nil, nil
+	}
+	return bytes.Clone(unsafe.Slice((*byte)(p), int(n))), nil
+}
+
+// cBytes returns a malloc'd, NUL-terminated copy of b for the caller to
+// release with ecx_free. It is never NULL, even for empty output.
+func cBytes(b []byte) (*C.uint8_t, C.size_t) {
+	p := C.malloc(C.size_t(len(b) + 1))
+	buf := unsafe.Slice((*byte)(p), len(b)+1)
+	copy(buf, b)
+	buf[len(b)] = 0
+	return (*C.uint8_t)(p), C.size_t(len(b))
+}
+
+func cString(s string) (*C.char, C.size_t) {
+	p, n := cBytes([]byte(s))
+	return (*C.char)(unsafe.Pointer(p)), n
+}
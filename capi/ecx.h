@@ -0,0 +1,106 @@
+/*
+ * ecx: C interface to the key handling, signing and AES-GCM code in this
+ * repository. Build with `make` (produces libecx.so) and link with -lecx.
+ *
+ * Ownership rules:
+ *   - Input pointers are borrowed for the duration of the call only.
+ *   - Every output buffer is allocated by the library and must be released
+ *     with ecx_free. Text outputs (PEM) are also NUL-terminated; the
+ *     reported length excludes the terminator.
+ *   - On error no output is allocated and output pointers are set to NULL.
+ *
+ * All functions return ECX_OK or one of the error codes below and are safe
+ * to call from multiple threads.
+ */
+#ifndef ECX_H
+#define ECX_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+#define ECX_OK 0
+#define ECX_ERR_ARGUMENT 1    /* NULL pointer, bad size or unknown option */
+#define ECX_ERR_PARSE 2       /* malformed PEM or key encoding */
+#define ECX_ERR_KEY_TYPE 3    /* key is not of a type this call accepts */
+#define ECX_ERR_CRYPTO 4      /* key generation or encryption failed */
+#define ECX_ERR_VERIFY 5      /* signature invalid */
+#define ECX_ERR_DECRYPT 6     /* ciphertext failed authentication */
+
+#ifndef ECX_NO_PROTOTYPES
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* Returns a static description of an error code. Never NULL. */
+const char *ecx_strerror(int code);
+
+/* Releases a buffer returned by the library. NULL is ignored. */
+void ecx_free(void *p);
+
+/*
+ * Generates an ECDSA key on P-256, P-384 or P-521 (curve_bits 256, 384 or
+ * 521) and returns the private key as an EC "PRIVATE KEY" block and the
+ * public key as a PKIX "PUBLIC KEY" block, as keys/keys.go writes them.
+ */
+int ecx_generate_ec_key(int curve_bits,
+                        char **priv_pem, size_t *priv_len,
+                        char **pub_pem, size_t *pub_len);
+
+/*
+ * Generates an RSA key of 2048 to 8192 bits and returns PKCS #1
+ * "RSA PRIVATE KEY" and PKIX "RSA PUBLIC KEY" blocks, as rsa/main.go does.
+ */
+int ecx_generate_rsa_key(int bits,
+                         char **priv_pem, size_t *priv_len,
+                         char **pub_pem, size_t *pub_len);
+
+/* Derives the public key PEM from an EC or RSA private key PEM. */
+int ecx_public_key(const char *priv_pem, size_t priv_len,
+                   char **pub_pem, size_t *pub_len);
+
+/* Wraps DER bytes in a PEM block of the given type. */
+int ecx_pem_encode(const char *type,
+                   const uint8_t *der, size_t der_len,
+                   char **pem, size_t *pem_len);
+
+/* Decodes the first PEM block, returning its type and DER bytes. */
+int ecx_pem_decode(const char *pem, size_t pem_len,
+                   char **type, size_t *type_len,
+                   uint8_t **der, size_t *der_len);
+
+/*
+ * Signs the SHA-256 digest of msg. EC keys produce an ASN.1 ECDSA
+ * signature, RSA keys a PKCS #1 v1.5 signature.
+ */
+int ecx_sign(const char *priv_pem, size_t priv_len,
+             const uint8_t *msg, size_t msg_len,
+             uint8_t **sig, size_t *sig_len);
+
+/* Returns ECX_OK if sig is a valid signature of msg, ECX_ERR_VERIFY if not. */
+int ecx_verify(const char *pub_pem, size_t pub_len,
+               const uint8_t *msg, size_t msg_len,
+               const uint8_t *sig, size_t sig_len);
+
+/*
+ * AES-GCM with a 16, 24 or 32 byte key. The ciphertext is the random
+ * nonce followed by the sealed data, the layout aes/main.go writes.
+ * aad may be NULL when aad_len is 0.
+ */
+int ecx_aes_gcm_encrypt(const uint8_t *key, size_t key_len,
+                        const uint8_t *plaintext, size_t plaintext_len,
+                        const uint8_t *aad, size_t aad_len,
+                        uint8_t **ciphertext, size_t *ciphertext_len);
+
+int ecx_aes_gcm_decrypt(const uint8_t *key, size_t key_len,
+                        const uint8_t *ciphertext, size_t ciphertext_len,
+                        const uint8_t *aad, size_t aad_len,
+                        uint8_t **plaintext, size_t *plaintext_len);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* ECX_NO_PROTOTYPES */
+
+#endif /* ECX_H */
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"ecdsa/internal/config"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: config [flags] <command>
+
+commands:
+  show [command]   print the effective settings of the selected profile,
+                   with the defaults of sign, keys, rsa or aes if named
+  validate         check every profile in the file
+  profiles         list the defined profiles
+  flags <command>  print the flags the profile gives sign, keys, rsa or aes
+
+Settings are taken from the profile, then CRYPTO_* environment variables,
+then the flags below. sign, keys, rsa and aes read the same file and the
+profile named by $CRYPTO_PROFILE themselves, and refuse to run with
+settings the profile's policy does not allow. A profile without a curve
+leaves each command its own: P-256 for sign and P-384 for keys.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	path := flag.String("config", config.ConfigPath(), "configuration file (TOML, or YAML if named .yaml or .yml)")
+	profile := flag.String("profile", os.Getenv("CRYPTO_PROFILE"), "profile to use instead of the file's active profile")
+	format := flag.String("o", "", "output format for show: toml or json (default from the profile's format)")
+	for _, s := range config.Settings() {
+		if !strings.HasPrefix(s.Key, "policy.") {
+			flag.String(s.Key, "", "override "+s.Key+" (env "+s.Env+")")
+		}
+	}
+	flag.Usage = usage
+	flag.Parse()
+
+	overrides := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "profile" && f.Name != "o" {
+			overrides[f.Name] = f.Value.String()
+		}
+	})
+
+	cfg, err := config.LoadConfig(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "show":
+		if flag.Arg(1) != "" {
+			if _, ok := config.CommandFlags(flag.Arg(1)); !ok {
+				log.Fatalf("show: unknown command %q", flag.Arg(1))
+			}
+			cfg.Command = flag.Arg(1)
+		}
+		p, err := cfg.Resolve(*profile, overrides)
+		if err != nil {
+			log.Fatal(err)
+		}
+		name := *profile
+		if name == "" {
+			name = cfg.Active
+		}
+		if *format == "" {
+			*format = "toml"
+			if p.Format == "json" {
+				*format = "json"
+			}
+		}
+		if err := show(os.Stdout, cfg, name, p, *format); err != nil {
+			log.Fatal(err)
+		}
+	case "validate":
+		failed := false
+		for _, name := range cfg.ProfileNames() {
+			if err := cfg.Profiles[name].Validate(); err != nil {
+				fmt.Printf("%s: %v\n", name, strings.ReplaceAll(err.Error(), "\n", "; "))
+				failed = true
+			}
+		}
+		if _, ok := cfg.Profiles[cfg.Active]; !ok {
+			fmt.Printf("active profile %q is not defined\n", cfg.Active)
+			failed = true
+		}
+		if failed {
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+	case "profiles":
+		for _, name := range cfg.ProfileNames() {
+			marker := " "
+			if name == cfg.Active {
+				marker = "*"
+			}
+			fmt.Println(marker, name)
+		}
+	case "flags":
+		mapping, ok := config.CommandFlags(flag.Arg(1))
+		if !ok {
+			log.Fatalf("flags: unknown command %q", flag.Arg(1))
+		}
+		cfg.Command = flag.Arg(1)
+		p, err := cfg.Resolve(*profile, overrides)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var args []string
+		for _, m := range mapping {
+			v := fmt.Sprint(p.Lookup(m[1]))
+			if v != "" {
+				args = append(args, "-"+m[0]+"="+v)
+			}
+		}
+		fmt.Println(strings.Join(args, " "))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func show(w *os.File, cfg *config.Config, name string, p *config.Profile, format string) error {
+	switch format {
+	case "json":
+		values := map[string]any{"profile": name}
+		for _, s := range config.Settings() {
+			values[s.Key] = s.Get(p)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(values)
+	case "toml":
+		fmt.Fprintf(w, "[profiles.%s]\n", name)
+		section := ""
+		for _, s := range config.Settings() {
+			key := s.Key
+			if sec, k, ok := strings.Cut(key, "."); ok {
+				if sec != section {
+					fmt.Fprintf(w, "\n[profiles.%s.%s]\n", name, sec)
+					section = sec
+				}
+				key = k
+			}
+			fmt.Fprintf(w, "%-16s = %-40s # %s\n", key, tomlValue(s.Get(p)), cfg.Source[s.Key])
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func tomlValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []string:
+		q := make([]string, len(v))
+		for i, s := range v {
+			q[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(q, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,3 @@
+module ecdsa
+
+go 1.26
@@ -0,0 +1,427 @@
+// Package config reads the configuration profiles shared by sign/,
+// keys/, rsa/ and aes/, so every command takes the same defaults and
+// enforces the same policy. Each command calls LoadProfile before
+// defining its flags and ResolveFlags after parsing them.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ecdsa/internal/digest"
+)
+
+// Profile is a named set of defaults for the commands in this repository.
+type Profile struct {
+	Curve    string // ECDSA curve for sign/ and keys/; empty for each command's own default
+	Hash     string // digest identifier for sign/
+	RsaBits  int    // key size for rsa/
+	KeyStore string // directory keys/ writes PEM files to
+	Input    string // plaintext file for aes/
+	Output   string // ciphertext file for aes/
+	Format   string // how sign/, keys/, rsa/ and config show print results: text or json
+	Policy   Policy
+}
+
+// Policy restricts the values a profile (after overrides) may take.
+type Policy struct {
+	MinRsaBits    int
+	AllowedCurves []string
+	AllowedHashes []string
+}
+
+type Config struct {
+	Active   string
+	Profiles map[string]*Profile
+	// Selected is the profile LoadProfile chose, and Command the command
+	// it was loaded for, whose defaults fill the settings the profile
+	// leaves unset.
+	Selected string
+	Command  string
+	// Source records where each setting returned by Resolve came from:
+	// "profile <name>", "default for <command>", "env <variable>" or
+	// "flag".
+	Source map[string]string
+}
+
+var (
+	knownCurves  = []string{"P-256", "P-384", "P-521"}
+	knownFormats = []string{"text", "json"}
+)
+
+// The values the commands used before profiles existed. The curve is
+// left to commandDefaults, as sign/ and keys/ used different ones.
+func defaultProfile() *Profile {
+	return &Profile{
+		Hash:    digest.SHA256,
+		RsaBits: 4096,
+		Input:   "input.pdf",
+		Output:  "ciphertext.pdf",
+		Format:  "text",
+		Policy: Policy{
+			MinRsaBits:    2048,
+			AllowedCurves: knownCurves,
+			AllowedHashes: digest.Algorithms(),
+		},
+	}
+}
+
+// commandDefaults holds each command's own default for settings the
+// commands do not agree on. It applies only when neither the profile
+// nor an override sets the value.
+var commandDefaults = map[string]map[string]string{
+	"sign": {"curve": "P-256"},
+	"keys": {"curve": "P-384"},
+}
+
+// A Setting is one profile key: its name in the file, the environment
+// variable that overrides it and how to assign it.
+type Setting struct {
+	Key string
+	Env string
+	set func(p *Profile, v any) error
+	get func(p *Profile) any
+}
+
+// Get returns the setting's value in p.
+func (s Setting) Get(p *Profile) any { return s.get(p) }
+
+// Settings returns every profile key in the order config show prints
+// them.
+func Settings() []Setting { return slices.Clone(settings) }
+
+// Lookup returns the value of the setting named key in p, or nil.
+func (p *Profile) Lookup(key string) any {
+	for _, s := range settings {
+		if s.Key == key {
+			return s.get(p)
+		}
+	}
+	return nil
+}
+
+var settings = []Setting{
+	{"curve", "CRYPTO_CURVE", setString(func(p *Profile) *string { return &p.Curve }), func(p *Profile) any { return p.Curve }},
+	{"hash", "CRYPTO_HASH", setString(func(p *Profile) *string { return &p.Hash }), func(p *Profile) any { return p.Hash }},
+	{"rsa_bits", "CRYPTO_RSA_BITS", setInt(func(p *Profile) *int { return &p.RsaBits }), func(p *Profile) any { return p.RsaBits }},
+	{"key_store", "CRYPTO_KEY_STORE", setString(func(p *Profile) *string { return &p.KeyStore }), func(p *Profile) any { return p.KeyStore }},
+	{"input", "CRYPTO_INPUT", setString(func(p *Profile) *string { return &p.Input }), func(p *Profile) any { return p.Input }},
+	{"output", "CRYPTO_OUTPUT", setString(func(p *Profile) *string { return &p.Output }), func(p *Profile) any { return p.Output }},
+	{"format", "CRYPTO_FORMAT", setString(func(p *Profile) *string { return &p.Format }), func(p *Profile) any { return p.Format }},
+	{"policy.min_rsa_bits", "", setInt(func(p *Profile) *int { return &p.Policy.MinRsaBits }), func(p *Profile) any { return p.Policy.MinRsaBits }},
+	{"policy.allowed_curves", "", setStrings(func(p *Profile) *[]string { return &p.Policy.AllowedCurves }), func(p *Profile) any { return p.Policy.AllowedCurves }},
+	{"policy.allowed_hashes", "", setStrings(func(p *Profile) *[]string { return &p.Policy.AllowedHashes }), func(p *Profile) any { return p.Policy.AllowedHashes }},
+}
+
+func setString(field func(*Profile) *string) func(*Profile, any) error {
+	return func(p *Profile, v any) error {
+		s, ok := v.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		*field(p) = s
+		return nil
+	}
+}
+
+func setInt(field func(*Profile) *int) func(*Profile, any) error {
+	return func(p *Profile, v any) error {
+		switch v := v.(type) {
+		case int64:
+			*field(p) = int(v)
+		case string:
+			// Environment variables and flags arrive as text.
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return errors.New("must be an integer")
+			}
+			*field(p) = n
+		default:
+			return errors.New("must be an integer")
+		}
+		return nil
+	}
+}
+
+func setStrings(field func(*Profile) *[]string) func(*Profile, any) error {
+	return func(p *Profile, v any) error {
+		items, ok := v.([]any)
+		if !ok {
+			return errors.New("must be an array of strings")
+		}
+		out := make([]string, len(items))
+		for i, item := range items {
+			if out[i], ok = item.(string); !ok {
+				return errors.New("must be an array of strings")
+			}
+		}
+		*field(p) = out
+		return nil
+	}
+}
+
+// commandFlags maps each command in this repository to the flags it
+// accepts and the profile setting each flag takes its value from.
+var commandFlags = map[string][][2]string{
+	"sign": {{"curve", "curve"}, {"hash", "hash"}, {"format", "format"}},
+	"keys": {{"curve", "curve"}, {"store", "key_store"}, {"format", "format"}},
+	"rsa":  {{"bits", "rsa_bits"}, {"format", "format"}},
+	"aes":  {{"in", "input"}, {"out", "output"}},
+}
+
+// CommandFlags returns the flags of command and the setting each one
+// takes its value from.
+func CommandFlags(command string) ([][2]string, bool) {
+	m, ok := commandFlags[command]
+	return m, ok
+}
+
+// ParseConfig builds a Config from TOML or YAML source. Every profile
+// starts from the built-in defaults; unknown keys and wrongly typed
+// values are errors.
+func ParseConfig(src, format string) (*Config, error) {
+	parse := parseTOML
+	switch format {
+	case "toml":
+	case "yaml":
+		parse = parseYAML
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+	doc, lines, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Active: "default", Profiles: map[string]*Profile{}, Source: map[string]string{}}
+	for key, v := range doc {
+		switch key {
+		case "profile":
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("line %d: profile must be a string", lines[key])
+			}
+			cfg.Active = s
+		case "profiles":
+			table, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("line %d: profiles must be a table", lines[key])
+			}
+			for name, body := range table {
+				p, err := parseProfile("profiles."+name, body, lines)
+				if err != nil {
+					return nil, err
+				}
+				cfg.Profiles[name] = p
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", lines[key], key)
+		}
+	}
+	if _, ok := cfg.Profiles["default"]; !ok {
+		cfg.Profiles["default"] = defaultProfile()
+	}
+	return cfg, nil
+}
+
+func parseProfile(path string, body any, lines map[string]int) (*Profile, error) {
+	p := defaultProfile()
+	values := map[string]any{}
+	if err := flatten("", body, values); err != nil {
+		return nil, fmt.Errorf("line %d: %s: %v", lines[path], path, err)
+	}
+	for key, v := range values {
+		i := slices.IndexFunc(settings, func(s Setting) bool { return s.Key == key })
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: unknown key %q", lines[path+"."+key], path+"."+key)
+		}
+		if err := settings[i].set(p, v); err != nil {
+			return nil, fmt.Errorf("line %d: %s.%s %v", lines[path+"."+key], path, key, err)
+		}
+	}
+	return p, nil
+}
+
+func flatten(prefix string, v any, out map[string]any) error {
+	table, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("must be a table")
+	}
+	for k, v := range table {
+		if sub, ok := v.(map[string]any); ok {
+			if err := flatten(prefix+k+".", sub, out); err != nil {
+				return err
+			}
+			continue
+		}
+		out[prefix+k] = v
+	}
+	return nil
+}
+
+// ConfigPath returns the file named by $CRYPTO_CONFIG, or the first of
+// crypto.toml, crypto.yaml and crypto.yml in the working directory.
+func ConfigPath() string {
+	if path := os.Getenv("CRYPTO_CONFIG"); path != "" {
+		return path
+	}
+	for _, name := range []string{"crypto.toml", "crypto.yaml", "crypto.yml"} {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return "crypto.toml"
+}
+
+// LoadConfig reads the configuration file at path, as YAML if its name
+// ends in .yaml or .yml and as TOML otherwise. A missing file yields the
+// built-in default profile so the commands keep working without one.
+func LoadConfig(path string) (*Config, error) {
+	format := "toml"
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		format = "yaml"
+	}
+	src, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ParseConfig("", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(string(src), format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadProfile loads the configuration from ConfigPath and returns the
+// profile named by $CRYPTO_PROFILE, or the file's active profile, with
+// environment overrides and command's defaults applied. Commands use
+// its values as their flag defaults; the result is not validated until
+// ResolveFlags.
+func LoadProfile(command string) (*Config, *Profile, error) {
+	cfg, err := LoadConfig(ConfigPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.Command = command
+	cfg.Selected = os.Getenv("CRYPTO_PROFILE")
+	if cfg.Selected == "" {
+		cfg.Selected = cfg.Active
+	}
+	p, err := cfg.resolve(cfg.Selected, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, p, nil
+}
+
+// ResolveFlags applies the command-line flags that were set for the
+// command (see commandFlags) on top of the selected profile and
+// validates the result against the profile's policy.
+func (c *Config) ResolveFlags() (*Profile, error) {
+	overrides := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		for _, m := range commandFlags[c.Command] {
+			if m[0] == f.Name {
+				overrides[m[1]] = f.Value.String()
+			}
+		}
+	})
+	return c.Resolve(c.Selected, overrides)
+}
+
+// Resolve selects the active profile and applies environment and flag
+// overrides on top of it, in that order, then the defaults of
+// c.Command for whatever is still unset, and validates the result.
+func (c *Config) Resolve(profile string, flags map[string]string) (*Profile, error) {
+	p, err := c.resolve(profile, flags)
+	if err != nil {
+		return nil, err
+	}
+	return p, p.Validate()
+}
+
+func (c *Config) resolve(profile string, flags map[string]string) (*Profile, error) {
+	if profile == "" {
+		profile = c.Active
+	}
+	base, ok := c.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %q is not defined", profile)
+	}
+	p := *base
+	for _, s := range settings {
+		c.Source[s.Key] = "profile " + profile
+		if v, ok := os.LookupEnv(s.Env); ok && s.Env != "" {
+			if err := s.set(&p, v); err != nil {
+				return nil, fmt.Errorf("%s: %v", s.Env, err)
+			}
+			c.Source[s.Key] = "env " + s.Env
+		}
+		if v, ok := flags[s.Key]; ok {
+			if err := s.set(&p, v); err != nil {
+				return nil, fmt.Errorf("-%s: %v", s.Key, err)
+			}
+			c.Source[s.Key] = "flag"
+		}
+		if v, ok := commandDefaults[c.Command][s.Key]; ok && s.get(&p) == "" {
+			s.set(&p, v)
+			c.Source[s.Key] = "default for " + c.Command
+		}
+	}
+	return &p, nil
+}
+
+// Validate checks the profile against the schema and its own policy.
+func (p *Profile) Validate() error {
+	var errs []error
+	for _, c := range p.Policy.AllowedCurves {
+		if !slices.Contains(knownCurves, c) {
+			errs = append(errs, fmt.Errorf("policy.allowed_curves: unknown curve %q", c))
+		}
+	}
+	for _, h := range p.Policy.AllowedHashes {
+		if !slices.Contains(digest.Algorithms(), h) {
+			errs = append(errs, fmt.Errorf("policy.allowed_hashes: unknown hash %q", h))
+		}
+	}
+	// An empty curve stands for the defaults of sign/ and keys/, which
+	// are checked when a command resolves the profile.
+	if p.Curve != "" && !slices.Contains(p.Policy.AllowedCurves, p.Curve) {
+		errs = append(errs, fmt.Errorf("curve %q is not allowed (allowed: %s)", p.Curve, strings.Join(p.Policy.AllowedCurves, ", ")))
+	}
+	if !slices.Contains(p.Policy.AllowedHashes, p.Hash) {
+		errs = append(errs, fmt.Errorf("hash %q is not allowed (allowed: %s)", p.Hash, strings.Join(p.Policy.AllowedHashes, ", ")))
+	}
+	if p.RsaBits < p.Policy.MinRsaBits || p.RsaBits > 8192 || p.RsaBits%8 != 0 {
+		errs = append(errs, fmt.Errorf("rsa_bits %d must be a multiple of 8 between %d and 8192", p.RsaBits, p.Policy.MinRsaBits))
+	}
+	if p.Policy.MinRsaBits < 1024 {
+		errs = append(errs, errors.New("policy.min_rsa_bits must be at least 1024"))
+	}
+	if p.Input == "" || p.Output == "" {
+		errs = append(errs, errors.New("input and output must not be empty"))
+	}
+	if !slices.Contains(knownFormats, p.Format) {
+		errs = append(errs, fmt.Errorf("format %q must be one of %s", p.Format, strings.Join(knownFormats, ", ")))
+	}
+	return errors.Join(errs...)
+}
+
+// ProfileNames returns the defined profiles in sorted order.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
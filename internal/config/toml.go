@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML the configuration needs: comments,
+// [dotted.table] headers, and key = value pairs whose values are strings,
+// integers, booleans or single-line arrays of those. Tables are returned
+// as nested maps; lines records where each dotted key was defined.
+func parseTOML(src string) (root map[string]any, lines map[string]int, err error) {
+	root = map[string]any{}
+	lines = map[string]int{}
+	table, prefix := root, ""
+	for i, line := range strings.Split(src, "\n") {
+		n := i + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, nil, fmt.Errorf("line %d: unterminated table header", n)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if table, err = subtable(root, name); err != nil {
+				return nil, nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			prefix = name + "."
+			lines[name] = n
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key = strings.TrimSpace(key)
+		if !isBareKey(key) {
+			return nil, nil, fmt.Errorf("line %d: invalid key %q", n, key)
+		}
+		if _, dup := table[key]; dup {
+			return nil, nil, fmt.Errorf("line %d: duplicate key %q", n, prefix+key)
+		}
+		v, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %s: %v", n, prefix+key, err)
+		}
+		table[key] = v
+		lines[prefix+key] = n
+	}
+	return root, lines, nil
+}
+
+func subtable(root map[string]any, name string) (map[string]any, error) {
+	t := root
+	for _, part := range strings.Split(name, ".") {
+		part = strings.TrimSpace(part)
+		if !isBareKey(part) {
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
+		next, ok := t[part]
+		if !ok {
+			next = map[string]any{}
+			t[part] = next
+		}
+		if t, ok = next.(map[string]any); !ok {
+			return nil, fmt.Errorf("%q is not a table", name)
+		}
+	}
+	return t, nil
+}
+
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// stripComment removes a trailing # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0 && c == quote && !(quote == '"' && escaped(line, i)):
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func escaped(s string, i int) bool {
+	n := 0
+	for i--; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func parseValue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' || strings.Contains(s[1:len(s)-1], "'") {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s[0] == '[':
+		return parseArray(s)
+	}
+	v, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value %s", s)
+	}
+	return v, nil
+}
+
+func parseArray(s string) ([]any, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("arrays must be on a single line")
+	}
+	body := strings.TrimSpace(s[1 : len(s)-1])
+	var items []any
+	for body != "" {
+		end := itemEnd(body)
+		v, err := parseValue(strings.TrimSpace(body[:end]))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		body = strings.TrimSpace(body[end:])
+		body = strings.TrimSpace(strings.TrimPrefix(body, ","))
+	}
+	return items, nil
+}
+
+// itemEnd returns the index of the comma ending the first array item.
+func itemEnd(s string) int {
+	var quote rune
+	for i, c := range s {
+		switch {
+		case quote != 0 && c == quote && !(quote == '"' && escaped(s, i)):
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			return i
+		}
+	}
+	return len(s)
+}
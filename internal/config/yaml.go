@@ -0,0 +1,175 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the subset of YAML the configuration needs: comments,
+// nested block mappings, block and flow sequences of scalars, and plain,
+// quoted, integer and boolean scalars. It returns the same nested maps
+// as parseTOML, so both formats go through the same schema checks.
+// Anchors, tags, multi-line scalars and flow mappings are rejected.
+func parseYAML(src string) (map[string]any, map[string]int, error) {
+	p := &yamlParser{lines: map[string]int{}}
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(stripComment(line), " \r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" && len(p.src) == 0 {
+			continue
+		}
+		if text[0] == '\t' {
+			return nil, nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		p.src = append(p.src, yamlLine{n: i + 1, indent: len(line) - len(text), text: text})
+	}
+	if len(p.src) == 0 {
+		return map[string]any{}, p.lines, nil
+	}
+	root, err := p.mapping(p.src[0].indent, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, nil, fmt.Errorf("line %d: unexpected indentation", p.src[p.pos].n)
+	}
+	return root, p.lines, nil
+}
+
+type yamlLine struct {
+	n, indent int
+	text      string
+}
+
+type yamlParser struct {
+	src   []yamlLine
+	pos   int
+	lines map[string]int
+}
+
+func isItem(text string) bool { return text == "-" || strings.HasPrefix(text, "- ") }
+
+func (p *yamlParser) mapping(indent int, prefix string) (map[string]any, error) {
+	table := map[string]any{}
+	for p.pos < len(p.src) {
+		l := p.src[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.n)
+		}
+		key, raw, ok := strings.Cut(l.text, ":")
+		if !ok || isItem(l.text) || raw != "" && raw[0] != ' ' {
+			return nil, fmt.Errorf("line %d: expected key: value", l.n)
+		}
+		if !isBareKey(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", l.n, key)
+		}
+		if _, dup := table[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.n, prefix+key)
+		}
+		p.lines[prefix+key] = l.n
+		p.pos++
+		if raw = strings.TrimSpace(raw); raw != "" {
+			v, err := yamlScalar(raw)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %v", l.n, prefix+key, err)
+			}
+			table[key] = v
+			continue
+		}
+		// The value is the block that follows: a sequence, which may sit
+		// at the key's own indentation, or a more indented mapping.
+		var err error
+		switch next := p.peek(); {
+		case next != nil && isItem(next.text) && next.indent >= indent:
+			table[key], err = p.sequence(next.indent, prefix+key)
+		case next != nil && next.indent > indent:
+			table[key], err = p.mapping(next.indent, prefix+key+".")
+		default:
+			return nil, fmt.Errorf("line %d: %s: missing value", l.n, prefix+key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+func (p *yamlParser) sequence(indent int, key string) ([]any, error) {
+	var items []any
+	for p.pos < len(p.src) {
+		l := p.src[p.pos]
+		if l.indent != indent || !isItem(l.text) {
+			break
+		}
+		v, err := yamlScalar(strings.TrimSpace(l.text[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", l.n, key, err)
+		}
+		items = append(items, v)
+		p.pos++
+	}
+	return items, nil
+}
+
+func (p *yamlParser) peek() *yamlLine {
+	if p.pos == len(p.src) {
+		return nil
+	}
+	return &p.src[p.pos]
+}
+
+func yamlScalar(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, errors.New("missing value")
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case s == "~" || s == "null":
+		return nil, errors.New("null values are not supported")
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s[0] == '[':
+		return yamlFlowSequence(s)
+	case strings.ContainsAny(s[:1], "{&*!|>%@`") || strings.Contains(s, ": "):
+		return nil, fmt.Errorf("unsupported value %s", s)
+	}
+	if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return v, nil
+	}
+	return s, nil
+}
+
+func yamlFlowSequence(s string) ([]any, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, errors.New("flow sequences must be on a single line")
+	}
+	body := strings.TrimSpace(s[1 : len(s)-1])
+	var items []any
+	for body != "" {
+		end := itemEnd(body)
+		v, err := yamlScalar(strings.TrimSpace(body[:end]))
+		if err != nil {
+			return nil, err
+		}
+		if _, nested := v.([]any); nested {
+			return nil, errors.New("nested sequences are not supported")
+		}
+		items = append(items, v)
+		body = strings.TrimSpace(strings.TrimPrefix(body[end:], ","))
+	}
+	return items, nil
+}
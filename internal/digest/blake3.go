@@ -0,0 +1,308 @@
+package digest
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// BLAKE3 with a 32-byte digest. The incremental hasher follows the
+// reference implementation; sumBlake3File splits large files into
+// aligned subtrees and hashes them on all CPUs.
+
+const (
+	blake3ChunkLen = 1024
+	blake3BlockLen = 64
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+
+	// Chunks hashed per worker task when hashing a file in parallel.
+	// Must be a power of two so every full batch is a subtree.
+	blake3BatchChunks = 1024
+)
+
+var blake3IV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var blake3Perm = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	v := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	g := func(a, b, c, d int, x, y uint32) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft32(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft32(v[b]^v[c], -12)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft32(v[d]^v[a], -8)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft32(v[b]^v[c], -7)
+	}
+	for r := 0; r < 7; r++ {
+		g(0, 4, 8, 12, m[0], m[1])
+		g(1, 5, 9, 13, m[2], m[3])
+		g(2, 6, 10, 14, m[4], m[5])
+		g(3, 7, 11, 15, m[6], m[7])
+		g(0, 5, 10, 15, m[8], m[9])
+		g(1, 6, 11, 12, m[10], m[11])
+		g(2, 7, 8, 13, m[12], m[13])
+		g(3, 4, 9, 14, m[14], m[15])
+		if r < 6 {
+			var p [16]uint32
+			for i, j := range blake3Perm {
+				p[i] = m[j]
+			}
+			m = p
+		}
+	}
+	for i := 0; i < 8; i++ {
+		v[i] ^= v[i+8]
+		v[i+8] ^= cv[i]
+	}
+	return v
+}
+
+func blake3Words(b []byte) (w [16]uint32) {
+	var buf [blake3BlockLen]byte
+	copy(buf[:], b)
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+	return w
+}
+
+// blake3Output is a node whose compression has not yet been finalized,
+// so the caller can choose between a chaining value and the root hash.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o blake3Output) chainingValue() (cv [8]uint32) {
+	out := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], out[:8])
+	return cv
+}
+
+func (o blake3Output) root() []byte {
+	out := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	sum := make([]byte, 32)
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(sum[4*i:], out[i])
+	}
+	return sum
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+type blake3Chunk struct {
+	cv      [8]uint32
+	counter uint64
+	buf     [blake3BlockLen]byte
+	bufLen  int
+	blocks  int
+}
+
+func newBlake3Chunk(counter uint64) blake3Chunk {
+	return blake3Chunk{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3Chunk) len() int { return c.blocks*blake3BlockLen + c.bufLen }
+
+func (c *blake3Chunk) startFlag() uint32 {
+	if c.blocks == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3Chunk) update(p []byte) {
+	for len(p) > 0 {
+		if c.bufLen == blake3BlockLen {
+			w := blake3Words(c.buf[:])
+			out := blake3Compress(&c.cv, &w, c.counter, blake3BlockLen, c.startFlag())
+			copy(c.cv[:], out[:8])
+			c.blocks++
+			c.bufLen = 0
+		}
+		n := copy(c.buf[c.bufLen:], p)
+		c.bufLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3Chunk) output() blake3Output {
+	return blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(c.buf[:c.bufLen]),
+		counter:  c.counter,
+		blockLen: uint32(c.bufLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// blake3Hasher is the incremental hash.Hash form of BLAKE3.
+type blake3Hasher struct {
+	chunk blake3Chunk
+	stack [][8]uint32
+}
+
+func newBlake3() *blake3Hasher {
+	return &blake3Hasher{chunk: newBlake3Chunk(0)}
+}
+
+func (h *blake3Hasher) Size() int      { return 32 }
+func (h *blake3Hasher) BlockSize() int { return blake3BlockLen }
+
+func (h *blake3Hasher) Reset() {
+	h.chunk = newBlake3Chunk(0)
+	h.stack = h.stack[:0]
+}
+
+func (h *blake3Hasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if h.chunk.len() == blake3ChunkLen {
+			cv := h.chunk.output().chainingValue()
+			total := h.chunk.counter + 1
+			// Merge completed subtrees: one merge per trailing zero bit
+			// of the number of chunks seen so far.
+			for total&1 == 0 {
+				top := h.stack[len(h.stack)-1]
+				h.stack = h.stack[:len(h.stack)-1]
+				cv = blake3ParentOutput(top, cv).chainingValue()
+				total >>= 1
+			}
+			h.stack = append(h.stack, cv)
+			h.chunk = newBlake3Chunk(h.chunk.counter + 1)
+		}
+		n := blake3ChunkLen - h.chunk.len()
+		if n > len(p) {
+			n = len(p)
+		}
+		h.chunk.update(p[:n])
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (h *blake3Hasher) Sum(in []byte) []byte {
+	out := h.chunk.output()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		out = blake3ParentOutput(h.stack[i], out.chainingValue())
+	}
+	return append(in, out.root()...)
+}
+
+// blake3Subtree hashes the chunks of data, whose first chunk has index
+// counter, and returns the unfinalized top node of that subtree.
+func blake3Subtree(data []byte, counter uint64) blake3Output {
+	if len(data) <= blake3ChunkLen {
+		c := newBlake3Chunk(counter)
+		c.update(data)
+		return c.output()
+	}
+	left := blake3LeftLen(len(data))
+	l := blake3Subtree(data[:left], counter).chainingValue()
+	r := blake3Subtree(data[left:], counter+uint64(left/blake3ChunkLen)).chainingValue()
+	return blake3ParentOutput(l, r)
+}
+
+// blake3LeftLen returns the size of the left subtree for an input of n
+// bytes: the largest power-of-two number of chunks leaving at least one
+// byte for the right.
+func blake3LeftLen(n int) int {
+	chunks := (n - 1) / blake3ChunkLen
+	return blake3ChunkLen << (bits.Len(uint(chunks)) - 1)
+}
+
+// blake3MergeCVs joins the chaining values of equal, aligned subtrees
+// (the last may be smaller) with the same left-heavy shape as the tree.
+func blake3MergeCVs(cvs [][8]uint32) blake3Output {
+	left := 1 << (bits.Len(uint(len(cvs)-1)) - 1)
+	return blake3ParentOutput(blake3MergeCV(cvs[:left]), blake3MergeCV(cvs[left:]))
+}
+
+func blake3MergeCV(cvs [][8]uint32) [8]uint32 {
+	if len(cvs) == 1 {
+		return cvs[0]
+	}
+	return blake3MergeCVs(cvs).chainingValue()
+}
+
+// sumBlake3File hashes the file at path, reading and hashing batches of
+// chunks concurrently.
+func sumBlake3File(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	const batch = blake3BatchChunks * blake3ChunkLen
+	size := fi.Size()
+	if size <= batch {
+		h := newBlake3()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	n := int((size + batch - 1) / batch)
+	cvs := make([][8]uint32, n)
+	errs := make([]error, n)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, batch)
+			for i := range jobs {
+				off := int64(i) * batch
+				m, err := f.ReadAt(buf, off)
+				if err != nil && err != io.EOF {
+					errs[i] = err
+					continue
+				}
+				cvs[i] = blake3Subtree(buf[:m], uint64(off/blake3ChunkLen)).chainingValue()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blake3MergeCVs(cvs).root(), nil
+}
@@ -0,0 +1,112 @@
+// Package digest names the hash algorithms the commands in this
+// repository support and computes digests with them. The identifiers
+// are stored next to signatures, so a verifier recomputes the digest with
+// the same algorithm the signer used, and they are the values a
+// configuration profile's hash and policy.allowed_hashes may take.
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+)
+
+// Hash algorithm identifiers.
+const (
+	SHA256     = "sha256"
+	SHA384     = "sha384"
+	SHA512     = "sha512"
+	SHA3_256   = "sha3-256"
+	SHA3_384   = "sha3-384"
+	SHA3_512   = "sha3-512"
+	SHAKE128   = "shake128"
+	SHAKE256   = "shake256"
+	BLAKE2b256 = "blake2b-256"
+	BLAKE2b512 = "blake2b-512"
+	BLAKE2s256 = "blake2s-256"
+	BLAKE3     = "blake3"
+)
+
+var hashes = map[string]func() hash.Hash{
+	SHA256:     sha256.New,
+	SHA384:     sha512.New384,
+	SHA512:     sha512.New,
+	SHA3_256:   func() hash.Hash { return sha3.New256() },
+	SHA3_384:   func() hash.Hash { return sha3.New384() },
+	SHA3_512:   func() hash.Hash { return sha3.New512() },
+	SHAKE128:   func() hash.Hash { return &shakeHash{sha3.NewSHAKE128(), 32} },
+	SHAKE256:   func() hash.Hash { return &shakeHash{sha3.NewSHAKE256(), 64} },
+	BLAKE2b256: func() hash.Hash { h, _ := newBlake2b(32); return h },
+	BLAKE2b512: func() hash.Hash { h, _ := newBlake2b(64); return h },
+	BLAKE2s256: func() hash.Hash { h, _ := newBlake2s(32); return h },
+	BLAKE3:     func() hash.Hash { return newBlake3() },
+}
+
+// Algorithms returns the supported identifiers in sorted order.
+func Algorithms() []string {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func New(alg string) (hash.Hash, error) {
+	f, ok := hashes[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", alg)
+	}
+	return f(), nil
+}
+
+func Sum(alg string, data []byte) ([]byte, error) {
+	h, err := New(alg)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// SumFile digests the file at path. BLAKE3 files are hashed as a tree
+// across all CPUs; other algorithms stream the file.
+func SumFile(alg, path string) ([]byte, error) {
+	if alg == BLAKE3 {
+		return sumBlake3File(path)
+	}
+	h, err := New(alg)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// shakeHash adapts an extendable-output function to hash.Hash by reading
+// a fixed number of output bytes.
+type shakeHash struct {
+	*sha3.SHAKE
+	size int
+}
+
+func (s *shakeHash) Size() int { return s.size }
+
+func (s *shakeHash) Sum(in []byte) []byte {
+	c := *s.SHAKE
+	out := make([]byte, s.size)
+	c.Read(out)
+	return append(in, out...)
+}
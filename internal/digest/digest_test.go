@@ -0,0 +1,155 @@
+package digest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Inputs are the bytes 0, 1, ..., 250, 0, 1, ... as in the BLAKE3 test
+// vectors.
+func testInput(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// hashInPieces writes data in uneven pieces so block and chunk
+// boundaries fall inside a write as well as between writes.
+func hashInPieces(t *testing.T, alg string, data []byte) []byte {
+	h, err := New(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := []int{1, 7, 64, 65, 127, 1023, 1025, 3000}
+	for i := 0; len(data) > 0; i++ {
+		n := min(sizes[i%len(sizes)], len(data))
+		h.Write(data[:n])
+		data = data[n:]
+	}
+	return h.Sum(nil)
+}
+
+func TestBLAKE2(t *testing.T) {
+	tests := []struct {
+		alg  string
+		in   []byte
+		want string
+	}{
+		// RFC 7693, appendices A and B.
+		{BLAKE2b512, []byte("abc"), "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{BLAKE2s256, []byte("abc"), "508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"},
+		// Around the block sizes (64 and 128 bytes), from Python's hashlib.
+		{BLAKE2b512, testInput(0), "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{BLAKE2b512, testInput(127), "b6292669ccd38d5f01caae96ba272c76a879a45743afa0725d83b9ebb26665b731f1848c52f11972b6644f554c064fa90780dbbbf3a89d4fc31f67df3e5857ef"},
+		{BLAKE2b512, testInput(128), "2319e3789c47e2daa5fe807f61bec2a1a6537fa03f19ff32e87eecbfd64b7e0e8ccff439ac333b040f19b0c4ddd11a61e24ac1fe0f10a039806c5dcc0da3d115"},
+		{BLAKE2b512, testInput(129), "f59711d44a031d5f97a9413c065d1e614c417ede998590325f49bad2fd444d3e4418be19aec4e11449ac1a57207898bc57d76a1bcf3566292c20c683a5c4648f"},
+		{BLAKE2b512, testInput(1000), "c11e1c0340bd7e5a1b275f1230c962fad215ecb1391486e74e31b960a2f2996381a5fad092da06841d5f26e38f6ecfeaf441acbcd1c2de61aef121e7927175f5"},
+		{BLAKE2b256, testInput(0), "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{BLAKE2b256, testInput(128), "c3582f71ebb2be66fa5dd750f80baae97554f3b015663c8be377cfcb2488c1d1"},
+		{BLAKE2b256, testInput(256), "582f782226018ec33076bd8d1c42413530ac7e1126260ffc0f306ba3befc3f24"},
+		{BLAKE2s256, testInput(0), "69217a3079908094e11121d042354a7c1f55b6482ca1a51e1b250dfd1ed0eef9"},
+		{BLAKE2s256, testInput(63), "e57cb79487dd57902432b250733813bd96a84efce59f650fac26e6696aefafc3"},
+		{BLAKE2s256, testInput(64), "56f34e8b96557e90c1f24b52d0c89d51086acf1b00f634cf1dde9233b8eaaa3e"},
+		{BLAKE2s256, testInput(65), "1b53ee94aaf34e4b159d48de352c7f0661d0a40edff95a0b1639b4090e974472"},
+		{BLAKE2s256, testInput(1000), "1c067a5e746fb0f6734efac9a8cdb0e11061f0077f255184365c690115392501"},
+	}
+	for _, tt := range tests {
+		got, err := Sum(tt.alg, tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("%s of %d bytes: got %x", tt.alg, len(tt.in), got)
+		}
+		if got := hashInPieces(t, tt.alg, tt.in); hex.EncodeToString(got) != tt.want {
+			t.Errorf("%s of %d bytes in pieces: got %x", tt.alg, len(tt.in), got)
+		}
+	}
+}
+
+// testdata/blake3-vectors.json holds the cases of the BLAKE3 team's
+// test_vectors.json as shipped with lukechampine.com/blake3. Only the
+// unkeyed hash is supported here, so only the first 32 bytes of "hash"
+// are checked.
+func TestBLAKE3Vectors(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "blake3-vectors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors struct {
+		Cases []struct {
+			InputLen int    `json:"input_len"`
+			Hash     string `json:"hash"`
+		} `json:"cases"`
+	}
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors.Cases) == 0 {
+		t.Fatal("no test vectors")
+	}
+	for _, c := range vectors.Cases {
+		in := testInput(c.InputLen)
+		want := c.Hash[:64]
+		got, err := Sum(BLAKE3, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != want {
+			t.Errorf("%d bytes: got %x, want %s", c.InputLen, got, want)
+		}
+		if got := hashInPieces(t, BLAKE3, in); hex.EncodeToString(got) != want {
+			t.Errorf("%d bytes in pieces: got %x, want %s", c.InputLen, got, want)
+		}
+	}
+}
+
+// SumFile hashes files of more than one batch (blake3BatchChunks chunks)
+// as a tree of batches on all CPUs. These sizes end on, just past and
+// just short of batch and chunk boundaries; the digests are from
+// lukechampine.com/blake3.
+func TestBLAKE3File(t *testing.T) {
+	const batch = blake3BatchChunks * blake3ChunkLen
+	if batch != 1<<20 {
+		t.Fatalf("batch is %d bytes; the sizes below assume 1 MiB", batch)
+	}
+	tests := []struct {
+		size int
+		want string
+	}{
+		{1 << 20, "74cb441fd087764ca9c3694da742ebe30cbeb3060a17009ca81825c7a8d10343"},
+		{1<<20 + 1, "2f053cd7472cf0cd2f9adaf45c1180255b91b9a865404a63671a0ee5f792ed33"},
+		{2<<20 + 1024, "9fa22e1f0f1cdf5b8523d388b65eaa0d18b071c539b91af24ed36518539e58aa"},
+		{3<<20 + 1025, "0f28f2b0a420e6f867b062268346a4fd56ecdaa3f16f0cccada991d3b850025a"},
+		{4 << 20, "4e94e6f582581a0f3855f3ce504b153e951e65036fe9e2f010b7e25473c54f98"},
+		{5<<20 - 1, "5da523926538ea1f0616411185e0d9940499cb1a949ed3b052c20a1db78e970b"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		in := testInput(tt.size)
+		path := filepath.Join(dir, "input")
+		if err := os.WriteFile(path, in, 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := SumFile(BLAKE3, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("file of %d bytes: got %x, want %s", tt.size, got, tt.want)
+		}
+		streamed, err := Sum(BLAKE3, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(streamed, got) {
+			t.Errorf("%d bytes: incremental hash %x differs from file hash %x", tt.size, streamed, got)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package keyfile
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Typed keys. Each holds its metadata and only has the operations of its
+// purpose, and each operation checks the key's usages, algorithms and
+// expiry first. Keys are created and loaded through a KeyRing, which
+// rejects key material that is already in use for another purpose.
+
+// SigningKey is an ECDSA or RSA private key.
+type SigningKey struct {
+	key  crypto.Signer // *ecdsa.PrivateKey or *rsa.PrivateKey
+	meta KeyMetadata
+}
+
+type VerifyingKey struct {
+	key  crypto.PublicKey // *ecdsa.PublicKey or *rsa.PublicKey
+	meta KeyMetadata
+}
+
+// DecryptionKey is the private half of an RSA-OAEP key pair.
+type DecryptionKey struct {
+	key  *rsa.PrivateKey
+	meta KeyMetadata
+}
+
+type EncryptionKey struct {
+	key  *rsa.PublicKey
+	meta KeyMetadata
+}
+
+// AgreementKey derives shared secrets with ECDH.
+type AgreementKey struct {
+	key  *ecdh.PrivateKey
+	meta KeyMetadata
+}
+
+// SecretKey is an AES key for encryption and key wrapping.
+type SecretKey struct {
+	key  []byte
+	meta KeyMetadata
+}
+
+// NewSigningKey admits key with meta. The key id defaults to one derived
+// from the public key.
+func (r *KeyRing) NewSigningKey(key crypto.Signer, meta KeyMetadata) (*SigningKey, error) {
+	switch key.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	if err := r.admit(key, &meta, purposeSignature); err != nil {
+		return nil, err
+	}
+	return &SigningKey{key, meta}, nil
+}
+
+func (r *KeyRing) NewVerifyingKey(key crypto.PublicKey, meta KeyMetadata) (*VerifyingKey, error) {
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported verifying key type %T", key)
+	}
+	if err := r.admit(key, &meta, purposeSignature); err != nil {
+		return nil, err
+	}
+	return &VerifyingKey{key, meta}, nil
+}
+
+func (r *KeyRing) NewDecryptionKey(key *rsa.PrivateKey, meta KeyMetadata) (*DecryptionKey, error) {
+	if err := r.admit(key, &meta, purposeEncryption); err != nil {
+		return nil, err
+	}
+	return &DecryptionKey{key, meta}, nil
+}
+
+func (r *KeyRing) NewEncryptionKey(key *rsa.PublicKey, meta KeyMetadata) (*EncryptionKey, error) {
+	if err := r.admit(key, &meta, purposeEncryption); err != nil {
+		return nil, err
+	}
+	return &EncryptionKey{key, meta}, nil
+}
+
+// NewAgreementKey accepts an *ecdh.PrivateKey or an *ecdsa.PrivateKey,
+// which is converted; the ring still rejects it if the same key is used
+// for signatures.
+func (r *KeyRing) NewAgreementKey(key crypto.PrivateKey, meta KeyMetadata) (*AgreementKey, error) {
+	var k *ecdh.PrivateKey
+	switch key := key.(type) {
+	case *ecdh.PrivateKey:
+		k = key
+	case *ecdsa.PrivateKey:
+		var err error
+		if k, err = key.ECDH(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported agreement key type %T", key)
+	}
+	if err := r.admit(k, &meta, purposeAgreement); err != nil {
+		return nil, err
+	}
+	return &AgreementKey{k, meta}, nil
+}
+
+// NewSecretKey admits an AES key. Its id defaults to a random one, so
+// the id does not reveal anything about the key.
+func (r *KeyRing) NewSecretKey(key []byte, meta KeyMetadata) (*SecretKey, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("AES keys are 16, 24 or 32 bytes")
+	}
+	if meta.ID == "" {
+		id := make([]byte, 8)
+		rand.Read(id)
+		meta.ID = hex.EncodeToString(id)
+	}
+	if err := r.admit(key, &meta, purposeEncryption); err != nil {
+		return nil, err
+	}
+	return &SecretKey{key, meta}, nil
+}
+
+func (k *SigningKey) Metadata() KeyMetadata    { return k.meta }
+func (k *VerifyingKey) Metadata() KeyMetadata  { return k.meta }
+func (k *DecryptionKey) Metadata() KeyMetadata { return k.meta }
+func (k *EncryptionKey) Metadata() KeyMetadata { return k.meta }
+func (k *AgreementKey) Metadata() KeyMetadata  { return k.meta }
+func (k *SecretKey) Metadata() KeyMetadata     { return k.meta }
+
+// Public returns the public half, with the usages mapped to their public
+// counterparts.
+func (k *SigningKey) Public() *VerifyingKey {
+	return &VerifyingKey{k.key.Public(), k.meta.publicMetadata()}
+}
+
+func (k *DecryptionKey) Public() *EncryptionKey {
+	return &EncryptionKey{&k.key.PublicKey, k.meta.publicMetadata()}
+}
+
+func (k *AgreementKey) PublicKey() *ecdh.PublicKey { return k.key.PublicKey() }
+
+// Fingerprint identifies the key material whatever its Key-Id header
+// says: the SHA-256 of the public key, in hex.
+func (k *VerifyingKey) Fingerprint() string {
+	_, fp, _ := fingerprint(k.key) // checked by the ring
+	return fp
+}
+
+// SignHash signs a SHA-256 hash with alg if the key may sign now.
+func (k *SigningKey) SignHash(alg string, hash []byte) ([]byte, error) {
+	if len(hash) != sha256.Size {
+		return nil, errors.New("hash is not a SHA-256 hash")
+	}
+	switch key := k.key.(type) {
+	case *ecdsa.PrivateKey:
+		if err := k.meta.check(UsageSign, alg, keyTypeEC, time.Now()); err != nil {
+			return nil, err
+		}
+		return ecdsa.SignASN1(rand.Reader, key, hash)
+	case *rsa.PrivateKey:
+		if err := k.meta.check(UsageSign, alg, keyTypeRSA, time.Now()); err != nil {
+			return nil, err
+		}
+		if alg == AlgRSAPSSSHA256 {
+			return rsa.SignPSS(rand.Reader, key, crypto.SHA256, hash, nil)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash)
+	}
+	return nil, errors.New("unsupported key")
+}
+
+// Sign signs the SHA-256 hash of msg.
+func (k *SigningKey) Sign(alg string, msg []byte) ([]byte, error) {
+	hash := sha256.Sum256(msg)
+	return k.SignHash(alg, hash[:])
+}
+
+func (k *VerifyingKey) VerifyHash(alg string, hash, sig []byte) error {
+	if len(hash) != sha256.Size {
+		return errors.New("hash is not a SHA-256 hash")
+	}
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if err := k.meta.check(UsageVerify, alg, keyTypeEC, time.Now()); err != nil {
+			return err
+		}
+		if !ecdsa.VerifyASN1(key, hash, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if err := k.meta.check(UsageVerify, alg, keyTypeRSA, time.Now()); err != nil {
+			return err
+		}
+		if alg == AlgRSAPSSSHA256 {
+			return rsa.VerifyPSS(key, crypto.SHA256, hash, sig, nil)
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash, sig)
+	}
+	return errors.New("unsupported key")
+}
+
+func (k *VerifyingKey) Verify(alg string, msg, sig []byte) error {
+	hash := sha256.Sum256(msg)
+	return k.VerifyHash(alg, hash[:], sig)
+}
+
+// Encrypt encrypts with RSA-OAEP and SHA-256 if the key may encrypt now.
+func (k *EncryptionKey) Encrypt(alg string, plaintext []byte) ([]byte, error) {
+	if err := k.meta.check(UsageEncrypt, alg, keyTypeRSA, time.Now()); err != nil {
+		return nil, err
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, k.key, plaintext, nil)
+}
+
+func (k *DecryptionKey) Decrypt(alg string, ciphertext []byte) ([]byte, error) {
+	if err := k.meta.check(UsageDecrypt, alg, keyTypeRSA, time.Now()); err != nil {
+		return nil, err
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, k.key, ciphertext, nil)
+}
+
+// Wrap encrypts a secret key. The OAEP label differs from Encrypt's, so
+// a wrapped key cannot be opened with Decrypt or vice versa.
+func (k *EncryptionKey) Wrap(alg string, key *SecretKey) ([]byte, error) {
+	if err := k.meta.check(UsageWrap, alg, keyTypeRSA, time.Now()); err != nil {
+		return nil, err
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, k.key, key.key, []byte(UsageWrap))
+}
+
+// Unwrap recovers a wrapped secret key, which is admitted to r with
+// meta.
+func (k *DecryptionKey) Unwrap(r *KeyRing, alg string, wrapped []byte, meta KeyMetadata) (*SecretKey, error) {
+	if err := k.meta.check(UsageWrap, alg, keyTypeRSA, time.Now()); err != nil {
+		return nil, err
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, k.key, wrapped, []byte(UsageWrap))
+	if err != nil {
+		return nil, err
+	}
+	return r.NewSecretKey(key, meta)
+}
+
+// Derive computes a 32-byte key shared with peer, bound to info.
+func (k *AgreementKey) Derive(alg string, peer *ecdh.PublicKey, info string) ([]byte, error) {
+	keyType := keyTypeEC
+	if k.key.Curve() == ecdh.X25519() {
+		keyType = keyTypeX25519
+	}
+	if err := k.meta.check(UsageDerive, alg, keyType, time.Now()); err != nil {
+		return nil, err
+	}
+	shared, err := k.key.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Key(sha256.New, shared, nil, info, 32)
+}
+
+func (k *SecretKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt returns nonce||ciphertext as in aes/.
+func (k *SecretKey) Encrypt(alg string, plaintext []byte) ([]byte, error) {
+	return k.seal(UsageEncrypt, alg, plaintext)
+}
+
+func (k *SecretKey) Decrypt(alg string, ciphertext []byte) ([]byte, error) {
+	return k.open(UsageDecrypt, UsageEncrypt, alg, ciphertext)
+}
+
+func (k *SecretKey) Wrap(alg string, key *SecretKey) ([]byte, error) {
+	return k.seal(UsageWrap, alg, key.key)
+}
+
+func (k *SecretKey) Unwrap(r *KeyRing, alg string, wrapped []byte, meta KeyMetadata) (*SecretKey, error) {
+	key, err := k.open(UsageWrap, UsageWrap, alg, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return r.NewSecretKey(key, meta)
+}
+
+// seal and open authenticate the operation as additional data, so data
+// encrypted by one operation cannot be opened by another.
+func (k *SecretKey) seal(usage, alg string, plaintext []byte) ([]byte, error) {
+	if err := k.meta.check(usage, alg, keyTypeAES, time.Now()); err != nil {
+		return nil, err
+	}
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, []byte(usage)), nil
+}
+
+func (k *SecretKey) open(usage, op, alg string, ciphertext []byte) ([]byte, error) {
+	if err := k.meta.check(usage, alg, keyTypeAES, time.Now()); err != nil {
+		return nil, err
+	}
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], []byte(op))
+}
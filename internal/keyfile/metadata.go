@@ -0,0 +1,149 @@
+// Package keyfile reads and writes the PEM key files of keys/ and rsa/
+// together with the metadata carried in their headers. Keys are loaded
+// through a KeyRing as typed values that hold their metadata, and every
+// operation checks it, so a key cannot be used without its restrictions
+// or for more than one purpose.
+package keyfile
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PEM header names used for key metadata.
+const (
+	headerKeyID      = "Key-Id"
+	headerCreated    = "Created"
+	headerExpires    = "Expires"
+	headerUsage      = "Usage"
+	headerAlgorithms = "Algorithms"
+	headerOwner      = "Owner"
+)
+
+// Key usages.
+const (
+	UsageSign    = "sign"
+	UsageVerify  = "verify"
+	UsageEncrypt = "encrypt"
+	UsageDecrypt = "decrypt"
+	UsageWrap    = "wrap"
+	UsageDerive  = "derive"
+)
+
+var (
+	ErrKeyExpired = errors.New("key has expired")
+	ErrKeyUsage   = errors.New("key is not allowed for this usage")
+	ErrAlgorithm  = errors.New("key is not allowed for this algorithm")
+)
+
+// KeyMetadata is carried in the headers of an exported PEM block.
+// A zero Expires means the key does not expire. An empty Usage allows
+// nothing: a KeyRing refuses such a key, so keys written without
+// metadata cannot be loaded until they are exported again with one. An
+// empty Algorithms allows every algorithm of the key's purpose that fits
+// its type.
+type KeyMetadata struct {
+	ID         string
+	Created    time.Time
+	Expires    time.Time
+	Usage      []string
+	Algorithms []string
+	Owner      string
+}
+
+func (m KeyMetadata) headers() map[string]string {
+	h := map[string]string{}
+	if m.ID != "" {
+		h[headerKeyID] = m.ID
+	}
+	if !m.Created.IsZero() {
+		h[headerCreated] = m.Created.UTC().Format(time.RFC3339)
+	}
+	if !m.Expires.IsZero() {
+		h[headerExpires] = m.Expires.UTC().Format(time.RFC3339)
+	}
+	if len(m.Usage) > 0 {
+		h[headerUsage] = strings.Join(m.Usage, ", ")
+	}
+	if len(m.Algorithms) > 0 {
+		h[headerAlgorithms] = strings.Join(m.Algorithms, ", ")
+	}
+	if m.Owner != "" {
+		h[headerOwner] = m.Owner
+	}
+	return h
+}
+
+func parseMetadata(h map[string]string) (KeyMetadata, error) {
+	m := KeyMetadata{ID: h[headerKeyID], Owner: h[headerOwner]}
+	var err error
+	if v, ok := h[headerCreated]; ok {
+		if m.Created, err = time.Parse(time.RFC3339, v); err != nil {
+			return m, fmt.Errorf("invalid %s header: %v", headerCreated, err)
+		}
+	}
+	if v, ok := h[headerExpires]; ok {
+		if m.Expires, err = time.Parse(time.RFC3339, v); err != nil {
+			return m, fmt.Errorf("invalid %s header: %v", headerExpires, err)
+		}
+	}
+	m.Usage = splitList(h[headerUsage])
+	m.Algorithms = splitList(h[headerAlgorithms])
+	return m, nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Check reports whether the key may be used for usage with alg at time
+// now.
+func (m KeyMetadata) Check(usage, alg string, now time.Time) error {
+	if !m.Expires.IsZero() && !now.Before(m.Expires) {
+		return fmt.Errorf("key %s: %w on %s", m.ID, ErrKeyExpired, m.Expires.Format(time.RFC3339))
+	}
+	if len(m.Usage) == 0 {
+		return fmt.Errorf("key %s: %w %q (the key has no Usage header)", m.ID, ErrKeyUsage, usage)
+	}
+	if !slices.Contains(m.Usage, usage) {
+		return fmt.Errorf("key %s: %w %q (allowed: %s)", m.ID, ErrKeyUsage, usage, strings.Join(m.Usage, ", "))
+	}
+	if len(m.Algorithms) > 0 && !slices.Contains(m.Algorithms, alg) {
+		return fmt.Errorf("key %s: %w %q (allowed: %s)", m.ID, ErrAlgorithm, alg, strings.Join(m.Algorithms, ", "))
+	}
+	return nil
+}
+
+// publicMetadata is the metadata written next to the public half of a
+// key pair: the private key's usages mapped to their public counterparts.
+// Usages were checked when the key was admitted to its ring.
+func (m KeyMetadata) publicMetadata() KeyMetadata {
+	pub := m
+	pub.Usage = nil
+	for _, u := range m.Usage {
+		var p string
+		switch u {
+		case UsageSign, UsageVerify:
+			p = UsageVerify
+		case UsageDecrypt, UsageEncrypt:
+			p = UsageEncrypt
+		case UsageWrap:
+			p = UsageWrap
+		default:
+			continue
+		}
+		if !slices.Contains(pub.Usage, p) {
+			pub.Usage = append(pub.Usage, p)
+		}
+	}
+	return pub
+}
@@ -0,0 +1,201 @@
+package keyfile
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Keys are stored in the formats keys/ and rsa/ have always used: SEC1
+// EC keys in "PRIVATE KEY" blocks, PKCS #1 "RSA PRIVATE KEY" blocks and
+// PKIX public keys, with X25519 keys as PKCS #8 and AES keys in a
+// "SECRET KEY" block. The metadata travels in the PEM headers, so a
+// loaded key keeps its restrictions.
+
+func encodePEM(typ string, der []byte, meta KeyMetadata) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: typ, Headers: meta.headers(), Bytes: der}))
+}
+
+func decodePEM(pemEncoded string) (*pem.Block, KeyMetadata, error) {
+	block, _ := pem.Decode([]byte(pemEncoded))
+	if block == nil {
+		return nil, KeyMetadata{}, errors.New("failed to parse PEM block containing the key")
+	}
+	meta, err := parseMetadata(block.Headers)
+	return block, meta, err
+}
+
+func parsePrivateKey(block *pem.Block) (any, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY", "EC PRIVATE KEY":
+		if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+}
+
+func marshalPrivateKey(key any, meta KeyMetadata) (string, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return "", err
+		}
+		return encodePEM("PRIVATE KEY", der, meta), nil
+	case *rsa.PrivateKey:
+		return encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(k), meta), nil
+	case *ecdh.PrivateKey:
+		if k.Curve() != ecdh.X25519() {
+			// NIST keys go back to SEC1 so keys/ can read them.
+			curve := map[ecdh.Curve]elliptic.Curve{ecdh.P256(): elliptic.P256(), ecdh.P384(): elliptic.P384(), ecdh.P521(): elliptic.P521()}[k.Curve()]
+			ek, err := ecdsa.ParseRawPrivateKey(curve, k.Bytes())
+			if err != nil {
+				return "", err
+			}
+			return marshalPrivateKey(ek, meta)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return "", err
+		}
+		return encodePEM("PRIVATE KEY", der, meta), nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", key)
+}
+
+func marshalPublicKey(typ string, key any, meta KeyMetadata) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return encodePEM(typ, der, meta), nil
+}
+
+func (k *SigningKey) MarshalPEM() (string, error)    { return marshalPrivateKey(k.key, k.meta) }
+func (k *DecryptionKey) MarshalPEM() (string, error) { return marshalPrivateKey(k.key, k.meta) }
+func (k *AgreementKey) MarshalPEM() (string, error)  { return marshalPrivateKey(k.key, k.meta) }
+
+func (k *VerifyingKey) MarshalPEM() (string, error) {
+	return marshalPublicKey("PUBLIC KEY", k.key, k.meta)
+}
+
+func (k *EncryptionKey) MarshalPEM() (string, error) {
+	return marshalPublicKey("RSA PUBLIC KEY", k.key, k.meta)
+}
+
+func (k *SecretKey) MarshalPEM() (string, error) {
+	return encodePEM("SECRET KEY", k.key, k.meta), nil
+}
+
+func (r *KeyRing) ParseSigningKey(pemEncoded string) (*SigningKey, error) {
+	block, meta, err := decodePEM(pemEncoded)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return r.NewSigningKey(k, meta)
+	case *rsa.PrivateKey:
+		return r.NewSigningKey(k, meta)
+	}
+	return nil, fmt.Errorf("unsupported signing key type %T", key)
+}
+
+func (r *KeyRing) ParseVerifyingKey(pemEncoded string) (*VerifyingKey, error) {
+	block, meta, err := decodePEM(pemEncoded)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return r.NewVerifyingKey(key, meta)
+}
+
+func (r *KeyRing) ParseDecryptionKey(pemEncoded string) (*DecryptionKey, error) {
+	block, meta, err := decodePEM(pemEncoded)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key type is not RSA")
+	}
+	return r.NewDecryptionKey(k, meta)
+}
+
+func (r *KeyRing) ParseEncryptionKey(pemEncoded string) (*EncryptionKey, error) {
+	block, meta, err := decodePEM(pemEncoded)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("key type is not RSA")
+	}
+	return r.NewEncryptionKey(k, meta)
+}
+
+func (r *KeyRing) ParseAgreementKey(pemEncoded string) (*AgreementKey, error) {
+	block, meta, err := decodePEM(pemEncoded)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	return r.NewAgreementKey(key, meta)
+}
+
+func (r *KeyRing) ParseSecretKey(pemEncoded string) (*SecretKey, error) {
+	block, meta, err := decodePEM(pemEncoded)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != "SECRET KEY" {
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	return r.NewSecretKey(block.Bytes, meta)
+}
+
+// LoadSigningKey reads a private key file written by keys/.
+func (r *KeyRing) LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.ParseSigningKey(string(data))
+}
+
+// LoadVerifyingKey reads a public key file written by keys/.
+func (r *KeyRing) LoadVerifyingKey(path string) (*VerifyingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.ParseVerifyingKey(string(data))
+}
@@ -0,0 +1,104 @@
+package keyfile
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Algorithm identifiers stored in the Algorithms header.
+const (
+	AlgECDSASHA256    = "ECDSA-SHA256"
+	AlgRSAPSSSHA256   = "RSA-PSS-SHA256"
+	AlgRSAPKCS1SHA256 = "RSA-PKCS1v15-SHA256"
+	AlgRSAOAEPSHA256  = "RSA-OAEP-SHA256"
+	AlgECDHHKDFSHA256 = "ECDH-HKDF-SHA256"
+	AlgAESGCM         = "AES-GCM"
+)
+
+const (
+	keyTypeEC     = "EC"
+	keyTypeRSA    = "RSA"
+	keyTypeX25519 = "X25519"
+	keyTypeAES    = "AES"
+)
+
+// Purposes. A key serves exactly one.
+const (
+	purposeSignature  = "signature"
+	purposeEncryption = "encryption"
+	purposeAgreement  = "key agreement"
+)
+
+// Each algorithm works with some key types and belongs to one purpose.
+var algorithms = map[string]struct {
+	keyTypes []string
+	purpose  string
+}{
+	AlgECDSASHA256:    {[]string{keyTypeEC}, purposeSignature},
+	AlgRSAPSSSHA256:   {[]string{keyTypeRSA}, purposeSignature},
+	AlgRSAPKCS1SHA256: {[]string{keyTypeRSA}, purposeSignature},
+	AlgRSAOAEPSHA256:  {[]string{keyTypeRSA}, purposeEncryption},
+	AlgECDHHKDFSHA256: {[]string{keyTypeEC, keyTypeX25519}, purposeAgreement},
+	AlgAESGCM:         {[]string{keyTypeAES}, purposeEncryption},
+}
+
+var usagePurpose = map[string]string{
+	UsageSign:    purposeSignature,
+	UsageVerify:  purposeSignature,
+	UsageEncrypt: purposeEncryption,
+	UsageDecrypt: purposeEncryption,
+	UsageWrap:    purposeEncryption,
+	UsageDerive:  purposeAgreement,
+}
+
+var (
+	ErrMixedPurpose = errors.New("key usages and algorithms must all serve one purpose")
+	ErrKeyReuse     = errors.New("key is already in use for another purpose")
+)
+
+// purpose checks that meta allows at least one usage, that its usages
+// and algorithms are known and all serve the same purpose and that
+// every algorithm fits the key type, and returns that purpose.
+func (m KeyMetadata) purpose(keyType string) (string, error) {
+	if len(m.Usage) == 0 {
+		return "", fmt.Errorf("key %s: %w: the key has no Usage header", m.ID, ErrKeyUsage)
+	}
+	var purposes []string
+	for _, u := range m.Usage {
+		p, ok := usagePurpose[u]
+		if !ok {
+			return "", fmt.Errorf("key %s: unknown usage %q", m.ID, u)
+		}
+		purposes = append(purposes, p)
+	}
+	for _, a := range m.Algorithms {
+		alg, ok := algorithms[a]
+		if !ok {
+			return "", fmt.Errorf("key %s: unknown algorithm %q", m.ID, a)
+		}
+		if !slices.Contains(alg.keyTypes, keyType) {
+			return "", fmt.Errorf("key %s: %w %q with a %s key", m.ID, ErrAlgorithm, a, keyType)
+		}
+		purposes = append(purposes, alg.purpose)
+	}
+	slices.Sort(purposes)
+	if purposes = slices.Compact(purposes); len(purposes) > 1 {
+		return "", fmt.Errorf("key %s: %w (got %v)", m.ID, ErrMixedPurpose, purposes)
+	}
+	return purposes[0], nil
+}
+
+// check is Check plus the requirement that alg fits the key type and
+// usage, which a key without an Algorithms header does not otherwise
+// enforce.
+func (m KeyMetadata) check(usage, alg, keyType string, now time.Time) error {
+	if err := m.Check(usage, alg, now); err != nil {
+		return err
+	}
+	if a, ok := algorithms[alg]; !ok || !slices.Contains(a.keyTypes, keyType) || a.purpose != usagePurpose[usage] {
+		return fmt.Errorf("key %s: %w %q to %s with a %s key", m.ID, ErrAlgorithm, alg, usage, keyType)
+	}
+	return nil
+}
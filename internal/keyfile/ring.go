@@ -0,0 +1,180 @@
+package keyfile
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// KeyRing remembers the purpose of every key it has admitted, so the
+// same key material cannot come back under a different one: an RSA key
+// used for signatures cannot also decrypt, and an EC key used for ECDSA
+// cannot also be used for ECDH. Every key this package hands out is
+// admitted to a ring.
+//
+// A ring opened with OpenKeyRing keeps the purposes in a file, so the
+// check holds across runs and programs sharing the file. The file is
+// read again before each new key is recorded; two processes recording
+// the same new key at the same moment are not serialized.
+type KeyRing struct {
+	Path string // file the purposes are kept in, or "" for memory only
+
+	mu       sync.Mutex
+	purposes map[string]string // fingerprint to purpose
+}
+
+// NewKeyRing returns an empty in-memory ring.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{purposes: map[string]string{}}
+}
+
+// OpenKeyRing loads the ring at path, creating an empty one if the file
+// does not exist.
+func OpenKeyRing(path string) (*KeyRing, error) {
+	r := NewKeyRing()
+	r.Path = path
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *KeyRing) load() error {
+	data, err := os.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var purposes map[string]string
+	if err := json.Unmarshal(data, &purposes); err != nil {
+		return fmt.Errorf("%s: %w", r.Path, err)
+	}
+	for fp, p := range purposes {
+		r.purposes[fp] = p
+	}
+	return nil
+}
+
+// save replaces the ring file, as shred/ does its key store.
+func (r *KeyRing) save() error {
+	data, err := json.MarshalIndent(r.purposes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.Path); err != nil {
+		return err
+	}
+	d, err := os.Open(filepath.Dir(r.Path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (r *KeyRing) register(fingerprint, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.purposes[fingerprint] == purpose {
+		return nil
+	}
+	if r.Path != "" {
+		if err := r.load(); err != nil {
+			return err
+		}
+	}
+	if p, ok := r.purposes[fingerprint]; ok {
+		if p == purpose {
+			return nil
+		}
+		return fmt.Errorf("%w: registered for %s, requested for %s", ErrKeyReuse, p, purpose)
+	}
+	r.purposes[fingerprint] = purpose
+	if r.Path == "" {
+		return nil
+	}
+	if err := r.save(); err != nil {
+		delete(r.purposes, fingerprint)
+		return err
+	}
+	return nil
+}
+
+// admit checks meta serves want for key, fills in its id and records
+// the key's purpose.
+func (r *KeyRing) admit(key any, meta *KeyMetadata, want string) error {
+	keyType, fp, err := fingerprint(key)
+	if err != nil {
+		return err
+	}
+	if meta.ID == "" {
+		meta.ID = fp[:16]
+	}
+	p, err := meta.purpose(keyType)
+	if err != nil {
+		return err
+	}
+	if p != want {
+		return fmt.Errorf("key %s: %w: its usages are for %s", meta.ID, ErrKeyUsage, p)
+	}
+	return r.register(fp, p)
+}
+
+// fingerprint identifies key material independently of its Go type, so
+// an ECDSA key and the same key converted for ECDH match, and returns
+// its key type.
+func fingerprint(key any) (string, string, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return fingerprint(&k.PublicKey)
+	case *ecdsa.PublicKey:
+		e, err := k.ECDH()
+		if err != nil {
+			return "", "", err
+		}
+		return fingerprint(e)
+	case *ecdh.PrivateKey:
+		return fingerprint(k.PublicKey())
+	case *ecdh.PublicKey:
+		kt := keyTypeEC
+		if k.Curve() == ecdh.X25519() {
+			kt = keyTypeX25519
+		}
+		sum := sha256.Sum256(append([]byte(fmt.Sprint(k.Curve())), k.Bytes()...))
+		return kt, hex.EncodeToString(sum[:]), nil
+	case *rsa.PrivateKey:
+		return fingerprint(&k.PublicKey)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(append(k.N.Bytes(), byte(k.E>>16), byte(k.E>>8), byte(k.E)))
+		return keyTypeRSA, hex.EncodeToString(sum[:]), nil
+	case []byte:
+		sum := sha256.Sum256(append([]byte("keyfile secret key"), k...))
+		return keyTypeAES, hex.EncodeToString(sum[:]), nil
+	}
+	return "", "", fmt.Errorf("unsupported key type %T", key)
+}
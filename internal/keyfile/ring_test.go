@@ -0,0 +1,87 @@
+package keyfile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyRingSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenKeyRing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := r.NewSigningKey(key, KeyMetadata{Usage: []string{UsageSign}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemEncoded, err := signer.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("key ring mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	reopened, err := OpenKeyRing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.ParseSigningKey(pemEncoded); err != nil {
+		t.Fatalf("loading the key for its own purpose: %v", err)
+	}
+	if _, err := reopened.NewAgreementKey(key, KeyMetadata{Usage: []string{UsageDerive}}); !errors.Is(err, ErrKeyReuse) {
+		t.Fatalf("signing key for ECDH after reopening: got %v, want %v", err, ErrKeyReuse)
+	}
+
+	// A ring opened before the key was recorded sees it too.
+	other := NewKeyRing()
+	other.Path = path
+	if _, err := other.NewAgreementKey(key, KeyMetadata{Usage: []string{UsageDerive}}); !errors.Is(err, ErrKeyReuse) {
+		t.Fatalf("signing key for ECDH in a stale ring: got %v, want %v", err, ErrKeyReuse)
+	}
+}
+
+func TestKeyWithoutUsageRefused(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewKeyRing()
+	if _, err := r.NewSigningKey(key, KeyMetadata{}); !errors.Is(err, ErrKeyUsage) {
+		t.Fatalf("key without usage: got %v, want %v", err, ErrKeyUsage)
+	}
+	if err := (KeyMetadata{}).Check(UsageSign, AlgECDSASHA256, time.Now()); !errors.Is(err, ErrKeyUsage) {
+		t.Fatalf("Check without usage: got %v, want %v", err, ErrKeyUsage)
+	}
+}
+
+func TestAlgorithmMustFitKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewKeyRing()
+	signer, err := r.NewSigningKey(key, KeyMetadata{Usage: []string{UsageSign}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Sign(AlgRSAPSSSHA256, []byte("hello")); !errors.Is(err, ErrAlgorithm) {
+		t.Fatalf("RSA-PSS with an EC key: got %v, want %v", err, ErrAlgorithm)
+	}
+	if _, err := r.NewSigningKey(key, KeyMetadata{Usage: []string{UsageSign}, Algorithms: []string{AlgRSAOAEPSHA256}}); !errors.Is(err, ErrAlgorithm) {
+		t.Fatalf("RSA-OAEP allowed on an EC key: got %v, want %v", err, ErrAlgorithm)
+	}
+}
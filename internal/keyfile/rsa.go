@@ -0,0 +1,13 @@
+package keyfile
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+)
+
+// GenerateRsaKeyPair is the key generation of rsa/, shared with bench/ so
+// the benchmarks measure what rsa/ does.
+func GenerateRsaKeyPair(bits int) (*rsa.PrivateKey, *rsa.PublicKey) {
+	privkey, _ := rsa.GenerateKey(rand.Reader, bits)
+	return privkey, &privkey.PublicKey
+}
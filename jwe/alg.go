@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Algorithms from RFC 7518.
+
+// Key management ("alg").
+const (
+	AlgDir          = "dir"
+	AlgA256GCMKW    = "A256GCMKW"
+	AlgECDHES       = "ECDH-ES"
+	AlgECDHESA128KW = "ECDH-ES+A128KW"
+	AlgECDHESA256KW = "ECDH-ES+A256KW"
+	AlgRSAOAEP256   = "RSA-OAEP-256"
+)
+
+// Content encryption ("enc").
+const (
+	EncA128GCM      = "A128GCM"
+	EncA256GCM      = "A256GCM"
+	EncA128CBCHS256 = "A128CBC-HS256"
+)
+
+// cekLen returns the content encryption key length of enc.
+func cekLen(enc string) (int, error) {
+	switch enc {
+	case EncA128GCM:
+		return 16, nil
+	case EncA256GCM, EncA128CBCHS256:
+		return 32, nil
+	}
+	return 0, fmt.Errorf("jwe: unsupported content encryption %q", enc)
+}
+
+// encryptContent returns iv, ciphertext and tag.
+func encryptContent(enc string, cek, plaintext, aad []byte) ([]byte, []byte, []byte, error) {
+	if enc == EncA128CBCHS256 {
+		iv := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, nil, err
+		}
+		ct, tag, err := sealCBC(cek, iv, plaintext, aad)
+		return iv, ct, tag, err
+	}
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, aad)
+	n := len(sealed) - gcm.Overhead()
+	return iv, sealed[:n], sealed[n:], nil
+}
+
+func decryptContent(enc string, cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	if enc == EncA128CBCHS256 {
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, ErrDecrypt
+		}
+		// The tag is checked before anything is decrypted.
+		if !hmac.Equal(tag, cbcTag(cek[:16], aad, iv, ciphertext)) {
+			return nil, ErrDecrypt
+		}
+		block, err := aes.NewCipher(cek[16:])
+		if err != nil {
+			return nil, err
+		}
+		pt := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(pt, ciphertext)
+		pad := int(pt[len(pt)-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, ErrDecrypt
+		}
+		for _, b := range pt[len(pt)-pad:] {
+			if int(b) != pad {
+				return nil, ErrDecrypt
+			}
+		}
+		return pt[:len(pt)-pad], nil
+	}
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, ErrDecrypt
+	}
+	pt, err := gcm.Open(nil, iv, append(append([]byte(nil), ciphertext...), tag...), aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
+
+// sealCBC is AES_128_CBC_HMAC_SHA_256 (RFC 7518 section 5.2): the
+// second half of the key encrypts with PKCS #7 padding, the first half
+// authenticates.
+func sealCBC(cek, iv, plaintext, aad []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(cek[16:])
+	if err != nil {
+		return nil, nil, err
+	}
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ct := append(append([]byte(nil), plaintext...), make([]byte, pad)...)
+	for i := len(plaintext); i < len(ct); i++ {
+		ct[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ct, ct)
+	return ct, cbcTag(cek[:16], aad, iv, ct), nil
+}
+
+// cbcTag is the first half of HMAC-SHA-256 over AAD, IV, ciphertext and
+// the AAD length in bits (RFC 7518 section 5.2.2.1).
+func cbcTag(macKey, aad, iv, ciphertext []byte) []byte {
+	m := hmac.New(sha256.New, macKey)
+	m.Write(aad)
+	m.Write(iv)
+	m.Write(ciphertext)
+	m.Write(binary.BigEndian.AppendUint64(nil, uint64(len(aad))*8))
+	return m.Sum(nil)[:16]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AES Key Wrap (RFC 3394) with the default initial value.
+
+var kwIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+func keyWrap(kek, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, errors.New("jwe: key to wrap must be a multiple of 8 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, kwIV)
+	copy(out[8:], key)
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[8*i:])
+			block.Encrypt(b[:], b[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[8*i:], b[8:])
+		}
+	}
+	return out, nil
+}
+
+func keyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, ErrDecrypt
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	out := append([]byte(nil), wrapped...)
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(b[8:], out[8*i:])
+			block.Decrypt(b[:], b[:])
+			copy(out[:8], b[:8])
+			copy(out[8*i:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(out[:8], kwIV) != 1 {
+		return nil, ErrDecrypt
+	}
+	return out[8:], nil
+}
+
+// concatKDF is the single-step KDF of NIST SP 800-56A with SHA-256, as
+// profiled by RFC 7518 section 4.6.2.
+func concatKDF(z []byte, algID string, apu, apv []byte, keyLen int) []byte {
+	var other []byte
+	for _, field := range [][]byte{[]byte(algID), apu, apv} {
+		other = binary.BigEndian.AppendUint32(other, uint32(len(field)))
+		other = append(other, field...)
+	}
+	other = binary.BigEndian.AppendUint32(other, uint32(keyLen*8))
+	var out []byte
+	for counter := uint32(1); len(out) < keyLen; counter++ {
+		h := sha256.New()
+		h.Write(binary.BigEndian.AppendUint32(nil, counter))
+		h.Write(z)
+		h.Write(other)
+		out = h.Sum(out)
+	}
+	return out[:keyLen]
+}
+
+// An ephemeral public key travels in the "epk" header as an EC JWK.
+
+type ecJWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func curveName(c *ecdh.PublicKey) string {
+	switch c.Curve() {
+	case ecdh.P256():
+		return "P-256"
+	case ecdh.P384():
+		return "P-384"
+	case ecdh.P521():
+		return "P-521"
+	}
+	return ""
+}
+
+func toJWK(pub *ecdh.PublicKey) ecJWK {
+	point := pub.Bytes()
+	size := (len(point) - 1) / 2
+	return ecJWK{Kty: "EC", Crv: curveName(pub), X: b64(point[1 : 1+size]), Y: b64(point[1+size:])}
+}
+
+func (k ecJWK) publicKey() (*ecdh.PublicKey, error) {
+	var curve ecdh.Curve
+	var size int
+	switch k.Crv {
+	case "P-256":
+		curve, size = ecdh.P256(), 32
+	case "P-384":
+		curve, size = ecdh.P384(), 48
+	case "P-521":
+		curve, size = ecdh.P521(), 66
+	default:
+		return nil, fmt.Errorf("jwe: unsupported epk curve %q", k.Crv)
+	}
+	x, err := unb64(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := unb64(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if k.Kty != "EC" || len(x) != size || len(y) != size {
+		return nil, errors.New("jwe: malformed epk")
+	}
+	// NewPublicKey rejects points that are not on the curve.
+	return curve.NewPublicKey(append(append([]byte{4}, x...), y...))
+}
+
+// ecdhKey derives the key agreed with an ECDH-ES recipient: the CEK
+// itself for ECDH-ES, the key-wrapping key otherwise.
+func ecdhKey(priv *ecdh.PrivateKey, pub *ecdh.PublicKey, alg, enc string, apu, apv []byte) ([]byte, error) {
+	z, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case AlgECDHES:
+		n, err := cekLen(enc)
+		if err != nil {
+			return nil, err
+		}
+		return concatKDF(z, enc, apu, apv, n), nil
+	case AlgECDHESA128KW:
+		return concatKDF(z, alg, apu, apv, 16), nil
+	case AlgECDHESA256KW:
+		return concatKDF(z, alg, apu, apv, 32), nil
+	}
+	return nil, fmt.Errorf("jwe: %q is not an ECDH-ES algorithm", alg)
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// JSON Web Encryption (RFC 7516). A message has one content encryption
+// key (CEK) that encrypts the payload and is delivered to each recipient
+// by its key management algorithm. The protected header, and in the
+// JSON serialization any AAD, are authenticated with the payload.
+
+var (
+	ErrDecrypt     = errors.New("jwe: decryption failed")
+	ErrCompact     = errors.New("jwe: message cannot use the compact serialization")
+	errMalformed   = errors.New("jwe: malformed message")
+	errDirectMulti = errors.New("jwe: dir and ECDH-ES allow only one recipient")
+)
+
+// defaultMaxSize bounds the decompressed payload.
+const defaultMaxSize = 16 << 20
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func unb64(s string) ([]byte, error) { return base64.RawURLEncoding.DecodeString(s) }
+
+// Header is a JOSE header.
+type Header map[string]any
+
+func (h Header) str(name string) string {
+	s, _ := h[name].(string)
+	return s
+}
+
+func (h Header) bytes(name string) ([]byte, error) {
+	s, ok := h[name].(string)
+	if !ok {
+		return nil, nil
+	}
+	return unb64(s)
+}
+
+type Recipient struct {
+	Header       Header // per-recipient unprotected header
+	EncryptedKey []byte
+}
+
+type JWE struct {
+	Protected   Header
+	Unprotected Header // shared unprotected header, JSON only
+	Recipients  []Recipient
+	AAD         []byte // JSON only
+	IV          []byte
+	Ciphertext  []byte
+	Tag         []byte
+
+	// protected is the encoded protected header as sent; it is what
+	// the tag covers.
+	protected string
+}
+
+// RecipientKey is a recipient's public key, or the shared key for dir
+// and A256GCMKW, with the key management algorithm to use for it.
+type RecipientKey struct {
+	Algorithm string
+	Key       any // *ecdsa.PublicKey, *rsa.PublicKey or []byte
+	KeyID     string
+}
+
+type EncryptOptions struct {
+	Encryption string // defaults to A256GCM
+	Compress   bool   // DEFLATE the payload ("zip": "DEF")
+	Header     Header // more protected header parameters, e.g. typ or cty
+	AAD        []byte // JSON serialization only
+	// PartyUInfo and PartyVInfo feed the ECDH-ES key derivation.
+	PartyUInfo, PartyVInfo []byte
+}
+
+// wrapKey runs key management for one recipient. For dir and ECDH-ES
+// it returns the CEK it determines; otherwise it wraps cek.
+func wrapKey(r RecipientKey, enc string, cek []byte, o EncryptOptions) (Header, []byte, []byte, error) {
+	h := Header{"alg": r.Algorithm}
+	if r.KeyID != "" {
+		h["kid"] = r.KeyID
+	}
+	n, err := cekLen(enc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	switch r.Algorithm {
+	case AlgDir:
+		key, ok := r.Key.([]byte)
+		if !ok || len(key) != n {
+			return nil, nil, nil, fmt.Errorf("jwe: dir with %s needs a %d-byte key", enc, n)
+		}
+		return h, nil, bytes.Clone(key), nil
+	case AlgA256GCMKW:
+		key, ok := r.Key.([]byte)
+		if !ok || len(key) != 32 {
+			return nil, nil, nil, errors.New("jwe: A256GCMKW needs a 32-byte key")
+		}
+		iv, wrapped, tag, err := encryptContent(EncA256GCM, key, cek, nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		h["iv"], h["tag"] = b64(iv), b64(tag)
+		return h, wrapped, cek, nil
+	case AlgECDHES, AlgECDHESA128KW, AlgECDHESA256KW:
+		pub, ok := r.Key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("jwe: %s needs an EC public key", r.Algorithm)
+		}
+		recipient, err := pub.ECDH()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		eph, err := recipient.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		h["epk"] = toJWK(eph.PublicKey())
+		if o.PartyUInfo != nil {
+			h["apu"] = b64(o.PartyUInfo)
+		}
+		if o.PartyVInfo != nil {
+			h["apv"] = b64(o.PartyVInfo)
+		}
+		key, err := ecdhKey(eph, recipient, r.Algorithm, enc, o.PartyUInfo, o.PartyVInfo)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if r.Algorithm == AlgECDHES {
+			return h, nil, key, nil
+		}
+		wrapped, err := keyWrap(key, cek)
+		return h, wrapped, cek, err
+	case AlgRSAOAEP256:
+		pub, ok := r.Key.(*rsa.PublicKey)
+		if !ok {
+			return nil, nil, nil, errors.New("jwe: RSA-OAEP-256 needs an RSA public key")
+		}
+		if pub.Size() < 256 {
+			return nil, nil, nil, errors.New("jwe: RSA keys must be at least 2048 bits")
+		}
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, cek, nil)
+		return h, wrapped, cek, err
+	}
+	return nil, nil, nil, fmt.Errorf("jwe: unsupported key management algorithm %q", r.Algorithm)
+}
+
+// Encrypt encrypts plaintext to every recipient. With one recipient all
+// header parameters are protected, so the result can also be written in
+// the compact serialization.
+func Encrypt(plaintext []byte, recipients []RecipientKey, o EncryptOptions) (*JWE, error) {
+	enc := o.Encryption
+	if enc == "" {
+		enc = EncA256GCM
+	}
+	n, err := cekLen(enc)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("jwe: no recipients")
+	}
+	var cek []byte
+	for _, r := range recipients {
+		if r.Algorithm == AlgDir || r.Algorithm == AlgECDHES {
+			if len(recipients) > 1 {
+				return nil, errDirectMulti
+			}
+		}
+	}
+	if a := recipients[0].Algorithm; a != AlgDir && a != AlgECDHES {
+		cek = make([]byte, n)
+		if _, err := rand.Read(cek); err != nil {
+			return nil, err
+		}
+	}
+
+	j := &JWE{Protected: Header{}}
+	for name, v := range o.Header {
+		j.Protected[name] = v
+	}
+	j.Protected["enc"] = enc
+	if o.Compress {
+		j.Protected["zip"] = "DEF"
+	}
+	for _, r := range recipients {
+		h, wrapped, key, err := wrapKey(r, enc, cek, o)
+		if err != nil {
+			return nil, err
+		}
+		cek = key
+		if len(recipients) == 1 {
+			for name, v := range h {
+				j.Protected[name] = v
+			}
+			h = nil
+		}
+		j.Recipients = append(j.Recipients, Recipient{Header: h, EncryptedKey: wrapped})
+	}
+	defer clear(cek)
+
+	protected, err := json.Marshal(j.Protected)
+	if err != nil {
+		return nil, err
+	}
+	j.protected = b64(protected)
+	// Round-trip the header so that it reads the same as a parsed one.
+	json.Unmarshal(protected, &j.Protected)
+	j.AAD = o.AAD
+
+	if o.Compress {
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		w.Write(plaintext)
+		w.Close()
+		plaintext = buf.Bytes()
+	}
+	j.IV, j.Ciphertext, j.Tag, err = encryptContent(enc, cek, plaintext, j.contentAAD())
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// contentAAD is the additional authenticated data of the content
+// encryption (RFC 7516 section 5.1, step 14).
+func (j *JWE) contentAAD() []byte {
+	aad := j.protected
+	if len(j.AAD) > 0 {
+		aad += "." + b64(j.AAD)
+	}
+	return []byte(aad)
+}
+
+// DecryptOptions hold the recipient's key. Algorithms lists the
+// accepted "alg" values; by default every algorithm that fits the key
+// type is accepted. With KeyID set, only recipients with that "kid"
+// are tried.
+type DecryptOptions struct {
+	Key        any // *ecdsa.PrivateKey, *rsa.PrivateKey or []byte
+	KeyID      string
+	Algorithms []string
+	MaxSize    int // decompressed payload limit, defaultMaxSize if zero
+}
+
+func (o DecryptOptions) accepts(alg string) bool {
+	var fits []string
+	switch o.Key.(type) {
+	case *ecdsa.PrivateKey:
+		fits = []string{AlgECDHES, AlgECDHESA128KW, AlgECDHESA256KW}
+	case *rsa.PrivateKey:
+		fits = []string{AlgRSAOAEP256}
+	case []byte:
+		fits = []string{AlgDir, AlgA256GCMKW}
+	}
+	return slices.Contains(fits, alg) && (o.Algorithms == nil || slices.Contains(o.Algorithms, alg))
+}
+
+// header is the union of the protected, shared and per-recipient
+// headers, which must not share parameter names.
+func (j *JWE) header(r Recipient) (Header, error) {
+	h := Header{}
+	for _, part := range []Header{j.Protected, j.Unprotected, r.Header} {
+		for name, v := range part {
+			if _, dup := h[name]; dup {
+				return nil, fmt.Errorf("jwe: header parameter %q appears twice", name)
+			}
+			h[name] = v
+		}
+	}
+	if _, ok := h["crit"]; ok {
+		return nil, errors.New("jwe: critical header extensions are not supported")
+	}
+	return h, nil
+}
+
+func unwrapKey(h Header, encryptedKey []byte, key any) ([]byte, error) {
+	alg, enc := h.str("alg"), h.str("enc")
+	switch alg {
+	case AlgDir:
+		if len(encryptedKey) != 0 {
+			return nil, errMalformed
+		}
+		return key.([]byte), nil
+	case AlgA256GCMKW:
+		iv, err := h.bytes("iv")
+		if err != nil {
+			return nil, err
+		}
+		tag, err := h.bytes("tag")
+		if err != nil {
+			return nil, err
+		}
+		if len(key.([]byte)) != 32 {
+			return nil, ErrDecrypt
+		}
+		return decryptContent(EncA256GCM, key.([]byte), iv, encryptedKey, tag, nil)
+	case AlgECDHES, AlgECDHESA128KW, AlgECDHESA256KW:
+		raw, err := json.Marshal(h["epk"])
+		if err != nil {
+			return nil, err
+		}
+		var epk ecJWK
+		if err := json.Unmarshal(raw, &epk); err != nil {
+			return nil, errMalformed
+		}
+		eph, err := epk.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		priv, err := key.(*ecdsa.PrivateKey).ECDH()
+		if err != nil {
+			return nil, err
+		}
+		if eph.Curve() != priv.Curve() {
+			return nil, errors.New("jwe: epk is on a different curve than the key")
+		}
+		apu, err := h.bytes("apu")
+		if err != nil {
+			return nil, err
+		}
+		apv, err := h.bytes("apv")
+		if err != nil {
+			return nil, err
+		}
+		derived, err := ecdhKey(priv, eph, alg, enc, apu, apv)
+		if err != nil {
+			return nil, err
+		}
+		if alg == AlgECDHES {
+			if len(encryptedKey) != 0 {
+				return nil, errMalformed
+			}
+			return derived, nil
+		}
+		return keyUnwrap(derived, encryptedKey)
+	case AlgRSAOAEP256:
+		return rsa.DecryptOAEP(sha256.New(), nil, key.(*rsa.PrivateKey), encryptedKey, nil)
+	}
+	return nil, fmt.Errorf("jwe: unsupported key management algorithm %q", alg)
+}
+
+// Decrypt finds a recipient the key opens and returns the payload with
+// that recipient's complete header.
+func (j *JWE) Decrypt(o DecryptOptions) ([]byte, Header, error) {
+	tried := false
+	for _, r := range j.Recipients {
+		h, err := j.header(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !o.accepts(h.str("alg")) || o.KeyID != "" && h.str("kid") != o.KeyID {
+			continue
+		}
+		tried = true
+		n, err := cekLen(h.str("enc"))
+		if err != nil {
+			return nil, nil, err
+		}
+		cek, err := unwrapKey(h, r.EncryptedKey, o.Key)
+		if err != nil || len(cek) != n {
+			continue
+		}
+		plaintext, err := decryptContent(h.str("enc"), cek, j.IV, j.Ciphertext, j.Tag, j.contentAAD())
+		if err != nil {
+			continue
+		}
+		switch h.str("zip") {
+		case "":
+		case "DEF":
+			if plaintext, err = inflate(plaintext, o.MaxSize); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("jwe: unsupported compression %q", h.str("zip"))
+		}
+		return plaintext, h, nil
+	}
+	if !tried {
+		return nil, nil, errors.New("jwe: no recipient uses an algorithm accepted for this key")
+	}
+	return nil, nil, ErrDecrypt
+}
+
+func inflate(b []byte, limit int) ([]byte, error) {
+	if limit == 0 {
+		limit = defaultMaxSize
+	}
+	out, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(b)), int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("jwe: decompressing: %w", err)
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("jwe: payload exceeds %d bytes", limit)
+	}
+	return out, nil
+}
+
+// Compact serialization:
+// protected.encrypted_key.iv.ciphertext.tag
+
+func (j *JWE) Compact() (string, error) {
+	if len(j.Recipients) != 1 || len(j.Recipients[0].Header) > 0 || len(j.Unprotected) > 0 || len(j.AAD) > 0 {
+		return "", ErrCompact
+	}
+	return strings.Join([]string{j.protected, b64(j.Recipients[0].EncryptedKey), b64(j.IV), b64(j.Ciphertext), b64(j.Tag)}, "."), nil
+}
+
+func parseCompact(s string) (*JWE, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) != 5 {
+		return nil, errMalformed
+	}
+	var raw [4][]byte
+	for i, p := range parts[1:] {
+		b, err := unb64(p)
+		if err != nil {
+			return nil, errMalformed
+		}
+		raw[i] = b
+	}
+	j := &JWE{Recipients: []Recipient{{EncryptedKey: raw[0]}}, IV: raw[1], Ciphertext: raw[2], Tag: raw[3]}
+	return j, j.decodeProtected(parts[0])
+}
+
+func (j *JWE) decodeProtected(s string) error {
+	b, err := unb64(s)
+	if err != nil {
+		return errMalformed
+	}
+	if err := json.Unmarshal(b, &j.Protected); err != nil || j.Protected == nil {
+		return errMalformed
+	}
+	j.protected = s
+	return nil
+}
+
+// JSON serialization. A single recipient is written flattened.
+
+type jsonRecipient struct {
+	Header       Header `json:"header,omitempty"`
+	EncryptedKey string `json:"encrypted_key,omitempty"`
+}
+
+type jsonJWE struct {
+	Protected   string          `json:"protected"`
+	Unprotected Header          `json:"unprotected,omitempty"`
+	Recipients  []jsonRecipient `json:"recipients,omitempty"`
+	jsonRecipient
+	AAD        string `json:"aad,omitempty"`
+	IV         string `json:"iv"`
+	Ciphertext string `json:"ciphertext"`
+	Tag        string `json:"tag"`
+}
+
+func (j *JWE) MarshalJSON() ([]byte, error) {
+	out := jsonJWE{Protected: j.protected, Unprotected: j.Unprotected, IV: b64(j.IV), Ciphertext: b64(j.Ciphertext), Tag: b64(j.Tag)}
+	if len(j.AAD) > 0 {
+		out.AAD = b64(j.AAD)
+	}
+	for _, r := range j.Recipients {
+		out.Recipients = append(out.Recipients, jsonRecipient{Header: r.Header, EncryptedKey: b64(r.EncryptedKey)})
+	}
+	if len(out.Recipients) == 1 {
+		out.jsonRecipient, out.Recipients = out.Recipients[0], nil
+	}
+	return json.Marshal(out)
+}
+
+func parseJSON(b []byte) (*JWE, error) {
+	var in jsonJWE
+	if err := json.Unmarshal(b, &in); err != nil {
+		return nil, errMalformed
+	}
+	recipients := in.Recipients
+	if recipients == nil {
+		recipients = []jsonRecipient{in.jsonRecipient}
+	} else if in.Header != nil || in.EncryptedKey != "" {
+		return nil, errMalformed
+	}
+	j := &JWE{Unprotected: in.Unprotected}
+	var err error
+	for _, p := range []struct {
+		dst *[]byte
+		s   string
+	}{{&j.AAD, in.AAD}, {&j.IV, in.IV}, {&j.Ciphertext, in.Ciphertext}, {&j.Tag, in.Tag}} {
+		if *p.dst, err = unb64(p.s); err != nil {
+			return nil, errMalformed
+		}
+	}
+	for _, r := range recipients {
+		ek, err := unb64(r.EncryptedKey)
+		if err != nil {
+			return nil, errMalformed
+		}
+		j.Recipients = append(j.Recipients, Recipient{Header: r.Header, EncryptedKey: ek})
+	}
+	if in.Protected == "" {
+		j.Protected = Header{}
+		return j, nil
+	}
+	return j, j.decodeProtected(in.Protected)
+}
+
+// Parse reads either serialization.
+func Parse(data []byte) (*JWE, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseJSON(data)
+	}
+	return parseCompact(string(data))
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Keys are PEM files as written by keys/ (SEC1 "PRIVATE KEY" and PKIX
+// "PUBLIC KEY" blocks, curves P-256, P-384 and P-521) or by rsa/ (PKCS
+// #1 "RSA PRIVATE KEY" and PKIX "RSA PUBLIC KEY" blocks). A Key-Id PEM
+// header becomes the JWE "kid".
+
+const headerKeyID = "Key-Id"
+
+func loadKey(path string) (any, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", errors.New("failed to parse PEM block containing the key")
+	}
+	kid := block.Headers[headerKeyID]
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		return key, kid, err
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return key, kid, err
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, "", err
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey:
+			return key, kid, nil
+		}
+		return nil, "", errors.New("key type is not ECDSA or RSA")
+	}
+	return nil, "", fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// defaultAlgorithm picks the key management algorithm for a key.
+func defaultAlgorithm(key any) string {
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		return AlgECDHESA256KW
+	case *rsa.PublicKey:
+		return AlgRSAOAEP256
+	}
+	return AlgA256GCMKW
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: jwe [flags] <encrypt|decrypt> [flags]
+
+encrypt -to a.pub.pem,b.pub.pem   encrypt -in to the listed public keys
+encrypt -alg dir|A256GCMKW        encrypt under $CRYPTO_JWE_KEY (hex)
+decrypt -key a.pem                decrypt -in with a private key
+decrypt                           decrypt -in with $CRYPTO_JWE_KEY
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	to := flag.String("to", "", "comma-separated recipient public keys")
+	keyPath := flag.String("key", "", "private key to decrypt with")
+	alg := flag.String("alg", "", "key management algorithm (default: by key type)")
+	enc := flag.String("enc", EncA256GCM, "content encryption: A128GCM, A256GCM or A128CBC-HS256")
+	compress := flag.Bool("zip", false, "compress the payload")
+	asJSON := flag.Bool("json", false, "write the JSON serialization (implied by several recipients)")
+	in := flag.String("in", "", "input file (default stdin)")
+	out := flag.String("out", "", "output file (default stdout)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+
+	var shared []byte
+	if v := os.Getenv("CRYPTO_JWE_KEY"); v != "" {
+		var err error
+		if shared, err = hex.DecodeString(v); err != nil {
+			log.Fatal("CRYPTO_JWE_KEY: ", err)
+		}
+	}
+	var input []byte
+	var err error
+	if *in == "" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var output []byte
+	switch cmd {
+	case "encrypt":
+		var recipients []RecipientKey
+		if *to == "" {
+			if shared == nil {
+				log.Fatal("no recipients: give -to or set $CRYPTO_JWE_KEY")
+			}
+			recipients = append(recipients, RecipientKey{Key: shared})
+		}
+		for _, path := range strings.Split(*to, ",") {
+			if path == "" {
+				continue
+			}
+			key, kid, err := loadKey(path)
+			if err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+			recipients = append(recipients, RecipientKey{Key: key, KeyID: kid})
+		}
+		for i := range recipients {
+			recipients[i].Algorithm = *alg
+			if *alg == "" {
+				recipients[i].Algorithm = defaultAlgorithm(recipients[i].Key)
+			}
+		}
+		j, err := Encrypt(input, recipients, EncryptOptions{Encryption: *enc, Compress: *compress})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *asJSON || len(recipients) > 1 {
+			output, err = json.Marshal(j)
+		} else {
+			var s string
+			s, err = j.Compact()
+			output = []byte(s + "\n")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "decrypt":
+		o := DecryptOptions{Key: shared}
+		if *keyPath != "" {
+			if o.Key, o.KeyID, err = loadKey(*keyPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if o.Key == nil {
+			log.Fatal("no key: give -key or set $CRYPTO_JWE_KEY")
+		}
+		if *alg != "" {
+			o.Algorithms = []string{*alg}
+		}
+		j, err := Parse(input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var h Header
+		if output, h, err = j.Decrypt(o); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "alg %s, enc %s\n", h.str("alg"), h.str("enc"))
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if *out == "" {
+		os.Stdout.Write(output)
+	} else if err := os.WriteFile(*out, output, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+func must2[T, U any](t T, u U, err error) (T, U) {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return t, u
+}
+
+func mustHex(s string) []byte {
+	return must(hex.DecodeString(strings.ReplaceAll(s, " ", "")))
+}
+
+func ecKey(curve elliptic.Curve, d string) *ecdsa.PrivateKey {
+	return must(ecdsa.ParseRawPrivateKey(curve, must(unb64(d))))
+}
+
+func demo() {
+	// RFC 3394 section 4.1.
+	wrapped := must(keyWrap(mustHex("000102030405060708090A0B0C0D0E0F"), mustHex("00112233445566778899AABBCCDDEEFF")))
+	fmt.Println("AES key wrap, RFC 3394 4.1:", bytes.Equal(wrapped, mustHex("1FA68B0A8112B447 AEF34BD8FB5A7B82 9D3E862371D2CFE5")))
+	// RFC 7518 appendix B.1.
+	ct, tag := must2(sealCBC(
+		mustHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+		mustHex("1af38c2dc2b96ffdd86694092341bc04"),
+		[]byte("A cipher system must not be required to be secret, and it must be able to fall into the hands of the enemy without inconvenience"),
+		[]byte("The second principle of Auguste Kerckhoffs")))
+	fmt.Println("A128CBC-HS256, RFC 7518 B.1:", bytes.Equal(ct[:16], mustHex("c80edfa32ddf39d5ef00c0b468834279")) &&
+		bytes.Equal(tag, mustHex("652c3fa36b0a7c5b3219fab3a30bc1c4")))
+	// RFC 7518 appendix C: ECDH-ES between Alice's ephemeral key and Bob.
+	alice := must(ecKey(elliptic.P256(), "0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo").ECDH())
+	bob := must(ecKey(elliptic.P256(), "VEmDZpDXXK8p8N0Cndsxs924q6nS1RXFASRl6BfUqdw").ECDH())
+	derived := must(ecdhKey(alice, bob.PublicKey(), AlgECDHES, EncA128GCM, []byte("Alice"), []byte("Bob")))
+	fmt.Println("ECDH-ES, RFC 7518 C:", b64(derived) == "VqqN6vgjbSBcIijNcacQGg")
+
+	ecPriv := must(ecdsa.GenerateKey(elliptic.P384(), rand.Reader))
+	rsaPriv := must(rsa.GenerateKey(rand.Reader, 2048))
+	shared := make([]byte, 32)
+	rand.Read(shared)
+	payload := bytes.Repeat([]byte(`{"sub":"alice","scope":"orders:read"}`), 20)
+
+	fmt.Println()
+	for _, c := range []struct {
+		alg string
+		pub any
+		key any
+	}{
+		{AlgECDHES, &ecPriv.PublicKey, ecPriv},
+		{AlgECDHESA128KW, &ecPriv.PublicKey, ecPriv},
+		{AlgECDHESA256KW, &ecPriv.PublicKey, ecPriv},
+		{AlgRSAOAEP256, &rsaPriv.PublicKey, rsaPriv},
+		{AlgA256GCMKW, shared, shared},
+		{AlgDir, shared, shared},
+	} {
+		for _, enc := range []string{EncA128GCM, EncA256GCM, EncA128CBCHS256} {
+			if c.alg == AlgDir && enc == EncA128GCM {
+				continue // needs a 16-byte key
+			}
+			j := must(Encrypt(payload, []RecipientKey{{Algorithm: c.alg, Key: c.pub}}, EncryptOptions{Encryption: enc, Compress: true}))
+			token := must(j.Compact())
+			parsed := must(Parse([]byte(token)))
+			pt, _, err := parsed.Decrypt(DecryptOptions{Key: c.key})
+			fmt.Printf("%-15s %-14s compact, %4d chars: %v %v\n", c.alg, enc, len(token), bytes.Equal(pt, payload), err)
+		}
+	}
+
+	// Several recipients share one CEK; the JSON serialization carries
+	// their headers and the application's AAD.
+	fmt.Println()
+	j := must(Encrypt(payload, []RecipientKey{
+		{Algorithm: AlgECDHESA256KW, Key: &ecPriv.PublicKey, KeyID: "ops-p384"},
+		{Algorithm: AlgRSAOAEP256, Key: &rsaPriv.PublicKey, KeyID: "escrow-rsa"},
+		{Algorithm: AlgA256GCMKW, Key: shared, KeyID: "batch"},
+	}, EncryptOptions{Encryption: EncA128CBCHS256, AAD: []byte("tenant=acme"), Header: Header{"cty": "JWT"}}))
+	js := must(json.Marshal(j))
+	fmt.Printf("JSON, 3 recipients, %d bytes\n", len(js))
+	for _, key := range []any{ecPriv, rsaPriv, shared} {
+		pt, h, err := must(Parse(js)).Decrypt(DecryptOptions{Key: key})
+		fmt.Printf("  %-15s kid %-10s %v %v\n", h.str("alg"), h.str("kid"), bytes.Equal(pt, payload), err)
+	}
+	_, err := j.Compact()
+	fmt.Println("  as compact:", err)
+
+	fmt.Println()
+	var generic map[string]any
+	json.Unmarshal(js, &generic)
+	generic["aad"] = b64([]byte("tenant=evil"))
+	forged := must(json.Marshal(generic))
+	_, _, err = must(Parse(forged)).Decrypt(DecryptOptions{Key: rsaPriv})
+	fmt.Println("modified AAD:", err)
+	token := must(must(Encrypt(payload, []RecipientKey{{Algorithm: AlgRSAOAEP256, Key: &rsaPriv.PublicKey}}, EncryptOptions{})).Compact())
+	parts := strings.Split(token, ".")
+	parts[3] = "A" + parts[3][1:]
+	_, _, err = must(Parse([]byte(strings.Join(parts, ".")))).Decrypt(DecryptOptions{Key: rsaPriv})
+	fmt.Println("modified ciphertext:", err)
+	other := must(rsa.GenerateKey(rand.Reader, 2048))
+	_, _, err = must(Parse([]byte(token))).Decrypt(DecryptOptions{Key: other})
+	fmt.Println("wrong key:", err)
+	_, _, err = must(Parse([]byte(token))).Decrypt(DecryptOptions{Key: shared})
+	fmt.Println("symmetric key on an RSA token:", err)
+	_, err = Encrypt(payload, []RecipientKey{{Algorithm: AlgDir, Key: shared}, {Algorithm: AlgA256GCMKW, Key: shared}}, EncryptOptions{})
+	fmt.Println("dir with two recipients:", err)
+}
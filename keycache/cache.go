@@ -0,0 +1,254 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Context is the encryption context: non-secret key-value pairs bound
+// to every message as additional data. The cache is partitioned by it,
+// so a data key is only ever shared by messages with the same context.
+type Context map[string]string
+
+// encode is a canonical, length-prefixed encoding with sorted keys.
+func (c Context) encode() []byte {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(keys)))
+	for _, k := range keys {
+		for _, s := range []string{k, c[k]} {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+			b = append(b, s...)
+		}
+	}
+	return b
+}
+
+func decodeContext(b []byte) (Context, error) {
+	errContext := errors.New("malformed encryption context")
+	if len(b) < 2 {
+		return nil, errContext
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	c := Context{}
+	for range n {
+		var kv [2]string
+		for i := range kv {
+			if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+				return nil, errContext
+			}
+			l := int(binary.BigEndian.Uint16(b))
+			kv[i], b = string(b[2:2+l]), b[2+l:]
+		}
+		c[kv[0]] = kv[1]
+	}
+	if len(b) != 0 {
+		return nil, errContext
+	}
+	return c, nil
+}
+
+// Limits bound how much a cached data key may be used. A key is
+// replaced when any limit would be exceeded.
+type Limits struct {
+	MaxAge      time.Duration
+	MaxMessages uint64
+	MaxBytes    uint64
+}
+
+// maxRandomNonces is the most messages one key may encrypt with random
+// 96-bit GCM nonces (see aes/).
+const maxRandomNonces = 1 << 32
+
+// Materials are a data key ready for use.
+type Materials struct {
+	Key     []byte
+	Wrapped []byte
+}
+
+// Stats count cache activity since the manager was created.
+type Stats struct {
+	EncryptHits, EncryptMisses uint64
+	DecryptHits, DecryptMisses uint64
+	Evictions                  uint64
+}
+
+func (s Stats) HitRate() float64 {
+	total := s.EncryptHits + s.EncryptMisses + s.DecryptHits + s.DecryptMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.EncryptHits+s.DecryptHits) / float64(total)
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("encrypt %d hits / %d misses, decrypt %d hits / %d misses, %d evictions, hit rate %.1f%%",
+		s.EncryptHits, s.EncryptMisses, s.DecryptHits, s.DecryptMisses, s.Evictions, 100*s.HitRate())
+}
+
+type entry struct {
+	id       [32]byte
+	m        Materials
+	created  time.Time
+	messages uint64
+	bytes    uint64
+	elem     *list.Element
+}
+
+// CachingManager hands out data keys from a KeyProvider, reusing each
+// one within Limits. Entries for encryption are keyed by the context;
+// entries for decryption by the context and the wrapped key. At most
+// Capacity entries are kept, least recently used first out. It is safe
+// for concurrent use. Provider calls are made without holding the lock,
+// so a slow provider does not block hits on other partitions.
+type CachingManager struct {
+	provider KeyProvider
+	limits   Limits
+	capacity int
+
+	mu      sync.Mutex
+	entries map[[32]byte]*entry
+	lru     *list.List
+	stats   Stats
+	now     func() time.Time
+}
+
+func NewCachingManager(p KeyProvider, limits Limits, capacity int) (*CachingManager, error) {
+	if limits.MaxAge <= 0 {
+		return nil, errors.New("keycache: MaxAge must be positive")
+	}
+	if limits.MaxMessages == 0 || limits.MaxMessages > maxRandomNonces {
+		limits.MaxMessages = maxRandomNonces
+	}
+	if capacity <= 0 {
+		return nil, errors.New("keycache: capacity must be positive")
+	}
+	return &CachingManager{
+		provider: p,
+		limits:   limits,
+		capacity: capacity,
+		entries:  map[[32]byte]*entry{},
+		lru:      list.New(),
+		now:      time.Now,
+	}, nil
+}
+
+func cacheID(kind string, parts ...[]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte(kind))
+	for _, p := range parts {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(p))))
+		h.Write(p)
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+func (c *CachingManager) expired(e *entry) bool {
+	return c.now().Sub(e.created) >= c.limits.MaxAge
+}
+
+func (c *CachingManager) remove(e *entry) {
+	delete(c.entries, e.id)
+	c.lru.Remove(e.elem)
+}
+
+func (c *CachingManager) insert(e *entry) {
+	if old, ok := c.entries[e.id]; ok {
+		c.remove(old)
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.id] = e
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back().Value.(*entry))
+		c.stats.Evictions++
+	}
+}
+
+// EncryptionMaterials returns a data key for a message of size bytes
+// with the given context.
+func (c *CachingManager) EncryptionMaterials(context Context, size uint64) (Materials, error) {
+	encoded := context.encode()
+	id := cacheID("encrypt", encoded)
+	c.mu.Lock()
+	if e, ok := c.entries[id]; ok {
+		if c.expired(e) || e.messages+1 > c.limits.MaxMessages || (c.limits.MaxBytes > 0 && e.bytes+size > c.limits.MaxBytes) {
+			c.remove(e)
+		} else {
+			e.messages++
+			e.bytes += size
+			c.lru.MoveToFront(e.elem)
+			c.stats.EncryptHits++
+			c.mu.Unlock()
+			return e.m, nil
+		}
+	}
+	c.stats.EncryptMisses++
+	c.mu.Unlock()
+
+	key, wrapped, err := c.provider.GenerateDataKey(context)
+	if err != nil {
+		return Materials{}, err
+	}
+	m := Materials{Key: key, Wrapped: wrapped}
+	// A message larger than MaxBytes gets a key of its own.
+	if c.limits.MaxBytes > 0 && size > c.limits.MaxBytes {
+		return m, nil
+	}
+	c.mu.Lock()
+	c.insert(&entry{id: id, m: m, created: c.now(), messages: 1, bytes: size})
+	// Decrypting this key's messages needs no provider call either.
+	c.insert(&entry{id: cacheID("decrypt", encoded, wrapped), m: m, created: c.now()})
+	c.mu.Unlock()
+	return m, nil
+}
+
+// DecryptionMaterials returns the data key for wrapped. Only MaxAge
+// applies; usage limits protect encryption.
+func (c *CachingManager) DecryptionMaterials(wrapped []byte, context Context) (Materials, error) {
+	id := cacheID("decrypt", context.encode(), wrapped)
+	c.mu.Lock()
+	if e, ok := c.entries[id]; ok {
+		if !c.expired(e) {
+			c.lru.MoveToFront(e.elem)
+			c.stats.DecryptHits++
+			c.mu.Unlock()
+			return e.m, nil
+		}
+		c.remove(e)
+	}
+	c.stats.DecryptMisses++
+	c.mu.Unlock()
+
+	key, err := c.provider.DecryptDataKey(wrapped, context)
+	if err != nil {
+		return Materials{}, err
+	}
+	m := Materials{Key: key, Wrapped: wrapped}
+	c.mu.Lock()
+	c.insert(&entry{id: id, m: m, created: c.now()})
+	c.mu.Unlock()
+	return m, nil
+}
+
+func (c *CachingManager) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Len returns the number of cached entries.
+func (c *CachingManager) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// run encrypts and decrypts n messages of size bytes on workers
+// goroutines, spread over tenants encryption contexts.
+func run(c *CachingManager, n, workers, tenants, size int) (time.Duration, error) {
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := make([]byte, size)
+			for i := w; i < n; i += workers {
+				ctx := Context{"tenant": fmt.Sprint("t", i%tenants), "purpose": "events"}
+				ct, err := Encrypt(c, ctx, msg)
+				if err != nil {
+					errs <- err
+					return
+				}
+				pt, got, err := Decrypt(c, ct)
+				if err != nil || !bytes.Equal(pt, msg) || got["tenant"] != ctx["tenant"] {
+					errs <- fmt.Errorf("message %d did not round-trip: %v", i, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return time.Since(start), <-errs
+}
+
+func main() {
+	n := flag.Int("messages", 20000, "messages to encrypt")
+	workers := flag.Int("workers", 8, "concurrent goroutines")
+	tenants := flag.Int("tenants", 4, "distinct encryption contexts")
+	size := flag.Int("size", 1024, "message size in bytes")
+	latency := flag.Duration("latency", 5*time.Millisecond, "simulated KMS latency per call")
+	maxAge := flag.Duration("max-age", 5*time.Minute, "maximum age of a cached data key")
+	maxMessages := flag.Uint64("max-messages", 1000, "messages per cached data key")
+	maxBytes := flag.Uint64("max-bytes", 64<<20, "bytes per cached data key")
+	capacity := flag.Int("capacity", 100, "cache entries")
+	flag.Parse()
+
+	master := make([]byte, 32)
+	rand.Read(master)
+	kms, err := NewLocalKMS(master)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kms.Latency = *latency
+
+	for _, limits := range []Limits{
+		{MaxAge: *maxAge, MaxMessages: 1},
+		{MaxAge: *maxAge, MaxMessages: *maxMessages, MaxBytes: *maxBytes},
+	} {
+		c, err := NewCachingManager(kms, limits, *capacity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		kms.Calls.Store(0)
+		count := *n
+		if limits.MaxMessages == 1 {
+			count = min(count, 2000) // uncached is slow
+		}
+		elapsed, err := run(c, count, *workers, *tenants, *size)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("max %d messages per key: %d messages in %v, %d KMS calls, %.0f msg/s\n  %v\n",
+			limits.MaxMessages, count, elapsed.Round(time.Millisecond), kms.Calls.Load(), float64(count)/elapsed.Seconds(), c.Stats())
+	}
+
+	// Limits, on a fake clock.
+	kms.Latency = 0
+	c, _ := NewCachingManager(kms, Limits{MaxAge: time.Minute, MaxMessages: 3, MaxBytes: 100}, 4)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	wrappedKey := func(ctx Context, size int) string {
+		m, err := c.EncryptionMaterials(ctx, uint64(size))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return fmt.Sprintf("%x", m.Wrapped[len(m.Wrapped)-4:])
+	}
+	a := Context{"tenant": "a"}
+	fmt.Println("\nkeys for tenant a, 3 messages per key:", wrappedKey(a, 10), wrappedKey(a, 10), wrappedKey(a, 10), wrappedKey(a, 10))
+	fmt.Println("tenant b gets its own key:", wrappedKey(Context{"tenant": "b"}, 10))
+	fmt.Println("95 bytes after 20 exceed 100 bytes:", wrappedKey(a, 10), wrappedKey(a, 95))
+	k := wrappedKey(a, 1)
+	now = now.Add(2 * time.Minute)
+	fmt.Println("after MaxAge:", k, wrappedKey(a, 1))
+	fmt.Println("entries with capacity 4:", c.Len(), "-", c.Stats())
+
+	ct, _ := Encrypt(c, a, []byte("hello"))
+	ct[len(ct)-1] ^= 1
+	_, _, err = Decrypt(c, ct)
+	fmt.Println("modified message:", err, errors.Is(err, ErrMessage))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// A message is
+//
+//	version (1) || len (2) || context || len (2) || wrapped key ||
+//	nonce (12) || AES-256-GCM ciphertext
+//
+// with everything before the nonce as additional data. The nonce is
+// random and the ciphertext is nonce || gcm.Seal as in aes/, which is
+// why MaxMessages may not exceed 2^32.
+const messageVersion = 1
+
+var ErrMessage = errors.New("message is malformed or was modified")
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+func Encrypt(c *CachingManager, context Context, plaintext []byte) ([]byte, error) {
+	m, err := c.EncryptionMaterials(context, uint64(len(plaintext)))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(m.Key)
+	if err != nil {
+		return nil, err
+	}
+	encoded := context.encode()
+	header := []byte{messageVersion}
+	header = binary.BigEndian.AppendUint16(header, uint16(len(encoded)))
+	header = append(header, encoded...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(m.Wrapped)))
+	header = append(header, m.Wrapped...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return gcm.Seal(out, nonce, plaintext, header), nil
+}
+
+// Decrypt returns the plaintext and the encryption context of message.
+// Callers should check the context is what they expect.
+func Decrypt(c *CachingManager, message []byte) ([]byte, Context, error) {
+	field := func(b []byte) ([]byte, []byte, bool) {
+		if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+			return nil, nil, false
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		return b[2 : 2+n], b[2+n:], true
+	}
+	if len(message) < 1 || message[0] != messageVersion {
+		return nil, nil, ErrMessage
+	}
+	encoded, rest, ok := field(message[1:])
+	if !ok {
+		return nil, nil, ErrMessage
+	}
+	wrapped, rest, ok := field(rest)
+	if !ok || len(rest) < 12 {
+		return nil, nil, ErrMessage
+	}
+	context, err := decodeContext(encoded)
+	if err != nil {
+		return nil, nil, ErrMessage
+	}
+	m, err := c.DecryptionMaterials(wrapped, context)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := newGCM(m.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := message[:len(message)-len(rest)]
+	pt, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, nil, ErrMessage
+	}
+	return pt, context, nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// BlockKind classifies a PEM block in a bundle.
+type BlockKind int
+
+const (
+	KindUnknown BlockKind = iota
+	KindPrivateKey
+	KindPublicKey
+	KindCertificate
+	KindCSR
+	KindParameters
+)
+
+func (k BlockKind) String() string {
+	switch k {
+	case KindPrivateKey:
+		return "private key"
+	case KindPublicKey:
+		return "public key"
+	case KindCertificate:
+		return "certificate"
+	case KindCSR:
+		return "certificate request"
+	case KindParameters:
+		return "parameters"
+	}
+	return "unknown"
+}
+
+// BundleItem is one block of a bundle with its parsed contents. Exactly
+// one of Key, Cert, CSR and Params is set for recognised blocks.
+type BundleItem struct {
+	Block  *pem.Block
+	Kind   BlockKind
+	Key    any // crypto.Signer for private keys, crypto.PublicKey for public keys
+	Cert   *x509.Certificate
+	CSR    *x509.CertificateRequest
+	Params string // curve name for EC PARAMETERS
+}
+
+var curveOIDs = map[string]elliptic.Curve{
+	"1.2.840.10045.3.1.7": elliptic.P256(),
+	"1.3.132.0.34":        elliptic.P384(),
+	"1.3.132.0.35":        elliptic.P521(),
+}
+
+// ReadBundle parses every PEM block in data, unlike decode, which only
+// looks at the first. Text between blocks is ignored; a block that cannot
+// be parsed as its type says is an error.
+func ReadBundle(data []byte) ([]BundleItem, error) {
+	var items []BundleItem
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		item, err := classify(block)
+		if err != nil {
+			return nil, fmt.Errorf("block %d (%s): %v", len(items)+1, block.Type, err)
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, errors.New("no PEM blocks found")
+	}
+	return items, nil
+}
+
+func classify(block *pem.Block) (BundleItem, error) {
+	item := BundleItem{Block: block}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY", "EC PRIVATE KEY", "RSA PRIVATE KEY":
+		item.Kind = KindPrivateKey
+		item.Key, err = parsePrivateKeyDER(block.Bytes)
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		item.Kind = KindPublicKey
+		if item.Key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil && block.Type == "RSA PUBLIC KEY" {
+			item.Key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		}
+	case "CERTIFICATE":
+		item.Kind = KindCertificate
+		item.Cert, err = x509.ParseCertificate(block.Bytes)
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		item.Kind = KindCSR
+		item.CSR, err = x509.ParseCertificateRequest(block.Bytes)
+	case "EC PARAMETERS":
+		item.Kind = KindParameters
+		var oid asn1.ObjectIdentifier
+		if _, err = asn1.Unmarshal(block.Bytes, &oid); err == nil {
+			curve, ok := curveOIDs[oid.String()]
+			if !ok {
+				return item, fmt.Errorf("unsupported curve %s", oid)
+			}
+			item.Params = curve.Params().Name
+		}
+	case "DH PARAMETERS", "DSA PARAMETERS":
+		item.Kind = KindParameters
+	}
+	return item, err
+}
+
+// parsePrivateKeyDER accepts SEC 1 (which keys.go writes, even under the
+// "PRIVATE KEY" type), PKCS #1 and PKCS #8 encodings.
+func parsePrivateKeyDER(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("unrecognised private key encoding")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+// KeyMatch pairs a private key with the certificate for its public key.
+type KeyMatch struct {
+	Key  BundleItem
+	Cert BundleItem
+}
+
+// MatchKeys finds the certificate of every private key in items. Keys
+// without a certificate are left out.
+func MatchKeys(items []BundleItem) []KeyMatch {
+	var matches []KeyMatch
+	for _, k := range items {
+		if k.Kind != KindPrivateKey {
+			continue
+		}
+		pub := k.Key.(crypto.Signer).Public()
+		for _, c := range items {
+			if c.Kind == KindCertificate && publicKeysEqual(pub, c.Cert.PublicKey) {
+				matches = append(matches, KeyMatch{Key: k, Cert: c})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	eq, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && eq.Equal(b)
+}
+
+// WriteBundle encodes key, its leaf certificate and the chain up to the
+// root in that order. The chain may be given in any order; it is sorted
+// so each certificate is followed by its issuer.
+func WriteBundle(key crypto.Signer, leaf *x509.Certificate, chain []*x509.Certificate) ([]byte, error) {
+	if !publicKeysEqual(key.Public(), leaf.PublicKey) {
+		return nil, errors.New("private key does not match the leaf certificate")
+	}
+	var der []byte
+	var err error
+	keyType := "PRIVATE KEY"
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err = x509.MarshalECPrivateKey(key)
+	case *rsa.PrivateKey:
+		der = x509.MarshalPKCS1PrivateKey(key)
+		keyType = "RSA PRIVATE KEY"
+	case ed25519.PrivateKey:
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	default:
+		err = errors.New("unsupported private key type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: keyType, Bytes: der})
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+
+	remaining := append([]*x509.Certificate(nil), chain...)
+	for cert := leaf; len(remaining) > 0; {
+		i := issuerIndex(cert, remaining)
+		if i < 0 {
+			return nil, fmt.Errorf("no issuer for %q among the remaining chain certificates", cert.Subject)
+		}
+		cert = remaining[i]
+		remaining = append(remaining[:i], remaining[i+1:]...)
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes(), nil
+}
+
+func issuerIndex(cert *x509.Certificate, candidates []*x509.Certificate) int {
+	for i, c := range candidates {
+		if cert.CheckSignatureFrom(c) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// printBundle describes each block of the bundle in path.
+func printBundle(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	items, err := ReadBundle(data)
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		desc := ""
+		switch {
+		case item.Cert != nil:
+			desc = item.Cert.Subject.String()
+		case item.CSR != nil:
+			desc = item.CSR.Subject.String()
+		case item.Params != "":
+			desc = item.Params
+		case item.Key != nil:
+			desc = fmt.Sprintf("%T", item.Key)
+		}
+		fmt.Printf("%d: %-24s %-20s %s\n", i+1, item.Block.Type, item.Kind, desc)
+	}
+	for _, m := range MatchKeys(items) {
+		fmt.Printf("private key matches certificate %q\n", m.Cert.Cert.Subject)
+	}
+	return nil
+}
+
+// bundleDemo issues a root, an intermediate and a leaf for privateKey,
+// writes them as one bundle with the chain out of order, and reads it back.
+func bundleDemo(privateKey *ecdsa.PrivateKey) error {
+	issue := func(subject string, pub crypto.PublicKey, parent *x509.Certificate, signer crypto.Signer, ca bool) (*x509.Certificate, error) {
+		serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+		if err != nil {
+			return nil, err
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          serial,
+			Subject:               pkix.Name{CommonName: subject},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().AddDate(1, 0, 0),
+			IsCA:                  ca,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		}
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCertificate(der)
+	}
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	interKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	root, err := issue("Demo Root", &rootKey.PublicKey, nil, rootKey, true)
+	if err != nil {
+		return err
+	}
+	inter, err := issue("Demo Intermediate", &interKey.PublicKey, root, rootKey, true)
+	if err != nil {
+		return err
+	}
+	leaf, err := issue("demo.example", &privateKey.PublicKey, inter, interKey, false)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := WriteBundle(privateKey, leaf, []*x509.Certificate{root, inter})
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "bundle-*.pem")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bundle); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return printBundle(f.Name())
+}
@@ -4,18 +4,32 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"flag"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
 	"reflect"
+	"time"
+
+	"ecdsa/internal/config"
+	"ecdsa/internal/keyfile"
 )
 
-func encode(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) (string, string) {
-	x509Encoded, _ := x509.MarshalECPrivateKey(privateKey)
-	pemEncoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509Encoded})
-	x509EncodedPub, _ := x509.MarshalPKIXPublicKey(publicKey)
-	pemEncodedPub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: x509EncodedPub})
-	return string(pemEncoded), string(pemEncodedPub)
+func curveByName(name string) (elliptic.Curve, error) {
+	switch name {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported curve %q", name)
 }
 
 func decode(pemEncoded string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.PublicKey) {
@@ -30,11 +44,118 @@ func decode(pemEncoded string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.
 }
 
 func main() {
-	privateKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	cfg, profile, err := config.LoadProfile("keys")
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag.String("curve", profile.Curve, "curve: P-256, P-384 or P-521")
+	flag.String("store", profile.KeyStore, "directory to write private.pem and public.pem to")
+	flag.String("format", profile.Format, "output format: text or json")
+	bundle := flag.String("bundle", "", "list the blocks of this PEM bundle and exit")
+	flag.Parse()
+	if profile, err = cfg.ResolveFlags(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *bundle != "" {
+		if err := printBundle(*bundle); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	curve, err := curveByName(profile.Curve)
+	if err != nil {
+		log.Fatal(err)
+	}
+	privateKey, _ := ecdsa.GenerateKey(curve, rand.Reader)
 	publicKey := &privateKey.PublicKey
-	encPriv, encPub := encode(privateKey, publicKey)
-	fmt.Println(encPriv)
-	fmt.Println(encPub)
+	// The store keeps the key with its metadata, and its ring records
+	// that the key is for signatures; a key without a Usage header cannot
+	// be loaded.
+	ring := keyfile.NewKeyRing()
+	if profile.KeyStore != "" {
+		if err := os.MkdirAll(profile.KeyStore, 0700); err != nil {
+			log.Fatal(err)
+		}
+		if ring, err = keyfile.OpenKeyRing(filepath.Join(profile.KeyStore, "keyring.json")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	now := time.Now()
+	signer, err := ring.NewSigningKey(privateKey, keyfile.KeyMetadata{Created: now, Usage: []string{keyfile.UsageSign}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	encPriv, err := signer.MarshalPEM()
+	if err != nil {
+		log.Fatal(err)
+	}
+	encPub, err := signer.Public().MarshalPEM()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if profile.Format != "json" {
+		fmt.Println(encPriv)
+		fmt.Println(encPub)
+	}
+	if profile.KeyStore != "" {
+		if err := os.WriteFile(filepath.Join(profile.KeyStore, "private.pem"), []byte(encPriv), 0600); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(profile.KeyStore, "public.pem"), []byte(encPub), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if profile.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]string{"curve": profile.Curve, "private": encPriv, "public": encPub, "store": profile.KeyStore}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Load the keys back; the metadata comes with them.
+	loadedPriv, err := ring.ParseSigningKey(encPriv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loadedPub, err := ring.ParseVerifyingKey(encPub)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("metadata: %+v\n", loadedPriv.Metadata())
+	hash := sha256.Sum256([]byte("hello, world"))
+	sig, err := loadedPriv.SignHash(keyfile.AlgECDSASHA256, hash[:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("signature verified:", loadedPub.VerifyHash(keyfile.AlgECDSASHA256, hash[:], sig) == nil)
+
+	restricted := func(meta keyfile.KeyMetadata) *keyfile.SigningKey {
+		k, err := ring.NewSigningKey(privateKey, meta)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return k
+	}
+	_, err = restricted(keyfile.KeyMetadata{Expires: now.Add(-time.Minute), Usage: []string{keyfile.UsageSign}}).SignHash(keyfile.AlgECDSASHA256, hash[:])
+	fmt.Println("expired key:", err)
+	_, err = restricted(keyfile.KeyMetadata{Usage: []string{keyfile.UsageVerify}}).SignHash(keyfile.AlgECDSASHA256, hash[:])
+	fmt.Println("verify-only key:", err)
+	_, err = ring.NewSigningKey(privateKey, keyfile.KeyMetadata{})
+	fmt.Println("key without usage:", err)
+	_, err = ring.NewSigningKey(privateKey, keyfile.KeyMetadata{Usage: []string{keyfile.UsageEncrypt}})
+	fmt.Println("encrypt-only key:", err)
+	_, err = ring.NewSigningKey(privateKey, keyfile.KeyMetadata{Usage: []string{"signing"}})
+	fmt.Println("unknown usage:", err)
+	_, err = ring.NewAgreementKey(privateKey, keyfile.KeyMetadata{Usage: []string{keyfile.UsageDerive}})
+	fmt.Println("same key for ECDH:", err)
+
+	if err := bundleDemo(privateKey); err != nil {
+		log.Fatal(err)
+	}
 	priv2, pub2 := decode(encPriv, encPub)
 	if !reflect.DeepEqual(privateKey, priv2) {
 		fmt.Println("Private keys do not match.")
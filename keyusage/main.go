@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ecdsa/internal/keyfile"
+)
+
+func check(name string, err error, want error) {
+	if !errors.Is(err, want) {
+		log.Fatalf("%s: got %v, want %v", name, err, want)
+	}
+	fmt.Printf("%s: %v\n", name, err)
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "keyusage")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ringPath := filepath.Join(dir, "keyring.json")
+	ring, err := keyfile.OpenKeyRing(ringPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	msg := []byte("hello, world")
+
+	// An RSA key for PSS signatures only.
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signer, err := ring.NewSigningKey(rsaKey, keyfile.KeyMetadata{Created: now, Usage: []string{keyfile.UsageSign}, Algorithms: []string{keyfile.AlgRSAPSSSHA256}, Owner: "demo"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sig, err := signer.Sign(keyfile.AlgRSAPSSSHA256, msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("RSA-PSS signature verified:", signer.Public().Verify(keyfile.AlgRSAPSSSHA256, msg, sig) == nil)
+	_, err = signer.Sign(keyfile.AlgRSAPKCS1SHA256, msg)
+	check("PKCS #1 v1.5 with a PSS-only key", err, keyfile.ErrAlgorithm)
+	_, err = ring.NewDecryptionKey(rsaKey, keyfile.KeyMetadata{Usage: []string{keyfile.UsageDecrypt}, Algorithms: []string{keyfile.AlgRSAOAEPSHA256}})
+	check("same RSA key for decryption", err, keyfile.ErrKeyReuse)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, err = ring.NewSigningKey(other, keyfile.KeyMetadata{Usage: []string{keyfile.UsageSign, keyfile.UsageDecrypt}, Algorithms: []string{keyfile.AlgRSAPSSSHA256, keyfile.AlgRSAOAEPSHA256}})
+	check("sign and decrypt on one key", err, keyfile.ErrMixedPurpose)
+
+	// The signing key keeps its constraints across export and import.
+	pemEncoded, err := signer.MarshalPEM()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(pemEncoded[:200], "...\n")
+	// A ring opened from the same file, as another program would, still
+	// knows what the key is for.
+	reopened, err := keyfile.OpenKeyRing(ringPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loaded, err := reopened.ParseSigningKey(pemEncoded)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("loaded usages %v, algorithms %v\n", loaded.Metadata().Usage, loaded.Metadata().Algorithms)
+	_, err = reopened.ParseDecryptionKey(pemEncoded)
+	check("signing key loaded as decryption key", err, keyfile.ErrKeyUsage)
+	_, err = reopened.NewDecryptionKey(rsaKey, keyfile.KeyMetadata{Usage: []string{keyfile.UsageDecrypt}})
+	check("same RSA key for decryption, reopened ring", err, keyfile.ErrKeyReuse)
+
+	// ECDSA keys cannot double as ECDH keys.
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := ring.NewSigningKey(ecKey, keyfile.KeyMetadata{Usage: []string{keyfile.UsageSign}, Algorithms: []string{keyfile.AlgECDSASHA256}}); err != nil {
+		log.Fatal(err)
+	}
+	_, err = ring.NewAgreementKey(ecKey, keyfile.KeyMetadata{Usage: []string{keyfile.UsageDerive}, Algorithms: []string{keyfile.AlgECDHHKDFSHA256}})
+	check("ECDSA key for ECDH", err, keyfile.ErrKeyReuse)
+
+	deriveMeta := keyfile.KeyMetadata{Usage: []string{keyfile.UsageDerive}, Algorithms: []string{keyfile.AlgECDHHKDFSHA256}}
+	a, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	b, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	alice, err := ring.NewAgreementKey(a, deriveMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bob, err := ring.NewAgreementKey(b, deriveMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	k1, _ := alice.Derive(keyfile.AlgECDHHKDFSHA256, bob.PublicKey(), "demo session")
+	k2, _ := bob.Derive(keyfile.AlgECDHHKDFSHA256, alice.PublicKey(), "demo session")
+	fmt.Println("ECDH keys agree:", string(k1) == string(k2))
+
+	// RSA encryption, with and without the wrap usage.
+	encKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	decrypter, err := ring.NewDecryptionKey(encKey, keyfile.KeyMetadata{Usage: []string{keyfile.UsageDecrypt}, Algorithms: []string{keyfile.AlgRSAOAEPSHA256}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ct, err := decrypter.Public().Encrypt(keyfile.AlgRSAOAEPSHA256, msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pt, err := decrypter.Decrypt(keyfile.AlgRSAOAEPSHA256, ct)
+	fmt.Println("RSA-OAEP round trip:", err == nil && string(pt) == string(msg))
+
+	dekBytes := make([]byte, 32)
+	rand.Read(dekBytes)
+	dek, err := ring.NewSecretKey(dekBytes, keyfile.KeyMetadata{Usage: []string{keyfile.UsageEncrypt, keyfile.UsageDecrypt}, Algorithms: []string{keyfile.AlgAESGCM}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = decrypter.Public().Wrap(keyfile.AlgRSAOAEPSHA256, dek)
+	check("wrap with an encrypt-only key", err, keyfile.ErrKeyUsage)
+
+	kekBytes := make([]byte, 32)
+	rand.Read(kekBytes)
+	kek, err := ring.NewSecretKey(kekBytes, keyfile.KeyMetadata{Usage: []string{keyfile.UsageWrap}, Algorithms: []string{keyfile.AlgAESGCM}, Expires: now.Add(time.Hour)})
+	if err != nil {
+		log.Fatal(err)
+	}
+	wrapped, err := kek.Wrap(keyfile.AlgAESGCM, dek)
+	if err != nil {
+		log.Fatal(err)
+	}
+	unwrapped, err := kek.Unwrap(ring, keyfile.AlgAESGCM, wrapped, dek.Metadata())
+	if err != nil {
+		log.Fatal(err)
+	}
+	sealed, err := dek.Encrypt(keyfile.AlgAESGCM, msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opened, err := unwrapped.Decrypt(keyfile.AlgAESGCM, sealed)
+	fmt.Println("AES-GCM wrap round trip:", err == nil && string(opened) == string(msg))
+	_, err = kek.Encrypt(keyfile.AlgAESGCM, msg)
+	check("encrypt with a wrap-only key", err, keyfile.ErrKeyUsage)
+
+	old, err := ring.NewSecretKey(kekBytes, keyfile.KeyMetadata{ID: kek.Metadata().ID, Usage: []string{keyfile.UsageWrap}, Expires: now.Add(-time.Minute)})
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = old.Wrap(keyfile.AlgAESGCM, dek)
+	check("expired key", err, keyfile.ErrKeyExpired)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Client is a KMIP client over one TLS connection. Each call sends a
+// request with a single batch item and waits for its response.
+type Client struct {
+	mu   sync.Mutex
+	conn *tls.Conn
+}
+
+func Dial(addr string, config *tls.Config) (*Client, error) {
+	config = config.Clone()
+	config.MinVersion = tls.VersionTLS12
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+func (c *Client) Close() error { return c.conn.Close() }
+
+func (c *Client) do(op uint32, payload ...*Item) (*Item, error) {
+	req := Struct(TagRequestMessage,
+		Struct(TagRequestHeader,
+			Struct(TagProtocolVersion, Int(TagProtocolVersionMajor, protocolMajor), Int(TagProtocolVersionMinor, protocolMinor)),
+			Int(TagBatchCount, 1)),
+		Struct(TagBatchItem,
+			Enum(TagOperation, op),
+			Struct(TagRequestPayload, payload...)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := c.conn.Write(req.Marshal()); err != nil {
+		return nil, err
+	}
+	resp, err := ReadMessage(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	items := resp.Children(TagBatchItem)
+	if resp.Tag != TagResponseMessage || len(items) != 1 {
+		return nil, errors.New("kmip: unexpected response")
+	}
+	bi := items[0]
+	if bi.Child(TagResultStatus).Enum() != StatusSuccess {
+		return nil, &Error{bi.Child(TagResultReason).Enum(), bi.Child(TagResultMessage).Text()}
+	}
+	if got := bi.Child(TagOperation).Enum(); got != op {
+		return nil, fmt.Errorf("kmip: response is for operation 0x%02x", got)
+	}
+	return bi.Child(TagResponsePayload), nil
+}
+
+// Template holds the attributes of a new object. An object is created
+// pre-active unless Activate is set.
+type Template struct {
+	Algorithm uint32
+	Length    int32
+	UsageMask int32
+	Name      string
+	Activate  bool
+}
+
+// Attribute builds an Attribute structure; value's tag is replaced.
+func Attribute(name string, value *Item) *Item {
+	value.Tag = TagAttributeValue
+	return Struct(TagAttribute, Text(TagAttributeName, name), value)
+}
+
+func NameAttribute(name string) *Item {
+	return Attribute(AttrName, Struct(0, Text(TagNameValue, name), Enum(TagNameType, NameText)))
+}
+
+func (t Template) attributes() []*Item {
+	var attrs []*Item
+	if t.Algorithm != 0 {
+		attrs = append(attrs, Attribute(AttrCryptographicAlgorithm, Enum(0, t.Algorithm)))
+	}
+	if t.Length != 0 {
+		attrs = append(attrs, Attribute(AttrCryptographicLength, Int(0, t.Length)))
+	}
+	if t.UsageMask != 0 {
+		attrs = append(attrs, Attribute(AttrCryptographicUsageMask, Int(0, t.UsageMask)))
+	}
+	if t.Name != "" {
+		attrs = append(attrs, NameAttribute(t.Name))
+	}
+	if t.Activate {
+		attrs = append(attrs, Attribute(AttrActivationDate, Time(0, time.Now())))
+	}
+	return attrs
+}
+
+// Create makes a symmetric key on the server.
+func (c *Client) Create(t Template) (string, error) {
+	p, err := c.do(OpCreate, Enum(TagObjectType, ObjectSymmetricKey), Struct(TagTemplateAttribute, t.attributes()...))
+	if err != nil {
+		return "", err
+	}
+	return p.Child(TagUniqueIdentifier).Text(), nil
+}
+
+// CreateKeyPair makes an RSA or EC key pair; Length is the modulus size
+// or the curve size.
+func (c *Client) CreateKeyPair(t Template) (privateID, publicID string, err error) {
+	p, err := c.do(OpCreateKeyPair, Struct(TagCommonTemplateAttribute, t.attributes()...))
+	if err != nil {
+		return "", "", err
+	}
+	return p.Child(TagPrivateKeyUniqueIdentifier).Text(), p.Child(TagPublicKeyUniqueIdentifier).Text(), nil
+}
+
+// Register stores an existing key: a []byte AES key, or an RSA or ECDSA
+// private or public key. Algorithm and length come from the key.
+func (c *Client) Register(t Template, key any) (string, error) {
+	var typ, format uint32
+	var material []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		typ, format, material = ObjectSymmetricKey, FormatRaw, k
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		typ, format = ObjectPrivateKey, FormatPKCS8
+		material, err = x509.MarshalPKCS8PrivateKey(k)
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		typ, format = ObjectPublicKey, FormatX509
+		material, err = x509.MarshalPKIXPublicKey(k)
+	default:
+		return "", fmt.Errorf("kmip: cannot register a %T", key)
+	}
+	if err != nil {
+		return "", err
+	}
+	t.Algorithm, t.Length = 0, 0
+	p, err := c.do(OpRegister,
+		Enum(TagObjectType, typ),
+		Struct(TagTemplateAttribute, t.attributes()...),
+		Struct(objectTags[typ], Struct(TagKeyBlock,
+			Enum(TagKeyFormatType, format),
+			Struct(TagKeyValue, Bytes(TagKeyMaterial, material)))))
+	if err != nil {
+		return "", err
+	}
+	return p.Child(TagUniqueIdentifier).Text(), nil
+}
+
+// Key is a managed object returned by Get.
+type Key struct {
+	ID        string
+	Type      uint32
+	Algorithm uint32
+	Length    int32
+	Format    uint32
+	Material  []byte
+}
+
+// Parse returns the key as a []byte, or an RSA or ECDSA key.
+func (k *Key) Parse() (any, error) {
+	switch k.Format {
+	case FormatRaw:
+		return k.Material, nil
+	case FormatPKCS8:
+		return x509.ParsePKCS8PrivateKey(k.Material)
+	case FormatX509:
+		return x509.ParsePKIXPublicKey(k.Material)
+	}
+	return nil, fmt.Errorf("kmip: unsupported key format 0x%x", k.Format)
+}
+
+func (c *Client) Get(id string) (*Key, error) {
+	p, err := c.do(OpGet, Text(TagUniqueIdentifier, id))
+	if err != nil {
+		return nil, err
+	}
+	typ := p.Child(TagObjectType).Enum()
+	block := p.Child(objectTags[typ]).Child(TagKeyBlock)
+	if block == nil {
+		return nil, errors.New("kmip: response has no key block")
+	}
+	return &Key{
+		ID:        p.Child(TagUniqueIdentifier).Text(),
+		Type:      typ,
+		Algorithm: block.Child(TagCryptographicAlgorithm).Enum(),
+		Length:    block.Child(TagCryptographicLength).Int(),
+		Format:    block.Child(TagKeyFormatType).Enum(),
+		Material:  block.Child(TagKeyValue).Child(TagKeyMaterial).Bytes(),
+	}, nil
+}
+
+// Locate returns the ids of objects matching every attribute, at most
+// max if max > 0.
+func (c *Client) Locate(max int32, attrs ...*Item) ([]string, error) {
+	payload := attrs
+	if max > 0 {
+		payload = append([]*Item{Int(TagMaximumItems, max)}, attrs...)
+	}
+	p, err := c.do(OpLocate, payload...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, it := range p.Children(TagUniqueIdentifier) {
+		ids = append(ids, it.Text())
+	}
+	return ids, nil
+}
+
+func (c *Client) Activate(id string) error {
+	_, err := c.do(OpActivate, Text(TagUniqueIdentifier, id))
+	return err
+}
+
+func (c *Client) Revoke(id string, reason uint32, message string) error {
+	var msg *Item
+	if message != "" {
+		msg = Text(TagRevocationMessage, message)
+	}
+	_, err := c.do(OpRevoke, Text(TagUniqueIdentifier, id), Struct(TagRevocationReason, Enum(TagRevocationReasonCode, reason), msg))
+	return err
+}
+
+func (c *Client) Destroy(id string) error {
+	_, err := c.do(OpDestroy, Text(TagUniqueIdentifier, id))
+	return err
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Keys for register and get are PEM files in the keys/ format (SEC1
+// "PRIVATE KEY", PKIX "PUBLIC KEY") or the rsa/ format (PKCS #1 "RSA
+// PRIVATE KEY", PKIX "RSA PUBLIC KEY"); AES keys are hex. TLS
+// certificates and keys are ordinary PEM files.
+
+func readKey(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func encodeKey(key any) ([]byte, error) {
+	var typ string
+	var der []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		return []byte(hex.EncodeToString(k) + "\n"), nil
+	case *ecdsa.PrivateKey:
+		typ = "PRIVATE KEY"
+		der, err = x509.MarshalECPrivateKey(k)
+	case *rsa.PrivateKey:
+		typ, der = "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(k)
+	case *ecdsa.PublicKey:
+		typ = "PUBLIC KEY"
+		der, err = x509.MarshalPKIXPublicKey(k)
+	case *rsa.PublicKey:
+		typ = "RSA PUBLIC KEY"
+		der, err = x509.MarshalPKIXPublicKey(k)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), nil
+}
+
+func tlsConfig(certFile, keyFile, caFile string, server bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("%s: no certificates", caFile)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if server {
+		config.ClientCAs = pool
+	} else {
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+var algorithms = map[string]uint32{"AES": AlgAES, "RSA": AlgRSA, "EC": AlgECDSA}
+
+var reasons = map[string]uint32{
+	"unspecified": RevokeUnspecified,
+	"compromise":  RevokeKeyCompromise,
+	"superseded":  RevokeSuperseded,
+	"cessation":   RevokeCessationOfOperation,
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: kmip [flags] <command> [flags]
+
+commands:
+  serve -listen ADDR -store FILE        run a server; master key in $CRYPTO_KMIP_MASTER_KEY
+  create -length N -name NAME           create an AES key
+  create-key-pair -alg RSA|EC -length N create a key pair
+  register -in FILE                     register a key (PEM or hex AES key)
+  get -id ID -out FILE                  fetch a key
+  locate [-name NAME] [-state STATE]    list matching object ids
+  activate|destroy -id ID
+  revoke -id ID -reason unspecified|compromise|superseded|cessation
+
+Without a command, a demo runs a server and client in-process.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	server := flag.String("server", "127.0.0.1:5696", "server address")
+	listen := flag.String("listen", ":5696", "address to serve on")
+	storePath := flag.String("store", "kmip-store.json", "key store file")
+	certFile := flag.String("cert", "", "TLS certificate (PEM)")
+	keyFile := flag.String("key", "", "TLS private key (PEM)")
+	caFile := flag.String("ca", "", "CA certificate that issued the peer's certificate (PEM)")
+	alg := flag.String("alg", "AES", "algorithm: AES, RSA or EC")
+	length := flag.Int("length", 256, "key length in bits, or the curve size")
+	name := flag.String("name", "", "object name")
+	activate := flag.Bool("activate", false, "create the object active")
+	id := flag.String("id", "", "object identifier")
+	reason := flag.String("reason", "unspecified", "revocation reason")
+	message := flag.String("message", "", "revocation message")
+	state := flag.String("state", "", "locate objects in this state, e.g. Active")
+	max := flag.Int("max", 0, "locate at most this many objects")
+	in := flag.String("in", "", "key file to register")
+	out := flag.String("out", "", "file to write the key to (default stdout)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if err := demo(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+
+	if cmd == "serve" {
+		masterKey, err := hex.DecodeString(os.Getenv("CRYPTO_KMIP_MASTER_KEY"))
+		if err != nil {
+			log.Fatal("CRYPTO_KMIP_MASTER_KEY: ", err)
+		}
+		store, err := OpenStore(*storePath, masterKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config, err := tlsConfig(*certFile, *keyFile, *caFile, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal((&Server{Store: store, TLS: config}).Serve(l))
+	}
+
+	config, err := tlsConfig(*certFile, *keyFile, *caFile, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := Dial(*server, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	t := Template{Algorithm: algorithms[*alg], Length: int32(*length), Name: *name, Activate: *activate}
+
+	switch cmd {
+	case "create":
+		newID, err := c.Create(t)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(newID)
+	case "create-key-pair":
+		privID, pubID, err := c.CreateKeyPair(t)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("private %s\npublic %s\n", privID, pubID)
+	case "register":
+		key, err := readKey(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		newID, err := c.Register(t, key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(newID)
+	case "get":
+		k, err := c.Get(*id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := k.Parse()
+		if err != nil {
+			log.Fatal(err)
+		}
+		data, err := encodeKey(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *out == "" {
+			os.Stdout.Write(data)
+		} else if err := os.WriteFile(*out, data, 0600); err != nil {
+			log.Fatal(err)
+		}
+	case "locate":
+		var attrs []*Item
+		if *name != "" {
+			attrs = append(attrs, NameAttribute(*name))
+		}
+		if *state != "" {
+			found := false
+			for v, n := range stateNames {
+				if strings.EqualFold(n, *state) {
+					attrs, found = append(attrs, Attribute(AttrState, Enum(0, v))), true
+				}
+			}
+			if !found {
+				log.Fatalf("unknown state %q", *state)
+			}
+		}
+		ids, err := c.Locate(int32(*max), attrs...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+	case "activate":
+		err = c.Activate(*id)
+	case "revoke":
+		code, ok := reasons[*reason]
+		if !ok {
+			log.Fatalf("unknown revocation reason %q", *reason)
+		}
+		err = c.Revoke(*id, code, *message)
+	case "destroy":
+		err = c.Destroy(*id)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+// issue makes a certificate for key signed by parent (self-signed if
+// parent is nil).
+func issue(name string, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, usage x509.ExtKeyUsage) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	return must(x509.ParseCertificate(must(x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey))))
+}
+
+func tlsCert(cert *x509.Certificate, key *ecdsa.PrivateKey) tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func demo() error {
+	// Encodings from section 9.1.2 of the KMIP 1.2 specification.
+	examples := []struct {
+		item *Item
+		hex  string
+	}{
+		{Int(0x420020, 8), "42002002000000040000000800000000"},
+		{Long(0x420020, 123456789000000000), "420020030000000801B69B4BA5749200"},
+		{Enum(0x420020, 255), "4200200500000004000000FF00000000"},
+		{Bool(0x420020, true), "42002006000000080000000000000001"},
+		{Text(0x420020, "Hello World"), "420020070000000B48656C6C6F20576F726C640000000000"},
+		{Bytes(0x420020, []byte{1, 2, 3}), "42002008000000030102030000000000"},
+		{Time(0x420020, time.Date(2008, 3, 14, 11, 56, 40, 0, time.UTC)), "42002009000000080000000047DA67F8"},
+		{Struct(0x420020, Enum(0x420004, 254), Int(0x420005, 255)), "42002001000000204200040500000004000000FE000000004200050200000004000000FF00000000"},
+	}
+	ok := true
+	for _, e := range examples {
+		enc := e.item.Marshal()
+		back, err := Unmarshal(enc)
+		ok = ok && strings.EqualFold(hex.EncodeToString(enc), e.hex) && err == nil && bytes.Equal(back.Marshal(), enc)
+	}
+	fmt.Println("TTLV encodings match the KMIP 1.2 examples:", ok)
+
+	caKey := must(ecdsa.GenerateKey(elliptic.P256(), rand.Reader))
+	ca := issue("demo KMIP CA", caKey, nil, nil, 0)
+	serverKey := must(ecdsa.GenerateKey(elliptic.P256(), rand.Reader))
+	clientKey := must(ecdsa.GenerateKey(elliptic.P256(), rand.Reader))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	tmp, err := os.MkdirTemp("", "kmip")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+	storePath := filepath.Join(tmp, "store.json")
+	store, err := OpenStore(storePath, masterKey)
+	if err != nil {
+		return err
+	}
+	srv := &Server{Store: store, TLS: &tls.Config{
+		Certificates: []tls.Certificate{tlsCert(issue("kmip server", serverKey, ca, caKey, x509.ExtKeyUsageServerAuth), serverKey)},
+		ClientCAs:    pool,
+	}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go srv.Serve(l)
+
+	c, err := Dial(l.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{tlsCert(issue("storage-appliance-7", clientKey, ca, caKey, x509.ExtKeyUsageClientAuth), clientKey)},
+		RootCAs:      pool,
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// A symmetric key through its life cycle.
+	fmt.Println()
+	volID, err := c.Create(Template{Algorithm: AlgAES, Length: 256, UsageMask: UsageEncrypt | UsageDecrypt, Name: "volume-0042"})
+	if err != nil {
+		return err
+	}
+	found, _ := c.Locate(0, NameAttribute("volume-0042"))
+	fmt.Printf("created AES-256 key %s, located by name: %v\n", volID, found)
+	if err := c.Activate(volID); err != nil {
+		return err
+	}
+	vol, err := c.Get(volID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("activated and fetched: %d-bit key, %d bytes of material\n", vol.Length, len(vol.Material))
+	fmt.Println("activate again:", c.Activate(volID))
+	fmt.Println("destroy while active:", c.Destroy(volID))
+	if err := c.Revoke(volID, RevokeSuperseded, "rotated to a new volume key"); err != nil {
+		return err
+	}
+	if err := c.Destroy(volID); err != nil {
+		return err
+	}
+	_, err = c.Get(volID)
+	fmt.Println("get after revoke and destroy:", err)
+
+	// Key pairs, used after fetching both halves.
+	fmt.Println()
+	for _, t := range []Template{
+		{Algorithm: AlgRSA, Length: 2048, Name: "firmware-signing", Activate: true},
+		{Algorithm: AlgECDSA, Length: 384, Name: "log-signing", Activate: true},
+	} {
+		privID, pubID, err := c.CreateKeyPair(t)
+		if err != nil {
+			return err
+		}
+		priv := must(must(c.Get(privID)).Parse())
+		pub := must(must(c.Get(pubID)).Parse())
+		digest := sha256.Sum256([]byte("firmware image"))
+		var valid bool
+		switch k := priv.(type) {
+		case *rsa.PrivateKey:
+			sig := must(rsa.SignPSS(rand.Reader, k, 5, digest[:], nil))
+			valid = rsa.VerifyPSS(pub.(*rsa.PublicKey), 5, digest[:], sig, nil) == nil
+		case *ecdsa.PrivateKey:
+			sig := must(ecdsa.SignASN1(rand.Reader, k, digest[:]))
+			valid = ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig)
+		}
+		fmt.Printf("key pair %s/%s (%T): signature verifies with the fetched public key: %v\n", privID, pubID, priv, valid)
+	}
+
+	// Keys made elsewhere can be registered.
+	imported := make([]byte, 16)
+	rand.Read(imported)
+	impID, err := c.Register(Template{Name: "legacy-backup", Activate: true}, imported)
+	if err != nil {
+		return err
+	}
+	ecKey := must(ecdsa.GenerateKey(elliptic.P256(), rand.Reader))
+	pubID, err := c.Register(Template{Name: "partner-verify"}, &ecKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	got := must(c.Get(impID))
+	gotPub := must(must(c.Get(pubID)).Parse())
+	fmt.Printf("registered AES-%d key %s and EC public key %s: round trip %v\n",
+		got.Length, impID, pubID, bytes.Equal(got.Material, imported) && ecKey.PublicKey.Equal(gotPub))
+
+	if err := c.Revoke(impID, RevokeKeyCompromise, "backup tape lost"); err != nil {
+		return err
+	}
+	compromised, _ := c.Locate(0, Attribute(AttrState, Enum(0, StateCompromised)))
+	active, _ := c.Locate(0, Attribute(AttrState, Enum(0, StateActive)))
+	fmt.Printf("compromised: %v, active: %v\n", compromised, active)
+
+	fmt.Println()
+	_, err = c.Get("9999")
+	fmt.Println("unknown id:", err)
+	_, err = c.Create(Template{Algorithm: AlgAES, Length: 100})
+	fmt.Println("bad length:", err)
+	var kerr *Error
+	fmt.Println("  reason is Invalid Field:", errors.As(err, &kerr) && kerr.Reason == ReasonInvalidField)
+
+	// The server names its CA in the certificate request, so crypto/tls
+	// would send no certificate from another CA; GetClientCertificate
+	// makes the client present the rogue one anyway.
+	rogueKey := must(ecdsa.GenerateKey(elliptic.P256(), rand.Reader))
+	rogueCA := issue("rogue CA", rogueKey, nil, nil, 0)
+	rogueCert := tlsCert(issue("rogue", rogueKey, rogueCA, rogueKey, x509.ExtKeyUsageClientAuth), rogueKey)
+	for _, tc := range []struct {
+		name   string
+		config *tls.Config
+	}{
+		{"a certificate from an untrusted CA", &tls.Config{
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &rogueCert, nil },
+			RootCAs:              pool,
+		}},
+		{"no certificate", &tls.Config{RootCAs: pool}},
+	} {
+		rogue, err := Dial(l.Addr().String(), tc.config)
+		if err == nil {
+			// TLS 1.3 reports a rejected client certificate on first read.
+			_, err = rogue.Locate(0)
+			rogue.Close()
+		}
+		fmt.Printf("client with %s rejected: %v\n", tc.name, err)
+	}
+
+	// Key material on disk is sealed under the master key.
+	data, err := os.ReadFile(storePath)
+	if err != nil {
+		return err
+	}
+	reopened, err := OpenStore(storePath, masterKey)
+	if err != nil {
+		return err
+	}
+	_, material, err := reopened.Get(pubID)
+	fmt.Printf("store reopened: %v, imported key absent from the file: %v\n",
+		err == nil && len(material) > 0, !bytes.Contains(data, imported) && !strings.Contains(string(data), hex.EncodeToString(imported)))
+	return nil
+}
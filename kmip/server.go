@@ -0,0 +1,430 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"time"
+)
+
+// Error is a failed batch item: a KMIP result reason and message.
+type Error struct {
+	Reason  uint32
+	Message string
+}
+
+func (e *Error) Error() string {
+	name, ok := reasonNames[e.Reason]
+	if !ok {
+		name = fmt.Sprintf("reason 0x%x", e.Reason)
+	}
+	return fmt.Sprintf("kmip: %s: %s", name, e.Message)
+}
+
+func failf(reason uint32, format string, args ...any) *Error {
+	return &Error{reason, fmt.Sprintf(format, args...)}
+}
+
+// Server answers KMIP requests over TLS from clients holding a
+// certificate issued by one of the configured client CAs.
+type Server struct {
+	Store *Store
+	TLS   *tls.Config
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	config := s.TLS.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.MinVersion = tls.VersionTLS12
+	l = tls.NewListener(l, config)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.handle(conn); err != nil {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) error {
+	defer conn.Close()
+	if err := conn.(*tls.Conn).Handshake(); err != nil {
+		return err
+	}
+	for {
+		req, err := ReadMessage(conn)
+		if err != nil {
+			if errors.Is(err, errTTLV) {
+				return err
+			}
+			return nil // client went away
+		}
+		if _, err := conn.Write(s.Handle(req).Marshal()); err != nil {
+			return err
+		}
+	}
+}
+
+// Handle processes a Request Message and returns the Response Message.
+// Batch items are processed in order and independently.
+func (s *Server) Handle(req *Item) *Item {
+	header := req.Child(TagRequestHeader)
+	version := header.Child(TagProtocolVersion)
+	var items []*Item
+	if req.Tag != TagRequestMessage || header == nil || version.Child(TagProtocolVersionMajor).Int() != protocolMajor {
+		items = append(items, result(nil, nil, failf(ReasonInvalidMessage, "not a KMIP 1.x request")))
+	} else {
+		for _, bi := range req.Children(TagBatchItem) {
+			payload, err := s.dispatch(bi.Child(TagOperation).Enum(), bi.Child(TagRequestPayload))
+			items = append(items, result(bi, payload, err))
+		}
+	}
+	return Struct(TagResponseMessage, append([]*Item{
+		Struct(TagResponseHeader,
+			Struct(TagProtocolVersion, Int(TagProtocolVersionMajor, protocolMajor), Int(TagProtocolVersionMinor, protocolMinor)),
+			Time(TagTimeStamp, time.Now()),
+			Int(TagBatchCount, int32(len(items)))),
+	}, items...)...)
+}
+
+func result(req, payload *Item, err error) *Item {
+	var op, id *Item
+	if req != nil {
+		op = req.Child(TagOperation)
+		id = req.Child(TagUniqueBatchItemID)
+	}
+	if err == nil {
+		return Struct(TagBatchItem, op, id, Enum(TagResultStatus, StatusSuccess), payload)
+	}
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+	case errors.Is(err, ErrNotFound):
+		e = &Error{ReasonItemNotFound, err.Error()}
+	case errors.Is(err, ErrState):
+		e = &Error{ReasonPermissionDenied, err.Error()}
+	default:
+		e = &Error{ReasonGeneralFailure, err.Error()}
+	}
+	return Struct(TagBatchItem, op, id,
+		Enum(TagResultStatus, StatusOperationFailed),
+		Enum(TagResultReason, e.Reason),
+		Text(TagResultMessage, e.Message))
+}
+
+func (s *Server) dispatch(op uint32, p *Item) (*Item, error) {
+	if p == nil {
+		return nil, failf(ReasonInvalidMessage, "missing request payload")
+	}
+	switch op {
+	case OpCreate:
+		return s.create(p)
+	case OpCreateKeyPair:
+		return s.createKeyPair(p)
+	case OpRegister:
+		return s.register(p)
+	case OpGet:
+		return s.get(p)
+	case OpLocate:
+		return s.locate(p)
+	case OpActivate, OpRevoke, OpDestroy:
+		id := p.Child(TagUniqueIdentifier).Text()
+		if id == "" {
+			return nil, failf(ReasonMissingData, "missing unique identifier")
+		}
+		var err error
+		switch op {
+		case OpActivate:
+			err = s.Store.Activate(id)
+		case OpRevoke:
+			reason := p.Child(TagRevocationReason)
+			if reason.Child(TagRevocationReasonCode) == nil {
+				return nil, failf(ReasonMissingData, "missing revocation reason")
+			}
+			err = s.Store.Revoke(id, reason.Child(TagRevocationReasonCode).Enum(), reason.Child(TagRevocationMessage).Text())
+		case OpDestroy:
+			err = s.Store.Destroy(id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return Struct(TagResponsePayload, Text(TagUniqueIdentifier, id)), nil
+	}
+	return nil, failf(ReasonOperationNotSupported, "operation 0x%02x", op)
+}
+
+// attributes are the template attributes this server understands.
+type attributes struct {
+	objectType uint32
+	algorithm  uint32
+	length     int32
+	usage      int32
+	names      []string
+	state      uint32
+	activation time.Time
+}
+
+func parseAttributes(items ...*Item) (attributes, error) {
+	var a attributes
+	for _, attr := range items {
+		name := attr.Child(TagAttributeName).Text()
+		v := attr.Child(TagAttributeValue)
+		if v == nil {
+			return a, failf(ReasonInvalidField, "attribute %q has no value", name)
+		}
+		switch name {
+		case AttrObjectType:
+			a.objectType = v.Enum()
+		case AttrCryptographicAlgorithm:
+			a.algorithm = v.Enum()
+		case AttrCryptographicLength:
+			a.length = v.Int()
+		case AttrCryptographicUsageMask:
+			a.usage = v.Int()
+		case AttrName:
+			a.names = append(a.names, v.Child(TagNameValue).Text())
+		case AttrState:
+			a.state = v.Enum()
+		case AttrActivationDate:
+			a.activation = v.Time()
+		default:
+			return a, failf(ReasonInvalidField, "unsupported attribute %q", name)
+		}
+	}
+	return a, nil
+}
+
+// templateAttributes parses the attributes of the template structures
+// with the given tags; later ones override earlier ones.
+func templateAttributes(p *Item, tags ...Tag) (attributes, error) {
+	var items []*Item
+	for _, tag := range tags {
+		items = append(items, p.Child(tag).Children(TagAttribute)...)
+	}
+	return parseAttributes(items...)
+}
+
+// object builds a store record from template attributes; an activation
+// date that has passed creates the object active.
+func (a attributes) object(typ, format uint32) *Object {
+	o := &Object{Type: typ, Algorithm: a.algorithm, Length: a.length, UsageMask: a.usage, Names: a.names, Format: format, State: StatePreActive}
+	if !a.activation.IsZero() && !a.activation.After(time.Now()) {
+		o.State = StateActive
+	}
+	return o
+}
+
+func (s *Server) create(p *Item) (*Item, error) {
+	a, err := templateAttributes(p, TagTemplateAttribute)
+	if err != nil {
+		return nil, err
+	}
+	if p.Child(TagObjectType).Enum() != ObjectSymmetricKey {
+		return nil, failf(ReasonInvalidField, "Create makes symmetric keys only")
+	}
+	if a.algorithm != AlgAES || a.length != 128 && a.length != 192 && a.length != 256 {
+		return nil, failf(ReasonInvalidField, "unsupported algorithm 0x%x or length %d", a.algorithm, a.length)
+	}
+	key := make([]byte, a.length/8)
+	rand.Read(key)
+	defer clear(key)
+	ids, err := s.Store.Add([]*Object{a.object(ObjectSymmetricKey, FormatRaw)}, [][]byte{key})
+	if err != nil {
+		return nil, err
+	}
+	return Struct(TagResponsePayload, Enum(TagObjectType, ObjectSymmetricKey), Text(TagUniqueIdentifier, ids[0])), nil
+}
+
+func ecCurve(length int32) elliptic.Curve {
+	switch length {
+	case 256:
+		return elliptic.P256()
+	case 384:
+		return elliptic.P384()
+	case 521:
+		return elliptic.P521()
+	}
+	return nil
+}
+
+func (s *Server) createKeyPair(p *Item) (*Item, error) {
+	a, err := templateAttributes(p, TagCommonTemplateAttribute)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := templateAttributes(p, TagCommonTemplateAttribute, TagPrivateKeyTemplateAttribute)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := templateAttributes(p, TagCommonTemplateAttribute, TagPublicKeyTemplateAttribute)
+	if err != nil {
+		return nil, err
+	}
+	if priv.usage == 0 {
+		priv.usage = UsageSign
+	}
+	if pub.usage == 0 {
+		pub.usage = UsageVerify
+	}
+	var key crypto.Signer
+	switch {
+	case a.algorithm == AlgRSA && (a.length == 2048 || a.length == 3072 || a.length == 4096):
+		key, err = rsa.GenerateKey(rand.Reader, int(a.length))
+	case a.algorithm == AlgECDSA && ecCurve(a.length) != nil:
+		key, err = ecdsa.GenerateKey(ecCurve(a.length), rand.Reader)
+	default:
+		return nil, failf(ReasonInvalidField, "unsupported algorithm 0x%x or length %d", a.algorithm, a.length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(privDER)
+	pubDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	ids, err := s.Store.Add(
+		[]*Object{priv.object(ObjectPrivateKey, FormatPKCS8), pub.object(ObjectPublicKey, FormatX509)},
+		[][]byte{privDER, pubDER})
+	if err != nil {
+		return nil, err
+	}
+	return Struct(TagResponsePayload,
+		Text(TagPrivateKeyUniqueIdentifier, ids[0]),
+		Text(TagPublicKeyUniqueIdentifier, ids[1])), nil
+}
+
+// keyInfo checks key material against its format and returns its
+// algorithm and length.
+func keyInfo(typ, format uint32, material []byte) (uint32, int32, error) {
+	var key any
+	var err error
+	switch {
+	case typ == ObjectSymmetricKey && format == FormatRaw:
+		if n := len(material); n != 16 && n != 24 && n != 32 {
+			return 0, 0, failf(ReasonInvalidField, "AES keys are 16, 24 or 32 bytes")
+		}
+		return AlgAES, int32(len(material) * 8), nil
+	case typ == ObjectPrivateKey && format == FormatPKCS8:
+		key, err = x509.ParsePKCS8PrivateKey(material)
+	case typ == ObjectPublicKey && format == FormatX509:
+		key, err = x509.ParsePKIXPublicKey(material)
+	default:
+		return 0, 0, failf(ReasonInvalidField, "unsupported key format 0x%x for object type 0x%x", format, typ)
+	}
+	if err != nil {
+		return 0, 0, failf(ReasonInvalidField, "key material: %v", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return AlgRSA, int32(k.N.BitLen()), nil
+	case *rsa.PublicKey:
+		return AlgRSA, int32(k.N.BitLen()), nil
+	case *ecdsa.PrivateKey:
+		return AlgECDSA, int32(k.Curve.Params().BitSize), nil
+	case *ecdsa.PublicKey:
+		return AlgECDSA, int32(k.Curve.Params().BitSize), nil
+	}
+	return 0, 0, failf(ReasonInvalidField, "unsupported key type %T", key)
+}
+
+var objectTags = map[uint32]Tag{
+	ObjectSymmetricKey: TagSymmetricKey,
+	ObjectPublicKey:    TagPublicKey,
+	ObjectPrivateKey:   TagPrivateKey,
+}
+
+func (s *Server) register(p *Item) (*Item, error) {
+	a, err := templateAttributes(p, TagTemplateAttribute)
+	if err != nil {
+		return nil, err
+	}
+	typ := p.Child(TagObjectType).Enum()
+	tag, ok := objectTags[typ]
+	if !ok {
+		return nil, failf(ReasonInvalidField, "unsupported object type 0x%x", typ)
+	}
+	block := p.Child(tag).Child(TagKeyBlock)
+	material := block.Child(TagKeyValue).Child(TagKeyMaterial).Bytes()
+	if material == nil {
+		return nil, failf(ReasonMissingData, "missing key material")
+	}
+	format := block.Child(TagKeyFormatType).Enum()
+	a.algorithm, a.length, err = keyInfo(typ, format, material)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := s.Store.Add([]*Object{a.object(typ, format)}, [][]byte{material})
+	if err != nil {
+		return nil, err
+	}
+	return Struct(TagResponsePayload, Text(TagUniqueIdentifier, ids[0])), nil
+}
+
+func keyBlock(o *Object, material []byte) *Item {
+	return Struct(TagKeyBlock,
+		Enum(TagKeyFormatType, o.Format),
+		Struct(TagKeyValue, Bytes(TagKeyMaterial, material)),
+		Enum(TagCryptographicAlgorithm, o.Algorithm),
+		Int(TagCryptographicLength, o.Length))
+}
+
+func (s *Server) get(p *Item) (*Item, error) {
+	id := p.Child(TagUniqueIdentifier).Text()
+	if id == "" {
+		return nil, failf(ReasonMissingData, "missing unique identifier")
+	}
+	o, material, err := s.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return Struct(TagResponsePayload,
+		Enum(TagObjectType, o.Type),
+		Text(TagUniqueIdentifier, id),
+		Struct(objectTags[o.Type], keyBlock(o, material))), nil
+}
+
+func (s *Server) locate(p *Item) (*Item, error) {
+	a, err := parseAttributes(p.Children(TagAttribute)...)
+	if err != nil {
+		return nil, err
+	}
+	var filters []func(*Object) bool
+	if a.objectType != 0 {
+		filters = append(filters, func(o *Object) bool { return o.Type == a.objectType })
+	}
+	if a.algorithm != 0 {
+		filters = append(filters, func(o *Object) bool { return o.Algorithm == a.algorithm })
+	}
+	if a.length != 0 {
+		filters = append(filters, func(o *Object) bool { return o.Length == a.length })
+	}
+	if a.state != 0 {
+		filters = append(filters, func(o *Object) bool { return o.State == a.state })
+	}
+	for _, name := range a.names {
+		filters = append(filters, func(o *Object) bool { return slices.Contains(o.Names, name) })
+	}
+	var out []*Item
+	for _, id := range s.Store.Locate(int(p.Child(TagMaximumItems).Int()), filters...) {
+		out = append(out, Text(TagUniqueIdentifier, id))
+	}
+	return Struct(TagResponsePayload, out...), nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The store keeps managed objects with their attributes and life-cycle
+// state. Key material is sealed with AES-256-GCM under the master key,
+// bound to the object's id, and dropped when the object is destroyed;
+// the rest of the record stays for audit.
+
+var (
+	ErrNotFound = errors.New("no object with this identifier")
+	ErrState    = errors.New("operation not allowed in the object's state")
+)
+
+type Object struct {
+	ID        string   `json:"id"`
+	Type      uint32   `json:"type"`
+	Algorithm uint32   `json:"algorithm"`
+	Length    int32    `json:"length"`
+	UsageMask int32    `json:"usage_mask,omitempty"`
+	Names     []string `json:"names,omitempty"`
+	Format    uint32   `json:"format"`
+	Material  []byte   `json:"material,omitempty"` // sealed
+	// Link is the other half of a key pair.
+	Link string `json:"link,omitempty"`
+
+	State             uint32    `json:"state"`
+	Created           time.Time `json:"created"`
+	Activated         time.Time `json:"activated,omitzero"`
+	Deactivated       time.Time `json:"deactivated,omitzero"`
+	Compromised       time.Time `json:"compromised,omitzero"`
+	Destroyed         time.Time `json:"destroyed,omitzero"`
+	RevocationReason  uint32    `json:"revocation_reason,omitempty"`
+	RevocationMessage string    `json:"revocation_message,omitempty"`
+}
+
+type storeFile struct {
+	Next    int                `json:"next"`
+	Objects map[string]*Object `json:"objects"`
+}
+
+type Store struct {
+	Path   string
+	master cipher.AEAD
+	mu     sync.Mutex
+	data   storeFile
+	now    func() time.Time
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// NewStore returns an empty in-memory store.
+func NewStore(masterKey []byte) (*Store, error) {
+	if len(masterKey) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{master: gcm, data: storeFile{Next: 1, Objects: map[string]*Object{}}, now: time.Now}, nil
+}
+
+// OpenStore loads the store at path, creating an empty one if the file
+// does not exist.
+func OpenStore(path string, masterKey []byte) (*Store, error) {
+	s, err := NewStore(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	s.Path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.data.Objects == nil {
+		s.data.Objects = map[string]*Object{}
+	}
+	return s, nil
+}
+
+func (s *Store) save() error {
+	if s.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+func (s *Store) seal(id string, material []byte) []byte {
+	nonce := make([]byte, s.master.NonceSize())
+	rand.Read(nonce)
+	return s.master.Seal(nonce, nonce, material, []byte(id))
+}
+
+// Add stores new objects with their key material, assigning ids. Objects
+// added together are linked to each other, as the halves of a key pair
+// are.
+func (s *Store) Add(objects []*Object, material [][]byte) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for i, o := range objects {
+		o.ID = strconv.Itoa(s.data.Next)
+		s.data.Next++
+		o.Created = s.now().UTC().Truncate(time.Second)
+		if o.State == StateActive {
+			o.Activated = o.Created
+		}
+		o.Material = s.seal(o.ID, material[i])
+		s.data.Objects[o.ID] = o
+		ids = append(ids, o.ID)
+	}
+	if len(objects) == 2 {
+		objects[0].Link, objects[1].Link = ids[1], ids[0]
+	}
+	if err := s.save(); err != nil {
+		for _, id := range ids {
+			delete(s.data.Objects, id)
+		}
+		s.data.Next -= len(ids)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Get returns a copy of the object and its key material.
+func (s *Store) Get(id string) (*Object, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.data.Objects[id]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	if o.Material == nil {
+		return nil, nil, fmt.Errorf("%w: object %s is Destroyed", ErrState, id)
+	}
+	n := s.master.NonceSize()
+	material, err := s.master.Open(nil, o.Material[:n], o.Material[n:], []byte(id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("object %s: unsealing key material: %w", id, err)
+	}
+	cp := *o
+	cp.Material = nil
+	return &cp, material, nil
+}
+
+// Locate returns the ids of objects matching every filter, oldest
+// first, at most max of them if max > 0.
+func (s *Store) Locate(max int, filters ...func(*Object) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, o := range s.data.Objects {
+		match := true
+		for _, f := range filters {
+			match = match && f(o)
+		}
+		if match {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+	if max > 0 && len(ids) > max {
+		ids = ids[:max]
+	}
+	return ids
+}
+
+// update applies f to a copy of the object and keeps the copy only if
+// the store can be saved with it. Sealed material the copy drops is
+// wiped once the change is on disk.
+func (s *Store) update(id string, f func(o *Object, now time.Time) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.data.Objects[id]
+	if !ok {
+		return ErrNotFound
+	}
+	cp := *o
+	if err := f(&cp, s.now().UTC().Truncate(time.Second)); err != nil {
+		return err
+	}
+	s.data.Objects[id] = &cp
+	if err := s.save(); err != nil {
+		s.data.Objects[id] = o
+		return err
+	}
+	if cp.Material == nil {
+		clear(o.Material)
+	}
+	return nil
+}
+
+// Activate moves a pre-active object to active.
+func (s *Store) Activate(id string) error {
+	return s.update(id, func(o *Object, now time.Time) error {
+		if o.State != StatePreActive {
+			return fmt.Errorf("%w: object %s is %s", ErrState, id, stateNames[o.State])
+		}
+		o.State, o.Activated = StateActive, now
+		return nil
+	})
+}
+
+// Revoke deactivates an object, or marks it compromised when the reason
+// is a key compromise. A compromised object stays compromised.
+func (s *Store) Revoke(id string, reason uint32, message string) error {
+	return s.update(id, func(o *Object, now time.Time) error {
+		switch {
+		case o.State == StateDestroyed || o.State == StateDestroyedCompromised:
+			return fmt.Errorf("%w: object %s is Destroyed", ErrState, id)
+		case reason == RevokeKeyCompromise:
+			o.State, o.Compromised = StateCompromised, now
+		case o.State == StateActive:
+			o.State, o.Deactivated = StateDeactivated, now
+		case o.State == StatePreActive:
+			// Never used; it can be retired directly.
+			o.State, o.Deactivated = StateDeactivated, now
+		default:
+			return fmt.Errorf("%w: object %s is %s", ErrState, id, stateNames[o.State])
+		}
+		o.RevocationReason, o.RevocationMessage = reason, message
+		return nil
+	})
+}
+
+// Destroy drops the key material of an object that is not active.
+func (s *Store) Destroy(id string) error {
+	return s.update(id, func(o *Object, now time.Time) error {
+		switch o.State {
+		case StatePreActive, StateDeactivated:
+			o.State = StateDestroyed
+		case StateCompromised:
+			o.State = StateDestroyedCompromised
+		case StateActive:
+			return fmt.Errorf("%w: object %s is Active; revoke it first", ErrState, id)
+		default:
+			return fmt.Errorf("%w: object %s is already Destroyed", ErrState, id)
+		}
+		o.Material, o.Destroyed = nil, now
+		return nil
+	})
+}
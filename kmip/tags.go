@@ -0,0 +1,224 @@
+package main
+
+// Tags and enumerations of KMIP 1.2 used by this subset.
+
+const (
+	TagActivationDate              Tag = 0x420001
+	TagAttribute                   Tag = 0x420008
+	TagAttributeName               Tag = 0x42000A
+	TagAttributeValue              Tag = 0x42000B
+	TagBatchCount                  Tag = 0x42000D
+	TagBatchItem                   Tag = 0x42000F
+	TagCommonTemplateAttribute     Tag = 0x42001F
+	TagCompromiseOccurrenceDate    Tag = 0x420021
+	TagCryptographicAlgorithm      Tag = 0x420028
+	TagCryptographicLength         Tag = 0x42002A
+	TagCryptographicUsageMask      Tag = 0x42002C
+	TagKeyBlock                    Tag = 0x420040
+	TagKeyFormatType               Tag = 0x420042
+	TagKeyMaterial                 Tag = 0x420043
+	TagKeyValue                    Tag = 0x420045
+	TagMaximumItems                Tag = 0x42004F
+	TagName                        Tag = 0x420053
+	TagNameType                    Tag = 0x420054
+	TagNameValue                   Tag = 0x420055
+	TagObjectType                  Tag = 0x420057
+	TagOperation                   Tag = 0x42005C
+	TagPrivateKey                  Tag = 0x420064
+	TagPrivateKeyTemplateAttribute Tag = 0x420065
+	TagPrivateKeyUniqueIdentifier  Tag = 0x420066
+	TagProtocolVersion             Tag = 0x420069
+	TagProtocolVersionMajor        Tag = 0x42006A
+	TagProtocolVersionMinor        Tag = 0x42006B
+	TagPublicKey                   Tag = 0x42006D
+	TagPublicKeyTemplateAttribute  Tag = 0x42006E
+	TagPublicKeyUniqueIdentifier   Tag = 0x42006F
+	TagRequestHeader               Tag = 0x420077
+	TagRequestMessage              Tag = 0x420078
+	TagRequestPayload              Tag = 0x420079
+	TagResponseHeader              Tag = 0x42007A
+	TagResponseMessage             Tag = 0x42007B
+	TagResponsePayload             Tag = 0x42007C
+	TagResultMessage               Tag = 0x42007D
+	TagResultReason                Tag = 0x42007E
+	TagResultStatus                Tag = 0x42007F
+	TagRevocationMessage           Tag = 0x420080
+	TagRevocationReason            Tag = 0x420081
+	TagRevocationReasonCode        Tag = 0x420082
+	TagState                       Tag = 0x42008D
+	TagSymmetricKey                Tag = 0x42008F
+	TagTemplateAttribute           Tag = 0x420091
+	TagTimeStamp                   Tag = 0x420092
+	TagUniqueBatchItemID           Tag = 0x420093
+	TagUniqueIdentifier            Tag = 0x420094
+)
+
+var tagNames = map[Tag]string{
+	TagActivationDate:              "Activation Date",
+	TagAttribute:                   "Attribute",
+	TagAttributeName:               "Attribute Name",
+	TagAttributeValue:              "Attribute Value",
+	TagBatchCount:                  "Batch Count",
+	TagBatchItem:                   "Batch Item",
+	TagCommonTemplateAttribute:     "Common Template-Attribute",
+	TagCompromiseOccurrenceDate:    "Compromise Occurrence Date",
+	TagCryptographicAlgorithm:      "Cryptographic Algorithm",
+	TagCryptographicLength:         "Cryptographic Length",
+	TagCryptographicUsageMask:      "Cryptographic Usage Mask",
+	TagKeyBlock:                    "Key Block",
+	TagKeyFormatType:               "Key Format Type",
+	TagKeyMaterial:                 "Key Material",
+	TagKeyValue:                    "Key Value",
+	TagMaximumItems:                "Maximum Items",
+	TagName:                        "Name",
+	TagNameType:                    "Name Type",
+	TagNameValue:                   "Name Value",
+	TagObjectType:                  "Object Type",
+	TagOperation:                   "Operation",
+	TagPrivateKey:                  "Private Key",
+	TagPrivateKeyTemplateAttribute: "Private Key Template-Attribute",
+	TagPrivateKeyUniqueIdentifier:  "Private Key Unique Identifier",
+	TagProtocolVersion:             "Protocol Version",
+	TagProtocolVersionMajor:        "Protocol Version Major",
+	TagProtocolVersionMinor:        "Protocol Version Minor",
+	TagPublicKey:                   "Public Key",
+	TagPublicKeyTemplateAttribute:  "Public Key Template-Attribute",
+	TagPublicKeyUniqueIdentifier:   "Public Key Unique Identifier",
+	TagRequestHeader:               "Request Header",
+	TagRequestMessage:              "Request Message",
+	TagRequestPayload:              "Request Payload",
+	TagResponseHeader:              "Response Header",
+	TagResponseMessage:             "Response Message",
+	TagResponsePayload:             "Response Payload",
+	TagResultMessage:               "Result Message",
+	TagResultReason:                "Result Reason",
+	TagResultStatus:                "Result Status",
+	TagRevocationMessage:           "Revocation Message",
+	TagRevocationReason:            "Revocation Reason",
+	TagRevocationReasonCode:        "Revocation Reason Code",
+	TagState:                       "State",
+	TagSymmetricKey:                "Symmetric Key",
+	TagTemplateAttribute:           "Template-Attribute",
+	TagTimeStamp:                   "Time Stamp",
+	TagUniqueBatchItemID:           "Unique Batch Item ID",
+	TagUniqueIdentifier:            "Unique Identifier",
+}
+
+// Operation
+const (
+	OpCreate        uint32 = 0x01
+	OpCreateKeyPair uint32 = 0x02
+	OpRegister      uint32 = 0x03
+	OpLocate        uint32 = 0x08
+	OpGet           uint32 = 0x0A
+	OpActivate      uint32 = 0x12
+	OpRevoke        uint32 = 0x13
+	OpDestroy       uint32 = 0x14
+)
+
+var opNames = map[uint32]string{
+	OpCreate:        "Create",
+	OpCreateKeyPair: "CreateKeyPair",
+	OpRegister:      "Register",
+	OpLocate:        "Locate",
+	OpGet:           "Get",
+	OpActivate:      "Activate",
+	OpRevoke:        "Revoke",
+	OpDestroy:       "Destroy",
+}
+
+// Object Type
+const (
+	ObjectSymmetricKey uint32 = 0x02
+	ObjectPublicKey    uint32 = 0x03
+	ObjectPrivateKey   uint32 = 0x04
+)
+
+// Cryptographic Algorithm
+const (
+	AlgAES   uint32 = 0x03
+	AlgRSA   uint32 = 0x04
+	AlgECDSA uint32 = 0x06
+)
+
+// Key Format Type
+const (
+	FormatRaw   uint32 = 0x01
+	FormatPKCS8 uint32 = 0x04
+	FormatX509  uint32 = 0x05 // SubjectPublicKeyInfo
+)
+
+// Cryptographic Usage Mask
+const (
+	UsageSign    int32 = 0x01
+	UsageVerify  int32 = 0x02
+	UsageEncrypt int32 = 0x04
+	UsageDecrypt int32 = 0x08
+)
+
+// Name Type
+const NameText uint32 = 0x01
+
+// State
+const (
+	StatePreActive            uint32 = 0x01
+	StateActive               uint32 = 0x02
+	StateDeactivated          uint32 = 0x03
+	StateCompromised          uint32 = 0x04
+	StateDestroyed            uint32 = 0x05
+	StateDestroyedCompromised uint32 = 0x06
+)
+
+var stateNames = map[uint32]string{
+	StatePreActive:            "Pre-Active",
+	StateActive:               "Active",
+	StateDeactivated:          "Deactivated",
+	StateCompromised:          "Compromised",
+	StateDestroyed:            "Destroyed",
+	StateDestroyedCompromised: "Destroyed Compromised",
+}
+
+// Revocation Reason Code
+const (
+	RevokeUnspecified          uint32 = 0x01
+	RevokeKeyCompromise        uint32 = 0x02
+	RevokeSuperseded           uint32 = 0x05
+	RevokeCessationOfOperation uint32 = 0x06
+)
+
+// Result Status and Result Reason
+const (
+	StatusSuccess         uint32 = 0x00
+	StatusOperationFailed uint32 = 0x01
+
+	ReasonItemNotFound          uint32 = 0x01
+	ReasonInvalidMessage        uint32 = 0x04
+	ReasonOperationNotSupported uint32 = 0x05
+	ReasonMissingData           uint32 = 0x06
+	ReasonInvalidField          uint32 = 0x07
+	ReasonPermissionDenied      uint32 = 0x0C
+	ReasonGeneralFailure        uint32 = 0x100
+)
+
+var reasonNames = map[uint32]string{
+	ReasonItemNotFound:          "Item Not Found",
+	ReasonInvalidMessage:        "Invalid Message",
+	ReasonOperationNotSupported: "Operation Not Supported",
+	ReasonMissingData:           "Missing Data",
+	ReasonInvalidField:          "Invalid Field",
+	ReasonPermissionDenied:      "Permission Denied",
+	ReasonGeneralFailure:        "General Failure",
+}
+
+// Attribute names
+const (
+	AttrCryptographicAlgorithm = "Cryptographic Algorithm"
+	AttrCryptographicLength    = "Cryptographic Length"
+	AttrCryptographicUsageMask = "Cryptographic Usage Mask"
+	AttrName                   = "Name"
+	AttrObjectType             = "Object Type"
+	AttrState                  = "State"
+	AttrActivationDate         = "Activation Date"
+)
+
+const protocolMajor, protocolMinor = 1, 2
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TTLV is KMIP's binary encoding: a 3-byte tag, a 1-byte type, a 4-byte
+// big-endian length and the value, padded with zeros to a multiple of
+// eight bytes. A structure's value is its encoded children.
+
+type Tag uint32
+
+// Item types.
+const (
+	TypeStructure   byte = 0x01
+	TypeInteger     byte = 0x02
+	TypeLongInteger byte = 0x03
+	TypeBigInteger  byte = 0x04
+	TypeEnumeration byte = 0x05
+	TypeBoolean     byte = 0x06
+	TypeTextString  byte = 0x07
+	TypeByteString  byte = 0x08
+	TypeDateTime    byte = 0x09
+	TypeInterval    byte = 0x0A
+)
+
+const (
+	maxMessage = 1 << 20
+	maxDepth   = 16
+)
+
+var errTTLV = errors.New("kmip: malformed TTLV")
+
+// Item is one TTLV node. Value holds an int32 (Integer), int64 (Long
+// Integer), uint32 (Enumeration, Interval), bool, string, []byte (Byte
+// String, Big Integer), time.Time or, for a structure, []*Item.
+type Item struct {
+	Tag   Tag
+	Type  byte
+	Value any
+}
+
+func Struct(tag Tag, children ...*Item) *Item {
+	var items []*Item
+	for _, c := range children {
+		if c != nil {
+			items = append(items, c)
+		}
+	}
+	return &Item{tag, TypeStructure, items}
+}
+
+func Int(tag Tag, v int32) *Item      { return &Item{tag, TypeInteger, v} }
+func Long(tag Tag, v int64) *Item     { return &Item{tag, TypeLongInteger, v} }
+func Enum(tag Tag, v uint32) *Item    { return &Item{tag, TypeEnumeration, v} }
+func Bool(tag Tag, v bool) *Item      { return &Item{tag, TypeBoolean, v} }
+func Text(tag Tag, v string) *Item    { return &Item{tag, TypeTextString, v} }
+func Bytes(tag Tag, v []byte) *Item   { return &Item{tag, TypeByteString, v} }
+func Time(tag Tag, v time.Time) *Item { return &Item{tag, TypeDateTime, v} }
+
+// Child returns the first child with tag, or nil.
+func (it *Item) Child(tag Tag) *Item {
+	for _, c := range it.Items() {
+		if c.Tag == tag {
+			return c
+		}
+	}
+	return nil
+}
+
+// Children returns every child with tag.
+func (it *Item) Children(tag Tag) []*Item {
+	var out []*Item
+	for _, c := range it.Items() {
+		if c.Tag == tag {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (it *Item) Items() []*Item {
+	if it == nil {
+		return nil
+	}
+	items, _ := it.Value.([]*Item)
+	return items
+}
+
+// The typed getters return the zero value when the item is missing or
+// of another type; required fields are checked with Child.
+
+func (it *Item) Int() int32 {
+	if it == nil {
+		return 0
+	}
+	v, _ := it.Value.(int32)
+	return v
+}
+
+func (it *Item) Enum() uint32 {
+	if it == nil {
+		return 0
+	}
+	v, _ := it.Value.(uint32)
+	return v
+}
+
+func (it *Item) Text() string {
+	if it == nil {
+		return ""
+	}
+	v, _ := it.Value.(string)
+	return v
+}
+
+func (it *Item) Bytes() []byte {
+	if it == nil {
+		return nil
+	}
+	v, _ := it.Value.([]byte)
+	return v
+}
+
+func (it *Item) Time() time.Time {
+	if it == nil {
+		return time.Time{}
+	}
+	v, _ := it.Value.(time.Time)
+	return v
+}
+
+func pad8(n int) int { return (n + 7) &^ 7 }
+
+// Marshal returns the TTLV encoding of it.
+func (it *Item) Marshal() []byte {
+	return it.append(nil)
+}
+
+func (it *Item) append(b []byte) []byte {
+	b = append(b, byte(it.Tag>>16), byte(it.Tag>>8), byte(it.Tag), it.Type)
+	lenAt := len(b)
+	b = append(b, 0, 0, 0, 0)
+	start := len(b)
+	switch v := it.Value.(type) {
+	case []*Item:
+		for _, c := range v {
+			b = c.append(b)
+		}
+	case int32:
+		b = binary.BigEndian.AppendUint32(b, uint32(v))
+	case uint32:
+		b = binary.BigEndian.AppendUint32(b, v)
+	case int64:
+		b = binary.BigEndian.AppendUint64(b, uint64(v))
+	case bool:
+		var x uint64
+		if v {
+			x = 1
+		}
+		b = binary.BigEndian.AppendUint64(b, x)
+	case string:
+		b = append(b, v...)
+	case []byte:
+		b = append(b, v...)
+	case time.Time:
+		b = binary.BigEndian.AppendUint64(b, uint64(v.Unix()))
+	default:
+		panic(fmt.Sprintf("kmip: cannot encode %T", v))
+	}
+	n := len(b) - start
+	binary.BigEndian.PutUint32(b[lenAt:], uint32(n))
+	return append(b, make([]byte, pad8(n)-n)...)
+}
+
+// Unmarshal decodes exactly one item from b.
+func Unmarshal(b []byte) (*Item, error) {
+	it, rest, err := parseItem(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errTTLV
+	}
+	return it, nil
+}
+
+func parseItem(b []byte, depth int) (*Item, []byte, error) {
+	if len(b) < 8 || depth > maxDepth {
+		return nil, nil, errTTLV
+	}
+	it := &Item{Tag: Tag(b[0])<<16 | Tag(b[1])<<8 | Tag(b[2]), Type: b[3]}
+	n := int(binary.BigEndian.Uint32(b[4:]))
+	b = b[8:]
+	if n > len(b) || pad8(n) > len(b) {
+		return nil, nil, errTTLV
+	}
+	v, rest := b[:n], b[pad8(n):]
+	fixed := func(size int) bool { return n == size }
+	switch it.Type {
+	case TypeStructure:
+		var items []*Item
+		for len(v) > 0 {
+			c, r, err := parseItem(v, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items, v = append(items, c), r
+		}
+		it.Value = items
+	case TypeInteger:
+		if !fixed(4) {
+			return nil, nil, errTTLV
+		}
+		it.Value = int32(binary.BigEndian.Uint32(v))
+	case TypeEnumeration, TypeInterval:
+		if !fixed(4) {
+			return nil, nil, errTTLV
+		}
+		it.Value = binary.BigEndian.Uint32(v)
+	case TypeLongInteger:
+		if !fixed(8) {
+			return nil, nil, errTTLV
+		}
+		it.Value = int64(binary.BigEndian.Uint64(v))
+	case TypeBoolean:
+		if !fixed(8) || binary.BigEndian.Uint64(v) > 1 {
+			return nil, nil, errTTLV
+		}
+		it.Value = v[7] == 1
+	case TypeDateTime:
+		if !fixed(8) {
+			return nil, nil, errTTLV
+		}
+		it.Value = time.Unix(int64(binary.BigEndian.Uint64(v)), 0).UTC()
+	case TypeTextString:
+		it.Value = string(v)
+	case TypeByteString, TypeBigInteger:
+		it.Value = append([]byte(nil), v...)
+	default:
+		return nil, nil, fmt.Errorf("kmip: unknown item type 0x%02x", it.Type)
+	}
+	return it, rest, nil
+}
+
+// ReadMessage reads one top-level item from r.
+func ReadMessage(r io.Reader) (*Item, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[4:]))
+	if hdr[3] != TypeStructure || n > maxMessage {
+		return nil, errTTLV
+	}
+	b := make([]byte, 8+pad8(n))
+	copy(b, hdr[:])
+	if _, err := io.ReadFull(r, b[8:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return Unmarshal(b)
+}
+
+// String renders an item tree, one item per line, for debugging.
+func (it *Item) String() string {
+	var b []byte
+	var walk func(it *Item, indent string)
+	walk = func(it *Item, indent string) {
+		name, ok := tagNames[it.Tag]
+		if !ok {
+			name = fmt.Sprintf("0x%06x", uint32(it.Tag))
+		}
+		if items, ok := it.Value.([]*Item); ok {
+			b = fmt.Appendf(b, "%s%s\n", indent, name)
+			for _, c := range items {
+				walk(c, indent+"  ")
+			}
+			return
+		}
+		v := it.Value
+		if raw, ok := v.([]byte); ok {
+			v = fmt.Sprintf("%x", raw)
+			if len(raw) > 16 {
+				v = fmt.Sprintf("%x... (%d bytes)", raw[:16], len(raw))
+			}
+		}
+		b = fmt.Appendf(b, "%s%s: %v\n", indent, name, v)
+	}
+	walk(it, "")
+	return string(b)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A license key is the base32 encoding of
+//
+//	version (1) || algorithm (1) || serial (4) || issued (2) ||
+//	expires (2) || features (4) || len (1) || customer || signature (64)
+//
+// with dates as days since 2024-01-01 (expires 0 means perpetual) and
+// features as a bit mask over Features. The signature covers "LICENSE1"
+// and everything before it; it is Ed25519, or ECDSA P-256 over the
+// SHA-256 digest as in sign/ but in fixed-size r || s form rather than
+// ASN.1. The text is grouped in fives:
+//
+//	AEAQA-AAAAC-...
+//
+// Keys are long: 79 bytes plus the customer name, which grouped comes to
+// 153 characters for a one-letter customer and 229 for the longest. They
+// are meant to be pasted; the grouping and normalize only help when one
+// has to be read out or typed.
+const (
+	licenseVersion = 1
+	AlgEd25519     = 1
+	AlgP256        = 2
+
+	sigSize     = 64
+	maxCustomer = 40
+)
+
+// Features lists the licensable features by bit position. Only append:
+// issued keys refer to features by index.
+var Features = []string{"export", "sso", "audit-log", "api", "multi-site", "priority-support"}
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	ErrMalformed     = errors.New("license key is malformed")
+	ErrSignature     = errors.New("license key signature is invalid")
+	ErrExpired       = errors.New("license has expired")
+	ErrRevoked       = errors.New("license has been revoked")
+	ErrClockRollback = errors.New("system clock appears to have been set back")
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type License struct {
+	Serial   uint32
+	Customer string
+	Features []string
+	Issued   time.Time
+	Expires  time.Time // zero for a perpetual license
+}
+
+func (l *License) Has(feature string) bool { return slices.Contains(l.Features, feature) }
+
+func toDays(t time.Time) (uint16, error) {
+	d := t.Sub(epoch).Hours() / 24
+	if d < 0 || d > 0xffff {
+		return 0, fmt.Errorf("date %s is out of range", t.Format(time.DateOnly))
+	}
+	return uint16(d), nil
+}
+
+func fromDays(d uint16) time.Time { return epoch.AddDate(0, 0, int(d)) }
+
+func (l *License) marshal(alg byte) ([]byte, error) {
+	if len(l.Customer) == 0 || len(l.Customer) > maxCustomer {
+		return nil, fmt.Errorf("customer name must be 1 to %d bytes", maxCustomer)
+	}
+	var mask uint32
+	for _, f := range l.Features {
+		i := slices.Index(Features, f)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown feature %q", f)
+		}
+		mask |= 1 << i
+	}
+	issued, err := toDays(l.Issued)
+	if err != nil {
+		return nil, err
+	}
+	var expires uint16
+	if !l.Expires.IsZero() {
+		if expires, err = toDays(l.Expires); err != nil {
+			return nil, err
+		}
+		if expires <= issued {
+			return nil, errors.New("license expires before it is issued")
+		}
+	}
+	b := []byte{licenseVersion, alg}
+	b = binary.BigEndian.AppendUint32(b, l.Serial)
+	b = binary.BigEndian.AppendUint16(b, issued)
+	b = binary.BigEndian.AppendUint16(b, expires)
+	b = binary.BigEndian.AppendUint32(b, mask)
+	b = append(b, byte(len(l.Customer)))
+	return append(b, l.Customer...), nil
+}
+
+func unmarshalLicense(b []byte) (*License, byte, error) {
+	if len(b) < 15 || b[0] != licenseVersion || int(b[14]) != len(b)-15 {
+		return nil, 0, ErrMalformed
+	}
+	l := &License{
+		Serial:   binary.BigEndian.Uint32(b[2:]),
+		Issued:   fromDays(binary.BigEndian.Uint16(b[6:])),
+		Customer: string(b[15:]),
+	}
+	if d := binary.BigEndian.Uint16(b[8:]); d != 0 {
+		l.Expires = fromDays(d)
+	}
+	mask := binary.BigEndian.Uint32(b[10:])
+	for i, f := range Features {
+		if mask&(1<<i) != 0 {
+			l.Features = append(l.Features, f)
+		}
+	}
+	if mask>>len(Features) != 0 {
+		return nil, 0, fmt.Errorf("%w: license uses features unknown to this version", ErrMalformed)
+	}
+	return l, b[1], nil
+}
+
+func algOf(key crypto.PublicKey) (byte, error) {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return AlgEd25519, nil
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return AlgP256, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported license key type %T", key)
+}
+
+// sign signs label || msg with an Ed25519 or ECDSA P-256 key.
+func sign(key crypto.Signer, label string, msg []byte) ([]byte, error) {
+	data := append([]byte(label), msg...)
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, data), nil
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, sigSize)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported license key type %T", key)
+}
+
+func verify(key crypto.PublicKey, label string, msg, sig []byte) bool {
+	data := append([]byte(label), msg...)
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	}
+	return false
+}
+
+// Issue signs l and returns the license key text.
+func Issue(key crypto.Signer, l *License) (string, error) {
+	alg, err := algOf(key.Public())
+	if err != nil {
+		return "", err
+	}
+	payload, err := l.marshal(alg)
+	if err != nil {
+		return "", err
+	}
+	sig, err := sign(key, "LICENSE1", payload)
+	if err != nil {
+		return "", err
+	}
+	return group(b32.EncodeToString(append(payload, sig...))), nil
+}
+
+func group(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i += 5 {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(s[i:min(i+5, len(s))])
+	}
+	return b.String()
+}
+
+// normalize undoes grouping and common typing mistakes: lower case,
+// spaces, and digits that look like base32 letters.
+func normalize(key string) string {
+	key = strings.ToUpper(key)
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '\t', '\n', '\r':
+			return -1
+		case '0':
+			return 'O'
+		case '1':
+			return 'I'
+		case '8':
+			return 'B'
+		}
+		return r
+	}, key)
+}
+
+// decodeKey checks the signature of key against pub and returns the
+// license it encodes.
+func decodeKey(pub crypto.PublicKey, key string) (*License, error) {
+	raw, err := b32.DecodeString(normalize(key))
+	if err != nil || len(raw) < sigSize {
+		return nil, ErrMalformed
+	}
+	payload, sig := raw[:len(raw)-sigSize], raw[len(raw)-sigSize:]
+	l, alg, err := unmarshalLicense(payload)
+	if err != nil {
+		return nil, err
+	}
+	want, err := algOf(pub)
+	if err != nil {
+		return nil, err
+	}
+	if alg != want || !verify(pub, "LICENSE1", payload, sig) {
+		return nil, ErrSignature
+	}
+	return l, nil
+}
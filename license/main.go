@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	_ "embed"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vendorKey is the public key that license keys are checked against
+// when -pub is not given. Replace license.pub.pem with the public half
+// of `license genkey` and rebuild.
+//
+//go:embed license.pub.pem
+var vendorKey []byte
+
+// Private keys are PEM "PRIVATE KEY" blocks: SEC1 for P-256 as in keys/,
+// PKCS #8 for Ed25519. Public keys are PKIX "PUBLIC KEY" blocks.
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func readFile[T any](path string, parse func([]byte) (T, error)) T {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	v, err := parse(data)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	return v
+}
+
+func generateKey(alg string) (crypto.Signer, []byte, error) {
+	var key crypto.Signer
+	var der []byte
+	var err error
+	switch alg {
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = priv
+		der, err = x509.MarshalPKCS8PrivateKey(priv)
+	case "p256":
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = priv
+		der, err = x509.MarshalECPrivateKey(priv)
+	default:
+		return nil, nil, fmt.Errorf("unknown algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func publicPEM(key crypto.Signer) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func parseSerials(s string) ([]uint32, error) {
+	var serials []uint32
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		n, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		serials = append(serials, uint32(n))
+	}
+	return serials, nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: license [flags] <command> [flags] [key]
+
+commands:
+  genkey        write -out.pem and -out.pub.pem
+  issue         print a license key signed with -key
+  revoke        write a revocation list of -serials signed with -key
+  verify <key>  check a license key offline
+
+Without a command, a demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	alg := flag.String("alg", "ed25519", "genkey algorithm: ed25519 or p256")
+	out := flag.String("out", "vendor", "genkey output name, or revoke output file")
+	keyPath := flag.String("key", "vendor.pem", "vendor private key")
+	pubPath := flag.String("pub", "", "vendor public key (default: the embedded key)")
+	customer := flag.String("customer", "", "licensed customer")
+	features := flag.String("features", "", "comma-separated features: "+strings.Join(Features, ", "))
+	serial := flag.Uint("serial", 0, "license serial number")
+	days := flag.Int("days", 365, "license lifetime in days, 0 for perpetual")
+	serials := flag.String("serials", "", "comma-separated serials to revoke")
+	seq := flag.Uint("seq", 1, "revocation list sequence number")
+	revocations := flag.String("revocations", "", "revocation list to apply when verifying")
+	state := flag.String("state", "", "file recording the latest time seen and the revocations applied")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	switch cmd {
+	case "genkey":
+		key, privPEM, err := generateKey(*alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*out+".pem", privPEM, 0600); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*out+".pub.pem", publicPEM(key), 0644); err != nil {
+			log.Fatal(err)
+		}
+	case "issue":
+		key := readFile(*keyPath, parsePrivateKey)
+		l := &License{Serial: uint32(*serial), Customer: *customer, Issued: time.Now().UTC().Truncate(24 * time.Hour)}
+		if *features != "" {
+			l.Features = strings.Split(*features, ",")
+		}
+		if *days > 0 {
+			l.Expires = l.Issued.AddDate(0, 0, *days)
+		}
+		text, err := Issue(key, l)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(text)
+	case "revoke":
+		key := readFile(*keyPath, parsePrivateKey)
+		list, err := parseSerials(*serials)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rl := &RevocationList{Sequence: uint32(*seq), Issued: time.Now().UTC(), Serials: list}
+		data, err := rl.Sign(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*out, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	case "verify":
+		pub, err := parsePublicKey(vendorKey)
+		if *pubPath != "" {
+			pub, err = readFile(*pubPath, parsePublicKey), nil
+		}
+		if err != nil {
+			log.Fatal("embedded key: ", err)
+		}
+		c, err := NewChecker(pub, *state)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *revocations != "" {
+			data, err := os.ReadFile(*revocations)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := c.LoadRevocations(data); err != nil {
+				log.Fatal(err)
+			}
+		}
+		l, err := c.Check(strings.Join(flag.Args(), ""))
+		if err != nil {
+			log.Fatal(err)
+		}
+		printLicense(l)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func printLicense(l *License) {
+	expires := "never"
+	if !l.Expires.IsZero() {
+		expires = l.Expires.Format(time.DateOnly)
+	}
+	fmt.Printf("serial %d, customer %q, features [%s], issued %s, expires %s\n",
+		l.Serial, l.Customer, strings.Join(l.Features, " "), l.Issued.Format(time.DateOnly), expires)
+}
+
+func demo() {
+	today := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, alg := range []string{"ed25519", "p256"} {
+		key, _, err := generateKey(alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		text, err := Issue(key, &License{
+			Serial:   1042,
+			Customer: "Acme Corp",
+			Features: []string{"export", "sso", "audit-log"},
+			Issued:   today,
+			Expires:  today.AddDate(1, 0, 0),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s key (%d characters):\n  %s\n", alg, len(text), text)
+
+		c, _ := NewChecker(key.Public(), "")
+		now := today.Add(10 * 24 * time.Hour)
+		c.now = func() time.Time { return now }
+		check := func(label, text string) {
+			l, err := c.Check(text)
+			if err != nil {
+				fmt.Printf("  %-30s %v\n", label+":", err)
+				return
+			}
+			fmt.Printf("  %-30s ok, sso: %v, api: %v\n", label+":", l.Has("sso"), l.Has("api"))
+		}
+		check("as issued", text)
+		check("typed in lower case", strings.ToLower(strings.ReplaceAll(text, "-", " ")))
+		forged := []byte(text)
+		forged[40] = map[bool]byte{true: 'B', false: 'A'}[forged[40] == 'A']
+		check("one character changed", string(forged))
+
+		rl := &RevocationList{Sequence: 2, Issued: today.Add(20 * 24 * time.Hour), Serials: []uint32{1042}}
+		data, _ := rl.Sign(key)
+		old, _ := (&RevocationList{Sequence: 1, Issued: today}).Sign(key)
+		if err := c.LoadRevocations(data); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("  %-30s %v\n", "older list after newer one:", c.LoadRevocations(old))
+		check("clock behind revocation list", text)
+		now = today.Add(30 * 24 * time.Hour)
+		check("after revocation", text)
+		now = today.Add(2 * 24 * time.Hour)
+		check("clock set back 4 weeks", text)
+	}
+
+	key, _, _ := generateKey("ed25519")
+	c, _ := NewChecker(key.Public(), "")
+	c.now = func() time.Time { return today.AddDate(2, 0, 0) }
+	text, _ := Issue(key, &License{Serial: 7, Customer: "Initech", Issued: today, Expires: today.AddDate(1, 0, 0)})
+	_, err := c.Check(text)
+	fmt.Println("\nlicense checked two years later:", err)
+
+	other, _, _ := generateKey("ed25519")
+	c, _ = NewChecker(other.Public(), "")
+	_, err = c.Check(text)
+	fmt.Println("checked against another vendor key:", err)
+
+	// A revocation sticks once applied: a later run that is not given
+	// the list still refuses the key.
+	dir, err := os.MkdirTemp("", "license")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+	text, _ = Issue(key, &License{Serial: 8, Customer: "Initech", Issued: today})
+	rl, _ := (&RevocationList{Sequence: 1, Issued: today, Serials: []uint32{8}}).Sign(key)
+	c, _ = NewChecker(key.Public(), statePath)
+	if err := c.LoadRevocations(rl); err != nil {
+		log.Fatal(err)
+	}
+	c, _ = NewChecker(key.Public(), statePath)
+	_, err = c.Check(text)
+	fmt.Println("next run without the list:", err)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// A revocation list is a PEM "LICENSE REVOCATION LIST" block holding
+//
+//	sequence (4) || issued, Unix seconds (8) || count (4) ||
+//	serial (4) * count || signature (64)
+//
+// signed like a license key under the label "REVOKE1". Lists are
+// cumulative and the sequence increases with each one, so a client that
+// has seen list n refuses any earlier list, which would un-revoke keys.
+const revocationPEM = "LICENSE REVOCATION LIST"
+
+var ErrStaleRevocations = errors.New("revocation list is older than one already applied")
+
+type RevocationList struct {
+	Sequence uint32
+	Issued   time.Time
+	Serials  []uint32
+}
+
+func (rl *RevocationList) marshal() []byte {
+	b := binary.BigEndian.AppendUint32(nil, rl.Sequence)
+	b = binary.BigEndian.AppendUint64(b, uint64(rl.Issued.Unix()))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(rl.Serials)))
+	for _, s := range rl.Serials {
+		b = binary.BigEndian.AppendUint32(b, s)
+	}
+	return b
+}
+
+// Sign returns the signed list in PEM form.
+func (rl *RevocationList) Sign(key crypto.Signer) ([]byte, error) {
+	payload := rl.marshal()
+	sig, err := sign(key, "REVOKE1", payload)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: revocationPEM, Bytes: append(payload, sig...)}), nil
+}
+
+func parseRevocationList(pub crypto.PublicKey, data []byte) (*RevocationList, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != revocationPEM {
+		return nil, errors.New("no revocation list found")
+	}
+	raw := block.Bytes
+	if len(raw) < 16+sigSize {
+		return nil, errors.New("revocation list is malformed")
+	}
+	payload, sig := raw[:len(raw)-sigSize], raw[len(raw)-sigSize:]
+	if !verify(pub, "REVOKE1", payload, sig) {
+		return nil, errors.New("revocation list signature is invalid")
+	}
+	n := binary.BigEndian.Uint32(payload[12:])
+	if uint64(len(payload)) != 16+4*uint64(n) {
+		return nil, errors.New("revocation list is malformed")
+	}
+	rl := &RevocationList{
+		Sequence: binary.BigEndian.Uint32(payload),
+		Issued:   time.Unix(int64(binary.BigEndian.Uint64(payload[4:])), 0).UTC(),
+	}
+	for i := range n {
+		rl.Serials = append(rl.Serials, binary.BigEndian.Uint32(payload[16+4*i:]))
+	}
+	return rl, nil
+}
+
+// checkerState is persisted between runs, including the serials of the
+// last revocation list applied, so they stay revoked when later runs
+// are not given the list. A user who controls the machine can edit it,
+// so rollback detection only raises the bar.
+type checkerState struct {
+	LastSeen          time.Time `json:"last_seen"`
+	RevocationSeq     uint32    `json:"revocation_seq"`
+	RevocationsIssued time.Time `json:"revocations_issued,omitzero"`
+	Revoked           []uint32  `json:"revoked,omitempty"`
+}
+
+// Checker verifies license keys offline against the vendor's public
+// key, a revocation list and the latest time it has observed.
+type Checker struct {
+	pub       crypto.PublicKey
+	statePath string
+	state     checkerState
+	// Tolerance is how far the clock may go back, for ordinary
+	// corrections such as NTP adjustments and time zone mix-ups.
+	Tolerance time.Duration
+	now       func() time.Time
+}
+
+// NewChecker loads the state at statePath; an empty path keeps state in
+// memory only.
+func NewChecker(pub crypto.PublicKey, statePath string) (*Checker, error) {
+	if _, err := algOf(pub); err != nil {
+		return nil, err
+	}
+	c := &Checker{pub: pub, statePath: statePath, Tolerance: 24 * time.Hour, now: time.Now}
+	if statePath == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.state); err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath, err)
+	}
+	return c, nil
+}
+
+func (c *Checker) save() error {
+	if c.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.statePath, data, 0600)
+}
+
+// LoadRevocations applies a signed revocation list and records it in the
+// state.
+func (c *Checker) LoadRevocations(data []byte) error {
+	rl, err := parseRevocationList(c.pub, data)
+	if err != nil {
+		return err
+	}
+	if rl.Sequence < c.state.RevocationSeq {
+		return fmt.Errorf("%w (%d < %d)", ErrStaleRevocations, rl.Sequence, c.state.RevocationSeq)
+	}
+	c.state.RevocationSeq = rl.Sequence
+	c.state.RevocationsIssued = rl.Issued
+	c.state.Revoked = rl.Serials
+	return c.save()
+}
+
+// Check verifies key and returns its license if it is genuine, current
+// and not revoked.
+func (c *Checker) Check(key string) (*License, error) {
+	l, err := decodeKey(c.pub, key)
+	if err != nil {
+		return nil, err
+	}
+	// The clock cannot be earlier than anything already seen: the last
+	// check, the revocation list's issue time or the license's.
+	now := c.now()
+	floor := c.state.LastSeen
+	if c.state.RevocationsIssued.After(floor) {
+		floor = c.state.RevocationsIssued
+	}
+	if l.Issued.After(floor) {
+		floor = l.Issued
+	}
+	if now.Add(c.Tolerance).Before(floor) {
+		return nil, fmt.Errorf("%w (now %s, but %s was already seen)", ErrClockRollback, now.Format(time.RFC3339), floor.Format(time.RFC3339))
+	}
+	if now.After(c.state.LastSeen) {
+		c.state.LastSeen = now.UTC()
+		if err := c.save(); err != nil {
+			return nil, err
+		}
+	}
+	if slices.Contains(c.state.Revoked, l.Serial) {
+		return nil, ErrRevoked
+	}
+	if !l.Expires.IsZero() && !now.Before(l.Expires) {
+		return nil, ErrExpired
+	}
+	return l, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	file := flag.String("file", "", "file to encode instead of random demo content")
+	flag.Parse()
+
+	var content []byte
+	var err error
+	if *file != "" {
+		content, err = os.ReadFile(*file)
+	} else {
+		content = make([]byte, 10*ChunkSize+123)
+		_, err = rand.Read(content)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	root, outboard, err := Outboard(bytes.NewReader(content), uint64(len(content)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("root: %x (outboard %d bytes)\n", root, len(outboard))
+
+	// Only the root hash is signed; the outboard can travel unsigned.
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, root[:])
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("root signature verified:", ecdsa.VerifyASN1(&privateKey.PublicKey, root[:], sig))
+
+	r := NewReader(bytes.NewReader(content), bytes.NewReader(outboard), root)
+	got, err := io.ReadAll(r)
+	fmt.Println("stream verified:", err == nil && bytes.Equal(got, content))
+
+	if len(content) > 3*ChunkSize {
+		corrupt := bytes.Clone(content)
+		corrupt[2*ChunkSize+10] ^= 1
+		r = NewReader(bytes.NewReader(corrupt), bytes.NewReader(outboard), root)
+		n, err := io.Copy(io.Discard, r)
+		fmt.Printf("corrupted stream: %d bytes accepted, then %v (is ErrCorrupt: %v)\n",
+			n, err, errors.Is(err, ErrCorrupt))
+	}
+
+	// An attacker who controls the download can truncate both the content
+	// and the outboard header to zero length.
+	empty := make([]byte, headerSize)
+	n, err := io.Copy(io.Discard, NewReader(bytes.NewReader(nil), bytes.NewReader(empty), root))
+	fmt.Printf("stream truncated to empty: %d bytes accepted, then %v\n", n, err)
+	emptyRoot, emptyOutboard, err := Outboard(bytes.NewReader(nil), 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = io.ReadAll(NewReader(bytes.NewReader(nil), bytes.NewReader(emptyOutboard), emptyRoot))
+	fmt.Println("genuinely empty stream verified:", err == nil)
+
+	start, size := uint64(len(content)/3), uint64(len(content)/4)
+	if size == 0 {
+		return
+	}
+	var slice bytes.Buffer
+	if err := ExtractSlice(&slice, bytes.NewReader(content), bytes.NewReader(outboard), start, size); err != nil {
+		log.Fatal(err)
+	}
+	sliceLen := slice.Len()
+	got, err = io.ReadAll(NewSliceReader(&slice, root, start, size))
+	fmt.Printf("slice [%d, %d) in %d bytes verified: %v\n",
+		start, start+size, sliceLen, err == nil && bytes.Equal(got, content[start:start+size]))
+}
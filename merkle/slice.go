@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// A slice proves a byte range of the content against the root hash
+// without the rest of it. It holds the 8-byte length header followed by
+// the parent nodes and chunks that overlap the range, in the pre-order
+// the tree is walked in, so it can be verified as it streams.
+
+// ExtractSlice writes the slice covering [start, start+size) to w, reading
+// the tree from outboard and the covered chunks from content.
+func ExtractSlice(w io.Writer, content io.ReaderAt, outboard io.Reader, start, size uint64) error {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(outboard, hdr[:]); err != nil {
+		return err
+	}
+	length := binary.LittleEndian.Uint64(hdr[:])
+	if size == 0 || start >= length || size > length-start {
+		return errSliceRange
+	}
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	stack := []node{{count: chunkCount(length)}}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !n.overlaps(start, start+size) {
+			// Skip the parents of the whole subtree in the outboard.
+			if _, err := io.CopyN(io.Discard, outboard, int64(n.count-1)*parentSize); err != nil {
+				return unexpected(err)
+			}
+			continue
+		}
+		if n.count == 1 {
+			chunk := make([]byte, chunkLen(length, n.start))
+			if _, err := content.ReadAt(chunk, int64(n.start*ChunkSize)); err != nil && err != io.EOF {
+				return err
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := io.CopyN(w, outboard, parentSize); err != nil {
+			return unexpected(err)
+		}
+		left := leftCount(n.count)
+		stack = append(stack,
+			node{start: n.start + left, count: n.count - left},
+			node{start: n.start, count: left})
+	}
+	return nil
+}
+
+// NewSliceReader verifies a slice produced by ExtractSlice against root
+// and returns only the bytes in [start, start+size).
+func NewSliceReader(slice io.Reader, root [32]byte, start, size uint64) *Reader {
+	return &Reader{content: slice, outboard: slice, root: root, from: start, to: start + size}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// Content is split into fixed-size chunks that form the leaves of a
+// binary tree. As in Bao, the left subtree of every node holds the
+// largest power-of-two number of chunks that leaves at least one chunk
+// for the right, and the parent nodes are stored in pre-order in an
+// "outboard" file next to the unmodified content:
+//
+//	8-byte little-endian content length
+//	(left hash || right hash) for every parent, pre-order
+//
+// The signed root hash binds the content length, so truncation and
+// extension are detected like any other corruption.
+const ChunkSize = 16 * 1024
+
+const (
+	leafTag   = 0
+	parentTag = 1
+	rootTag   = 2
+
+	headerSize = 8
+	parentSize = 2 * sha256.Size
+)
+
+var (
+	ErrCorrupt    = errors.New("content does not match the root hash")
+	errSliceRange = errors.New("slice range is outside the content")
+)
+
+// chunkError reports which chunk failed verification.
+type chunkError struct {
+	chunk uint64
+}
+
+func (e *chunkError) Error() string {
+	return fmt.Sprintf("chunk %d: %v", e.chunk, ErrCorrupt)
+}
+
+func (e *chunkError) Unwrap() error { return ErrCorrupt }
+
+func leafHash(index uint64, chunk []byte) (h [32]byte) {
+	d := sha256.New()
+	var hdr [9]byte
+	hdr[0] = leafTag
+	binary.LittleEndian.PutUint64(hdr[1:], index)
+	d.Write(hdr[:])
+	d.Write(chunk)
+	d.Sum(h[:0])
+	return h
+}
+
+func parentHash(left, right []byte) (h [32]byte) {
+	d := sha256.New()
+	d.Write([]byte{parentTag})
+	d.Write(left)
+	d.Write(right)
+	d.Sum(h[:0])
+	return h
+}
+
+func rootHash(length uint64, top [32]byte) (h [32]byte) {
+	d := sha256.New()
+	var hdr [9]byte
+	hdr[0] = rootTag
+	binary.LittleEndian.PutUint64(hdr[1:], length)
+	d.Write(hdr[:])
+	d.Write(top[:])
+	d.Sum(h[:0])
+	return h
+}
+
+// chunkCount returns the number of leaves for length bytes of content.
+// Empty content still has a single, empty chunk.
+func chunkCount(length uint64) uint64 {
+	if length == 0 {
+		return 1
+	}
+	return (length + ChunkSize - 1) / ChunkSize
+}
+
+// leftCount returns how many of count chunks go to the left subtree.
+func leftCount(count uint64) uint64 {
+	return 1 << (bits.Len64(count-1) - 1)
+}
+
+func chunkLen(length, index uint64) int {
+	if rest := length - index*ChunkSize; rest < ChunkSize {
+		return int(rest)
+	}
+	return ChunkSize
+}
+
+// Outboard hashes length bytes of content and returns the root hash and
+// the outboard tree.
+func Outboard(content io.ReaderAt, length uint64) ([32]byte, []byte, error) {
+	count := chunkCount(length)
+	outboard := make([]byte, headerSize+(count-1)*parentSize)
+	binary.LittleEndian.PutUint64(outboard, length)
+
+	buf := make([]byte, ChunkSize)
+	var build func(start, count uint64, slot []byte) ([32]byte, error)
+	build = func(start, count uint64, slot []byte) ([32]byte, error) {
+		if count == 1 {
+			n := chunkLen(length, start)
+			if _, err := content.ReadAt(buf[:n], int64(start*ChunkSize)); err != nil && err != io.EOF {
+				return [32]byte{}, err
+			}
+			return leafHash(start, buf[:n]), nil
+		}
+		left := leftCount(count)
+		l, err := build(start, left, slot[parentSize:])
+		if err != nil {
+			return l, err
+		}
+		r, err := build(start+left, count-left, slot[left*parentSize:])
+		if err != nil {
+			return r, err
+		}
+		copy(slot, l[:])
+		copy(slot[sha256.Size:], r[:])
+		return parentHash(l[:], r[:]), nil
+	}
+	top, err := build(0, count, outboard[headerSize:])
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	return rootHash(length, top), outboard, nil
+}
+
+// node is a subtree whose hash is known but whose children have not
+// been read yet.
+type node struct {
+	hash         [32]byte
+	start, count uint64
+	root         bool
+}
+
+func (n node) check(h [32]byte, length uint64) bool {
+	if n.root {
+		h = rootHash(length, h)
+	}
+	return h == n.hash
+}
+
+func (n node) overlaps(from, to uint64) bool {
+	return n.start*ChunkSize < to && (n.start+n.count)*ChunkSize > from
+}
+
+// Reader verifies content against a trusted root hash while it is read,
+// using the outboard tree. Every chunk is checked before any of its
+// bytes are returned, so a corrupt chunk fails the read at that chunk.
+type Reader struct {
+	content  io.Reader
+	outboard io.Reader
+	root     [32]byte
+	length   uint64
+	from, to uint64
+	stack    []node
+	buf      []byte
+	err      error
+}
+
+func NewReader(content, outboard io.Reader, root [32]byte) *Reader {
+	return &Reader{content: content, outboard: outboard, root: root, to: math.MaxUint64}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) next() error {
+	if r.stack == nil {
+		var hdr [headerSize]byte
+		if _, err := io.ReadFull(r.outboard, hdr[:]); err != nil {
+			return err
+		}
+		r.length = binary.LittleEndian.Uint64(hdr[:])
+		r.stack = []node{{hash: r.root, count: chunkCount(r.length), root: true}}
+		r.to = min(r.to, r.length)
+		if r.from >= r.to && r.length > 0 {
+			return errSliceRange
+		}
+	}
+	for len(r.stack) > 0 {
+		n := r.stack[len(r.stack)-1]
+		r.stack = r.stack[:len(r.stack)-1]
+		// The root is always checked, even when the header claims empty
+		// content, so a download truncated to nothing does not verify.
+		if !n.root && !n.overlaps(r.from, r.to) {
+			continue
+		}
+		if n.count == 1 {
+			chunk := make([]byte, chunkLen(r.length, n.start))
+			if _, err := io.ReadFull(r.content, chunk); err != nil {
+				return unexpected(err)
+			}
+			if !n.check(leafHash(n.start, chunk), r.length) {
+				return &chunkError{n.start}
+			}
+			r.buf = r.trim(n.start, chunk)
+			return nil
+		}
+		var pair [parentSize]byte
+		if _, err := io.ReadFull(r.outboard, pair[:]); err != nil {
+			return unexpected(err)
+		}
+		if !n.check(parentHash(pair[:32], pair[32:]), r.length) {
+			return &chunkError{n.start}
+		}
+		left := leftCount(n.count)
+		r.stack = append(r.stack,
+			node{start: n.start + left, count: n.count - left, hash: [32]byte(pair[32:])},
+			node{start: n.start, count: left, hash: [32]byte(pair[:32])})
+	}
+	// Anything past the declared length means the content was extended.
+	var extra [1]byte
+	if n, _ := r.content.Read(extra[:]); n > 0 {
+		return ErrCorrupt
+	}
+	return io.EOF
+}
+
+// trim drops the parts of a verified chunk outside the requested range.
+func (r *Reader) trim(index uint64, chunk []byte) []byte {
+	off := index * ChunkSize
+	if r.to < off+uint64(len(chunk)) {
+		chunk = chunk[:r.to-off]
+	}
+	if r.from > off {
+		chunk = chunk[r.from-off:]
+	}
+	return chunk
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Application messages are PrivateMessages: the signed content is
+// encrypted under the sender's ratchet, and the sender's leaf and
+// generation are encrypted under a key derived from a sample of that
+// ciphertext.
+
+const paddingBlock = 32
+
+func (g *Group) senderDataAEAD(ciphertext []byte) (key, nonce []byte) {
+	return g.cs.senderDataKeys(g.secrets.senderData, ciphertext)
+}
+
+func (cs *CipherSuite) senderDataKeys(secret, ciphertext []byte) (key, nonce []byte) {
+	sample := ciphertext[:min(len(ciphertext), nh)]
+	return cs.expandWithLabel(secret, "key", sample, nk), cs.expandWithLabel(secret, "nonce", sample, nn)
+}
+
+func contentAAD(groupID []byte, epoch uint64, contentType uint8, authData []byte, withAuthData bool) []byte {
+	return encode(func(w *writer) {
+		w.opaque(groupID)
+		w.u64(epoch)
+		w.u8(contentType)
+		if withAuthData {
+			w.opaque(authData)
+		}
+	})
+}
+
+// Encrypt protects data for the current members of the group.
+func (g *Group) Encrypt(data, authData []byte) ([]byte, error) {
+	if !g.active {
+		return nil, ErrRemoved
+	}
+	fc := &FramedContent{
+		GroupID:           g.ctx.GroupID,
+		Epoch:             g.ctx.Epoch,
+		Sender:            g.me,
+		AuthenticatedData: authData,
+		ContentType:       contentApplication,
+		Application:       data,
+	}
+	sig := g.sigKey.signWithLabel("FramedContentTBS", fc.tbs(wirePrivate, &g.ctx))
+	content := encode(func(w *writer) {
+		w.opaque(data)
+		w.opaque(sig)
+	})
+	content = append(content, make([]byte, (paddingBlock-len(content)%paddingBlock)%paddingBlock)...)
+
+	r, err := g.appKeys.ratchet(g.me)
+	if err != nil {
+		return nil, err
+	}
+	generation, key, nonce := r.step()
+	guard := randomBytes(4)
+	for i := range guard {
+		nonce[i] ^= guard[i]
+	}
+	m := &PrivateMessage{
+		GroupID:           g.ctx.GroupID,
+		Epoch:             g.ctx.Epoch,
+		ContentType:       contentApplication,
+		AuthenticatedData: authData,
+	}
+	m.Ciphertext = g.cs.aead(key).Seal(nil, nonce, content, contentAAD(m.GroupID, m.Epoch, m.ContentType, authData, true))
+
+	senderData := encode(func(w *writer) {
+		w.u32(g.me)
+		w.u32(generation)
+		w.raw(guard)
+	})
+	sdKey, sdNonce := g.senderDataAEAD(m.Ciphertext)
+	m.EncryptedSenderData = g.cs.aead(sdKey).Seal(nil, sdNonce, senderData, contentAAD(m.GroupID, m.Epoch, m.ContentType, nil, false))
+	return encode(m.marshal), nil
+}
+
+// Decrypt opens an application message and returns the sender's
+// identity, the data and the authenticated data.
+func (g *Group) Decrypt(msg []byte) (sender string, data, authData []byte, err error) {
+	if !g.active {
+		return "", nil, nil, ErrRemoved
+	}
+	wf, r, err := parseMessage(msg)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if wf != wirePrivate {
+		return "", nil, nil, errors.New("mls: expected a PrivateMessage")
+	}
+	var m PrivateMessage
+	m.unmarshal(r)
+	if err := r.finish(); err != nil {
+		return "", nil, nil, err
+	}
+	if !bytes.Equal(m.GroupID, g.ctx.GroupID) || m.Epoch != g.ctx.Epoch {
+		return "", nil, nil, ErrWrongEpoch
+	}
+	if m.ContentType != contentApplication {
+		return "", nil, nil, errors.New("mls: only application data is sent encrypted")
+	}
+
+	sdKey, sdNonce := g.senderDataAEAD(m.Ciphertext)
+	sd, err := g.cs.aead(sdKey).Open(nil, sdNonce, m.EncryptedSenderData, contentAAD(m.GroupID, m.Epoch, m.ContentType, nil, false))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("mls: sender data: %w", err)
+	}
+	var leaf, generation uint32
+	var guard []byte
+	if err := decode(sd, func(r *reader) { leaf, generation, guard = r.u32(), r.u32(), r.take(4) }); err != nil {
+		return "", nil, nil, err
+	}
+	signer := g.leafAt(leaf)
+	if signer == nil || leaf == g.me {
+		return "", nil, nil, fmt.Errorf("mls: no other member at leaf %d", leaf)
+	}
+	rt, err := g.appKeys.ratchet(leaf)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	key, nonce, err := rt.get(generation)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	for i := range guard {
+		nonce[i] ^= guard[i]
+	}
+	content, err := g.cs.aead(key).Open(nil, nonce, m.Ciphertext, contentAAD(m.GroupID, m.Epoch, m.ContentType, m.AuthenticatedData, true))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var sig []byte
+	err = decode(content, func(r *reader) {
+		data = r.opaque()
+		sig = r.opaque()
+		for _, b := range r.take(len(r.b)) {
+			if b != 0 {
+				r.err = errors.New("mls: non-zero padding")
+			}
+		}
+	})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	fc := &FramedContent{
+		GroupID:           m.GroupID,
+		Epoch:             m.Epoch,
+		Sender:            leaf,
+		AuthenticatedData: m.AuthenticatedData,
+		ContentType:       contentApplication,
+		Application:       data,
+	}
+	if err := g.cs.verifyWithLabel(signer.SignatureKey, "FramedContentTBS", fc.tbs(wirePrivate, &g.ctx), sig); err != nil {
+		return "", nil, nil, err
+	}
+	return string(signer.Credential.Identity), data, m.AuthenticatedData, nil
+}
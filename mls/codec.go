@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Encoding follows the TLS presentation language as profiled by RFC 9420:
+// fixed-width big-endian integers, and vectors prefixed with a
+// variable-length integer (section 2.1.2).
+
+var errDecode = errors.New("mls: malformed encoding")
+
+type writer struct {
+	b []byte
+}
+
+func (w *writer) u8(v uint8)   { w.b = append(w.b, v) }
+func (w *writer) u16(v uint16) { w.b = binary.BigEndian.AppendUint16(w.b, v) }
+func (w *writer) u32(v uint32) { w.b = binary.BigEndian.AppendUint32(w.b, v) }
+func (w *writer) u64(v uint64) { w.b = binary.BigEndian.AppendUint64(w.b, v) }
+func (w *writer) raw(b []byte) { w.b = append(w.b, b...) }
+
+func (w *writer) varint(n int) {
+	switch {
+	case n < 1<<6:
+		w.u8(uint8(n))
+	case n < 1<<14:
+		w.u16(uint16(n) | 0x4000)
+	case n < 1<<30:
+		w.u32(uint32(n) | 0x80000000)
+	default:
+		panic("mls: vector too long")
+	}
+}
+
+// opaque writes a variable-length byte vector.
+func (w *writer) opaque(b []byte) {
+	w.varint(len(b))
+	w.raw(b)
+}
+
+// vector writes the elements written by f as one variable-length vector.
+func (w *writer) vector(f func(w *writer)) {
+	var inner writer
+	f(&inner)
+	w.opaque(inner.b)
+}
+
+// optional writes the presence octet and, if present, the value.
+func (w *writer) optional(present bool, f func(w *writer)) {
+	if !present {
+		w.u8(0)
+		return
+	}
+	w.u8(1)
+	f(w)
+}
+
+func encode(f func(w *writer)) []byte {
+	var w writer
+	f(&w)
+	return w.b
+}
+
+// reader decodes; the first error sticks and later reads return zeros.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil || n > len(r.b) || n < 0 {
+		r.err = errDecode
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) varint() int {
+	first := r.take(1)
+	if first == nil {
+		return 0
+	}
+	switch first[0] >> 6 {
+	case 0:
+		return int(first[0])
+	case 1:
+		rest := r.take(1)
+		if rest == nil {
+			return 0
+		}
+		return int(first[0]&0x3f)<<8 | int(rest[0])
+	case 2:
+		rest := r.take(3)
+		if rest == nil {
+			return 0
+		}
+		return int(first[0]&0x3f)<<24 | int(rest[0])<<16 | int(rest[1])<<8 | int(rest[2])
+	}
+	r.err = errDecode
+	return 0
+}
+
+func (r *reader) opaque() []byte {
+	b := r.take(r.varint())
+	return append([]byte(nil), b...)
+}
+
+// vector calls f for each element of a variable-length vector.
+func (r *reader) vector(f func(r *reader)) {
+	inner := &reader{b: r.take(r.varint())}
+	if r.err != nil {
+		return
+	}
+	for len(inner.b) > 0 && inner.err == nil {
+		f(inner)
+	}
+	if inner.err != nil {
+		r.err = inner.err
+	}
+}
+
+func (r *reader) optional(f func(r *reader)) bool {
+	switch r.u8() {
+	case 0:
+		return false
+	case 1:
+		f(r)
+		return r.err == nil
+	}
+	r.err = errDecode
+	return false
+}
+
+// decode runs f over b and requires it to consume all of b.
+func decode(b []byte, f func(r *reader)) error {
+	r := &reader{b: b}
+	f(r)
+	return r.finish()
+}
+
+// finish reports any decoding error, including unconsumed input.
+func (r *reader) finish() error {
+	if r.err == nil && len(r.b) != 0 {
+		r.err = errDecode
+	}
+	return r.err
+}
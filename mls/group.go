@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/hpke"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Group is one member's view of an MLS group. Simplifications against
+// RFC 9420: credentials are basic, there are no PSKs, external commits or extensions, and every commit carries
+// an UpdatePath. Adds and removes are sent by value in the commit;
+// updates are proposals committed by reference.
+type Group struct {
+	cs        *CipherSuite
+	ctx       GroupContext
+	tree      *RatchetTree
+	me        uint32
+	sigKey    *SignaturePrivateKey
+	nodeKeys  map[uint32]hpke.PrivateKey
+	secrets   *epochSecrets
+	appKeys   *secretTree
+	interim   []byte
+	proposals map[string]pendingProposal
+	active    bool
+}
+
+type pendingProposal struct {
+	sender   uint32
+	proposal *Proposal
+	leafKey  hpke.PrivateKey // our own update
+}
+
+var (
+	ErrRemoved    = errors.New("mls: we were removed from the group")
+	ErrWrongEpoch = errors.New("mls: message is not for the current epoch")
+)
+
+// CreateGroup starts a one-member group at epoch 0.
+func CreateGroup(groupID []byte, kp *KeyPackageBundle) (*Group, error) {
+	cs := kp.KeyPackage.Suite
+	tree := &RatchetTree{suite: cs, nodes: []*Node{{Leaf: &kp.KeyPackage.Leaf}}}
+	g := &Group{
+		cs:     cs,
+		tree:   tree,
+		sigKey: kp.sigKey,
+		ctx: GroupContext{
+			Suite:                   cs,
+			GroupID:                 groupID,
+			TreeHash:                tree.rootHash(),
+			ConfirmedTranscriptHash: []byte{},
+		},
+		nodeKeys:  map[uint32]hpke.PrivateKey{0: kp.leafKey},
+		proposals: map[string]pendingProposal{},
+		active:    true,
+	}
+	joiner := cs.joinerSecret(randomBytes(nh), make([]byte, nh), &g.ctx)
+	g.setEpoch(cs.epochSecrets(joiner, nil, &g.ctx))
+	g.interim = g.interimHash(cs.mac(g.secrets.confirmation, g.ctx.ConfirmedTranscriptHash))
+	return g, nil
+}
+
+func (g *Group) Epoch() uint64 { return g.ctx.Epoch }
+
+// EpochAuthenticator is equal for all members that agree on the epoch.
+func (g *Group) EpochAuthenticator() []byte { return g.secrets.authentication }
+
+// Members lists the identities of the occupied leaves.
+func (g *Group) Members() []string {
+	var out []string
+	for i := uint32(0); i < g.tree.leaves(); i++ {
+		if l := g.tree.leaf(i); l != nil {
+			out = append(out, string(l.Credential.Identity))
+		}
+	}
+	return out
+}
+
+// LeafIndex returns the leaf of the member with identity.
+func (g *Group) LeafIndex(identity string) (uint32, bool) {
+	for i := uint32(0); i < g.tree.leaves(); i++ {
+		if l := g.tree.leaf(i); l != nil && string(l.Credential.Identity) == identity {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (g *Group) setEpoch(s *epochSecrets) {
+	g.secrets = s
+	g.appKeys = newSecretTree(g.cs, s.encryption, g.tree.leaves())
+	clear(g.proposals)
+}
+
+func (g *Group) interimHash(confirmationTag []byte) []byte {
+	return g.cs.hash(append(slices.Clone(g.ctx.ConfirmedTranscriptHash), encode(func(w *writer) { w.opaque(confirmationTag) })...))
+}
+
+// ProposeUpdate replaces our leaf's encryption key. The returned
+// proposal must be sent to the group and committed by some member.
+func (g *Group) ProposeUpdate() ([]byte, error) {
+	priv, pub, err := g.cs.generateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	leaf := *g.tree.leaf(g.me)
+	leaf.EncryptionKey = pub
+	leaf.Source = sourceUpdate
+	leaf.NotBefore, leaf.NotAfter, leaf.ParentHash = 0, 0, nil
+	leaf.sign(g.sigKey, g.ctx.GroupID, g.me)
+
+	m := &PublicMessage{Content: FramedContent{
+		GroupID:     g.ctx.GroupID,
+		Epoch:       g.ctx.Epoch,
+		Sender:      g.me,
+		ContentType: contentProposal,
+		Proposal:    &Proposal{Type: proposalUpdate, Leaf: &leaf},
+	}}
+	g.signPublic(m)
+	m.MembershipTag = g.cs.mac(g.secrets.membership, m.tbm(&g.ctx))
+	g.cacheProposal(m, priv)
+	return encode(func(w *writer) { m.marshal(w) }), nil
+}
+
+func (g *Group) signPublic(m *PublicMessage) {
+	m.Signature = g.sigKey.signWithLabel("FramedContentTBS", m.Content.tbs(wirePublic, &g.ctx))
+}
+
+func (g *Group) cacheProposal(m *PublicMessage, leafKey hpke.PrivateKey) {
+	ref := g.cs.refHash("MLS 1.0 Proposal Reference", m.authenticatedContent())
+	g.proposals[string(ref)] = pendingProposal{sender: m.Content.Sender, proposal: m.Content.Proposal, leafKey: leafKey}
+}
+
+// Commit adds the members of adds and removes the given leaves, together
+// with all pending update proposals, and moves our own view to the new
+// epoch. It returns the commit for the existing members and, if anyone
+// was added, the Welcome for the new ones.
+func (g *Group) Commit(adds []*KeyPackage, removes []uint32) (commit, welcome []byte, err error) {
+	if !g.active {
+		return nil, nil, ErrRemoved
+	}
+	c := &Commit{}
+	for ref, p := range g.proposals {
+		if p.sender == g.me {
+			// RFC 9420 forbids committing our own update; the path
+			// refreshes our leaf anyway.
+			continue
+		}
+		if slices.Contains(removes, p.sender) {
+			continue
+		}
+		c.Proposals = append(c.Proposals, ProposalOrRef{Ref: []byte(ref)})
+	}
+	slices.SortFunc(c.Proposals, func(a, b ProposalOrRef) int { return bytes.Compare(a.Ref, b.Ref) })
+	for _, leaf := range removes {
+		if leaf == g.me {
+			return nil, nil, errors.New("mls: a member cannot remove itself")
+		}
+		c.Proposals = append(c.Proposals, ProposalOrRef{Proposal: &Proposal{Type: proposalRemove, Removed: leaf}})
+	}
+	now := time.Now()
+	for _, kp := range adds {
+		if err := kp.Verify(now); err != nil {
+			return nil, nil, err
+		}
+		if kp.Suite != g.cs {
+			return nil, nil, errors.New("mls: key package uses a different cipher suite")
+		}
+		c.Proposals = append(c.Proposals, ProposalOrRef{Proposal: &Proposal{Type: proposalAdd, KeyPackage: kp}})
+	}
+
+	tree, joiners, err := g.applyProposals(c.Proposals, g.me)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Fresh path secrets for our filtered direct path.
+	tree.blankPath(g.me)
+	fdp := tree.filteredDirectPath(g.me)
+	leafSecret := randomBytes(nh)
+	leafKey, leafPub := g.cs.deriveKeyPair(g.cs.deriveSecret(leafSecret, "node"))
+	nodeKeys := map[uint32]hpke.PrivateKey{leafNode(g.me): leafKey}
+	pathSecrets := make([][]byte, len(fdp))
+	ps := g.cs.deriveSecret(leafSecret, "path")
+	for i, x := range fdp {
+		pathSecrets[i] = ps
+		priv, pub := g.cs.deriveKeyPair(g.cs.deriveSecret(ps, "node"))
+		nodeKeys[x] = priv
+		tree.nodes[x] = &Node{Parent: &ParentNode{EncryptionKey: pub}}
+		ps = g.cs.deriveSecret(ps, "path")
+	}
+	commitSecret := ps
+
+	leaf := *g.tree.leaf(g.me)
+	leaf.EncryptionKey = leafPub
+	leaf.Source = sourceCommit
+	leaf.NotBefore, leaf.NotAfter = 0, 0
+	leaf.ParentHash = tree.setParentHashes(g.me)
+	leaf.sign(g.sigKey, g.ctx.GroupID, g.me)
+	tree.nodes[leafNode(g.me)] = &Node{Leaf: &leaf}
+
+	provisional := GroupContext{
+		Suite:                   g.cs,
+		GroupID:                 g.ctx.GroupID,
+		Epoch:                   g.ctx.Epoch + 1,
+		TreeHash:                tree.rootHash(),
+		ConfirmedTranscriptHash: g.ctx.ConfirmedTranscriptHash,
+	}
+	path := &UpdatePath{Leaf: leaf}
+	for i, x := range fdp {
+		n := UpdatePathNode{EncryptionKey: tree.nodes[x].Parent.EncryptionKey}
+		for _, r := range tree.resolution(copathChild(x, g.me), joiners) {
+			ct, err := g.cs.encryptWithLabel(tree.nodes[r].encryptionKey(), "UpdatePathNode", provisional.bytes(), pathSecrets[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			n.EncryptedPathSecret = append(n.EncryptedPathSecret, ct)
+		}
+		path.Nodes = append(path.Nodes, n)
+	}
+	c.Path = path
+
+	m := &PublicMessage{Content: FramedContent{
+		GroupID:     g.ctx.GroupID,
+		Epoch:       g.ctx.Epoch,
+		Sender:      g.me,
+		ContentType: contentCommit,
+		Commit:      c,
+	}}
+	g.signPublic(m)
+	next, secrets, joiner := g.nextEpoch(m, tree, commitSecret)
+	m.ConfirmationTag = g.cs.mac(secrets.confirmation, next.ConfirmedTranscriptHash)
+	m.MembershipTag = g.cs.mac(g.secrets.membership, m.tbm(&g.ctx))
+	commit = encode(func(w *writer) { m.marshal(w) })
+
+	if len(adds) > 0 {
+		wl, err := g.welcome(tree, next, m.ConfirmationTag, joiner, adds, joiners, fdp, pathSecrets)
+		if err != nil {
+			return nil, nil, err
+		}
+		welcome = encode(wl.marshal)
+	}
+
+	g.tree, g.ctx, g.nodeKeys = tree, *next, nodeKeys
+	g.setEpoch(secrets)
+	g.interim = g.interimHash(m.ConfirmationTag)
+	return commit, welcome, nil
+}
+
+// copathChild returns the child of x that is not on leaf's direct path.
+func copathChild(x, leaf uint32) uint32 {
+	if l := left(x); l == leafNode(leaf) || isAncestor(l, leafNode(leaf)) {
+		return right(x)
+	}
+	return left(x)
+}
+
+// applyProposals applies updates, then removes, then adds to a copy of
+// the tree and returns it with the leaves of the added members.
+func (g *Group) applyProposals(props []ProposalOrRef, committer uint32) (*RatchetTree, []uint32, error) {
+	var updates []pendingProposal
+	var removes, adds []*Proposal
+	for _, p := range props {
+		if p.Proposal == nil {
+			pp, ok := g.proposals[string(p.Ref)]
+			if !ok {
+				return nil, nil, errors.New("mls: commit references an unknown proposal")
+			}
+			if pp.proposal.Type != proposalUpdate || pp.sender == committer {
+				return nil, nil, errors.New("mls: invalid proposal by reference")
+			}
+			updates = append(updates, pp)
+			continue
+		}
+		switch p.Proposal.Type {
+		case proposalRemove:
+			removes = append(removes, p.Proposal)
+		case proposalAdd:
+			adds = append(adds, p.Proposal)
+		default:
+			return nil, nil, errors.New("mls: update proposals must be committed by reference")
+		}
+	}
+	tree := g.tree.clone()
+	for _, u := range updates {
+		tree.updateLeaf(u.sender, u.proposal.Leaf)
+	}
+	for _, r := range removes {
+		if r.Removed == committer || r.Removed >= tree.leaves() || tree.leaf(r.Removed) == nil {
+			return nil, nil, fmt.Errorf("mls: cannot remove leaf %d", r.Removed)
+		}
+		tree.removeLeaf(r.Removed)
+	}
+	var joiners []uint32
+	for _, a := range adds {
+		if err := a.KeyPackage.Verify(time.Now()); err != nil {
+			return nil, nil, err
+		}
+		joiners = append(joiners, tree.addLeaf(&a.KeyPackage.Leaf))
+	}
+	return tree, joiners, nil
+}
+
+// nextEpoch computes the group context and secrets after commit m.
+func (g *Group) nextEpoch(m *PublicMessage, tree *RatchetTree, commitSecret []byte) (*GroupContext, *epochSecrets, []byte) {
+	input := encode(func(w *writer) {
+		w.u16(wirePublic)
+		m.Content.marshal(w)
+		w.opaque(m.Signature)
+	})
+	next := &GroupContext{
+		Suite:                   g.cs,
+		GroupID:                 g.ctx.GroupID,
+		Epoch:                   g.ctx.Epoch + 1,
+		TreeHash:                tree.rootHash(),
+		ConfirmedTranscriptHash: g.cs.hash(append(slices.Clone(g.interim), input...)),
+	}
+	joiner := g.cs.joinerSecret(g.secrets.init, commitSecret, next)
+	return next, g.cs.epochSecrets(joiner, nil, next), joiner
+}
+
+func (g *Group) welcome(tree *RatchetTree, ctx *GroupContext, confirmationTag, joiner []byte, adds []*KeyPackage, joiners, fdp []uint32, pathSecrets [][]byte) (*Welcome, error) {
+	gi := &GroupInfo{Context: *ctx, Tree: tree, ConfirmationTag: confirmationTag, Signer: g.me}
+	gi.Signature = g.sigKey.signWithLabel("GroupInfoTBS", encode(gi.marshalTBS))
+	key, nonce := g.cs.welcomeKeys(joiner)
+	wl := &Welcome{
+		Suite:              g.cs,
+		EncryptedGroupInfo: g.cs.aead(key).Seal(nil, nonce, encode(func(w *writer) { gi.marshalTBS(w); w.opaque(gi.Signature) }), nil),
+	}
+	for i, kp := range adds {
+		gs := &GroupSecrets{JoinerSecret: joiner}
+		// The lowest path node above the new member is the one whose
+		// secret the member can use to learn the rest of the path.
+		for j, x := range fdp {
+			if isAncestor(x, leafNode(joiners[i])) {
+				gs.PathSecret = pathSecrets[j]
+				break
+			}
+		}
+		ct, err := g.cs.encryptWithLabel(kp.InitKey, "Welcome", wl.EncryptedGroupInfo, encode(gs.marshal))
+		if err != nil {
+			return nil, err
+		}
+		wl.Secrets = append(wl.Secrets, EncryptedGroupSecrets{NewMember: kp.Ref(), Secrets: ct})
+	}
+	return wl, nil
+}
+
+// Join creates our view of a group from a Welcome addressed to kp.
+func Join(welcome []byte, kp *KeyPackageBundle) (*Group, error) {
+	wf, r, err := parseMessage(welcome)
+	if err != nil {
+		return nil, err
+	}
+	if wf != wireWelcome {
+		return nil, errors.New("mls: not a Welcome message")
+	}
+	var wl Welcome
+	wl.unmarshal(r)
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+	cs := wl.Suite
+	if cs != kp.KeyPackage.Suite {
+		return nil, errors.New("mls: Welcome uses a different cipher suite")
+	}
+	ref := kp.KeyPackage.Ref()
+	i := slices.IndexFunc(wl.Secrets, func(s EncryptedGroupSecrets) bool { return bytes.Equal(s.NewMember, ref) })
+	if i < 0 {
+		return nil, errors.New("mls: Welcome is not addressed to this key package")
+	}
+	pt, err := cs.decryptWithLabel(kp.initKey, "Welcome", wl.EncryptedGroupInfo, wl.Secrets[i].Secrets)
+	if err != nil {
+		return nil, err
+	}
+	var gs GroupSecrets
+	if err := decode(pt, gs.unmarshal); err != nil {
+		return nil, err
+	}
+	key, nonce := cs.welcomeKeys(gs.JoinerSecret)
+	pt, err = cs.aead(key).Open(nil, nonce, wl.EncryptedGroupInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+	var gi GroupInfo
+	if err := decode(pt, gi.unmarshal); err != nil {
+		return nil, err
+	}
+	if gi.Context.Suite != cs {
+		return nil, errors.New("mls: GroupInfo uses a different cipher suite")
+	}
+	tree := gi.Tree
+	if !bytes.Equal(tree.rootHash(), gi.Context.TreeHash) {
+		return nil, errors.New("mls: ratchet tree does not match the tree hash")
+	}
+	if err := verifyLeaves(tree, gi.Context.GroupID); err != nil {
+		return nil, err
+	}
+	if err := tree.verifyParentHashes(); err != nil {
+		return nil, err
+	}
+	signer := tree.leaf(gi.Signer)
+	if gi.Signer >= tree.leaves() || signer == nil {
+		return nil, errors.New("mls: GroupInfo signer is not a member")
+	}
+	if err := cs.verifyWithLabel(signer.SignatureKey, "GroupInfoTBS", encode(gi.marshalTBS), gi.Signature); err != nil {
+		return nil, err
+	}
+	me, ok := tree.findLeaf(&kp.KeyPackage.Leaf)
+	if !ok {
+		return nil, errors.New("mls: our key package is not in the tree")
+	}
+
+	g := &Group{
+		cs:        cs,
+		ctx:       gi.Context,
+		tree:      tree,
+		me:        me,
+		sigKey:    kp.sigKey,
+		nodeKeys:  map[uint32]hpke.PrivateKey{leafNode(me): kp.leafKey},
+		proposals: map[string]pendingProposal{},
+		active:    true,
+	}
+	if gs.PathSecret != nil {
+		// Walk the committer's filtered direct path from the first node
+		// above us.
+		fdp := tree.filteredDirectPath(gi.Signer)
+		start := slices.IndexFunc(fdp, func(x uint32) bool { return isAncestor(x, leafNode(me)) })
+		if start < 0 {
+			return nil, errors.New("mls: unexpected path secret")
+		}
+		if _, err := g.walkPath(tree, fdp[start:], gs.PathSecret, g.nodeKeys); err != nil {
+			return nil, err
+		}
+	}
+	g.setEpoch(cs.epochSecrets(gs.JoinerSecret, nil, &g.ctx))
+	if !hmac.Equal(cs.mac(g.secrets.confirmation, g.ctx.ConfirmedTranscriptHash), gi.ConfirmationTag) {
+		return nil, errors.New("mls: invalid confirmation tag")
+	}
+	g.interim = g.interimHash(gi.ConfirmationTag)
+	return g, nil
+}
+
+func verifyLeaves(tree *RatchetTree, groupID []byte) error {
+	for i := uint32(0); i < tree.leaves(); i++ {
+		l := tree.leaf(i)
+		if l == nil {
+			continue
+		}
+		if err := l.verify(tree.suite, groupID, i); err != nil {
+			return fmt.Errorf("mls: leaf %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// walkPath derives the key pairs of path from the first node's path
+// secret, checks them against the tree and stores the private keys in
+// keys. It returns the path secret after the last node, which is the
+// commit secret when path ends at the root.
+func (g *Group) walkPath(tree *RatchetTree, path []uint32, ps []byte, keys map[uint32]hpke.PrivateKey) ([]byte, error) {
+	for _, x := range path {
+		priv, pub := g.cs.deriveKeyPair(g.cs.deriveSecret(ps, "node"))
+		if n := tree.nodes[x]; n == nil || !bytes.Equal(n.encryptionKey(), pub) {
+			return nil, errors.New("mls: path secret does not match the tree")
+		}
+		keys[x] = priv
+		ps = g.cs.deriveSecret(ps, "path")
+	}
+	return ps, nil
+}
+
+// Process handles a proposal or commit from another member. A commit
+// moves the group to the next epoch; ErrRemoved means it removed us.
+func (g *Group) Process(msg []byte) error {
+	if !g.active {
+		return ErrRemoved
+	}
+	wf, r, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	if wf != wirePublic {
+		return errors.New("mls: expected a PublicMessage")
+	}
+	var m PublicMessage
+	m.unmarshal(r)
+	if err := r.finish(); err != nil {
+		return err
+	}
+	fc := &m.Content
+	if !bytes.Equal(fc.GroupID, g.ctx.GroupID) || fc.Epoch != g.ctx.Epoch {
+		return ErrWrongEpoch
+	}
+	if fc.Sender == g.me {
+		return errors.New("mls: message from ourselves")
+	}
+	sender := g.leafAt(fc.Sender)
+	if sender == nil {
+		return fmt.Errorf("mls: no member at leaf %d", fc.Sender)
+	}
+	if !hmac.Equal(g.cs.mac(g.secrets.membership, m.tbm(&g.ctx)), m.MembershipTag) {
+		return errors.New("mls: invalid membership tag")
+	}
+	if err := g.cs.verifyWithLabel(sender.SignatureKey, "FramedContentTBS", fc.tbs(wirePublic, &g.ctx), m.Signature); err != nil {
+		return err
+	}
+
+	switch fc.ContentType {
+	case contentProposal:
+		if fc.Proposal.Type != proposalUpdate {
+			return errors.New("mls: only update proposals are sent on their own")
+		}
+		if err := fc.Proposal.Leaf.verify(g.cs, g.ctx.GroupID, fc.Sender); err != nil {
+			return err
+		}
+		if fc.Proposal.Leaf.Source != sourceUpdate || !bytes.Equal(fc.Proposal.Leaf.SignatureKey, sender.SignatureKey) {
+			return errors.New("mls: invalid update leaf")
+		}
+		g.cacheProposal(&m, nil)
+		return nil
+	case contentCommit:
+		return g.processCommit(&m)
+	}
+	return errors.New("mls: application data must be sent as a PrivateMessage")
+}
+
+func (g *Group) leafAt(i uint32) *LeafNode {
+	if i >= g.tree.leaves() {
+		return nil
+	}
+	return g.tree.leaf(i)
+}
+
+func (g *Group) processCommit(m *PublicMessage) error {
+	c := m.Content.Commit
+	committer := m.Content.Sender
+	if c.Path == nil {
+		return errors.New("mls: commit without an UpdatePath")
+	}
+	tree, joiners, err := g.applyProposals(c.Proposals, committer)
+	if err != nil {
+		return err
+	}
+	if tree.leaf(g.me) == nil || !bytes.Equal(tree.leaf(g.me).SignatureKey, g.sigKey.public) {
+		g.active = false
+		return ErrRemoved
+	}
+
+	leaf := &c.Path.Leaf
+	if leaf.Source != sourceCommit || !bytes.Equal(leaf.SignatureKey, g.tree.leaf(committer).SignatureKey) {
+		return errors.New("mls: invalid commit leaf")
+	}
+	if err := leaf.verify(g.cs, g.ctx.GroupID, committer); err != nil {
+		return err
+	}
+	tree.blankPath(committer)
+	fdp := tree.filteredDirectPath(committer)
+	if len(fdp) != len(c.Path.Nodes) {
+		return errors.New("mls: UpdatePath does not match the filtered direct path")
+	}
+	for i, x := range fdp {
+		tree.nodes[x] = &Node{Parent: &ParentNode{EncryptionKey: c.Path.Nodes[i].EncryptionKey}}
+	}
+	if !bytes.Equal(leaf.ParentHash, tree.setParentHashes(committer)) {
+		return errors.New("mls: commit leaf has the wrong parent hash")
+	}
+	tree.nodes[leafNode(committer)] = &Node{Leaf: leaf}
+
+	provisional := GroupContext{
+		Suite:                   g.cs,
+		GroupID:                 g.ctx.GroupID,
+		Epoch:                   g.ctx.Epoch + 1,
+		TreeHash:                tree.rootHash(),
+		ConfirmedTranscriptHash: g.ctx.ConfirmedTranscriptHash,
+	}
+
+	// Our own pending update, if it was committed, replaces our leaf key.
+	keys := map[uint32]hpke.PrivateKey{}
+	for x, k := range g.nodeKeys {
+		keys[x] = k
+	}
+	for _, p := range c.Proposals {
+		if pp, ok := g.proposals[string(p.Ref)]; ok && p.Ref != nil && pp.sender == g.me {
+			keys[leafNode(g.me)] = pp.leafKey
+		}
+	}
+
+	// Decrypt the path secret of the lowest path node above us.
+	start := slices.IndexFunc(fdp, func(x uint32) bool { return isAncestor(x, leafNode(g.me)) })
+	if start < 0 {
+		return errors.New("mls: commit path does not cover us")
+	}
+	var ps []byte
+	res := tree.resolution(copathChild(fdp[start], committer), joiners)
+	for j, x := range res {
+		k, ok := keys[x]
+		if !ok || !bytes.Equal(k.PublicKey().Bytes(), tree.nodes[x].encryptionKey()) {
+			continue
+		}
+		cts := c.Path.Nodes[start].EncryptedPathSecret
+		if len(cts) != len(res) {
+			return errors.New("mls: wrong number of encrypted path secrets")
+		}
+		if ps, err = g.cs.decryptWithLabel(k, "UpdatePathNode", provisional.bytes(), cts[j]); err != nil {
+			return err
+		}
+		break
+	}
+	if ps == nil {
+		return errors.New("mls: no key to decrypt the path secret")
+	}
+	commitSecret, err := g.walkPath(tree, fdp[start:], ps, keys)
+	if err != nil {
+		return err
+	}
+
+	next, secrets, _ := g.nextEpoch(m, tree, commitSecret)
+	if !hmac.Equal(g.cs.mac(secrets.confirmation, next.ConfirmedTranscriptHash), m.ConfirmationTag) {
+		return errors.New("mls: invalid confirmation tag")
+	}
+
+	// Keep only private keys that still match the tree.
+	for x, k := range keys {
+		if int(x) >= len(tree.nodes) || tree.nodes[x] == nil || !bytes.Equal(tree.nodes[x].encryptionKey(), k.PublicKey().Bytes()) {
+			delete(keys, x)
+		}
+	}
+	g.tree, g.ctx, g.nodeKeys = tree, *next, keys
+	g.setEpoch(secrets)
+	g.interim = g.interimHash(m.ConfirmationTag)
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hpke"
+	"errors"
+	"time"
+)
+
+const protocolVersion = 1 // mls10
+
+// KeyPackage advertises a client that can be added to a group.
+type KeyPackage struct {
+	Suite     *CipherSuite
+	InitKey   []byte
+	Leaf      LeafNode
+	Signature []byte
+}
+
+func (kp *KeyPackage) marshalTBS(w *writer) {
+	w.u16(protocolVersion)
+	w.u16(kp.Suite.ID)
+	w.opaque(kp.InitKey)
+	kp.Leaf.marshal(w)
+	w.vector(func(w *writer) {}) // extensions
+}
+
+func (kp *KeyPackage) marshal(w *writer) {
+	kp.marshalTBS(w)
+	w.opaque(kp.Signature)
+}
+
+func (kp *KeyPackage) unmarshal(r *reader) {
+	if r.u16() != protocolVersion {
+		r.err = errors.New("mls: unsupported protocol version")
+		return
+	}
+	suite, err := suiteByID(r.u16())
+	if err != nil {
+		r.err = err
+		return
+	}
+	kp.Suite = suite
+	kp.InitKey = r.opaque()
+	kp.Leaf.unmarshal(r)
+	r.vector(func(r *reader) { r.u16(); r.opaque() })
+	kp.Signature = r.opaque()
+}
+
+// Ref is the KeyPackageRef naming this key package in a Welcome.
+func (kp *KeyPackage) Ref() []byte {
+	return kp.Suite.refHash("MLS 1.0 KeyPackage Reference", encode(kp.marshal))
+}
+
+// Verify checks both signatures, the lifetime and that the init and
+// encryption keys differ.
+func (kp *KeyPackage) Verify(now time.Time) error {
+	cs := kp.Suite
+	if kp.Leaf.Source != sourceKeyPackage {
+		return errors.New("mls: key package leaf has the wrong source")
+	}
+	if t := uint64(now.Unix()); t < kp.Leaf.NotBefore || t > kp.Leaf.NotAfter {
+		return errors.New("mls: key package is outside its lifetime")
+	}
+	if bytes.Equal(kp.InitKey, kp.Leaf.EncryptionKey) {
+		return errors.New("mls: init key reused as encryption key")
+	}
+	if err := kp.Leaf.verify(cs, nil, 0); err != nil {
+		return err
+	}
+	return cs.verifyWithLabel(kp.Leaf.SignatureKey, "KeyPackageTBS", encode(kp.marshalTBS), kp.Signature)
+}
+
+// KeyPackageBundle is a key package with the private keys behind it.
+type KeyPackageBundle struct {
+	KeyPackage *KeyPackage
+	initKey    hpke.PrivateKey
+	leafKey    hpke.PrivateKey
+	sigKey     *SignaturePrivateKey
+}
+
+// NewKeyPackage creates a key package for identity valid for lifetime.
+func NewKeyPackage(sigKey *SignaturePrivateKey, identity string, lifetime time.Duration) (*KeyPackageBundle, error) {
+	cs := sigKey.suite
+	initKey, initPub, err := cs.generateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	leafKey, leafPub, err := cs.generateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	kp := &KeyPackage{
+		Suite:   cs,
+		InitKey: initPub,
+		Leaf: LeafNode{
+			EncryptionKey: leafPub,
+			SignatureKey:  sigKey.public,
+			Credential:    Credential{Identity: []byte(identity)},
+			Capabilities: Capabilities{
+				Versions:     []uint16{protocolVersion},
+				CipherSuites: []uint16{cs.ID},
+				Credentials:  []uint16{credentialBasic},
+			},
+			Source:    sourceKeyPackage,
+			NotBefore: uint64(now.Add(-time.Hour).Unix()),
+			NotAfter:  uint64(now.Add(lifetime).Unix()),
+		},
+	}
+	kp.Leaf.sign(sigKey, nil, 0)
+	kp.Signature = sigKey.signWithLabel("KeyPackageTBS", encode(kp.marshalTBS))
+	return &KeyPackageBundle{KeyPackage: kp, initKey: initKey, leafKey: leafKey, sigKey: sigKey}, nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+)
+
+// Wire formats.
+const (
+	wirePublic  = 1
+	wirePrivate = 2
+	wireWelcome = 3
+)
+
+// Content types.
+const (
+	contentApplication = 1
+	contentProposal    = 2
+	contentCommit      = 3
+)
+
+// Proposal types.
+const (
+	proposalAdd    = 1
+	proposalUpdate = 2
+	proposalRemove = 3
+)
+
+const senderMember = 1
+
+type Proposal struct {
+	Type       uint16
+	KeyPackage *KeyPackage // add
+	Leaf       *LeafNode   // update
+	Removed    uint32      // remove
+}
+
+func (p *Proposal) marshal(w *writer) {
+	w.u16(p.Type)
+	switch p.Type {
+	case proposalAdd:
+		p.KeyPackage.marshal(w)
+	case proposalUpdate:
+		p.Leaf.marshal(w)
+	case proposalRemove:
+		w.u32(p.Removed)
+	}
+}
+
+func (p *Proposal) unmarshal(r *reader) {
+	p.Type = r.u16()
+	switch p.Type {
+	case proposalAdd:
+		p.KeyPackage = &KeyPackage{}
+		p.KeyPackage.unmarshal(r)
+	case proposalUpdate:
+		p.Leaf = &LeafNode{}
+		p.Leaf.unmarshal(r)
+	case proposalRemove:
+		p.Removed = r.u32()
+	default:
+		r.err = errors.New("mls: unsupported proposal type")
+	}
+}
+
+// ProposalOrRef: commits carry adds and removes by value and update
+// proposals, which only their sender may make, by reference.
+type ProposalOrRef struct {
+	Proposal *Proposal
+	Ref      []byte
+}
+
+type UpdatePathNode struct {
+	EncryptionKey       []byte
+	EncryptedPathSecret []HPKECiphertext
+}
+
+type UpdatePath struct {
+	Leaf  LeafNode
+	Nodes []UpdatePathNode
+}
+
+type Commit struct {
+	Proposals []ProposalOrRef
+	Path      *UpdatePath
+}
+
+func (c *Commit) marshal(w *writer) {
+	w.vector(func(w *writer) {
+		for _, p := range c.Proposals {
+			if p.Proposal != nil {
+				w.u8(1)
+				p.Proposal.marshal(w)
+			} else {
+				w.u8(2)
+				w.opaque(p.Ref)
+			}
+		}
+	})
+	w.optional(c.Path != nil, func(w *writer) {
+		c.Path.Leaf.marshal(w)
+		w.vector(func(w *writer) {
+			for _, n := range c.Path.Nodes {
+				w.opaque(n.EncryptionKey)
+				w.vector(func(w *writer) {
+					for _, ct := range n.EncryptedPathSecret {
+						ct.marshal(w)
+					}
+				})
+			}
+		})
+	})
+}
+
+func (c *Commit) unmarshal(r *reader) {
+	r.vector(func(r *reader) {
+		var p ProposalOrRef
+		switch r.u8() {
+		case 1:
+			p.Proposal = &Proposal{}
+			p.Proposal.unmarshal(r)
+		case 2:
+			p.Ref = r.opaque()
+		default:
+			r.err = errDecode
+		}
+		c.Proposals = append(c.Proposals, p)
+	})
+	r.optional(func(r *reader) {
+		c.Path = &UpdatePath{}
+		c.Path.Leaf.unmarshal(r)
+		r.vector(func(r *reader) {
+			var n UpdatePathNode
+			n.EncryptionKey = r.opaque()
+			r.vector(func(r *reader) {
+				var ct HPKECiphertext
+				ct.unmarshal(r)
+				n.EncryptedPathSecret = append(n.EncryptedPathSecret, ct)
+			})
+			c.Path.Nodes = append(c.Path.Nodes, n)
+		})
+	})
+}
+
+// FramedContent is the signed body of handshake and application messages.
+type FramedContent struct {
+	GroupID           []byte
+	Epoch             uint64
+	Sender            uint32
+	AuthenticatedData []byte
+	ContentType       uint8
+	Application       []byte
+	Proposal          *Proposal
+	Commit            *Commit
+}
+
+func (fc *FramedContent) marshal(w *writer) {
+	w.opaque(fc.GroupID)
+	w.u64(fc.Epoch)
+	w.u8(senderMember)
+	w.u32(fc.Sender)
+	w.opaque(fc.AuthenticatedData)
+	w.u8(fc.ContentType)
+	switch fc.ContentType {
+	case contentApplication:
+		w.opaque(fc.Application)
+	case contentProposal:
+		fc.Proposal.marshal(w)
+	case contentCommit:
+		fc.Commit.marshal(w)
+	}
+}
+
+func (fc *FramedContent) unmarshal(r *reader) {
+	fc.GroupID = r.opaque()
+	fc.Epoch = r.u64()
+	if r.u8() != senderMember {
+		r.err = errors.New("mls: only member senders are supported")
+		return
+	}
+	fc.Sender = r.u32()
+	fc.AuthenticatedData = r.opaque()
+	fc.ContentType = r.u8()
+	switch fc.ContentType {
+	case contentApplication:
+		fc.Application = r.opaque()
+	case contentProposal:
+		fc.Proposal = &Proposal{}
+		fc.Proposal.unmarshal(r)
+	case contentCommit:
+		fc.Commit = &Commit{}
+		fc.Commit.unmarshal(r)
+	default:
+		r.err = errDecode
+	}
+}
+
+// tbs is FramedContentTBS for a member sender.
+func (fc *FramedContent) tbs(wireFormat uint16, ctx *GroupContext) []byte {
+	return encode(func(w *writer) {
+		w.u16(protocolVersion)
+		w.u16(wireFormat)
+		fc.marshal(w)
+		ctx.marshal(w)
+	})
+}
+
+// PublicMessage carries proposals and commits, signed and tagged with
+// the epoch's membership key.
+type PublicMessage struct {
+	Content         FramedContent
+	Signature       []byte
+	ConfirmationTag []byte // commits only
+	MembershipTag   []byte
+}
+
+func (m *PublicMessage) marshalAuth(w *writer) {
+	w.opaque(m.Signature)
+	if m.Content.ContentType == contentCommit {
+		w.opaque(m.ConfirmationTag)
+	}
+}
+
+func (m *PublicMessage) marshal(w *writer) {
+	w.u16(protocolVersion)
+	w.u16(wirePublic)
+	m.Content.marshal(w)
+	m.marshalAuth(w)
+	w.opaque(m.MembershipTag)
+}
+
+func (m *PublicMessage) unmarshal(r *reader) {
+	m.Content.unmarshal(r)
+	m.Signature = r.opaque()
+	if m.Content.ContentType == contentCommit {
+		m.ConfirmationTag = r.opaque()
+	}
+	m.MembershipTag = r.opaque()
+}
+
+// tbm is AuthenticatedContentTBM, the input to the membership tag.
+func (m *PublicMessage) tbm(ctx *GroupContext) []byte {
+	return append(m.Content.tbs(wirePublic, ctx), encode(m.marshalAuth)...)
+}
+
+// authenticatedContent is what proposal references hash.
+func (m *PublicMessage) authenticatedContent() []byte {
+	return encode(func(w *writer) {
+		w.u16(wirePublic)
+		m.Content.marshal(w)
+		m.marshalAuth(w)
+	})
+}
+
+// PrivateMessage carries encrypted application data.
+type PrivateMessage struct {
+	GroupID             []byte
+	Epoch               uint64
+	ContentType         uint8
+	AuthenticatedData   []byte
+	EncryptedSenderData []byte
+	Ciphertext          []byte
+}
+
+func (m *PrivateMessage) marshal(w *writer) {
+	w.u16(protocolVersion)
+	w.u16(wirePrivate)
+	w.opaque(m.GroupID)
+	w.u64(m.Epoch)
+	w.u8(m.ContentType)
+	w.opaque(m.AuthenticatedData)
+	w.opaque(m.EncryptedSenderData)
+	w.opaque(m.Ciphertext)
+}
+
+func (m *PrivateMessage) unmarshal(r *reader) {
+	m.GroupID = r.opaque()
+	m.Epoch = r.u64()
+	m.ContentType = r.u8()
+	m.AuthenticatedData = r.opaque()
+	m.EncryptedSenderData = r.opaque()
+	m.Ciphertext = r.opaque()
+}
+
+type GroupInfo struct {
+	Context         GroupContext
+	Tree            *RatchetTree // ratchet_tree extension
+	ConfirmationTag []byte
+	Signer          uint32
+	Signature       []byte
+}
+
+const extensionRatchetTree = 2
+
+func (gi *GroupInfo) marshalTBS(w *writer) {
+	gi.Context.marshal(w)
+	w.vector(func(w *writer) {
+		w.u16(extensionRatchetTree)
+		w.vector(gi.Tree.marshal)
+	})
+	w.opaque(gi.ConfirmationTag)
+	w.u32(gi.Signer)
+}
+
+func (gi *GroupInfo) unmarshal(r *reader) {
+	gi.Context.unmarshal(r)
+	if r.err != nil {
+		return
+	}
+	gi.Tree = &RatchetTree{suite: gi.Context.Suite}
+	r.vector(func(r *reader) {
+		typ := r.u16()
+		data := r.opaque()
+		if typ == extensionRatchetTree {
+			r.err = decode(data, gi.Tree.unmarshal)
+		}
+	})
+	gi.ConfirmationTag = r.opaque()
+	gi.Signer = r.u32()
+	gi.Signature = r.opaque()
+}
+
+type EncryptedGroupSecrets struct {
+	NewMember []byte // KeyPackageRef
+	Secrets   HPKECiphertext
+}
+
+type Welcome struct {
+	Suite              *CipherSuite
+	Secrets            []EncryptedGroupSecrets
+	EncryptedGroupInfo []byte
+}
+
+func (wl *Welcome) marshal(w *writer) {
+	w.u16(protocolVersion)
+	w.u16(wireWelcome)
+	w.u16(wl.Suite.ID)
+	w.vector(func(w *writer) {
+		for _, s := range wl.Secrets {
+			w.opaque(s.NewMember)
+			s.Secrets.marshal(w)
+		}
+	})
+	w.opaque(wl.EncryptedGroupInfo)
+}
+
+func (wl *Welcome) unmarshal(r *reader) {
+	if wl.Suite, r.err = suiteByID(r.u16()); r.err != nil {
+		return
+	}
+	r.vector(func(r *reader) {
+		var s EncryptedGroupSecrets
+		s.NewMember = r.opaque()
+		s.Secrets.unmarshal(r)
+		wl.Secrets = append(wl.Secrets, s)
+	})
+	wl.EncryptedGroupInfo = r.opaque()
+}
+
+// GroupSecrets is what each new member decrypts from a Welcome.
+type GroupSecrets struct {
+	JoinerSecret []byte
+	PathSecret   []byte // nil when absent
+}
+
+func (gs *GroupSecrets) marshal(w *writer) {
+	w.opaque(gs.JoinerSecret)
+	w.optional(gs.PathSecret != nil, func(w *writer) { w.opaque(gs.PathSecret) })
+	w.vector(func(w *writer) {}) // psks
+}
+
+func (gs *GroupSecrets) unmarshal(r *reader) {
+	gs.JoinerSecret = r.opaque()
+	r.optional(func(r *reader) { gs.PathSecret = r.opaque() })
+	r.vector(func(r *reader) { r.err = errors.New("mls: PSKs are not supported") })
+}
+
+// parseMessage splits an MLSMessage into its wire format and body.
+func parseMessage(data []byte) (uint16, *reader, error) {
+	r := &reader{b: data}
+	if r.u16() != protocolVersion {
+		return 0, nil, errors.New("mls: unsupported protocol version")
+	}
+	wf := r.u16()
+	return wf, r, r.err
+}
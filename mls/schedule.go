@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// GroupContext is the state every member signs and derives keys over.
+type GroupContext struct {
+	Suite                   *CipherSuite
+	GroupID                 []byte
+	Epoch                   uint64
+	TreeHash                []byte
+	ConfirmedTranscriptHash []byte
+}
+
+func (gc *GroupContext) marshal(w *writer) {
+	w.u16(protocolVersion)
+	w.u16(gc.Suite.ID)
+	w.opaque(gc.GroupID)
+	w.u64(gc.Epoch)
+	w.opaque(gc.TreeHash)
+	w.opaque(gc.ConfirmedTranscriptHash)
+	w.vector(func(w *writer) {}) // extensions
+}
+
+func (gc *GroupContext) unmarshal(r *reader) {
+	if r.u16() != protocolVersion {
+		r.err = errors.New("mls: unsupported protocol version")
+		return
+	}
+	if gc.Suite, r.err = suiteByID(r.u16()); r.err != nil {
+		return
+	}
+	gc.GroupID = r.opaque()
+	gc.Epoch = r.u64()
+	gc.TreeHash = r.opaque()
+	gc.ConfirmedTranscriptHash = r.opaque()
+	r.vector(func(r *reader) { r.u16(); r.opaque() })
+}
+
+func (gc *GroupContext) bytes() []byte { return encode(gc.marshal) }
+
+// epochSecrets are derived from the epoch secret of each epoch
+// (RFC 9420 section 8). Groups do not use PSKs, so they pass a nil
+// psk_secret, which stands for all zeros; the test vectors pass theirs.
+type epochSecrets struct {
+	senderData     []byte
+	encryption     []byte
+	exporter       []byte
+	external       []byte // unused: external joins are not supported
+	confirmation   []byte
+	membership     []byte
+	resumption     []byte
+	authentication []byte
+	init           []byte
+}
+
+// joinerSecret runs the first step of the key schedule for a new epoch.
+func (cs *CipherSuite) joinerSecret(initSecret, commitSecret []byte, ctx *GroupContext) []byte {
+	return cs.expandWithLabel(cs.extract(initSecret, commitSecret), "joiner", ctx.bytes(), nh)
+}
+
+func (cs *CipherSuite) memberSecret(joiner, psk []byte) []byte {
+	if psk == nil {
+		psk = make([]byte, nh)
+	}
+	return cs.extract(joiner, psk)
+}
+
+func (cs *CipherSuite) welcomeSecret(joiner, psk []byte) []byte {
+	return cs.deriveSecret(cs.memberSecret(joiner, psk), "welcome")
+}
+
+func (cs *CipherSuite) welcomeKeys(joiner []byte) (key, nonce []byte) {
+	welcome := cs.welcomeSecret(joiner, nil)
+	return cs.expandWithLabel(welcome, "key", nil, nk), cs.expandWithLabel(welcome, "nonce", nil, nn)
+}
+
+func (cs *CipherSuite) epochSecrets(joiner, psk []byte, ctx *GroupContext) *epochSecrets {
+	epoch := cs.expandWithLabel(cs.memberSecret(joiner, psk), "epoch", ctx.bytes(), nh)
+	return &epochSecrets{
+		senderData:     cs.deriveSecret(epoch, "sender data"),
+		encryption:     cs.deriveSecret(epoch, "encryption"),
+		exporter:       cs.deriveSecret(epoch, "exporter"),
+		external:       cs.deriveSecret(epoch, "external"),
+		confirmation:   cs.deriveSecret(epoch, "confirm"),
+		membership:     cs.deriveSecret(epoch, "membership"),
+		resumption:     cs.deriveSecret(epoch, "resumption"),
+		authentication: cs.deriveSecret(epoch, "authentication"),
+		init:           cs.deriveSecret(epoch, "init"),
+	}
+}
+
+// export is MLS-Exporter (section 8.5).
+func (cs *CipherSuite) export(exporterSecret []byte, label string, context []byte, length int) []byte {
+	return cs.expandWithLabel(cs.deriveSecret(exporterSecret, label), "exported", cs.hash(context), length)
+}
+
+// Out-of-order application messages are tolerated up to this many
+// generations ahead of the newest seen.
+const maxSkippedGenerations = 1000
+
+// secretTree hands out per-sender application ratchets derived from the
+// epoch's encryption secret. Node secrets are deleted as soon as their
+// children are derived.
+type secretTree struct {
+	cs       *CipherSuite
+	leaves   uint32
+	secrets  map[uint32][]byte
+	ratchets map[uint32]*ratchet
+}
+
+func newSecretTree(cs *CipherSuite, encryptionSecret []byte, leaves uint32) *secretTree {
+	return &secretTree{
+		cs:       cs,
+		leaves:   leaves,
+		secrets:  map[uint32][]byte{root(leaves): encryptionSecret},
+		ratchets: map[uint32]*ratchet{},
+	}
+}
+
+func (st *secretTree) ratchet(leaf uint32) (*ratchet, error) {
+	if r, ok := st.ratchets[leaf]; ok {
+		return r, nil
+	}
+	leafSecret, err := st.leafSecret(leaf)
+	if err != nil {
+		return nil, err
+	}
+	r := newRatchet(st.cs, leafSecret, "application")
+	st.ratchets[leaf] = r
+	return r, nil
+}
+
+// leafSecret derives the secret of leaf and deletes it from the tree.
+// Groups only use its application ratchet, as handshake messages are
+// sent as PublicMessages.
+func (st *secretTree) leafSecret(leaf uint32) ([]byte, error) {
+	if leaf >= st.leaves {
+		return nil, fmt.Errorf("mls: no sender at leaf %d", leaf)
+	}
+	target := leafNode(leaf)
+	path := append([]uint32{target}, directPath(target, st.leaves)...)
+	for i := len(path) - 1; i > 0; i-- {
+		x := path[i]
+		secret, ok := st.secrets[x]
+		if !ok {
+			continue
+		}
+		st.secrets[left(x)] = st.cs.expandWithLabel(secret, "tree", []byte("left"), nh)
+		st.secrets[right(x)] = st.cs.expandWithLabel(secret, "tree", []byte("right"), nh)
+		delete(st.secrets, x)
+	}
+	leafSecret, ok := st.secrets[target]
+	if !ok {
+		return nil, errors.New("mls: leaf secret already consumed")
+	}
+	delete(st.secrets, target)
+	return leafSecret, nil
+}
+
+// newRatchet starts the "application" or "handshake" ratchet of a leaf.
+func newRatchet(cs *CipherSuite, leafSecret []byte, label string) *ratchet {
+	return &ratchet{cs: cs, secret: cs.expandWithLabel(leafSecret, label, nil, nh), skipped: map[uint32][2][]byte{}}
+}
+
+type ratchet struct {
+	cs         *CipherSuite
+	secret     []byte
+	generation uint32
+	skipped    map[uint32][2][]byte
+}
+
+func (r *ratchet) step() (uint32, []byte, []byte) {
+	var ctx [4]byte
+	binary.BigEndian.PutUint32(ctx[:], r.generation)
+	gen := r.generation
+	key := r.cs.expandWithLabel(r.secret, "key", ctx[:], nk)
+	nonce := r.cs.expandWithLabel(r.secret, "nonce", ctx[:], nn)
+	r.secret = r.cs.expandWithLabel(r.secret, "secret", ctx[:], nh)
+	r.generation++
+	return gen, key, nonce
+}
+
+// get returns the key and nonce for generation, each usable once.
+func (r *ratchet) get(generation uint32) ([]byte, []byte, error) {
+	if generation < r.generation {
+		kn, ok := r.skipped[generation]
+		if !ok {
+			return nil, nil, errors.New("mls: generation already used or expired")
+		}
+		delete(r.skipped, generation)
+		return kn[0], kn[1], nil
+	}
+	if generation-r.generation > maxSkippedGenerations {
+		return nil, nil, errors.New("mls: generation too far ahead")
+	}
+	for r.generation < generation {
+		gen, key, nonce := r.step()
+		r.skipped[gen] = [2][]byte{key, nonce}
+	}
+	_, key, nonce := r.step()
+	return key, nonce, nil
+}
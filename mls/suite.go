@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/hpke"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// CipherSuite bundles the algorithms of an MLS cipher suite. Both
+// supported suites use HKDF-SHA256 and AES-128-GCM and differ in the
+// HPKE KEM and the signature scheme.
+type CipherSuite struct {
+	ID    uint16
+	Name  string
+	kem   hpke.KEM
+	ecdsa bool // ECDSA P-256 signatures instead of Ed25519
+}
+
+var (
+	// MLS_128_DHKEMX25519_AES128GCM_SHA256_Ed25519
+	SuiteX25519Ed25519 = &CipherSuite{ID: 0x0001, Name: "MLS_128_DHKEMX25519_AES128GCM_SHA256_Ed25519", kem: hpke.DHKEM(ecdh.X25519())}
+	// MLS_128_DHKEMP256_AES128GCM_SHA256_P256
+	SuiteP256 = &CipherSuite{ID: 0x0002, Name: "MLS_128_DHKEMP256_AES128GCM_SHA256_P256", kem: hpke.DHKEM(ecdh.P256()), ecdsa: true}
+)
+
+func suiteByID(id uint16) (*CipherSuite, error) {
+	switch id {
+	case SuiteX25519Ed25519.ID:
+		return SuiteX25519Ed25519, nil
+	case SuiteP256.ID:
+		return SuiteP256, nil
+	}
+	return nil, fmt.Errorf("mls: unsupported cipher suite 0x%04x", id)
+}
+
+const (
+	nh = sha256.Size // hash and secret size
+	nk = 16          // AES-128 key size
+	nn = 12          // GCM nonce size
+
+	labelPrefix = "MLS 1.0 "
+)
+
+var errSignature = errors.New("mls: invalid signature")
+
+func (cs *CipherSuite) hash(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+func (cs *CipherSuite) extract(salt, ikm []byte) []byte {
+	prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+	return prk
+}
+
+// ExpandWithLabel is KDF.Expand(secret, KDFLabel, length).
+func (cs *CipherSuite) expandWithLabel(secret []byte, label string, context []byte, length int) []byte {
+	info := encode(func(w *writer) {
+		w.u16(uint16(length))
+		w.opaque([]byte(labelPrefix + label))
+		w.opaque(context)
+	})
+	out, err := hkdf.Expand(sha256.New, secret, string(info), length)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (cs *CipherSuite) deriveSecret(secret []byte, label string) []byte {
+	return cs.expandWithLabel(secret, label, nil, nh)
+}
+
+func (cs *CipherSuite) mac(key, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// refHash computes the hash references used for key packages.
+func (cs *CipherSuite) refHash(label string, value []byte) []byte {
+	return cs.hash(encode(func(w *writer) {
+		w.opaque([]byte(label))
+		w.opaque(value)
+	}))
+}
+
+func (cs *CipherSuite) aead(key []byte) cipher.AEAD {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	gcm, err := cipher.NewGCM(b)
+	if err != nil {
+		panic(err)
+	}
+	return gcm
+}
+
+// SignaturePrivateKey is a member's long-term signing key.
+type SignaturePrivateKey struct {
+	suite  *CipherSuite
+	signer crypto.Signer
+	public []byte
+}
+
+func (cs *CipherSuite) GenerateSignatureKey() (*SignaturePrivateKey, error) {
+	if cs.ecdsa {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := key.PublicKey.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return &SignaturePrivateKey{cs, key, pub}, nil
+	}
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SignaturePrivateKey{cs, key, pub}, nil
+}
+
+// parseSignatureKey reads a private key as the test vectors write it:
+// an Ed25519 seed or a raw P-256 scalar.
+func (cs *CipherSuite) parseSignatureKey(priv []byte) (*SignaturePrivateKey, error) {
+	if cs.ecdsa {
+		key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), priv)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := key.PublicKey.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return &SignaturePrivateKey{cs, key, pub}, nil
+	}
+	if len(priv) != ed25519.SeedSize {
+		return nil, errors.New("mls: Ed25519 private keys are 32-byte seeds")
+	}
+	key := ed25519.NewKeyFromSeed(priv)
+	return &SignaturePrivateKey{cs, key, key.Public().(ed25519.PublicKey)}, nil
+}
+
+func signContent(label string, content []byte) []byte {
+	return encode(func(w *writer) {
+		w.opaque([]byte(labelPrefix + label))
+		w.opaque(content)
+	})
+}
+
+// SignWithLabel signs SignContent; ECDSA signatures are DER encoded.
+func (k *SignaturePrivateKey) signWithLabel(label string, content []byte) []byte {
+	msg := signContent(label, content)
+	var sig []byte
+	var err error
+	if k.suite.ecdsa {
+		digest := sha256.Sum256(msg)
+		sig, err = ecdsa.SignASN1(rand.Reader, k.signer.(*ecdsa.PrivateKey), digest[:])
+	} else {
+		sig = ed25519.Sign(k.signer.(ed25519.PrivateKey), msg)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+func (cs *CipherSuite) verifyWithLabel(public []byte, label string, content, sig []byte) error {
+	msg := signContent(label, content)
+	if cs.ecdsa {
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), public)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(msg)
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return errSignature
+		}
+		return nil
+	}
+	if len(public) != ed25519.PublicKeySize || !ed25519.Verify(public, msg, sig) {
+		return errSignature
+	}
+	return nil
+}
+
+// HPKECiphertext as produced by EncryptWithLabel.
+type HPKECiphertext struct {
+	KEMOutput  []byte
+	Ciphertext []byte
+}
+
+func (c *HPKECiphertext) marshal(w *writer) {
+	w.opaque(c.KEMOutput)
+	w.opaque(c.Ciphertext)
+}
+
+func (c *HPKECiphertext) unmarshal(r *reader) {
+	c.KEMOutput = r.opaque()
+	c.Ciphertext = r.opaque()
+}
+
+func encryptContext(label string, context []byte) []byte {
+	return encode(func(w *writer) {
+		w.opaque([]byte(labelPrefix + label))
+		w.opaque(context)
+	})
+}
+
+func (cs *CipherSuite) encryptWithLabel(public []byte, label string, context, plaintext []byte) (HPKECiphertext, error) {
+	pk, err := cs.kem.NewPublicKey(public)
+	if err != nil {
+		return HPKECiphertext{}, err
+	}
+	enc, sender, err := hpke.NewSender(pk, hpke.HKDFSHA256(), hpke.AES128GCM(), encryptContext(label, context))
+	if err != nil {
+		return HPKECiphertext{}, err
+	}
+	ct, err := sender.Seal(nil, plaintext)
+	if err != nil {
+		return HPKECiphertext{}, err
+	}
+	return HPKECiphertext{KEMOutput: enc, Ciphertext: ct}, nil
+}
+
+func (cs *CipherSuite) decryptWithLabel(priv hpke.PrivateKey, label string, context []byte, ct HPKECiphertext) ([]byte, error) {
+	recipient, err := hpke.NewRecipient(ct.KEMOutput, priv, hpke.HKDFSHA256(), hpke.AES128GCM(), encryptContext(label, context))
+	if err != nil {
+		return nil, err
+	}
+	return recipient.Open(nil, ct.Ciphertext)
+}
+
+// deriveKeyPair turns a node secret into the node's HPKE key pair.
+func (cs *CipherSuite) deriveKeyPair(secret []byte) (hpke.PrivateKey, []byte) {
+	priv, err := cs.kem.DeriveKeyPair(secret)
+	if err != nil {
+		panic(err)
+	}
+	return priv, priv.PublicKey().Bytes()
+}
+
+func (cs *CipherSuite) generateKeyPair() (hpke.PrivateKey, []byte, error) {
+	priv, err := cs.kem.GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, priv.PublicKey().Bytes(), nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
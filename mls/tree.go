@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+// Array-based left-balanced binary tree (RFC 9420 appendix C). Leaf i is
+// node 2i; the tree always holds a power-of-two number of leaves.
+
+func level(x uint32) int { return bits.TrailingZeros32(^x) }
+
+func nodeWidth(leaves uint32) uint32 { return 2*leaves - 1 }
+
+func root(leaves uint32) uint32 {
+	w := nodeWidth(leaves)
+	return 1<<(bits.Len32(w)-1) - 1
+}
+
+func left(x uint32) uint32  { return x ^ 1<<(level(x)-1) }
+func right(x uint32) uint32 { return x ^ 3<<(level(x)-1) }
+
+func parent(x uint32) uint32 {
+	k := level(x)
+	b := (x >> (k + 1)) & 1
+	return (x | 1<<k) ^ b<<(k+1)
+}
+
+func sibling(x uint32) uint32 {
+	p := parent(x)
+	if x < p {
+		return right(p)
+	}
+	return left(p)
+}
+
+func directPath(x, leaves uint32) []uint32 {
+	r := root(leaves)
+	var path []uint32
+	for x != r {
+		x = parent(x)
+		path = append(path, x)
+	}
+	return path
+}
+
+func copath(x, leaves uint32) []uint32 {
+	path := append([]uint32{x}, directPath(x, leaves)...)
+	path = path[:len(path)-1]
+	out := make([]uint32, len(path))
+	for i, n := range path {
+		out[i] = sibling(n)
+	}
+	return out
+}
+
+func leafNode(leaf uint32) uint32 { return 2 * leaf }
+
+func isAncestor(a, x uint32) bool {
+	k := level(a)
+	return x>>(k+1) == a>>(k+1) && x != a && level(x) < k
+}
+
+// Credential is a basic credential: an application-defined identity.
+type Credential struct {
+	Identity []byte
+}
+
+const credentialBasic = 1
+
+func (c *Credential) marshal(w *writer) {
+	w.u16(credentialBasic)
+	w.opaque(c.Identity)
+}
+
+func (c *Credential) unmarshal(r *reader) {
+	if r.u16() != credentialBasic {
+		r.err = errors.New("mls: unsupported credential type")
+	}
+	c.Identity = r.opaque()
+}
+
+// Leaf node sources.
+const (
+	sourceKeyPackage = 1
+	sourceUpdate     = 2
+	sourceCommit     = 3
+)
+
+// Capabilities lists what a member supports. Leaves keep the lists they
+// were received with, so tree hashes match other implementations.
+type Capabilities struct {
+	Versions     []uint16
+	CipherSuites []uint16
+	Extensions   []uint16
+	Proposals    []uint16
+	Credentials  []uint16
+}
+
+func (c *Capabilities) lists() []*[]uint16 {
+	return []*[]uint16{&c.Versions, &c.CipherSuites, &c.Extensions, &c.Proposals, &c.Credentials}
+}
+
+type Extension struct {
+	Type uint16
+	Data []byte
+}
+
+type LeafNode struct {
+	EncryptionKey []byte
+	SignatureKey  []byte
+	Credential    Credential
+	Capabilities  Capabilities
+	Source        uint8
+	NotBefore     uint64 // lifetime, key package leaves only
+	NotAfter      uint64
+	ParentHash    []byte // commit leaves only
+	Extensions    []Extension
+	Signature     []byte
+}
+
+// marshalContent writes everything but the signature.
+func (l *LeafNode) marshalContent(w *writer) {
+	w.opaque(l.EncryptionKey)
+	w.opaque(l.SignatureKey)
+	l.Credential.marshal(w)
+	for _, list := range l.Capabilities.lists() {
+		w.vector(func(w *writer) {
+			for _, v := range *list {
+				w.u16(v)
+			}
+		})
+	}
+	w.u8(l.Source)
+	switch l.Source {
+	case sourceKeyPackage:
+		w.u64(l.NotBefore)
+		w.u64(l.NotAfter)
+	case sourceCommit:
+		w.opaque(l.ParentHash)
+	}
+	w.vector(func(w *writer) {
+		for _, e := range l.Extensions {
+			w.u16(e.Type)
+			w.opaque(e.Data)
+		}
+	})
+}
+
+func (l *LeafNode) marshal(w *writer) {
+	l.marshalContent(w)
+	w.opaque(l.Signature)
+}
+
+func (l *LeafNode) unmarshal(r *reader) {
+	l.EncryptionKey = r.opaque()
+	l.SignatureKey = r.opaque()
+	l.Credential.unmarshal(r)
+	for _, list := range l.Capabilities.lists() {
+		r.vector(func(r *reader) { *list = append(*list, r.u16()) })
+	}
+	l.Source = r.u8()
+	switch l.Source {
+	case sourceKeyPackage:
+		l.NotBefore = r.u64()
+		l.NotAfter = r.u64()
+	case sourceCommit:
+		l.ParentHash = r.opaque()
+	case sourceUpdate:
+	default:
+		r.err = errDecode
+	}
+	r.vector(func(r *reader) { l.Extensions = append(l.Extensions, Extension{r.u16(), r.opaque()}) })
+	l.Signature = r.opaque()
+}
+
+// tbs is LeafNodeTBS: update and commit leaves are bound to the group
+// and their position.
+func (l *LeafNode) tbs(groupID []byte, leaf uint32) []byte {
+	return encode(func(w *writer) {
+		l.marshalContent(w)
+		if l.Source != sourceKeyPackage {
+			w.opaque(groupID)
+			w.u32(leaf)
+		}
+	})
+}
+
+func (l *LeafNode) sign(key *SignaturePrivateKey, groupID []byte, leaf uint32) {
+	l.Signature = key.signWithLabel("LeafNodeTBS", l.tbs(groupID, leaf))
+}
+
+func (l *LeafNode) verify(cs *CipherSuite, groupID []byte, leaf uint32) error {
+	return cs.verifyWithLabel(l.SignatureKey, "LeafNodeTBS", l.tbs(groupID, leaf), l.Signature)
+}
+
+type ParentNode struct {
+	EncryptionKey  []byte
+	ParentHash     []byte
+	UnmergedLeaves []uint32
+}
+
+func (p *ParentNode) marshal(w *writer) {
+	w.opaque(p.EncryptionKey)
+	w.opaque(p.ParentHash)
+	w.vector(func(w *writer) {
+		for _, l := range p.UnmergedLeaves {
+			w.u32(l)
+		}
+	})
+}
+
+func (p *ParentNode) unmarshal(r *reader) {
+	p.EncryptionKey = r.opaque()
+	p.ParentHash = r.opaque()
+	r.vector(func(r *reader) { p.UnmergedLeaves = append(p.UnmergedLeaves, r.u32()) })
+}
+
+// Node is a tree slot; a nil *Node is blank.
+type Node struct {
+	Leaf   *LeafNode
+	Parent *ParentNode
+}
+
+func (n *Node) encryptionKey() []byte {
+	if n.Leaf != nil {
+		return n.Leaf.EncryptionKey
+	}
+	return n.Parent.EncryptionKey
+}
+
+// RatchetTree is the public state of the group's tree.
+type RatchetTree struct {
+	suite *CipherSuite
+	nodes []*Node
+}
+
+func (t *RatchetTree) leaves() uint32 { return uint32(len(t.nodes)+1) / 2 }
+
+func (t *RatchetTree) leaf(i uint32) *LeafNode {
+	if n := t.nodes[leafNode(i)]; n != nil {
+		return n.Leaf
+	}
+	return nil
+}
+
+func (t *RatchetTree) clone() *RatchetTree {
+	c := &RatchetTree{suite: t.suite, nodes: make([]*Node, len(t.nodes))}
+	for i, n := range t.nodes {
+		if n == nil {
+			continue
+		}
+		cp := *n
+		if n.Parent != nil {
+			p := *n.Parent
+			p.UnmergedLeaves = slices.Clone(p.UnmergedLeaves)
+			cp.Parent = &p
+		}
+		c.nodes[i] = &cp
+	}
+	return c
+}
+
+// resolution lists the non-blank nodes covering the subtree at x,
+// skipping any leaf in exclude.
+func (t *RatchetTree) resolution(x uint32, exclude []uint32) []uint32 {
+	n := t.nodes[x]
+	if level(x) == 0 {
+		if n == nil || slices.Contains(exclude, x/2) {
+			return nil
+		}
+		return []uint32{x}
+	}
+	if n == nil {
+		return append(t.resolution(left(x), exclude), t.resolution(right(x), exclude)...)
+	}
+	res := []uint32{x}
+	for _, l := range n.Parent.UnmergedLeaves {
+		if !slices.Contains(exclude, l) {
+			res = append(res, leafNode(l))
+		}
+	}
+	return res
+}
+
+// filteredDirectPath drops the direct path nodes whose copath child has
+// an empty resolution.
+func (t *RatchetTree) filteredDirectPath(leaf uint32) []uint32 {
+	var out []uint32
+	dp := directPath(leafNode(leaf), t.leaves())
+	cp := copath(leafNode(leaf), t.leaves())
+	for i, n := range dp {
+		if len(t.resolution(cp[i], nil)) > 0 {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// addLeaf places a new member in the leftmost blank leaf, growing the
+// tree if it is full, and returns the leaf index.
+func (t *RatchetTree) addLeaf(l *LeafNode) uint32 {
+	i := uint32(0)
+	for ; i < t.leaves(); i++ {
+		if t.nodes[leafNode(i)] == nil {
+			break
+		}
+	}
+	if i == t.leaves() {
+		t.nodes = append(t.nodes, make([]*Node, len(t.nodes)+1)...)
+	}
+	t.nodes[leafNode(i)] = &Node{Leaf: l}
+	for _, p := range directPath(leafNode(i), t.leaves()) {
+		if n := t.nodes[p]; n != nil {
+			n.Parent.UnmergedLeaves = append(n.Parent.UnmergedLeaves, i)
+		}
+	}
+	return i
+}
+
+func (t *RatchetTree) blankPath(leaf uint32) {
+	for _, p := range directPath(leafNode(leaf), t.leaves()) {
+		t.nodes[p] = nil
+	}
+}
+
+func (t *RatchetTree) updateLeaf(leaf uint32, l *LeafNode) {
+	t.nodes[leafNode(leaf)] = &Node{Leaf: l}
+	t.blankPath(leaf)
+}
+
+func (t *RatchetTree) removeLeaf(leaf uint32) {
+	t.nodes[leafNode(leaf)] = nil
+	t.blankPath(leaf)
+	// Halve the tree while its right half is entirely blank.
+	for t.leaves() > 1 {
+		half := len(t.nodes) / 2
+		if slices.ContainsFunc(t.nodes[half:], func(n *Node) bool { return n != nil }) {
+			break
+		}
+		t.nodes = t.nodes[:half]
+	}
+}
+
+func (t *RatchetTree) findLeaf(l *LeafNode) (uint32, bool) {
+	for i := uint32(0); i < t.leaves(); i++ {
+		if x := t.leaf(i); x != nil && bytes.Equal(x.SignatureKey, l.SignatureKey) && bytes.Equal(x.EncryptionKey, l.EncryptionKey) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// treeHash is the hash of the subtree rooted at x.
+func (t *RatchetTree) treeHash(x uint32) []byte { return t.originalTreeHash(x, nil) }
+
+// originalTreeHash is the tree hash of x with the leaves in exclude
+// blanked and dropped from every unmerged leaves list, which is the
+// subtree as it was before those members were added.
+func (t *RatchetTree) originalTreeHash(x uint32, exclude []uint32) []byte {
+	n := t.nodes[x]
+	input := encode(func(w *writer) {
+		if level(x) == 0 {
+			w.u8(1)
+			w.u32(x / 2)
+			w.optional(n != nil && !slices.Contains(exclude, x/2), func(w *writer) { n.Leaf.marshal(w) })
+			return
+		}
+		w.u8(2)
+		w.optional(n != nil, func(w *writer) {
+			p := *n.Parent
+			p.UnmergedLeaves = slices.DeleteFunc(slices.Clone(p.UnmergedLeaves), func(l uint32) bool { return slices.Contains(exclude, l) })
+			p.marshal(w)
+		})
+		w.opaque(t.originalTreeHash(left(x), exclude))
+		w.opaque(t.originalTreeHash(right(x), exclude))
+	})
+	return t.suite.hash(input)
+}
+
+func (t *RatchetTree) rootHash() []byte { return t.treeHash(root(t.leaves())) }
+
+// parentHash is the parent hash of the parent node x over its child s
+// (RFC 9420 section 7.9): it binds x's key and parent hash to the
+// subtree below s as it was when x was last set.
+func (t *RatchetTree) parentHash(x, s uint32) []byte {
+	p := t.nodes[x].Parent
+	return t.suite.hash(encode(func(w *writer) {
+		w.opaque(p.EncryptionKey)
+		w.opaque(p.ParentHash)
+		w.opaque(t.originalTreeHash(s, p.UnmergedLeaves))
+	}))
+}
+
+// setParentHashes fills in the parent hashes along leaf's filtered direct
+// path from the root down, once an UpdatePath has been merged, and
+// returns the parent hash the leaf itself must carry.
+func (t *RatchetTree) setParentHashes(leaf uint32) []byte {
+	fdp := t.filteredDirectPath(leaf)
+	ph := []byte{}
+	for i := len(fdp) - 1; i >= 0; i-- {
+		x := fdp[i]
+		t.nodes[x].Parent.ParentHash = ph
+		ph = t.parentHash(x, copathChild(x, leaf))
+	}
+	return ph
+}
+
+// verifyParentHashes checks that every non-blank parent node is
+// parent-hash valid (RFC 9420 section 7.9.2). Following the chain from
+// any parent down ends at a commit leaf, whose parent hash is signed.
+func (t *RatchetTree) verifyParentHashes() error {
+	for i, n := range t.nodes {
+		x := uint32(i)
+		if n != nil && level(x) > 0 && !t.parentHashValid(x) {
+			return fmt.Errorf("mls: parent node %d is not parent-hash valid", x)
+		}
+	}
+	return nil
+}
+
+// parentHashValid reports whether one of x's children c resolves to a
+// single node D carrying x's parent hash over c's sibling, plus exactly
+// those of x's unmerged leaves that sit below c.
+func (t *RatchetTree) parentHashValid(x uint32) bool {
+	unmerged := t.nodes[x].Parent.UnmergedLeaves
+	for _, c := range []uint32{left(x), right(x)} {
+		res := t.resolution(c, nil)
+		below := 0
+		for _, l := range unmerged {
+			if leafNode(l) == c || isAncestor(c, leafNode(l)) {
+				below++
+			}
+		}
+		d := slices.DeleteFunc(slices.Clone(res), func(y uint32) bool {
+			return level(y) == 0 && slices.Contains(unmerged, y/2)
+		})
+		if len(d) != 1 || len(res) != below+1 {
+			continue
+		}
+		var ph []byte
+		switch n := t.nodes[d[0]]; {
+		case n.Parent != nil:
+			ph = n.Parent.ParentHash
+		case n.Leaf.Source == sourceCommit:
+			ph = n.Leaf.ParentHash
+		default:
+			continue
+		}
+		if bytes.Equal(ph, t.parentHash(x, sibling(c))) {
+			return true
+		}
+	}
+	return false
+}
+
+// marshal writes the ratchet_tree extension form: optional<Node> per slot,
+// with trailing blanks omitted.
+func (t *RatchetTree) marshal(w *writer) {
+	last := len(t.nodes) - 1
+	for last > 0 && t.nodes[last] == nil {
+		last--
+	}
+	w.vector(func(w *writer) {
+		for _, n := range t.nodes[:last+1] {
+			w.optional(n != nil, func(w *writer) {
+				if n.Leaf != nil {
+					w.u8(1)
+					n.Leaf.marshal(w)
+				} else {
+					w.u8(2)
+					n.Parent.marshal(w)
+				}
+			})
+		}
+	})
+}
+
+func (t *RatchetTree) unmarshal(r *reader) {
+	t.nodes = nil
+	r.vector(func(r *reader) {
+		var n *Node
+		r.optional(func(r *reader) {
+			n = &Node{}
+			switch r.u8() {
+			case 1:
+				n.Leaf = &LeafNode{}
+				n.Leaf.unmarshal(r)
+			case 2:
+				n.Parent = &ParentNode{}
+				n.Parent.unmarshal(r)
+			default:
+				r.err = errDecode
+			}
+		})
+		t.nodes = append(t.nodes, n)
+	})
+	// Pad back out to a full tree.
+	leaves := uint32(1)
+	for nodeWidth(leaves) < uint32(len(t.nodes)) {
+		leaves *= 2
+	}
+	t.nodes = append(t.nodes, make([]*Node, int(nodeWidth(leaves))-len(t.nodes))...)
+	for i, n := range t.nodes {
+		if n != nil && (n.Leaf != nil) != (level(uint32(i)) == 0) {
+			r.err = errors.New("mls: node type does not match its position")
+		}
+	}
+}
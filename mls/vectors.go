@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Runners for the JSON test vectors of github.com/mlswg/mls-implementations
+// (test-vectors/). Each file is a list of vectors, one or more per cipher
+// suite; vectors for suites this package does not implement are skipped.
+
+// vectorRunners maps each supported file, without .json, to its runner.
+var vectorRunners = map[string]func(path string) (checked, skipped int, err error){
+	"crypto-basics":   runVectors[cryptoBasicsVector],
+	"key-schedule":    runVectors[keyScheduleVector],
+	"secret-tree":     runVectors[secretTreeVector],
+	"tree-validation": runVectors[treeValidationVector],
+}
+
+// hexBytes is binary data, written as a hex string in the vectors.
+type hexBytes []byte
+
+func (h *hexBytes) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := hex.DecodeString(s)
+	*h = v
+	return err
+}
+
+type vectorSuite struct {
+	CipherSuite uint16 `json:"cipher_suite"`
+}
+
+func (v vectorSuite) suiteID() uint16 { return v.CipherSuite }
+
+type vector interface {
+	suiteID() uint16
+	check(cs *CipherSuite) error
+}
+
+// runVectors checks every vector in path for a supported cipher suite.
+func runVectors[T any, V interface {
+	*T
+	vector
+}](path string) (checked, skipped int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var vectors []T
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		return 0, 0, err
+	}
+	for i := range vectors {
+		v := V(&vectors[i])
+		cs, err := suiteByID(v.suiteID())
+		if err != nil {
+			skipped++
+			continue
+		}
+		if err := v.check(cs); err != nil {
+			return checked, skipped, fmt.Errorf("vector %d: %w", i, err)
+		}
+		checked++
+	}
+	return checked, skipped, nil
+}
+
+// A field is a computed value and the vector's value for it.
+type field struct {
+	name string
+	got  []byte
+	want hexBytes
+}
+
+// compare reports the first field whose values differ.
+func compare(fields ...field) error {
+	for _, f := range fields {
+		if !bytes.Equal(f.got, f.want) {
+			return fmt.Errorf("%s mismatch", f.name)
+		}
+	}
+	return nil
+}
+
+// cryptoBasicsVector is one entry of crypto-basics.json.
+type cryptoBasicsVector struct {
+	vectorSuite
+	RefHash struct {
+		Label      string
+		Value, Out hexBytes
+	} `json:"ref_hash"`
+	ExpandWithLabel struct {
+		Secret  hexBytes
+		Label   string
+		Context hexBytes
+		Length  uint16
+		Out     hexBytes
+	} `json:"expand_with_label"`
+	DeriveSecret struct {
+		Secret hexBytes
+		Label  string
+		Out    hexBytes
+	} `json:"derive_secret"`
+	DeriveTreeSecret struct {
+		Secret     hexBytes
+		Label      string
+		Generation uint32
+		Length     uint16
+		Out        hexBytes
+	} `json:"derive_tree_secret"`
+	SignWithLabel struct {
+		Priv, Pub, Content hexBytes
+		Label              string
+		Signature          hexBytes
+	} `json:"sign_with_label"`
+	EncryptWithLabel struct {
+		Priv, Pub  hexBytes
+		Label      string
+		Context    hexBytes
+		Plaintext  hexBytes
+		KEMOutput  hexBytes `json:"kem_output"`
+		Ciphertext hexBytes
+	} `json:"encrypt_with_label"`
+}
+
+// check recomputes the deterministic values. Signatures and HPKE
+// ciphertexts are randomized, so the vector's are verified and opened,
+// and fresh ones are made from its keys and checked the same way.
+func (v *cryptoBasicsVector) check(cs *CipherSuite) error {
+	e, d, t := v.ExpandWithLabel, v.DeriveSecret, v.DeriveTreeSecret
+	err := compare(
+		field{"ref_hash", cs.refHash(v.RefHash.Label, v.RefHash.Value), v.RefHash.Out},
+		field{"expand_with_label", cs.expandWithLabel(e.Secret, e.Label, e.Context, int(e.Length)), e.Out},
+		field{"derive_secret", cs.deriveSecret(d.Secret, d.Label), d.Out},
+		field{"derive_tree_secret", cs.expandWithLabel(t.Secret, t.Label, binary.BigEndian.AppendUint32(nil, t.Generation), int(t.Length)), t.Out},
+	)
+	if err != nil {
+		return err
+	}
+
+	s := v.SignWithLabel
+	if err := cs.verifyWithLabel(s.Pub, s.Label, s.Content, s.Signature); err != nil {
+		return fmt.Errorf("sign_with_label: %w", err)
+	}
+	sigKey, err := cs.parseSignatureKey(s.Priv)
+	if err != nil {
+		return fmt.Errorf("sign_with_label: %w", err)
+	}
+	if !bytes.Equal(sigKey.public, s.Pub) {
+		return errors.New("sign_with_label: public key mismatch")
+	}
+	if err := cs.verifyWithLabel(s.Pub, s.Label, s.Content, sigKey.signWithLabel(s.Label, s.Content)); err != nil {
+		return fmt.Errorf("sign_with_label: own signature: %w", err)
+	}
+
+	x := v.EncryptWithLabel
+	priv, err := cs.kem.NewPrivateKey(x.Priv)
+	if err != nil {
+		return fmt.Errorf("encrypt_with_label: %w", err)
+	}
+	if !bytes.Equal(priv.PublicKey().Bytes(), x.Pub) {
+		return errors.New("encrypt_with_label: public key mismatch")
+	}
+	pt, err := cs.decryptWithLabel(priv, x.Label, x.Context, HPKECiphertext{KEMOutput: x.KEMOutput, Ciphertext: x.Ciphertext})
+	if err != nil {
+		return fmt.Errorf("encrypt_with_label: %w", err)
+	}
+	if !bytes.Equal(pt, x.Plaintext) {
+		return errors.New("encrypt_with_label: plaintext mismatch")
+	}
+	ct, err := cs.encryptWithLabel(x.Pub, x.Label, x.Context, x.Plaintext)
+	if err != nil {
+		return fmt.Errorf("encrypt_with_label: %w", err)
+	}
+	if pt, err = cs.decryptWithLabel(priv, x.Label, x.Context, ct); err != nil || !bytes.Equal(pt, x.Plaintext) {
+		return fmt.Errorf("encrypt_with_label: own ciphertext: %v", err)
+	}
+	return nil
+}
+
+// keyScheduleVector is one entry of key-schedule.json: a chain of
+// epochs, each starting from the previous epoch's init secret.
+type keyScheduleVector struct {
+	vectorSuite
+	GroupID           hexBytes `json:"group_id"`
+	InitialInitSecret hexBytes `json:"initial_init_secret"`
+	Epochs            []struct {
+		TreeHash                hexBytes `json:"tree_hash"`
+		CommitSecret            hexBytes `json:"commit_secret"`
+		PSKSecret               hexBytes `json:"psk_secret"`
+		ConfirmedTranscriptHash hexBytes `json:"confirmed_transcript_hash"`
+
+		GroupContext       hexBytes `json:"group_context"`
+		JoinerSecret       hexBytes `json:"joiner_secret"`
+		WelcomeSecret      hexBytes `json:"welcome_secret"`
+		InitSecret         hexBytes `json:"init_secret"`
+		SenderDataSecret   hexBytes `json:"sender_data_secret"`
+		EncryptionSecret   hexBytes `json:"encryption_secret"`
+		ExporterSecret     hexBytes `json:"exporter_secret"`
+		EpochAuthenticator hexBytes `json:"epoch_authenticator"`
+		ExternalSecret     hexBytes `json:"external_secret"`
+		ConfirmationKey    hexBytes `json:"confirmation_key"`
+		MembershipKey      hexBytes `json:"membership_key"`
+		ResumptionPSK      hexBytes `json:"resumption_psk"`
+		ExternalPub        hexBytes `json:"external_pub"`
+		Exporter           struct {
+			Label, Context hexBytes
+			Length         uint32
+			Secret         hexBytes
+		}
+	}
+}
+
+func (v *keyScheduleVector) check(cs *CipherSuite) error {
+	initSecret := []byte(v.InitialInitSecret)
+	for i, e := range v.Epochs {
+		ctx := &GroupContext{
+			Suite:                   cs,
+			GroupID:                 v.GroupID,
+			Epoch:                   uint64(i),
+			TreeHash:                e.TreeHash,
+			ConfirmedTranscriptHash: e.ConfirmedTranscriptHash,
+		}
+		joiner := cs.joinerSecret(initSecret, e.CommitSecret, ctx)
+		s := cs.epochSecrets(joiner, e.PSKSecret, ctx)
+		_, externalPub := cs.deriveKeyPair(s.external)
+		err := compare(
+			field{"group_context", ctx.bytes(), e.GroupContext},
+			field{"joiner_secret", joiner, e.JoinerSecret},
+			field{"welcome_secret", cs.welcomeSecret(joiner, e.PSKSecret), e.WelcomeSecret},
+			field{"init_secret", s.init, e.InitSecret},
+			field{"sender_data_secret", s.senderData, e.SenderDataSecret},
+			field{"encryption_secret", s.encryption, e.EncryptionSecret},
+			field{"exporter_secret", s.exporter, e.ExporterSecret},
+			field{"epoch_authenticator", s.authentication, e.EpochAuthenticator},
+			field{"external_secret", s.external, e.ExternalSecret},
+			field{"confirmation_key", s.confirmation, e.ConfirmationKey},
+			field{"membership_key", s.membership, e.MembershipKey},
+			field{"resumption_psk", s.resumption, e.ResumptionPSK},
+			field{"external_pub", externalPub, e.ExternalPub},
+			field{"exporter.secret", cs.export(s.exporter, string(e.Exporter.Label), e.Exporter.Context, int(e.Exporter.Length)), e.Exporter.Secret},
+		)
+		if err != nil {
+			return fmt.Errorf("epoch %d: %w", i, err)
+		}
+		initSecret = s.init
+	}
+	return nil
+}
+
+// secretTreeVector is one entry of secret-tree.json: the sender data
+// keys for one ciphertext, and the handshake and application keys of
+// some generations of every leaf.
+type secretTreeVector struct {
+	vectorSuite
+	SenderData struct {
+		SenderDataSecret hexBytes `json:"sender_data_secret"`
+		Ciphertext       hexBytes
+		Key, Nonce       hexBytes
+	} `json:"sender_data"`
+	EncryptionSecret hexBytes `json:"encryption_secret"`
+	Leaves           [][]struct {
+		Generation       uint32
+		HandshakeKey     hexBytes `json:"handshake_key"`
+		HandshakeNonce   hexBytes `json:"handshake_nonce"`
+		ApplicationKey   hexBytes `json:"application_key"`
+		ApplicationNonce hexBytes `json:"application_nonce"`
+	}
+}
+
+func (v *secretTreeVector) check(cs *CipherSuite) error {
+	sd := v.SenderData
+	key, nonce := cs.senderDataKeys(sd.SenderDataSecret, sd.Ciphertext)
+	if err := compare(field{"sender_data.key", key, sd.Key}, field{"sender_data.nonce", nonce, sd.Nonce}); err != nil {
+		return err
+	}
+	st := newSecretTree(cs, v.EncryptionSecret, uint32(len(v.Leaves)))
+	for leaf, generations := range v.Leaves {
+		leafSecret, err := st.leafSecret(uint32(leaf))
+		if err != nil {
+			return err
+		}
+		handshake := newRatchet(cs, leafSecret, "handshake")
+		application := newRatchet(cs, leafSecret, "application")
+		for _, g := range generations {
+			// Step rather than get: the vectors may skip further ahead
+			// than a group tolerates.
+			hsKey, hsNonce := handshake.at(g.Generation)
+			appKey, appNonce := application.at(g.Generation)
+			err := compare(
+				field{"handshake_key", hsKey, g.HandshakeKey},
+				field{"handshake_nonce", hsNonce, g.HandshakeNonce},
+				field{"application_key", appKey, g.ApplicationKey},
+				field{"application_nonce", appNonce, g.ApplicationNonce},
+			)
+			if err != nil {
+				return fmt.Errorf("leaf %d generation %d: %w", leaf, g.Generation, err)
+			}
+		}
+	}
+	return nil
+}
+
+// at steps the ratchet to generation, which must not be behind it.
+func (r *ratchet) at(generation uint32) (key, nonce []byte) {
+	for r.generation < generation {
+		r.step()
+	}
+	_, key, nonce = r.step()
+	return key, nonce
+}
+
+// treeValidationVector is one entry of tree-validation.json.
+type treeValidationVector struct {
+	vectorSuite
+	Tree        hexBytes
+	GroupID     hexBytes   `json:"group_id"`
+	Resolutions [][]uint32 `json:"resolutions"`
+	TreeHashes  []hexBytes `json:"tree_hashes"`
+}
+
+// check verifies the resolution and tree hash of each node, parent-hash
+// validity and the leaf signatures.
+func (v *treeValidationVector) check(cs *CipherSuite) error {
+	tree := &RatchetTree{suite: cs}
+	if err := decode(v.Tree, tree.unmarshal); err != nil {
+		return err
+	}
+	if len(v.TreeHashes) > len(tree.nodes) || len(v.Resolutions) > len(tree.nodes) {
+		return fmt.Errorf("vector covers %d nodes, tree has %d", len(v.TreeHashes), len(tree.nodes))
+	}
+	for x, want := range v.Resolutions {
+		if got := tree.resolution(uint32(x), nil); !slices.Equal(got, want) {
+			return fmt.Errorf("node %d: resolution %v, want %v", x, got, want)
+		}
+	}
+	for x, want := range v.TreeHashes {
+		if !bytes.Equal(tree.treeHash(uint32(x)), want) {
+			return fmt.Errorf("node %d: tree hash mismatch", x)
+		}
+	}
+	if err := tree.verifyParentHashes(); err != nil {
+		return err
+	}
+	return verifyLeaves(tree, v.GroupID)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The vectors are the files of the same name in test-vectors/ of
+// github.com/mlswg/mls-implementations. They are read from testdata, or
+// from the directory named by $MLS_TEST_VECTORS, such as a checkout's
+// test-vectors. A test whose file is missing is skipped.
+
+func runVectorFile(t *testing.T, name string) {
+	dir := os.Getenv("MLS_TEST_VECTORS")
+	if dir == "" {
+		dir = "testdata"
+	}
+	path := filepath.Join(dir, name+".json")
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("%s is missing: copy it from mls-implementations/test-vectors", path)
+	}
+	checked, skipped, err := vectorRunners[name](path)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	if checked == 0 {
+		t.Fatalf("%s: no vectors for a supported cipher suite (%d skipped)", path, skipped)
+	}
+	t.Logf("%s: %d vectors passed, %d skipped", path, checked, skipped)
+}
+
+func TestCryptoBasics(t *testing.T)   { runVectorFile(t, "crypto-basics") }
+func TestKeySchedule(t *testing.T)    { runVectorFile(t, "key-schedule") }
+func TestSecretTree(t *testing.T)     { runVectorFile(t, "secret-tree") }
+func TestTreeValidation(t *testing.T) { runVectorFile(t, "tree-validation") }
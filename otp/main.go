@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: otp [flags] <command> [flags] [args]
+
+commands:
+  enroll <account>              create a secret and print its otpauth:// URI
+  uri <account>                 print the URI of an enrolled account again
+  verify <account> <code>       check and consume a code
+  resync <account> <c1> <c2>    realign an HOTP counter from two codes
+  code <uri>                    print the current code, as an authenticator would
+
+Secrets in the -vault file are encrypted with AES-256-GCM under the hex
+key in $CRYPTO_OTP_KEY. Without a command, a demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	vaultPath := flag.String("vault", "otp-vault.json", "enrollment file")
+	issuer := flag.String("issuer", "Admin Tools", "issuer shown in authenticator apps")
+	typ := flag.String("type", TypeTOTP, "totp or hotp")
+	alg := flag.String("alg", SHA1, "HMAC algorithm: SHA1, SHA256 or SHA512")
+	digits := flag.Int("digits", 6, "code length")
+	period := flag.Int("period", 30, "TOTP period in seconds")
+	skew := flag.Int("skew", 1, "TOTP steps accepted either side of the current one")
+	lookAhead := flag.Int("lookahead", 10, "HOTP counters accepted past the expected one")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	args := flag.Args()
+	if cmd == "code" {
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		e, secret, err := ParseURI(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if e.Type == TypeHOTP {
+			fmt.Println(HOTP(secret, e.Counter, e.Params))
+		} else {
+			fmt.Println(TOTP(secret, time.Now(), e.Params))
+		}
+		return
+	}
+
+	key, err := hex.DecodeString(os.Getenv("CRYPTO_OTP_KEY"))
+	if err != nil {
+		log.Fatal("CRYPTO_OTP_KEY: ", err)
+	}
+	v, err := OpenVault(*vaultPath, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	v.Skew, v.LookAhead = *skew, *lookAhead
+	need := map[string]int{"enroll": 1, "uri": 1, "verify": 2, "resync": 3}
+	if n, ok := need[cmd]; !ok || len(args) != n {
+		usage()
+		os.Exit(2)
+	}
+	switch cmd {
+	case "enroll":
+		uri, err := v.Enroll(args[0], *issuer, *typ, Params{Algorithm: strings.ToUpper(*alg), Digits: *digits, Period: *period})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(uri)
+	case "uri":
+		uri, err := v.URI(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(uri)
+	case "verify":
+		if err := v.Verify(args[0], args[1]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("ok")
+	case "resync":
+		if err := v.Resync(args[0], args[1], args[2], 1000); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("ok")
+	}
+}
+
+func demo() {
+	// RFC 4226 appendix D.
+	secret := []byte("12345678901234567890")
+	hotp := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	ok := true
+	for i, want := range hotp {
+		ok = ok && HOTP(secret, uint64(i), Params{}) == want
+	}
+	fmt.Println("HOTP matches RFC 4226 appendix D:", ok)
+
+	// RFC 6238 appendix B.
+	seeds := map[string][]byte{
+		SHA1:   secret,
+		SHA256: []byte("12345678901234567890123456789012"),
+		SHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	vectors := []struct {
+		t    int64
+		want map[string]string
+	}{
+		{59, map[string]string{SHA1: "94287082", SHA256: "46119246", SHA512: "90693936"}},
+		{1111111109, map[string]string{SHA1: "07081804", SHA256: "68084774", SHA512: "25091201"}},
+		{1111111111, map[string]string{SHA1: "14050471", SHA256: "67062674", SHA512: "99943326"}},
+		{1234567890, map[string]string{SHA1: "89005924", SHA256: "91819424", SHA512: "93441116"}},
+		{2000000000, map[string]string{SHA1: "69279037", SHA256: "90698825", SHA512: "38618901"}},
+		{20000000000, map[string]string{SHA1: "65353130", SHA256: "77737706", SHA512: "47863826"}},
+	}
+	ok = true
+	for _, tv := range vectors {
+		for alg, want := range tv.want {
+			ok = ok && TOTP(seeds[alg], time.Unix(tv.t, 0), Params{Algorithm: alg, Digits: 8}) == want
+		}
+	}
+	fmt.Println("TOTP matches RFC 6238 appendix B:", ok)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	v, err := NewVault(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Unix(1_800_000_000, 0)
+	v.now = func() time.Time { return now }
+
+	uri, err := v.Enroll("alice@example.com", "Admin Tools", TypeTOTP, Params{Algorithm: SHA256, Digits: 8})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("\nenroll:", uri)
+	app, appSecret, err := ParseURI(uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(v.accounts["alice@example.com"].Secret)
+	fmt.Println("stored secret is encrypted:", !bytes.Contains(sealed, appSecret))
+
+	check := func(label, account, code string) {
+		err := v.Verify(account, code)
+		if err == nil {
+			fmt.Printf("%-30s ok\n", label+":")
+			return
+		}
+		fmt.Printf("%-30s %v\n", label+":", err)
+	}
+	code := TOTP(appSecret, now, app.Params)
+	check("current code", "alice@example.com", code)
+	check("same code again", "alice@example.com", code)
+	now = now.Add(30 * time.Second)
+	check("previous code, 30s later", "alice@example.com", TOTP(appSecret, now.Add(-30*time.Second), app.Params))
+	now = now.Add(30 * time.Second)
+	check("phone clock 30s slow", "alice@example.com", TOTP(appSecret, now.Add(-30*time.Second), app.Params))
+	check("phone clock 2 min fast", "alice@example.com", TOTP(appSecret, now.Add(2*time.Minute), app.Params))
+	check("wrong code", "alice@example.com", "00000000")
+
+	// A record copied to another account does not decrypt there.
+	stolen := *v.accounts["alice@example.com"]
+	stolen.Account = "mallory@example.com"
+	v.accounts[stolen.Account] = &stolen
+	check("secret moved to other account", "mallory@example.com", code)
+
+	uri, err = v.Enroll("bob@example.com", "Admin Tools", TypeHOTP, Params{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("\nenroll:", uri)
+	_, bobSecret, _ := ParseURI(uri)
+	check("HOTP counter 0", "bob@example.com", HOTP(bobSecret, 0, Params{}))
+	check("HOTP counter 0 again", "bob@example.com", HOTP(bobSecret, 0, Params{}))
+	check("HOTP counter 5 (skipped 4)", "bob@example.com", HOTP(bobSecret, 5, Params{}))
+	check("HOTP counter 40", "bob@example.com", HOTP(bobSecret, 40, Params{}))
+	if err := v.Resync("bob@example.com", HOTP(bobSecret, 40, Params{}), HOTP(bobSecret, 41, Params{}), 100); err != nil {
+		log.Fatal(err)
+	}
+	check("HOTP counter 42 after resync", "bob@example.com", HOTP(bobSecret, 42, Params{}))
+	if err := v.Verify("carol@example.com", "123456"); !errors.Is(err, ErrUnknownAccount) {
+		log.Fatal("unknown account accepted")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// URI formats the credential in the otpauth:// Key URI format understood
+// by authenticator apps:
+//
+//	otpauth://totp/Issuer:account?secret=...&issuer=Issuer&algorithm=SHA1&digits=6&period=30
+func (e *Enrollment) URI(secret []byte) string {
+	label := e.Account
+	if e.Issuer != "" {
+		label = e.Issuer + ":" + e.Account
+	}
+	q := url.Values{}
+	q.Set("secret", b32.EncodeToString(secret))
+	if e.Issuer != "" {
+		q.Set("issuer", e.Issuer)
+	}
+	p := e.withDefaults()
+	q.Set("algorithm", p.Algorithm)
+	q.Set("digits", strconv.Itoa(p.Digits))
+	if e.Type == TypeHOTP {
+		q.Set("counter", strconv.FormatUint(e.Counter, 10))
+	} else {
+		q.Set("period", strconv.Itoa(p.Period))
+	}
+	u := url.URL{Scheme: "otpauth", Host: e.Type, Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// ParseURI reads an otpauth:// URI, returning the credential and its
+// secret. It is what an authenticator does with the enrollment QR code.
+func ParseURI(s string) (*Enrollment, []byte, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "otpauth" || (u.Host != TypeTOTP && u.Host != TypeHOTP) {
+		return nil, nil, errors.New("not an otpauth://totp or otpauth://hotp URI")
+	}
+	e := &Enrollment{Type: u.Host}
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		e.Issuer, e.Account = issuer, strings.TrimSpace(account)
+	} else {
+		e.Account = label
+	}
+	q := u.Query()
+	if v := q.Get("issuer"); v != "" {
+		e.Issuer = v
+	}
+	secret, err := b32.DecodeString(strings.ToUpper(strings.TrimRight(q.Get("secret"), "=")))
+	if err != nil || len(secret) == 0 {
+		return nil, nil, errors.New("otpauth URI has no valid secret")
+	}
+	e.Algorithm = q.Get("algorithm")
+	for _, f := range []struct {
+		name string
+		dst  *int
+	}{{"digits", &e.Digits}, {"period", &e.Period}} {
+		if v := q.Get(f.name); v != "" {
+			if *f.dst, err = strconv.Atoi(v); err != nil {
+				return nil, nil, fmt.Errorf("otpauth URI: bad %s", f.name)
+			}
+		}
+	}
+	if v := q.Get("counter"); v != "" {
+		if e.Counter, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, nil, errors.New("otpauth URI: bad counter")
+		}
+	}
+	if err := e.check(); err != nil {
+		return nil, nil, err
+	}
+	e.Params = e.withDefaults()
+	return e, secret, nil
+}
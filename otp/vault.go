@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TypeTOTP = "totp"
+	TypeHOTP = "hotp"
+)
+
+var (
+	ErrUnknownAccount = errors.New("no OTP enrolled for account")
+	ErrInvalidCode    = errors.New("invalid one-time password")
+	ErrReplayed       = errors.New("one-time password was already used")
+)
+
+// Enrollment is a stored OTP credential. The shared secret is sealed
+// with AES-256-GCM under the vault key as nonce||ciphertext, like aes/,
+// with the account name as additional data so secrets cannot be swapped
+// between records.
+type Enrollment struct {
+	Account string `json:"account"`
+	Issuer  string `json:"issuer"`
+	Type    string `json:"type"`
+	Params
+	Secret string `json:"secret"`
+	// Counter is the next acceptable HOTP counter.
+	Counter uint64 `json:"counter,omitempty"`
+	// LastStep is the last accepted TOTP time step; codes for it or
+	// earlier steps are rejected as replays.
+	LastStep uint64 `json:"last_step,omitempty"`
+	// Drift is the clock offset in steps observed on the last success,
+	// used to center the next verification window.
+	Drift int64 `json:"drift,omitempty"`
+}
+
+// Vault holds enrollments and verifies codes against them. Skew is the
+// number of TOTP steps accepted either side of the expected one and
+// LookAhead the number of HOTP counters tried past the expected one.
+type Vault struct {
+	Path      string
+	Skew      int
+	LookAhead int
+	aead      cipher.AEAD
+	mu        sync.Mutex
+	accounts  map[string]*Enrollment
+	now       func() time.Time
+}
+
+func NewVault(key []byte) (*Vault, error) {
+	if len(key) != 32 {
+		return nil, errors.New("vault key must be 32 bytes")
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{Skew: 1, LookAhead: 10, aead: gcm, accounts: map[string]*Enrollment{}, now: time.Now}, nil
+}
+
+// OpenVault loads the enrollments at path, starting empty if the file
+// does not exist.
+func OpenVault(path string, key []byte) (*Vault, error) {
+	v, err := NewVault(key)
+	if err != nil {
+		return nil, err
+	}
+	v.Path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Enrollment
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		v.accounts[e.Account] = e
+	}
+	return v, nil
+}
+
+func (v *Vault) save() error {
+	if v.Path == "" {
+		return nil
+	}
+	list := make([]*Enrollment, 0, len(v.accounts))
+	for _, e := range v.accounts {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Account < list[j].Account })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := v.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, v.Path)
+}
+
+func (v *Vault) seal(account string, secret []byte) (string, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(v.aead.Seal(nonce, nonce, secret, []byte(account))), nil
+}
+
+func (v *Vault) open(e *Enrollment) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(e.Secret)
+	if err != nil || len(data) < v.aead.NonceSize() {
+		return nil, fmt.Errorf("%s: corrupt secret", e.Account)
+	}
+	secret, err := v.aead.Open(nil, data[:v.aead.NonceSize()], data[v.aead.NonceSize():], []byte(e.Account))
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot decrypt secret: %w", e.Account, err)
+	}
+	return secret, nil
+}
+
+// Enroll creates a credential with a new random secret, replacing any
+// existing one for account, and returns the otpauth:// URI to hand to
+// the user's authenticator.
+func (v *Vault) Enroll(account, issuer, typ string, p Params) (string, error) {
+	if typ != TypeTOTP && typ != TypeHOTP {
+		return "", fmt.Errorf("unknown OTP type %q", typ)
+	}
+	if err := p.check(); err != nil {
+		return "", err
+	}
+	p = p.withDefaults()
+	// RFC 4226 recommends 160 bits; use the hash size for the others.
+	secret := make([]byte, otpHashes[p.Algorithm]().Size())
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	sealed, err := v.seal(account, secret)
+	if err != nil {
+		return "", err
+	}
+	e := &Enrollment{Account: account, Issuer: issuer, Type: typ, Params: p, Secret: sealed}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.accounts[account] = e
+	if err := v.save(); err != nil {
+		return "", err
+	}
+	return e.URI(secret), nil
+}
+
+// Verify checks code for account and, on success, consumes it.
+func (v *Vault) Verify(account, code string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.accounts[account]
+	if !ok {
+		return ErrUnknownAccount
+	}
+	secret, err := v.open(e)
+	if err != nil {
+		return err
+	}
+	if len(code) != e.Digits {
+		return ErrInvalidCode
+	}
+	if e.Type == TypeHOTP {
+		c, ok := match(secret, code, e.Counter, uint64(v.LookAhead)+1, e.Params)
+		if !ok {
+			return ErrInvalidCode
+		}
+		e.Counter = c + 1
+		return v.save()
+	}
+
+	now := int64(e.Step(v.now()))
+	lo := max(now+e.Drift-int64(v.Skew), 0)
+	hi := now + e.Drift + int64(v.Skew)
+	from := max(lo, int64(e.LastStep)+1)
+	if from <= hi {
+		if step, ok := match(secret, code, uint64(from), uint64(hi-from+1), e.Params); ok {
+			e.LastStep = step
+			e.Drift = int64(step) - now
+			return v.save()
+		}
+	}
+	if from > lo {
+		if _, ok := match(secret, code, uint64(lo), uint64(from-lo), e.Params); ok {
+			return ErrReplayed
+		}
+	}
+	return ErrInvalidCode
+}
+
+// Resync realigns an HOTP counter from two consecutive codes, searching
+// further ahead than Verify does, for tokens pressed many times offline.
+func (v *Vault) Resync(account, code1, code2 string, window uint64) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.accounts[account]
+	if !ok {
+		return ErrUnknownAccount
+	}
+	if e.Type != TypeHOTP {
+		return fmt.Errorf("%s: resync applies to HOTP only", account)
+	}
+	secret, err := v.open(e)
+	if err != nil {
+		return err
+	}
+	for c := e.Counter; c < e.Counter+window; c++ {
+		if _, ok := match(secret, code1, c, 1, e.Params); !ok {
+			continue
+		}
+		if _, ok := match(secret, code2, c+1, 1, e.Params); ok {
+			e.Counter = c + 2
+			return v.save()
+		}
+	}
+	return ErrInvalidCode
+}
+
+// URI returns the stored otpauth:// URI; it contains the secret.
+func (v *Vault) URI(account string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.accounts[account]
+	if !ok {
+		return "", ErrUnknownAccount
+	}
+	secret, err := v.open(e)
+	if err != nil {
+		return "", err
+	}
+	return e.URI(secret), nil
+}
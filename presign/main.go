@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// Keys are PEM files in the keys/ format: an SEC1 EC key in a
+// "PRIVATE KEY" block and a PKIX "PUBLIC KEY" block.
+
+func loadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func loadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != elliptic.P256() {
+		return nil, errors.New("key is not an ECDSA P-256 key")
+	}
+	return publicKey, nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: presign [flags] <command> [flags]
+
+commands:
+  sign    print a presigned version of -url
+  serve   serve -dir on -listen, for presigned requests only
+
+URLs are signed with the ECDSA P-256 key in -key (verified with -pub),
+or else with the hex HMAC secret in $CRYPTO_PRESIGN_SECRET. Without a
+command, a demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	rawURL := flag.String("url", "", "URL to sign")
+	method := flag.String("method", "GET", "HTTP method the URL allows")
+	ttl := flag.Duration("ttl", time.Hour, "how long the URL is valid")
+	ip := flag.String("ip", "", "client address or CIDR prefix to bind the URL to")
+	keyID := flag.String("key-id", "default", "key identifier placed in the URL")
+	keyPath := flag.String("key", "", "ECDSA P-256 private key for signing")
+	pubPath := flag.String("pub", "", "ECDSA P-256 public key for verifying")
+	listen := flag.String("listen", "localhost:8080", "address to serve on")
+	dir := flag.String("dir", ".", "directory to serve")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	secret := func() []byte {
+		key, err := hex.DecodeString(os.Getenv("CRYPTO_PRESIGN_SECRET"))
+		if err != nil || len(key) < 16 {
+			log.Fatal("CRYPTO_PRESIGN_SECRET must be at least 16 hex-encoded bytes")
+		}
+		return key
+	}
+	switch cmd {
+	case "sign":
+		var s *Signer
+		if *keyPath != "" {
+			key, err := loadPrivateKey(*keyPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			s = NewECDSASigner(*keyID, key)
+		} else {
+			s = NewHMACSigner(*keyID, secret())
+		}
+		signed, err := s.Sign(*rawURL, Options{Method: *method, TTL: *ttl, IP: *ip})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(signed)
+	case "serve":
+		v := NewVerifier()
+		if *pubPath != "" {
+			key, err := loadPublicKey(*pubPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			v.AddPublicKey(*keyID, key)
+		} else {
+			v.AddHMACKey(*keyID, secret())
+		}
+		log.Printf("serving %s on %s", *dir, *listen)
+		log.Fatal(http.ListenAndServe(*listen, v.Middleware(http.FileServer(http.Dir(*dir)))))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func demo() {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	edgeKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	origin := NewHMACSigner("origin-1", secret)
+	edge := NewECDSASigner("edge-1", edgeKey)
+
+	// Verifying edge-1 URLs needs only its public key.
+	v := NewVerifier()
+	v.AddHMACKey("origin-1", secret)
+	v.AddPublicKey("edge-1", &edgeKey.PublicKey)
+	v.MaxTTL = 7 * 24 * time.Hour
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	objects := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	})
+	srv := httptest.NewServer(v.Middleware(objects))
+	defer srv.Close()
+
+	try := func(label, method, url string) {
+		req, _ := http.NewRequest(method, url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Printf("%-28s %d %s\n", label+":", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	sign := func(s *Signer, path string, o Options) string {
+		u, err := s.Sign(srv.URL+path, o)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return u
+	}
+
+	get := sign(origin, "/reports/q3.pdf?download=1", Options{TTL: 15 * time.Minute})
+	fmt.Println("HS256:", strings.TrimPrefix(get, srv.URL))
+	try("download", "GET", get)
+	try("unsigned", "GET", srv.URL+"/reports/q3.pdf")
+	try("other object", "GET", strings.Replace(get, "q3.pdf", "q4.pdf", 1))
+	try("extra parameter", "GET", get+"&download=0")
+	try("wrong method", "DELETE", get)
+
+	put := sign(edge, "/uploads/photo.jpg", Options{Method: "PUT", TTL: time.Hour, IP: "127.0.0.0/8"})
+	fmt.Println("ES256:", strings.TrimPrefix(put, srv.URL))
+	try("upload from bound network", "PUT", put)
+	v.ClientIP = func(*http.Request) (netip.Addr, error) { return netip.MustParseAddr("198.51.100.9"), nil }
+	try("upload from elsewhere", "PUT", put)
+	v.ClientIP = nil
+	try("HS256 claimed for edge key", "PUT", strings.Replace(put, "X-Alg=ES256", "X-Alg=HS256", 1))
+
+	long := sign(origin, "/reports/q3.pdf", Options{TTL: 30 * 24 * time.Hour})
+	try("30-day link", "GET", long)
+	now = now.Add(20 * time.Minute)
+	try("download after 20 minutes", "GET", get)
+}
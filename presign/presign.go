@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A presigned URL carries its scope in query parameters and a signature
+// over the canonical request:
+//
+//	PRESIGN1
+//	<method>
+//	<path>
+//	<sorted query without X-Signature>
+//
+// The query includes X-Expires (Unix seconds), X-Method, X-Key-Id,
+// X-Alg and, when the link is bound to a client, X-IP (an address or a
+// CIDR prefix). Signing every other parameter as well means none can be
+// added or changed. HS256 signatures are HMAC-SHA256; ES256 signatures
+// are ASN.1 ECDSA P-256 over the SHA-256 digest, as in sign/, so edge
+// servers can verify with only the public key.
+const (
+	paramExpires   = "X-Expires"
+	paramMethod    = "X-Method"
+	paramKeyID     = "X-Key-Id"
+	paramAlg       = "X-Alg"
+	paramIP        = "X-IP"
+	paramSignature = "X-Signature"
+
+	AlgHS256 = "HS256"
+	AlgES256 = "ES256"
+)
+
+var (
+	ErrUnsigned   = errors.New("URL is not presigned")
+	ErrExpired    = errors.New("presigned URL has expired")
+	ErrMethod     = errors.New("presigned URL does not allow this method")
+	ErrIP         = errors.New("presigned URL is bound to another client address")
+	ErrUnknownKey = errors.New("presigned URL is signed with an unknown key")
+	ErrSignature  = errors.New("presigned URL signature is invalid")
+)
+
+func canonical(method, path string, q url.Values) []byte {
+	q = cloneValues(q)
+	q.Del(paramSignature)
+	// Encode sorts by key.
+	return []byte("PRESIGN1\n" + method + "\n" + path + "\n" + q.Encode())
+}
+
+func cloneValues(q url.Values) url.Values {
+	c := make(url.Values, len(q))
+	for k, v := range q {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// Options scope a presigned URL. Method defaults to GET; IP, if set,
+// is a client address or CIDR prefix the URL is restricted to.
+type Options struct {
+	Method string
+	TTL    time.Duration
+	IP     string
+}
+
+// Signer issues presigned URLs with either an HMAC key or an ECDSA
+// P-256 private key.
+type Signer struct {
+	KeyID   string
+	hmacKey []byte
+	ecKey   *ecdsa.PrivateKey
+	now     func() time.Time
+}
+
+func NewHMACSigner(keyID string, key []byte) *Signer {
+	return &Signer{KeyID: keyID, hmacKey: key, now: time.Now}
+}
+
+func NewECDSASigner(keyID string, key *ecdsa.PrivateKey) *Signer {
+	return &Signer{KeyID: keyID, ecKey: key, now: time.Now}
+}
+
+func (s *Signer) Sign(rawURL string, o Options) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if o.TTL <= 0 {
+		return "", errors.New("presign: TTL must be positive")
+	}
+	method := strings.ToUpper(o.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	q := u.Query()
+	for _, p := range []string{paramExpires, paramMethod, paramKeyID, paramAlg, paramIP, paramSignature} {
+		q.Del(p)
+	}
+	if o.IP != "" {
+		if _, err := parseIPScope(o.IP); err != nil {
+			return "", err
+		}
+		q.Set(paramIP, o.IP)
+	}
+	q.Set(paramExpires, strconv.FormatInt(s.now().Add(o.TTL).Unix(), 10))
+	q.Set(paramMethod, method)
+	q.Set(paramKeyID, s.KeyID)
+	q.Set(paramAlg, s.Alg())
+	msg := canonical(method, u.EscapedPath(), q)
+	var sig []byte
+	if s.ecKey != nil {
+		digest := sha256.Sum256(msg)
+		if sig, err = ecdsa.SignASN1(rand.Reader, s.ecKey, digest[:]); err != nil {
+			return "", err
+		}
+	} else {
+		mac := hmac.New(sha256.New, s.hmacKey)
+		mac.Write(msg)
+		sig = mac.Sum(nil)
+	}
+	q.Set(paramSignature, base64.RawURLEncoding.EncodeToString(sig))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (s *Signer) Alg() string {
+	if s.ecKey != nil {
+		return AlgES256
+	}
+	return AlgHS256
+}
+
+func parseIPScope(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()), nil
+}
+
+// Verifier checks presigned URLs. Keys are registered by id with their
+// algorithm, so a URL cannot choose how it is verified: an HS256 URL
+// naming an ECDSA key id is rejected.
+type Verifier struct {
+	// MaxTTL, if set, rejects URLs expiring further in the future, in
+	// case a signer was misconfigured.
+	MaxTTL time.Duration
+	// ClientIP returns the address to check X-IP against. The default
+	// is the connection's remote address; set it when running behind a
+	// trusted proxy.
+	ClientIP func(*http.Request) (netip.Addr, error)
+
+	mu       sync.RWMutex
+	hmacKeys map[string][]byte
+	ecKeys   map[string]*ecdsa.PublicKey
+	now      func() time.Time
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{hmacKeys: map[string][]byte{}, ecKeys: map[string]*ecdsa.PublicKey{}, now: time.Now}
+}
+
+func (v *Verifier) AddHMACKey(id string, key []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.hmacKeys[id] = key
+}
+
+func (v *Verifier) AddPublicKey(id string, key *ecdsa.PublicKey) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.ecKeys[id] = key
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	a, err := netip.ParseAddr(host)
+	return a.Unmap(), err
+}
+
+// Verify checks the signature and scope of r's URL.
+func (v *Verifier) Verify(r *http.Request) error {
+	q := r.URL.Query()
+	sigText := q.Get(paramSignature)
+	if sigText == "" {
+		return ErrUnsigned
+	}
+	for _, p := range []string{paramExpires, paramMethod, paramKeyID, paramAlg, paramIP, paramSignature} {
+		if len(q[p]) > 1 {
+			return fmt.Errorf("%w: repeated %s", ErrSignature, p)
+		}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigText)
+	if err != nil {
+		return ErrSignature
+	}
+	msg := canonical(q.Get(paramMethod), r.URL.EscapedPath(), q)
+
+	id, alg := q.Get(paramKeyID), q.Get(paramAlg)
+	v.mu.RLock()
+	hmacKey, isHMAC := v.hmacKeys[id]
+	ecKey, isEC := v.ecKeys[id]
+	v.mu.RUnlock()
+	switch {
+	case isHMAC && alg == AlgHS256:
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(msg)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+	case isEC && alg == AlgES256:
+		digest := sha256.Sum256(msg)
+		if !ecdsa.VerifyASN1(ecKey, digest[:], sig) {
+			return ErrSignature
+		}
+	case isHMAC || isEC:
+		return fmt.Errorf("%w: key %q does not use %s", ErrSignature, id, alg)
+	default:
+		return ErrUnknownKey
+	}
+
+	// The parameters below are now known to be the signer's.
+	expires, err := strconv.ParseInt(q.Get(paramExpires), 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+	now := v.now()
+	if now.Unix() >= expires {
+		return ErrExpired
+	}
+	if v.MaxTTL > 0 && time.Unix(expires, 0).Sub(now) > v.MaxTTL {
+		return fmt.Errorf("%w: expiry is more than %v away", ErrSignature, v.MaxTTL)
+	}
+	if r.Method != q.Get(paramMethod) {
+		return ErrMethod
+	}
+	if scope := q.Get(paramIP); scope != "" {
+		prefix, err := parseIPScope(scope)
+		if err != nil {
+			return ErrIP
+		}
+		clientIP := v.ClientIP
+		if clientIP == nil {
+			clientIP = remoteAddr
+		}
+		addr, err := clientIP(r)
+		if err != nil || !prefix.Contains(addr) {
+			return ErrIP
+		}
+	}
+	return nil
+}
+
+// Middleware serves only requests whose URL is validly presigned and
+// answers the rest with 403 Forbidden.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Batch processing replaces the named columns of a CSV file (which must
+// have a header row) or the named top-level string fields of each JSON
+// line. Other columns and fields pass through unchanged.
+
+func ProcessCSV(r io.Reader, w io.Writer, columns []string, f func(string) (string, error)) (int, error) {
+	cr := csv.NewReader(r)
+	cw := csv.NewWriter(w)
+	header, err := cr.Read()
+	if err != nil {
+		return 0, err
+	}
+	var idx []int
+	for _, c := range columns {
+		i := slices.Index(header, c)
+		if i < 0 {
+			return 0, fmt.Errorf("no column %q", c)
+		}
+		idx = append(idx, i)
+	}
+	if err := cw.Write(header); err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		for _, i := range idx {
+			if rec[i] == "" {
+				continue
+			}
+			if rec[i], err = f(rec[i]); err != nil {
+				return n, fmt.Errorf("row %d: %w", n+2, err)
+			}
+		}
+		if err := cw.Write(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+func ProcessJSONLines(r io.Reader, w io.Writer, fields []string, f func(string) (string, error)) (int, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	bw := bufio.NewWriter(w)
+	n := 0
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var obj map[string]any
+		d := json.NewDecoder(bytes.NewReader(s.Bytes()))
+		d.UseNumber()
+		if err := d.Decode(&obj); err != nil {
+			return n, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		for _, field := range fields {
+			v, ok := obj[field].(string)
+			if !ok || v == "" {
+				continue
+			}
+			var err error
+			if obj[field], err = f(v); err != nil {
+				return n, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		}
+		line, err := json.Marshal(obj)
+		if err != nil {
+			return n, err
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+		n++
+	}
+	if err := s.Err(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Pseudonyms are derived from a versioned master key. Each domain
+// (e.g. "analytics", "billing") gets its own subkeys through HKDF, so
+// the same id has unrelated pseudonyms in different domains. The key
+// version is part of every pseudonym:
+//
+//	v<version>.<base32>       one-way (HMAC-SHA256 or AES-SIV's S2V)
+//	r<version>.<base64url>    reversible: nonce || AES-GCM ciphertext as
+//	                          in aes/, with the nonce derived from the
+//	                          one-way pseudonym so it is still stable
+//
+// After a rotation, pseudonyms are re-derived under the new version:
+// one-way ones from the original ids, reversible ones directly.
+
+const (
+	MethodHMAC = "hmac"
+	MethodSIV  = "siv"
+
+	pseudonymLen = 16
+)
+
+var (
+	ErrUnknownVersion = errors.New("pseudonym uses an unknown key version")
+	ErrNotReversible  = errors.New("pseudonym is not reversible")
+)
+
+// Keyset holds every master key version; Current is used for new
+// pseudonyms.
+type Keyset struct {
+	Current int
+	Keys    map[int][]byte
+}
+
+type keysetJSON struct {
+	Current int               `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func NewKeyset() *Keyset {
+	ks := &Keyset{Keys: map[int][]byte{}}
+	ks.Rotate()
+	return ks
+}
+
+// Rotate adds a new key version and makes it current.
+func (ks *Keyset) Rotate() int {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	ks.Current++
+	ks.Keys[ks.Current] = key
+	return ks.Current
+}
+
+func LoadKeyset(path string) (*Keyset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kj keysetJSON
+	if err := json.Unmarshal(data, &kj); err != nil {
+		return nil, err
+	}
+	ks := &Keyset{Current: kj.Current, Keys: map[int][]byte{}}
+	for v, k := range kj.Keys {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key version %q", v)
+		}
+		if ks.Keys[version], err = hex.DecodeString(k); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := ks.Keys[ks.Current]; !ok {
+		return nil, fmt.Errorf("current key version %d is missing", ks.Current)
+	}
+	return ks, nil
+}
+
+func (ks *Keyset) Save(path string) error {
+	kj := keysetJSON{Current: ks.Current, Keys: map[string]string{}}
+	for v, k := range ks.Keys {
+		kj.Keys[strconv.Itoa(v)] = hex.EncodeToString(k)
+	}
+	data, err := json.MarshalIndent(kj, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// Pseudonymizer maps identifiers of one domain to pseudonyms.
+type Pseudonymizer struct {
+	Keys       *Keyset
+	Domain     string
+	Method     string
+	Reversible bool
+}
+
+func (p *Pseudonymizer) subkey(version int, purpose string) ([]byte, error) {
+	master, ok := p.Keys.Keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return hkdf.Key(sha256.New, master, nil, "pseudo "+purpose+" "+p.Domain, 32)
+}
+
+// oneWay is the keyed PRF of id under version.
+func (p *Pseudonymizer) oneWay(version int, id string) ([]byte, error) {
+	key, err := p.subkey(version, p.Method)
+	if err != nil {
+		return nil, err
+	}
+	switch p.Method {
+	case MethodHMAC:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(id))
+		return mac.Sum(nil)[:pseudonymLen], nil
+	case MethodSIV:
+		v, err := s2v(key, []byte(p.Domain), []byte(id))
+		return v[:], err
+	}
+	return nil, fmt.Errorf("unknown method %q", p.Method)
+}
+
+func (p *Pseudonymizer) gcm(version int) (cipher.AEAD, error) {
+	key, err := p.subkey(version, "reversible")
+	if err != nil {
+		return nil, err
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Pseudonymize returns the pseudonym of id under the current key.
+func (p *Pseudonymizer) Pseudonymize(id string) (string, error) {
+	return p.PseudonymizeWith(p.Keys.Current, id)
+}
+
+func (p *Pseudonymizer) PseudonymizeWith(version int, id string) (string, error) {
+	sum, err := p.oneWay(version, id)
+	if err != nil {
+		return "", err
+	}
+	if !p.Reversible {
+		return fmt.Sprintf("v%d.%s", version, strings.ToLower(b32.EncodeToString(sum))), nil
+	}
+	gcm, err := p.gcm(version)
+	if err != nil {
+		return "", err
+	}
+	nonce := sum[:gcm.NonceSize()]
+	ciphertext := gcm.Seal(bytes.Clone(nonce), nonce, []byte(id), []byte(p.Domain))
+	return fmt.Sprintf("r%d.%s", version, base64.RawURLEncoding.EncodeToString(ciphertext)), nil
+}
+
+func parsePseudonym(s string) (kind byte, version int, body string, err error) {
+	head, body, ok := strings.Cut(s, ".")
+	if !ok || len(head) < 2 || (head[0] != 'v' && head[0] != 'r') {
+		return 0, 0, "", fmt.Errorf("malformed pseudonym %q", s)
+	}
+	version, err = strconv.Atoi(head[1:])
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("malformed pseudonym %q", s)
+	}
+	return head[0], version, body, nil
+}
+
+// Reverse recovers the id behind a reversible pseudonym.
+func (p *Pseudonymizer) Reverse(pseudonym string) (string, error) {
+	kind, version, body, err := parsePseudonym(pseudonym)
+	if err != nil {
+		return "", err
+	}
+	if kind != 'r' {
+		return "", ErrNotReversible
+	}
+	data, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := p.gcm(version)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("pseudonym too short")
+	}
+	id, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(p.Domain))
+	if err != nil {
+		return "", fmt.Errorf("pseudonym does not belong to domain %q: %w", p.Domain, err)
+	}
+	return string(id), nil
+}
+
+// Rederive moves a pseudonym to the current key version. One-way
+// pseudonyms need the original id; reversible ones are decrypted.
+func (p *Pseudonymizer) Rederive(pseudonym, id string) (string, error) {
+	kind, version, _, err := parsePseudonym(pseudonym)
+	if err != nil {
+		return "", err
+	}
+	if version == p.Keys.Current {
+		return pseudonym, nil
+	}
+	if kind == 'r' {
+		if id, err = p.Reverse(pseudonym); err != nil {
+			return "", err
+		}
+	} else {
+		if id == "" {
+			return "", errors.New("one-way pseudonyms can only be re-derived from the original id")
+		}
+		old, err := p.PseudonymizeWith(version, id)
+		if err != nil {
+			return "", err
+		}
+		if !hmac.Equal([]byte(old), []byte(pseudonym)) {
+			return "", errors.New("id does not match the pseudonym")
+		}
+	}
+	return p.Pseudonymize(id)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+)
+
+// S2V from AES-SIV (RFC 5297): a CMAC-based PRF over a vector of
+// strings. Its output is the synthetic IV of AES-SIV.
+
+func dbl(b *[16]byte) {
+	carry := b[0] >> 7
+	for i := 0; i < 15; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[15] = b[15]<<1 ^ carry*0x87
+}
+
+// cmac is AES-CMAC (RFC 4493).
+func cmac(block cipher.Block, msg []byte) [16]byte {
+	var k1, k2 [16]byte
+	block.Encrypt(k1[:], k1[:])
+	dbl(&k1)
+	k2 = k1
+	dbl(&k2)
+
+	var x [16]byte
+	for len(msg) > 16 {
+		subtle.XORBytes(x[:], x[:], msg[:16])
+		block.Encrypt(x[:], x[:])
+		msg = msg[16:]
+	}
+	var last [16]byte
+	if len(msg) == 16 {
+		subtle.XORBytes(last[:], msg, k1[:])
+	} else {
+		copy(last[:], msg)
+		last[len(msg)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+func s2v(key []byte, strs ...[]byte) ([16]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	var zero [16]byte
+	d := cmac(block, zero[:])
+	for _, s := range strs[:len(strs)-1] {
+		dbl(&d)
+		m := cmac(block, s)
+		subtle.XORBytes(d[:], d[:], m[:])
+	}
+	last := strs[len(strs)-1]
+	if len(last) >= 16 {
+		t := append([]byte(nil), last...)
+		subtle.XORBytes(t[len(t)-16:], t[len(t)-16:], d[:])
+		return cmac(block, t), nil
+	}
+	dbl(&d)
+	var t [16]byte
+	copy(t[:], last)
+	t[len(last)] = 0x80
+	subtle.XORBytes(d[:], d[:], t[:])
+	return cmac(block, d[:]), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Stats summarises a directory run.
+type Stats struct {
+	Rekeyed, Skipped, AlreadyDone int
+	Failed                        map[string]error
+}
+
+// checkpoint is an append-only list of files that are finished, one path
+// per line, so an interrupted run can resume where it stopped. The first
+// line names the key the files were re-keyed to; a checkpoint left by a
+// rotation to a different key is discarded.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	done map[string]bool
+}
+
+const checkpointHeader = "rekey to "
+
+func openCheckpoint(path, keyID string) (*checkpoint, error) {
+	c := &checkpoint{path: path, done: map[string]bool{}}
+	header := checkpointHeader + keyID
+	flags := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	s := bufio.NewScanner(strings.NewReader(string(data)))
+	if s.Scan() && s.Text() == header {
+		for s.Scan() {
+			if line := s.Text(); line != "" {
+				c.done[line] = true
+			}
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	c.f = f
+	if flags&os.O_TRUNC != 0 {
+		if _, err := fmt.Fprintln(f, header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *checkpoint) isDone(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[path]
+}
+
+func (c *checkpoint) markDone(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[path] = true
+	if _, err := fmt.Fprintln(c.f, path); err != nil {
+		return err
+	}
+	return c.f.Sync()
+}
+
+func (c *checkpoint) Close() error { return c.f.Close() }
+
+// remove deletes the checkpoint once every file is done, so the next
+// rotation starts from scratch.
+func (c *checkpoint) remove() error {
+	c.f.Close()
+	return os.Remove(c.path)
+}
+
+// RekeyDir re-keys every regular file under dir whose name ends in ext
+// (all files when ext is empty) using workers goroutines. Progress is
+// recorded in checkpointPath; files listed there are skipped. The
+// checkpoint is removed when a run finishes without failures.
+func (r *Rekeyer) RekeyDir(dir, ext, checkpointPath string, workers int) (Stats, error) {
+	stats := Stats{Failed: map[string]error{}}
+	cp, err := openCheckpoint(checkpointPath, r.newID)
+	if err != nil {
+		return stats, err
+	}
+	cpAbs, _ := filepath.Abs(checkpointPath)
+
+	var mu sync.Mutex
+	record := func(path string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == nil:
+			stats.Rekeyed++
+		case errors.Is(err, ErrAlreadyRekeyed):
+			// Renamed before the previous run could checkpoint it.
+			stats.AlreadyDone++
+			err = nil
+		default:
+			stats.Failed[path] = err
+			return
+		}
+		if err := cp.markDone(path); err != nil {
+			stats.Failed[path] = err
+		}
+	}
+
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				record(path, r.RekeyFile(path))
+			}
+		}()
+	}
+
+	walkErr := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(path, ext) || strings.Contains(d.Name(), ".rekey-") {
+			return nil
+		}
+		if abs, _ := filepath.Abs(path); abs == cpAbs {
+			return nil
+		}
+		if cp.isDone(path) {
+			stats.Skipped++
+			return nil
+		}
+		paths <- path
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+	if walkErr != nil || len(stats.Failed) > 0 {
+		cp.Close()
+		return stats, walkErr
+	}
+	return stats, cp.remove()
+}
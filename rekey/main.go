@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+func main() {
+	oldKey := flag.String("old", "Test1234Test1234", `current key, raw or "hex:..."`)
+	newKey := flag.String("new", "", `replacement key, raw or "hex:..."`)
+	dir := flag.String("dir", "", "directory of ciphertexts to re-key (runs a demo when empty)")
+	ext := flag.String("ext", "", "only re-key files with this suffix")
+	checkpoint := flag.String("checkpoint", "rekey.checkpoint", "progress file for resuming; removed after a run with no failures")
+	workers := flag.Int("workers", runtime.NumCPU(), "files processed concurrently")
+	flag.Parse()
+
+	if *dir == "" {
+		if err := demo(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	from, err := ParseKey(*oldKey)
+	if err != nil {
+		log.Fatal("-old: ", err)
+	}
+	to, err := ParseKey(*newKey)
+	if err != nil {
+		log.Fatal("-new: ", err)
+	}
+	r, err := NewRekeyer(from, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats, err := r.RekeyDir(*dir, *ext, *checkpoint, *workers)
+	report(stats)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(stats.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+func report(stats Stats) {
+	fmt.Printf("rekeyed %d, already done %d, skipped (checkpoint) %d, failed %d\n",
+		stats.Rekeyed, stats.AlreadyDone, stats.Skipped, len(stats.Failed))
+	var failed []string
+	for path := range stats.Failed {
+		failed = append(failed, path)
+	}
+	sort.Strings(failed)
+	for _, path := range failed {
+		fmt.Printf("  %s: %v\n", path, stats.Failed[path])
+	}
+}
+
+// demo encrypts a few files with the aes/ key, both directly and as
+// envelopes, re-keys them in two passes and decrypts the results.
+func demo() error {
+	dir, err := os.MkdirTemp("", "rekey-demo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	oldKey := []byte("Test1234Test1234")
+	newKey := []byte("0123456789abcdef0123456789abcdef")
+	gcm, err := newGCM(oldKey)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 8; i++ {
+		data := []byte(fmt.Sprintf("document %d", i))
+		var ct []byte
+		if i%2 == 0 {
+			ct, err = seal(gcm, data)
+		} else {
+			ct, err = SealEnvelope(oldKey, data)
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("doc%d.enc", i)), ct, 0600); err != nil {
+			return err
+		}
+	}
+	// A file under an unknown key fails without stopping the others.
+	if err := os.WriteFile(filepath.Join(dir, "foreign.enc"), make([]byte, 40), 0600); err != nil {
+		return err
+	}
+
+	r, err := NewRekeyer(oldKey, newKey)
+	if err != nil {
+		return err
+	}
+	cp := filepath.Join(dir, "rekey.checkpoint")
+	for pass := 1; pass <= 2; pass++ {
+		stats, err := r.RekeyDir(dir, ".enc", cp, 4)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pass %d: ", pass)
+		report(stats)
+	}
+
+	// The next rotation ignores the checkpoint the failed file left behind.
+	nextKey := []byte("fedcba9876543210fedcba9876543210")
+	next, err := NewRekeyer(newKey, nextKey)
+	if err != nil {
+		return err
+	}
+	stats, err := next.RekeyDir(dir, ".enc", cp, 4)
+	if err != nil {
+		return err
+	}
+	fmt.Print("next rotation: ")
+	report(stats)
+	if err := os.Remove(filepath.Join(dir, "foreign.enc")); err != nil {
+		return err
+	}
+	if stats, err = next.RekeyDir(dir, ".enc", cp, 4); err != nil {
+		return err
+	}
+	_, err = os.Stat(cp)
+	fmt.Print("without the foreign file: ")
+	report(stats)
+	fmt.Println("checkpoint removed after a clean run:", errors.Is(err, fs.ErrNotExist))
+	newKey = nextKey
+
+	for _, name := range []string{"doc0.enc", "doc1.enc"} {
+		ct, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		pt, err := Decrypt(newKey, ct)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s decrypts with the new key: %q\n", name, pt)
+	}
+	return nil
+}
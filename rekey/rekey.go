@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Two ciphertext layouts are understood:
+//
+//	direct:   nonce || GCM(key, data)            as written by aes/main.go
+//	envelope: "ENV1" || uint16 length || wrapped data key || nonce || GCM(data key, data)
+//
+// where the wrapped data key is nonce || GCM(key, data key). Direct
+// ciphertexts are decrypted and re-encrypted; for envelopes only the data
+// key is re-wrapped and the body is left untouched.
+var envelopeMagic = []byte("ENV1")
+
+var (
+	ErrWrongKey        = errors.New("ciphertext does not decrypt with the old key")
+	ErrAlreadyRekeyed  = errors.New("ciphertext is already encrypted with the new key")
+	errVerifyMismatch  = errors.New("re-encrypted output does not decrypt to the original")
+	errShortCiphertext = errors.New("ciphertext too short")
+)
+
+// ParseKey reads a key given as "hex:<hex digits>" or as the raw key
+// string, the form aes/main.go uses.
+func ParseKey(s string) ([]byte, error) {
+	key := []byte(s)
+	if h, ok := strings.CutPrefix(s, "hex:"); ok {
+		var err error
+		if key, err = hex.DecodeString(h); err != nil {
+			return nil, err
+		}
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("AES keys must be 16, 24 or 32 bytes, got %d", len(key))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+func seal(gcm cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(gcm cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, errShortCiphertext
+	}
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+}
+
+// Rekeyer moves ciphertexts from one key to another.
+type Rekeyer struct {
+	old, new cipher.AEAD
+	newID    string
+}
+
+// keyID identifies a key in checkpoints without revealing it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("rekey checkpoint\x00"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+func NewRekeyer(oldKey, newKey []byte) (*Rekeyer, error) {
+	if bytes.Equal(oldKey, newKey) {
+		return nil, errors.New("old and new keys are the same")
+	}
+	from, err := newGCM(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	to, err := newGCM(newKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Rekeyer{old: from, new: to, newID: keyID(newKey)}, nil
+}
+
+// Rekey returns ciphertext re-encrypted (or, for envelopes, re-wrapped)
+// under the new key. The result is decrypted again and compared with the
+// original plaintext before it is returned.
+func (r *Rekeyer) Rekey(ciphertext []byte) ([]byte, error) {
+	if bytes.HasPrefix(ciphertext, envelopeMagic) {
+		return r.rewrap(ciphertext)
+	}
+	plaintext, err := open(r.old, ciphertext)
+	if err != nil {
+		if _, newErr := open(r.new, ciphertext); newErr == nil {
+			return nil, ErrAlreadyRekeyed
+		}
+		return nil, ErrWrongKey
+	}
+	out, err := seal(r.new, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	check, err := open(r.new, out)
+	if err != nil || !bytes.Equal(check, plaintext) {
+		return nil, errVerifyMismatch
+	}
+	return out, nil
+}
+
+func (r *Rekeyer) rewrap(envelope []byte) ([]byte, error) {
+	wrapped, body, err := splitEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(r.old, wrapped)
+	if err != nil {
+		if _, newErr := open(r.new, wrapped); newErr == nil {
+			return nil, ErrAlreadyRekeyed
+		}
+		return nil, ErrWrongKey
+	}
+	rewrapped, err := seal(r.new, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	out := buildEnvelope(rewrapped, body)
+
+	w, b, err := splitEnvelope(out)
+	if err != nil {
+		return nil, err
+	}
+	check, err := open(r.new, w)
+	if err != nil || !bytes.Equal(check, dataKey) || !bytes.Equal(b, body) {
+		return nil, errVerifyMismatch
+	}
+	return out, nil
+}
+
+func splitEnvelope(envelope []byte) (wrapped, body []byte, err error) {
+	rest := envelope[len(envelopeMagic):]
+	if len(rest) < 2 {
+		return nil, nil, errShortCiphertext
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < n {
+		return nil, nil, errShortCiphertext
+	}
+	return rest[:n], rest[n:], nil
+}
+
+func buildEnvelope(wrapped, body []byte) []byte {
+	out := append([]byte(nil), envelopeMagic...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	return append(out, body...)
+}
+
+// SealEnvelope encrypts data under a fresh data key wrapped with kek.
+func SealEnvelope(kek, data []byte) ([]byte, error) {
+	wrapper, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(wrapper, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	body, err := seal(gcm, data)
+	if err != nil {
+		return nil, err
+	}
+	return buildEnvelope(wrapped, body), nil
+}
+
+// Decrypt opens a direct or envelope ciphertext with key.
+func Decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(ciphertext, envelopeMagic) {
+		return open(gcm, ciphertext)
+	}
+	wrapped, body, err := splitEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(gcm, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if gcm, err = newGCM(dataKey); err != nil {
+		return nil, err
+	}
+	return open(gcm, body)
+}
+
+// RekeyFile rewrites the file at path under the new key. The output is
+// written to a temporary file next to it, synced, and renamed over the
+// original, so a crash leaves either the old or the new ciphertext.
+func (r *Rekeyer) RekeyFile(path string) error {
+	ciphertext, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out, err := r.Rekey(ciphertext)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, out, info.Mode().Perm())
+}
+
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".rekey-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename itself.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -1,18 +1,20 @@
 package main
 
 import (
-	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
-)
+	"log"
+	"os"
+	"time"
 
-func GenerateRsaKeyPair() (*rsa.PrivateKey, *rsa.PublicKey) {
-	privkey, _ := rsa.GenerateKey(rand.Reader, 4096)
-	return privkey, &privkey.PublicKey
-}
+	"ecdsa/internal/config"
+	"ecdsa/internal/keyfile"
+)
 
 func ExportRsaPrivateKeyAsPemStr(privkey *rsa.PrivateKey) string {
 	privkey_bytes := x509.MarshalPKCS1PrivateKey(privkey)
@@ -75,9 +77,19 @@ func ParseRsaPublicKeyFromPemStr(pubPEM string) (*rsa.PublicKey, error) {
 }
 
 func main() {
+	cfg, profile, err := config.LoadProfile("rsa")
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag.Int("bits", profile.RsaBits, "RSA key size")
+	flag.String("format", profile.Format, "output format: text or json")
+	flag.Parse()
+	if profile, err = cfg.ResolveFlags(); err != nil {
+		log.Fatal(err)
+	}
 
 	// Create the keys
-	priv, pub := GenerateRsaKeyPair()
+	priv, pub := keyfile.GenerateRsaKeyPair(profile.RsaBits)
 
 	// Export the keys to pem string
 	priv_pem := ExportRsaPrivateKeyAsPemStr(priv)
@@ -91,6 +103,16 @@ func main() {
 	priv_parsed_pem := ExportRsaPrivateKeyAsPemStr(priv_parsed)
 	pub_parsed_pem, _ := ExportRsaPublicKeyAsPemStr(pub_parsed)
 
+	if profile.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(map[string]any{"bits": profile.RsaBits, "private": priv_parsed_pem, "public": pub_parsed_pem,
+			"round_trip": priv_pem == priv_parsed_pem && pub_pem == pub_parsed_pem})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	fmt.Println(priv_parsed_pem)
 	fmt.Println(pub_parsed_pem)
 
@@ -100,4 +122,45 @@ func main() {
 	} else {
 		fmt.Println("Success")
 	}
+
+	// Attach metadata and let it gate encryption and decryption. The
+	// ring refuses to hand out the same key for another purpose.
+	ring := keyfile.NewKeyRing()
+	now := time.Now()
+	meta := keyfile.KeyMetadata{Created: now, Expires: now.AddDate(1, 0, 0), Usage: []string{keyfile.UsageDecrypt}, Owner: "demo"}
+	decrypter, err := ring.NewDecryptionKey(priv, meta)
+	if err != nil {
+		fmt.Println("Error :", err)
+		return
+	}
+	meta_priv_pem, _ := decrypter.MarshalPEM()
+	meta_pub_pem, _ := decrypter.Public().MarshalPEM()
+	fmt.Println(meta_pub_pem)
+
+	meta_priv, err := ring.ParseDecryptionKey(meta_priv_pem)
+	if err != nil {
+		fmt.Println("Error :", err)
+		return
+	}
+	meta_pub, err := ring.ParseEncryptionKey(meta_pub_pem)
+	if err != nil {
+		fmt.Println("Error :", err)
+		return
+	}
+	ciphertext, err := meta_pub.Encrypt(keyfile.AlgRSAOAEPSHA256, []byte("hello, world"))
+	if err != nil {
+		fmt.Println("Error :", err)
+		return
+	}
+	plaintext, err := meta_priv.Decrypt(keyfile.AlgRSAOAEPSHA256, ciphertext)
+	fmt.Printf("decrypted: %q %v\n", plaintext, err)
+
+	meta.Expires = now.Add(-time.Minute)
+	expired, _ := ring.NewDecryptionKey(priv, meta)
+	_, err = expired.Decrypt(keyfile.AlgRSAOAEPSHA256, ciphertext)
+	fmt.Println("expired key:", err)
+	_, err = ring.NewDecryptionKey(priv, keyfile.KeyMetadata{})
+	fmt.Println("key without usage:", err)
+	_, err = ring.NewSigningKey(priv, keyfile.KeyMetadata{Usage: []string{keyfile.UsageSign}})
+	fmt.Println("same key for signatures:", err)
 }
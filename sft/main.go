@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ecdsa/internal/keyfile"
+)
+
+// Keys are PEM files as keys/ writes them, loaded through a keyfile
+// ring: the private key must allow signing and the public keys
+// verifying, and none of them may be in use for another purpose.
+
+func writeKeyPair(ring *keyfile.KeyRing, dir, name string) error {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	key, err := ring.NewSigningKey(privateKey, keyfile.KeyMetadata{Created: time.Now(), Usage: []string{keyfile.UsageSign}})
+	if err != nil {
+		return err
+	}
+	priv, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	pub, err := key.Public().MarshalPEM()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), []byte(priv), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+".pub.pem"), []byte(pub), 0644)
+}
+
+// cutConn simulates a dropped connection after limit bytes are written.
+type cutConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *cutConn) Write(p []byte) (int, error) {
+	if len(p) > c.limit {
+		c.Conn.Close()
+		return 0, errors.New("connection dropped")
+	}
+	c.limit -= len(p)
+	return c.Conn.Write(p)
+}
+
+// sendRetrying sends path over a new connection to addr, wrapped by wrap
+// if it is not nil. The server may not have noticed that an earlier
+// connection for the same name dropped, in which case it still holds
+// the name and the client retries.
+func sendRetrying(c *Client, addr, path string, wrap func(net.Conn) net.Conn) (uint64, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return 0, err
+		}
+		if wrap != nil {
+			conn = wrap(conn)
+		}
+		resumed, err := c.Send(conn, path)
+		if attempt == 10 || err == nil || !strings.Contains(err.Error(), "already being received") {
+			return resumed, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+func demo() error {
+	tmp, err := os.MkdirTemp("", "sft")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	ring, err := keyfile.OpenKeyRing(filepath.Join(tmp, "keyring.json"))
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"server", "client", "colleague", "intruder"} {
+		if err := writeKeyPair(ring, tmp, name); err != nil {
+			return err
+		}
+	}
+	key := func(name string) string { return filepath.Join(tmp, name) }
+	inbox := filepath.Join(tmp, "inbox")
+	if err := os.Mkdir(inbox, 0700); err != nil {
+		return err
+	}
+
+	server := &Server{
+		Key:     must(ring.LoadSigningKey(key("server.pem"))),
+		Clients: []*keyfile.VerifyingKey{must(ring.LoadVerifyingKey(key("client.pub.pem"))), must(ring.LoadVerifyingKey(key("colleague.pub.pem")))},
+		Dir:     inbox,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go server.Serve(l)
+
+	content := make([]byte, 5*ChunkSize*ackEvery/2+1234)
+	rand.Read(content)
+	src := filepath.Join(tmp, "telemetry.bin")
+	if err := os.WriteFile(src, content, 0600); err != nil {
+		return err
+	}
+	client := &Client{Key: must(ring.LoadSigningKey(key("client.pem"))), ServerKey: must(ring.LoadVerifyingKey(key("server.pub.pem")))}
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return err
+	}
+	_, err = client.Send(&cutConn{Conn: conn, limit: len(content) / 2}, src)
+	fmt.Println("first attempt:", err)
+
+	// Another client sending a file of the same name does not pick up
+	// the first client's partial upload.
+	colleague := &Client{Key: must(ring.LoadSigningKey(key("colleague.pem"))), ServerKey: client.ServerKey}
+	other := filepath.Join(tmp, "colleague", "telemetry.bin")
+	if err := os.Mkdir(filepath.Dir(other), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(other, content[:len(content)/3], 0600); err != nil {
+		return err
+	}
+	resumed, err := sendRetrying(colleague, l.Addr().String(), other, func(conn net.Conn) net.Conn {
+		return &cutConn{Conn: conn, limit: ChunkSize * 2}
+	})
+	fmt.Printf("other client started at chunk %d: %v\n", resumed, err)
+
+	resumed, err = sendRetrying(client, l.Addr().String(), src, nil)
+	if err != nil {
+		return err
+	}
+	got, err := os.ReadFile(filepath.Join(inbox, "telemetry.bin"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("second attempt resumed at chunk %d of %d, file intact: %v\n",
+		resumed, (len(content)+ChunkSize-1)/ChunkSize, bytes.Equal(got, content))
+
+	// The name is taken now, so the other client cannot replace the file.
+	_, err = sendRetrying(colleague, l.Addr().String(), other, nil)
+	fmt.Println("other client, same name:", err)
+
+	intruder := &Client{Key: must(ring.LoadSigningKey(key("intruder.pem"))), ServerKey: client.ServerKey}
+	conn, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return err
+	}
+	_, err = intruder.Send(conn, src)
+	fmt.Println("unknown client:", err != nil)
+
+	impostor := &Client{Key: client.Key, ServerKey: must(ring.LoadVerifyingKey(key("intruder.pub.pem")))}
+	conn, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return err
+	}
+	_, err = impostor.Send(conn, src)
+	fmt.Println("wrong server key:", err)
+	return nil
+}
+
+func main() {
+	listen := flag.String("listen", "", "run a server on this address")
+	connect := flag.String("connect", "", "send -file to the server at this address")
+	keyPath := flag.String("key", "", "our private key (PEM)")
+	peers := flag.String("peer", "", "peer public key (PEM); a server accepts a comma-separated list")
+	file := flag.String("file", "", "file to send")
+	dir := flag.String("dir", ".", "directory the server stores received files in")
+	keyRing := flag.String("keyring", "", "file recording the purpose of every key loaded (default keyring.json next to -key)")
+	flag.Parse()
+
+	if *listen == "" && *connect == "" {
+		if err := demo(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *keyRing == "" {
+		*keyRing = filepath.Join(filepath.Dir(*keyPath), "keyring.json")
+	}
+	ring := must(keyfile.OpenKeyRing(*keyRing))
+	key := must(ring.LoadSigningKey(*keyPath))
+	var peerKeys []*keyfile.VerifyingKey
+	for _, p := range strings.Split(*peers, ",") {
+		peerKeys = append(peerKeys, must(ring.LoadVerifyingKey(p)))
+	}
+
+	if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := &Server{Key: key, Clients: peerKeys, Dir: *dir}
+		log.Fatal(server.Serve(l))
+	}
+	conn, err := net.Dial("tcp", *connect)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := &Client{Key: key, ServerKey: peerKeys[0]}
+	resumed, err := client.Send(conn, *file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("sent %s (resumed at chunk %d)\n", *file, resumed)
+}
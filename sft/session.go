@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"ecdsa/internal/keyfile"
+)
+
+// The handshake authenticates both peers with their long-term ECDSA keys,
+// which must allow signing and verifying (see internal/keyfile), and agrees on session keys with ephemeral P-256 ECDH:
+//
+//	client: "SFT1" || client ephemeral key || client nonce
+//	server: server ephemeral key || server nonce || sig_server(transcript)
+//	client: sig_client(transcript)
+//
+// Each side signs the SHA-256 of a role label and everything sent so
+// far, so a signature cannot be replayed into another session or role.
+// One AES-256-GCM key per direction is derived with HKDF-SHA256 from the
+// shared secret, salted with the transcript hash.
+
+const (
+	protocolMagic = "SFT1"
+	nonceSize     = 32
+	pointSize     = 65
+	maxRecord     = 1 << 20
+)
+
+var ErrPeerAuth = errors.New("peer authentication failed")
+
+// Conn is an authenticated, encrypted connection. Records carry an
+// implicit sequence number in their nonce, so a dropped, replayed or
+// reordered record fails to decrypt.
+type Conn struct {
+	conn    net.Conn
+	send    cipher.AEAD
+	recv    cipher.AEAD
+	sendSeq uint64
+	recvSeq uint64
+	// Peer is the authenticated long-term key of the other side.
+	Peer *keyfile.VerifyingKey
+}
+
+func transcriptHash(label string, parts ...[]byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(label))
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func writeSigned(w io.Writer, key *keyfile.SigningKey, hash []byte, prefix []byte) ([]byte, error) {
+	sig, err := key.SignHash(keyfile.AlgECDSASHA256, hash)
+	if err != nil {
+		return nil, err
+	}
+	msg := append(bytes.Clone(prefix), 0, 0)
+	binary.BigEndian.PutUint16(msg[len(prefix):], uint16(len(sig)))
+	msg = append(msg, sig...)
+	_, err = w.Write(msg)
+	return msg, err
+}
+
+func readSignature(r io.Reader) ([]byte, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	sig := make([]byte, binary.BigEndian.Uint16(n[:]))
+	_, err := io.ReadFull(r, sig)
+	return sig, err
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sessionKeys(shared, salt []byte, client bool) (send, recv cipher.AEAD, err error) {
+	c2s, err := hkdf.Key(sha256.New, shared, salt, "sft client to server", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	s2c, err := hkdf.Key(sha256.New, shared, salt, "sft server to client", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	if send, err = newAEAD(c2s); err != nil {
+		return nil, nil, err
+	}
+	if recv, err = newAEAD(s2c); err != nil {
+		return nil, nil, err
+	}
+	if !client {
+		send, recv = recv, send
+	}
+	return send, recv, nil
+}
+
+// ClientHandshake authenticates conn as a client with key and accepts
+// only a server holding serverKey.
+func ClientHandshake(conn net.Conn, key *keyfile.SigningKey, serverKey *keyfile.VerifyingKey) (*Conn, error) {
+	eph, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hello := append([]byte(protocolMagic), eph.PublicKey().Bytes()...)
+	hello = append(hello, randomNonce()...)
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+
+	serverHello := make([]byte, pointSize+nonceSize)
+	if _, err := io.ReadFull(conn, serverHello); err != nil {
+		return nil, err
+	}
+	sig, err := readSignature(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := serverKey.VerifyHash(keyfile.AlgECDSASHA256, transcriptHash("sft server auth", hello, serverHello), sig); err != nil {
+		return nil, fmt.Errorf("server: %w: %v", ErrPeerAuth, err)
+	}
+	serverMsg := append(serverHello, 0, 0)
+	binary.BigEndian.PutUint16(serverMsg[len(serverHello):], uint16(len(sig)))
+	serverMsg = append(serverMsg, sig...)
+
+	if _, err := writeSigned(conn, key, transcriptHash("sft client auth", hello, serverMsg), nil); err != nil {
+		return nil, err
+	}
+	peerEph, err := ecdh.P256().NewPublicKey(serverHello[:pointSize])
+	if err != nil {
+		return nil, err
+	}
+	return finishHandshake(conn, eph, peerEph, transcriptHash("sft keys", hello, serverMsg), true, serverKey)
+}
+
+// ServerHandshake authenticates conn as a server with key and accepts
+// any client holding one of clients.
+func ServerHandshake(conn net.Conn, key *keyfile.SigningKey, clients []*keyfile.VerifyingKey) (*Conn, error) {
+	hello := make([]byte, len(protocolMagic)+pointSize+nonceSize)
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return nil, err
+	}
+	if string(hello[:len(protocolMagic)]) != protocolMagic {
+		return nil, errors.New("not an SFT client")
+	}
+	peerEph, err := ecdh.P256().NewPublicKey(hello[len(protocolMagic) : len(protocolMagic)+pointSize])
+	if err != nil {
+		return nil, err
+	}
+	eph, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverHello := append(eph.PublicKey().Bytes(), randomNonce()...)
+	serverMsg, err := writeSigned(conn, key, transcriptHash("sft server auth", hello, serverHello), serverHello)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := readSignature(conn)
+	if err != nil {
+		return nil, err
+	}
+	hash := transcriptHash("sft client auth", hello, serverMsg)
+	for _, client := range clients {
+		if client.VerifyHash(keyfile.AlgECDSASHA256, hash, sig) == nil {
+			return finishHandshake(conn, eph, peerEph, transcriptHash("sft keys", hello, serverMsg), false, client)
+		}
+	}
+	return nil, fmt.Errorf("client: %w", ErrPeerAuth)
+}
+
+func finishHandshake(conn net.Conn, eph *ecdh.PrivateKey, peerEph *ecdh.PublicKey, salt []byte, client bool, peer *keyfile.VerifyingKey) (*Conn, error) {
+	shared, err := eph.ECDH(peerEph)
+	if err != nil {
+		return nil, err
+	}
+	send, recv, err := sessionKeys(shared, salt, client)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, send: send, recv: recv, Peer: peer}, nil
+}
+
+func randomNonce() []byte {
+	b := make([]byte, nonceSize)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func seqNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// WriteRecord sends one typed, encrypted record.
+func (c *Conn) WriteRecord(typ byte, payload []byte) error {
+	if len(payload)+1 > maxRecord {
+		return errors.New("record too large")
+	}
+	sealed := c.send.Seal(nil, seqNonce(c.sendSeq), append([]byte{typ}, payload...), nil)
+	c.sendSeq++
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	_, err := c.conn.Write(append(frame, sealed...))
+	return err
+}
+
+// ReadRecord receives and authenticates the next record.
+func (c *Conn) ReadRecord() (byte, []byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(c.conn, n[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > maxRecord+uint32(c.recv.Overhead()) || size < uint32(c.recv.Overhead())+1 {
+		return 0, nil, errors.New("invalid record length")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, sealed); err != nil {
+		return 0, nil, unexpected(err)
+	}
+	plain, err := c.recv.Open(sealed[:0], seqNonce(c.recvSeq), sealed, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("record %d: %w", c.recvSeq, err)
+	}
+	c.recvSeq++
+	return plain[0], plain[1:], nil
+}
+
+func (c *Conn) Close() error { return c.conn.Close() }
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ecdsa/internal/keyfile"
+)
+
+func newSigningKey(t *testing.T) *keyfile.SigningKey {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keyfile.NewKeyRing().NewSigningKey(privateKey, keyfile.KeyMetadata{Created: time.Now(), Usage: []string{keyfile.UsageSign}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// startServer runs a server on a loopback port that accepts the given
+// clients, and returns its address and inbox.
+func startServer(t *testing.T, key *keyfile.SigningKey, clients ...*keyfile.SigningKey) (string, string) {
+	t.Helper()
+	server := &Server{Key: key, Dir: t.TempDir()}
+	for _, c := range clients {
+		server.Clients = append(server.Clients, c.Public())
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go server.Serve(l)
+	return l.Addr().String(), server.Dir
+}
+
+func writeFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	content := make([]byte, size)
+	rand.Read(content)
+	path := filepath.Join(t.TempDir(), "upload.bin")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, content
+}
+
+func checkReceived(t *testing.T, inbox string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(inbox, "upload.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("received file differs from the one sent")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	serverKey, clientKey := newSigningKey(t), newSigningKey(t)
+	addr, inbox := startServer(t, serverKey, clientKey)
+	client := &Client{Key: clientKey, ServerKey: serverKey.Public()}
+
+	path, content := writeFile(t, 3*ChunkSize+100)
+	resumed, err := sendRetrying(client, addr, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed != 0 {
+		t.Errorf("fresh upload resumed at chunk %d", resumed)
+	}
+	checkReceived(t, inbox, content)
+}
+
+func TestResumeAfterCut(t *testing.T) {
+	serverKey, clientKey := newSigningKey(t), newSigningKey(t)
+	addr, inbox := startServer(t, serverKey, clientKey)
+	client := &Client{Key: clientKey, ServerKey: serverKey.Public()}
+
+	path, content := writeFile(t, 3*ackEvery*ChunkSize)
+	_, err := sendRetrying(client, addr, path, func(conn net.Conn) net.Conn {
+		return &cutConn{Conn: conn, limit: len(content) / 2}
+	})
+	if err == nil {
+		t.Fatal("cut upload succeeded")
+	}
+	resumed, err := sendRetrying(client, addr, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed == 0 {
+		t.Error("upload after a cut started again from chunk 0")
+	}
+	checkReceived(t, inbox, content)
+}
+
+func TestSameNameRejected(t *testing.T) {
+	serverKey, aliceKey, bobKey := newSigningKey(t), newSigningKey(t), newSigningKey(t)
+	addr, inbox := startServer(t, serverKey, aliceKey, bobKey)
+	alice := &Client{Key: aliceKey, ServerKey: serverKey.Public()}
+	bob := &Client{Key: bobKey, ServerKey: serverKey.Public()}
+
+	path, content := writeFile(t, 2*ChunkSize)
+	if _, err := sendRetrying(alice, addr, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := writeFile(t, ChunkSize)
+	_, err := sendRetrying(bob, addr, other, nil)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("second upload of the same name: got %v, want an already exists error", err)
+	}
+	checkReceived(t, inbox, content)
+}
+
+func TestUnknownClientRejected(t *testing.T) {
+	serverKey, clientKey := newSigningKey(t), newSigningKey(t)
+	addr, inbox := startServer(t, serverKey)
+	client := &Client{Key: clientKey, ServerKey: serverKey.Public()}
+
+	path, _ := writeFile(t, ChunkSize)
+	if _, err := sendRetrying(client, addr, path, nil); err == nil {
+		t.Fatal("unknown client was allowed to upload")
+	}
+	if _, err := os.Stat(filepath.Join(inbox, "upload.bin")); err == nil {
+		t.Fatal("upload from an unknown client was written")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"ecdsa/internal/keyfile"
+)
+
+// After the handshake the client sends PUT with the file's name, size
+// and SHA-256. The server answers RESUME with the first chunk it has not
+// acknowledged before, the client streams DATA records from there, and
+// the server writes, syncs and acknowledges every ackEvery chunks. END
+// asks the server to check the whole file against the hash from PUT
+// before it is moved into place.
+//
+// Partial uploads are kept per client under Dir/.partial/<key fingerprint>, so
+// one client can never resume, extend or discard another's upload. A
+// name is received by one connection at a time and never replaces an
+// existing file: a second upload of the same name is rejected.
+
+const (
+	ChunkSize = 64 * 1024
+	ackEvery  = 16
+)
+
+// Record types.
+const (
+	msgPut    = 1
+	msgResume = 2
+	msgData   = 3
+	msgAck    = 4
+	msgEnd    = 5
+	msgDone   = 6
+	msgError  = 7
+)
+
+var ErrHashMismatch = errors.New("received file does not match its hash")
+
+type Client struct {
+	Key       *keyfile.SigningKey
+	ServerKey *keyfile.VerifyingKey
+}
+
+// Send transfers the file at path over conn and returns the chunk the
+// transfer resumed from.
+func (c *Client) Send(conn net.Conn, path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	size := uint64(fi.Size())
+
+	s, err := ClientHandshake(conn, c.Key, c.ServerKey)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+
+	put := binary.BigEndian.AppendUint64(nil, size)
+	put = append(put, h.Sum(nil)...)
+	put = append(put, filepath.Base(path)...)
+	if err := s.WriteRecord(msgPut, put); err != nil {
+		return 0, err
+	}
+	payload, err := expect(s, msgResume)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) != 8 {
+		return 0, errors.New("malformed RESUME")
+	}
+	start := binary.BigEndian.Uint64(payload)
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	if start > chunks {
+		return 0, errors.New("server resumes past the end of the file")
+	}
+
+	buf := make([]byte, 8+ChunkSize)
+	for i := start; i < chunks; i++ {
+		binary.BigEndian.PutUint64(buf, i)
+		n, err := f.ReadAt(buf[8:], int64(i*ChunkSize))
+		if err != nil && err != io.EOF {
+			return start, err
+		}
+		if err := s.WriteRecord(msgData, buf[:8+n]); err != nil {
+			return start, err
+		}
+		if (i+1)%ackEvery == 0 || i+1 == chunks {
+			payload, err := expect(s, msgAck)
+			if err != nil {
+				return start, err
+			}
+			if len(payload) != 8 || binary.BigEndian.Uint64(payload) != i+1 {
+				return start, errors.New("unexpected acknowledgement")
+			}
+		}
+	}
+	if err := s.WriteRecord(msgEnd, nil); err != nil {
+		return start, err
+	}
+	_, err = expect(s, msgDone)
+	return start, err
+}
+
+// expect reads the next record and fails unless it has type typ. An
+// ERROR record from the peer is returned as an error.
+func expect(s *Conn, typ byte) ([]byte, error) {
+	got, payload, err := s.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+	if got == msgError {
+		return nil, fmt.Errorf("peer: %s", payload)
+	}
+	if got != typ {
+		return nil, fmt.Errorf("unexpected record type %d", got)
+	}
+	return payload, nil
+}
+
+type Server struct {
+	Key     *keyfile.SigningKey
+	Clients []*keyfile.VerifyingKey
+	Dir     string
+
+	mu     sync.Mutex
+	active map[string]bool // destination files being received
+}
+
+// partState is kept next to a partial upload so an interrupted transfer
+// of the same file resumes after the last acknowledged chunk.
+type partState struct {
+	Size   uint64 `json:"size"`
+	SHA256 string `json:"sha256"`
+	Acked  uint64 `json:"acked"`
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.handle(conn); err != nil {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) error {
+	sc, err := ServerHandshake(conn, s.Key, s.Clients)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer sc.Close()
+	if err := s.receive(sc); err != nil {
+		sc.WriteRecord(msgError, []byte(err.Error()))
+		return err
+	}
+	return nil
+}
+
+func (s *Server) receive(sc *Conn) error {
+	put, err := expect(sc, msgPut)
+	if err != nil {
+		return err
+	}
+	if len(put) <= 8+sha256.Size {
+		return errors.New("malformed PUT")
+	}
+	size := binary.BigEndian.Uint64(put)
+	sum := put[8 : 8+sha256.Size]
+	name := string(put[8+sha256.Size:])
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	dst := filepath.Join(s.Dir, name)
+	if !s.claim(dst) {
+		return fmt.Errorf("%s is already being received", name)
+	}
+	defer s.release(dst)
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", name)
+	}
+	partDir := filepath.Join(s.Dir, ".partial", sc.Peer.Fingerprint())
+	if err := os.MkdirAll(partDir, 0700); err != nil {
+		return err
+	}
+	part := filepath.Join(partDir, name+".part")
+	statePath := part + ".json"
+
+	state := partState{Size: size, SHA256: hex.EncodeToString(sum)}
+	var prev partState
+	if data, err := os.ReadFile(statePath); err == nil && json.Unmarshal(data, &prev) == nil &&
+		prev.Size == state.Size && prev.SHA256 == state.SHA256 {
+		state.Acked = prev.Acked
+	}
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Anything written after the last acknowledgement is discarded.
+	if err := f.Truncate(int64(min(state.Acked*ChunkSize, size))); err != nil {
+		return err
+	}
+	if err := writeState(statePath, state); err != nil {
+		return err
+	}
+	if err := sc.WriteRecord(msgResume, binary.BigEndian.AppendUint64(nil, state.Acked)); err != nil {
+		return err
+	}
+
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	next := state.Acked
+	for {
+		typ, payload, err := sc.ReadRecord()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case msgData:
+			if len(payload) < 8 || binary.BigEndian.Uint64(payload) != next || next >= chunks {
+				return errors.New("chunk out of sequence")
+			}
+			data := payload[8:]
+			if want := min(size-next*ChunkSize, ChunkSize); uint64(len(data)) != want {
+				return fmt.Errorf("chunk %d has %d bytes, want %d", next, len(data), want)
+			}
+			if _, err := f.WriteAt(data, int64(next*ChunkSize)); err != nil {
+				return err
+			}
+			next++
+			if next%ackEvery == 0 || next == chunks {
+				if err := f.Sync(); err != nil {
+					return err
+				}
+				state.Acked = next
+				if err := writeState(statePath, state); err != nil {
+					return err
+				}
+				if err := sc.WriteRecord(msgAck, binary.BigEndian.AppendUint64(nil, next)); err != nil {
+					return err
+				}
+			}
+		case msgEnd:
+			if next != chunks {
+				return fmt.Errorf("END after %d of %d chunks", next, chunks)
+			}
+			if err := verifyFile(f, sum); err != nil {
+				os.Remove(part)
+				os.Remove(statePath)
+				return err
+			}
+			// Link fails rather than replace a file created since PUT by
+			// anything other than this server.
+			if err := os.Link(part, dst); err != nil {
+				return err
+			}
+			os.Remove(part)
+			os.Remove(statePath)
+			return sc.WriteRecord(msgDone, nil)
+		default:
+			return fmt.Errorf("unexpected record type %d", typ)
+		}
+	}
+}
+
+func (s *Server) claim(dst string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		s.active = map[string]bool{}
+	}
+	if s.active[dst] {
+		return false
+	}
+	s.active[dst] = true
+	return true
+}
+
+func (s *Server) release(dst string) {
+	s.mu.Lock()
+	delete(s.active, dst)
+	s.mu.Unlock()
+}
+
+func verifyFile(f *os.File, sum []byte) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, 1<<62)); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+func writeState(path string, state partState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Each subject's records are encrypted under that subject's own
+// AES-256 key. Subject keys are stored only wrapped by the master key
+// (KEK) with AES-256-GCM, nonce || ciphertext as in aes/, with the key
+// id and subject as additional data. Shredding deletes the wrapped key
+// from the store, so every record encrypted under it becomes
+// permanently unreadable, wherever copies of the records live.
+//
+// A shredded key survives in any backup of the key store taken before
+// the shred, so key store backups must be kept for less time than the
+// erasure deadline, unlike record backups, which need no special care.
+
+var (
+	ErrNoKey    = errors.New("no key for this record")
+	ErrShredded = errors.New("subject key was shredded; the record is unrecoverable")
+	ErrSubject  = errors.New("unknown subject")
+)
+
+const keyIDSize = 16
+
+type subjectKey struct {
+	ID      string    `json:"id"`
+	Wrapped string    `json:"wrapped"`
+	Created time.Time `json:"created"`
+}
+
+// storeFile is the key store on disk. Tombstones are kept by key id
+// only, so the store retains no identifier of an erased subject.
+type storeFile struct {
+	Subjects map[string]*subjectKey `json:"subjects"`
+	Shredded map[string]time.Time   `json:"shredded"`
+}
+
+type KeyManager struct {
+	Path string
+	kek  cipher.AEAD
+	mu   sync.Mutex
+	data storeFile
+	now  func() time.Time
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// NewKeyManager returns an empty in-memory key manager.
+func NewKeyManager(kek []byte) (*KeyManager, error) {
+	if len(kek) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyManager{
+		kek:  gcm,
+		data: storeFile{Subjects: map[string]*subjectKey{}, Shredded: map[string]time.Time{}},
+		now:  time.Now,
+	}, nil
+}
+
+// OpenKeyManager loads the key store at path, creating an empty one if
+// the file does not exist.
+func OpenKeyManager(path string, kek []byte) (*KeyManager, error) {
+	m, err := NewKeyManager(kek)
+	if err != nil {
+		return nil, err
+	}
+	m.Path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if m.data.Subjects == nil {
+		m.data.Subjects = map[string]*subjectKey{}
+	}
+	if m.data.Shredded == nil {
+		m.data.Shredded = map[string]time.Time{}
+	}
+	return m, nil
+}
+
+// save replaces the store file and syncs it and its directory, so a
+// shred is durable once Shred returns.
+func (m *KeyManager) save() error {
+	if m.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.Path); err != nil {
+		return err
+	}
+	// Persist the rename itself.
+	d, err := os.Open(filepath.Dir(m.Path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (m *KeyManager) wrap(subject, id string, key []byte) (string, error) {
+	nonce := make([]byte, m.kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(m.kek.Seal(nonce, nonce, key, []byte(id+"\x00"+subject))), nil
+}
+
+func (m *KeyManager) unwrap(subject string, k *subjectKey) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(k.Wrapped)
+	if err != nil || len(data) < m.kek.NonceSize() {
+		return nil, fmt.Errorf("key %s is corrupt", k.ID)
+	}
+	key, err := m.kek.Open(nil, data[:m.kek.NonceSize()], data[m.kek.NonceSize():], []byte(k.ID+"\x00"+subject))
+	if err != nil {
+		return nil, fmt.Errorf("key %s cannot be unwrapped: %w", k.ID, err)
+	}
+	return key, nil
+}
+
+// keyFor returns the subject's key, creating one if needed.
+func (m *KeyManager) keyFor(subject string, create bool) (*subjectKey, []byte, error) {
+	if k, ok := m.data.Subjects[subject]; ok {
+		key, err := m.unwrap(subject, k)
+		return k, key, err
+	}
+	if !create {
+		return nil, nil, ErrSubject
+	}
+	id := make([]byte, keyIDSize)
+	key := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	k := &subjectKey{ID: hex.EncodeToString(id), Created: m.now().UTC()}
+	var err error
+	if k.Wrapped, err = m.wrap(subject, k.ID, key); err != nil {
+		return nil, nil, err
+	}
+	m.data.Subjects[subject] = k
+	if err := m.save(); err != nil {
+		delete(m.data.Subjects, subject)
+		return nil, nil, err
+	}
+	return k, key, nil
+}
+
+// Shred destroys the subject's key. Records encrypted under it can no
+// longer be decrypted by anyone, including with the master key.
+func (m *KeyManager) Shred(subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.data.Subjects[subject]
+	if !ok {
+		return ErrSubject
+	}
+	delete(m.data.Subjects, subject)
+	m.data.Shredded[k.ID] = m.now().UTC()
+	if err := m.save(); err != nil {
+		m.data.Subjects[subject] = k
+		delete(m.data.Shredded, k.ID)
+		return err
+	}
+	return nil
+}
+
+// Subjects lists the subjects that have a key.
+func (m *KeyManager) Subjects() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.data.Subjects))
+	for s := range m.data.Subjects {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: shred [flags] <command> [flags]
+
+commands:
+  encrypt   encrypt -in as a record of -subject
+  decrypt   decrypt -in, a record of -subject
+  shred     destroy the key of -subject
+  list      print the subjects that have keys
+
+Subject keys in -store are wrapped with the hex AES-256 master key in
+$CRYPTO_SHRED_KEK. Without a command, a demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	storePath := flag.String("store", "subject-keys.json", "subject key store")
+	subject := flag.String("subject", "", "data subject")
+	in := flag.String("in", "", "input file (default stdin)")
+	out := flag.String("out", "", "output file (default stdout)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	kek, err := hex.DecodeString(os.Getenv("CRYPTO_SHRED_KEK"))
+	if err != nil {
+		log.Fatal("CRYPTO_SHRED_KEK: ", err)
+	}
+	m, err := OpenKeyManager(*storePath, kek)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cmd == "list" {
+		for _, s := range m.Subjects() {
+			fmt.Println(s)
+		}
+		return
+	}
+	if *subject == "" {
+		log.Fatal("-subject is required")
+	}
+	if cmd == "shred" {
+		if err := m.Shred(*subject); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var input []byte
+	if *in == "" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var output []byte
+	switch cmd {
+	case "encrypt":
+		output, err = m.Encrypt(*subject, input)
+	case "decrypt":
+		output, err = m.Decrypt(*subject, input)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(output)
+	} else if err := os.WriteFile(*out, output, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func demo() {
+	dir, err := os.MkdirTemp("", "shred")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	kek := make([]byte, 32)
+	rand.Read(kek)
+	m, err := OpenKeyManager(path, kek)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	type row struct {
+		subject string
+		record  []byte
+	}
+	var db []row
+	for _, r := range []struct{ subject, data string }{
+		{"alice", "alice@example.com, 12 Elm St"},
+		{"bob", "bob@example.com, 3 Oak Ave"},
+		{"alice", "order 1001: 2x umbrella"},
+		{"alice", "support ticket: lost password"},
+	} {
+		rec, err := m.Encrypt(r.subject, []byte(r.data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		db = append(db, row{r.subject, rec})
+	}
+	// A backup of the records, taken before the erasure request.
+	backup := append([]row(nil), db...)
+	before, _ := os.ReadFile(path)
+	aliceKey := m.data.Subjects["alice"]
+
+	if err := m.Shred("alice"); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("shredded alice's key", aliceKey.ID)
+
+	// Reload the store from disk, as any later process would.
+	m, err = OpenKeyManager(path, kek)
+	if err != nil {
+		log.Fatal(err)
+	}
+	after, _ := os.ReadFile(path)
+	fmt.Println("wrapped key was in the store:", strings.Contains(string(before), aliceKey.Wrapped))
+	fmt.Println("wrapped key is in the store: ", strings.Contains(string(after), aliceKey.Wrapped))
+	fmt.Println("store mentions alice:        ", strings.Contains(string(after), "alice"))
+
+	for _, r := range backup {
+		pt, err := m.Decrypt(r.subject, r.record)
+		if err != nil {
+			fmt.Printf("  %-5s %v\n", r.subject, err)
+			continue
+		}
+		fmt.Printf("  %-5s %q\n", r.subject, pt)
+	}
+
+	// Even holding the master key, no key left in the store opens
+	// alice's old records under any subject.
+	opened := 0
+	for subject, k := range m.data.Subjects {
+		key, err := m.unwrap(subject, k)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gcm, _ := newGCM(key)
+		for _, r := range backup {
+			if r.subject != "alice" {
+				continue
+			}
+			header, rest := r.record[:1+keyIDSize], r.record[1+keyIDSize:]
+			if _, err := gcm.Open(nil, rest[:12], rest[12:], recordAD(header, "alice")); err == nil {
+				opened++
+			}
+		}
+	}
+	fmt.Println("alice's records opened with any remaining key:", opened)
+
+	// If alice signs up again she gets a new key; her old data stays gone.
+	rec, _ := m.Encrypt("alice", []byte("alice@example.net"))
+	pt, err := m.Decrypt("alice", rec)
+	fmt.Printf("new alice record: %q %v\n", pt, err)
+	_, err = m.Decrypt("alice", backup[0].record)
+	fmt.Println("old alice record:", err)
+}
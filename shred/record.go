@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// A record is
+//
+//	version (1) || key id (16) || nonce (12) || AES-256-GCM ciphertext
+//
+// with the header and the subject as additional data, so a record
+// cannot be passed off as another subject's.
+const recordVersion = 1
+
+var ErrRecord = errors.New("record is malformed or was modified")
+
+func recordAD(header []byte, subject string) []byte {
+	return append(append([]byte(nil), header...), subject...)
+}
+
+// Encrypt encrypts a record of subject's data, creating the subject's
+// key on first use. After a shred, the subject gets a new key; records
+// from before the shred stay unreadable.
+func (m *KeyManager) Encrypt(subject string, plaintext []byte) ([]byte, error) {
+	m.mu.Lock()
+	k, key, err := m.keyFor(subject, true)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := hex.DecodeString(k.ID)
+	header := append([]byte{recordVersion}, id...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return gcm.Seal(out, nonce, plaintext, recordAD(header, subject)), nil
+}
+
+// Decrypt decrypts a record of subject's data. It returns ErrShredded
+// for records whose key was shredded.
+func (m *KeyManager) Decrypt(subject string, record []byte) ([]byte, error) {
+	if len(record) < 1+keyIDSize+12 || record[0] != recordVersion {
+		return nil, ErrRecord
+	}
+	id := hex.EncodeToString(record[1 : 1+keyIDSize])
+	m.mu.Lock()
+	_, shredded := m.data.Shredded[id]
+	k, key, err := m.keyFor(subject, false)
+	m.mu.Unlock()
+	if shredded {
+		return nil, ErrShredded
+	}
+	if err != nil && !errors.Is(err, ErrSubject) {
+		return nil, err
+	}
+	if err != nil || k.ID != id {
+		return nil, ErrNoKey
+	}
+	defer clear(key)
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := record[:1+keyIDSize]
+	rest := record[1+keyIDSize:]
+	pt, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], recordAD(header, subject))
+	if err != nil {
+		return nil, ErrRecord
+	}
+	return pt, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestShredSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	kek := make([]byte, 32)
+	rand.Read(kek)
+	m, err := OpenKeyManager(path, kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type record struct {
+		subject string
+		data    []byte
+		sealed  []byte
+	}
+	var records []record
+	for _, r := range []struct{ subject, data string }{
+		{"alice", "alice@example.com"},
+		{"bob", "bob@example.com"},
+		{"alice", "order 1001"},
+		{"carol", "carol@example.com"},
+	} {
+		sealed, err := m.Encrypt(r.subject, []byte(r.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record{r.subject, []byte(r.data), sealed})
+	}
+
+	if err := m.Shred("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Shred("alice"); !errors.Is(err, ErrSubject) {
+		t.Errorf("second shred: got %v, want ErrSubject", err)
+	}
+
+	m, err = OpenKeyManager(path, kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		got, err := m.Decrypt(r.subject, r.sealed)
+		if r.subject == "alice" {
+			if !errors.Is(err, ErrShredded) {
+				t.Errorf("alice's %q after shred: got %v, want ErrShredded", r.data, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, r.data) {
+			t.Errorf("%s's %q: got %q, %v", r.subject, r.data, got, err)
+		}
+	}
+
+	// No key left in the store, under any subject, opens alice's records.
+	for subject, k := range m.data.Subjects {
+		key, err := m.unwrap(subject, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			if r.subject != "alice" {
+				continue
+			}
+			if hex.EncodeToString(r.sealed[1:1+keyIDSize]) == k.ID {
+				t.Errorf("alice's record names %s's key %s", subject, k.ID)
+			}
+			header, rest := r.sealed[:1+keyIDSize], r.sealed[1+keyIDSize:]
+			if _, err := gcm.Open(nil, rest[:12], rest[12:], recordAD(header, "alice")); err == nil {
+				t.Errorf("%s's key opens alice's %q", subject, r.data)
+			}
+		}
+	}
+}
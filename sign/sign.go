@@ -4,25 +4,119 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"ecdsa/internal/config"
+	"ecdsa/internal/digest"
 )
 
+// Signature is an ASN.1 ECDSA signature together with the identifier of
+// the hash it was computed over. Its text form is "<hash>:<hex>".
+type Signature struct {
+	Hash  string
+	Value []byte
+}
+
+func (s Signature) String() string {
+	return s.Hash + ":" + hex.EncodeToString(s.Value)
+}
+
+func ParseSignature(text string) (Signature, error) {
+	alg, value, ok := strings.Cut(text, ":")
+	if !ok {
+		return Signature{}, errors.New("signature is missing the hash algorithm")
+	}
+	if !slices.Contains(digest.Algorithms(), alg) {
+		return Signature{}, fmt.Errorf("unsupported hash algorithm %q", alg)
+	}
+	sig, err := hex.DecodeString(value)
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{Hash: alg, Value: sig}, nil
+}
+
+func curveByName(name string) (elliptic.Curve, error) {
+	switch name {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported curve %q", name)
+}
+
 func main() {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	cfg, profile, err := config.LoadProfile("sign")
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag.String("curve", profile.Curve, "curve: P-256, P-384 or P-521")
+	flag.String("hash", profile.Hash, "digest algorithm: "+strings.Join(digest.Algorithms(), ", "))
+	flag.String("format", profile.Format, "output format: text or json")
+	file := flag.String("file", "", "sign the contents of this file instead of the demo message")
+	flag.Parse()
+	if profile, err = cfg.ResolveFlags(); err != nil {
+		log.Fatal(err)
+	}
+
+	curve, err := curveByName(profile.Curve)
+	if err != nil {
+		log.Fatal(err)
+	}
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		panic(err)
 	}
 
 	msg := "hello, world"
-	hash := sha256.Sum256([]byte(msg))
+	sum := func(alg string) ([]byte, error) {
+		if *file != "" {
+			return digest.SumFile(alg, *file)
+		}
+		return digest.Sum(alg, []byte(msg))
+	}
 
-	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, hash[:])
+	hash, err := sum(profile.Hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, hash)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Printf("signature: %x\n", sig)
+	encoded := Signature{Hash: profile.Hash, Value: sig}.String()
 
-	valid := ecdsa.VerifyASN1(&privateKey.PublicKey, hash[:], sig)
+	// The verifier learns the hash from the signature, not from a flag.
+	parsed, err := ParseSignature(encoded)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hash, err = sum(parsed.Hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	valid := ecdsa.VerifyASN1(&privateKey.PublicKey, hash, parsed.Value)
+
+	if profile.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]any{"curve": profile.Curve, "signature": encoded, "verified": valid}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Println("signature:", encoded)
 	fmt.Println("signature verified:", valid)
 }
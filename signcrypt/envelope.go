@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// An envelope is signed, then encrypted:
+//
+//	inner = sender id || len(sig) (2) || sig || plaintext
+//	sig   = ECDSA-P256-SHA256("SIGNCRYPT1" || sender id || recipient id || plaintext)
+//	envelope = version (1) || ephemeral key (65) || recipient id (32) ||
+//	           nonce (12) || AES-256-GCM(inner, ad = everything before)
+//
+// An id is the SHA-256 of a key's PKIX encoding. Because the signature
+// names the recipient, a recipient who decrypts a message and encrypts
+// it again to someone else cannot pass it off as sent to them by the
+// original sender: the new recipient finds a different id in the signed
+// data. The sender's id and signature are encrypted, so only the
+// recipient learns who sent the message. The GCM key comes from
+// HKDF-SHA256 over an ephemeral-static P-256 ECDH secret, salted with
+// both public keys; the recipient's ECDSA key pair doubles as its ECDH
+// key pair.
+const (
+	envelopeVersion = 1
+	idSize          = sha256.Size
+	pointSize       = 65
+	headerSize      = 1 + pointSize + idSize
+	signLabel       = "SIGNCRYPT1"
+)
+
+var (
+	ErrNotForUs      = errors.New("envelope is addressed to another recipient")
+	ErrDecrypt       = errors.New("envelope cannot be decrypted or was modified")
+	ErrUnknownSender = errors.New("envelope is from an unknown sender")
+	ErrSignature     = errors.New("sender signature is invalid")
+)
+
+type ID [idSize]byte
+
+func (id ID) String() string { return fmt.Sprintf("%x", id[:8]) }
+
+// KeyID returns the identity bound into envelopes for key.
+func KeyID(key *ecdsa.PublicKey) (ID, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ID{}, err
+	}
+	return sha256.Sum256(der), nil
+}
+
+func signedData(sender, recipient ID, plaintext []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(signLabel))
+	h.Write(sender[:])
+	h.Write(recipient[:])
+	h.Write(plaintext)
+	return h.Sum(nil)
+}
+
+func envelopeKey(shared, eph, recipient []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, shared, append(bytes.Clone(eph), recipient...), "signcrypt aes-256-gcm", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal signs plaintext with sender's key and encrypts it to recipient.
+func Seal(sender *ecdsa.PrivateKey, recipient *ecdsa.PublicKey, plaintext []byte) ([]byte, error) {
+	senderID, err := KeyID(&sender.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	recipientID, err := KeyID(recipient)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, sender, signedData(senderID, recipientID, plaintext))
+	if err != nil {
+		return nil, err
+	}
+	inner := append(senderID[:], 0, 0)
+	binary.BigEndian.PutUint16(inner[idSize:], uint16(len(sig)))
+	inner = append(append(inner, sig...), plaintext...)
+	return encrypt(recipient, recipientID, inner)
+}
+
+func encrypt(recipient *ecdsa.PublicKey, recipientID ID, inner []byte) ([]byte, error) {
+	recipientECDH, err := recipient.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	eph, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(recipientECDH)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := envelopeKey(shared, eph.PublicKey().Bytes(), recipientECDH.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{envelopeVersion}, eph.PublicKey().Bytes()...)
+	header = append(header, recipientID[:]...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return gcm.Seal(out, nonce, inner, header), nil
+}
+
+// Recipient returns the id an envelope is addressed to, for picking the
+// decryption key.
+func Recipient(envelope []byte) (ID, error) {
+	if len(envelope) < headerSize || envelope[0] != envelopeVersion {
+		return ID{}, ErrDecrypt
+	}
+	return ID(envelope[1+pointSize : headerSize]), nil
+}
+
+func decrypt(recipient *ecdsa.PrivateKey, recipientID ID, envelope []byte) ([]byte, error) {
+	to, err := Recipient(envelope)
+	if err != nil {
+		return nil, err
+	}
+	if to != recipientID {
+		return nil, ErrNotForUs
+	}
+	priv, err := recipient.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	eph, err := ecdh.P256().NewPublicKey(envelope[1 : 1+pointSize])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	shared, err := priv.ECDH(eph)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	gcm, err := envelopeKey(shared, eph.Bytes(), priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	rest := envelope[headerSize:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	inner, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], envelope[:headerSize])
+	if err != nil || len(inner) < idSize+2 {
+		return nil, ErrDecrypt
+	}
+	return inner, nil
+}
+
+// Open decrypts envelope with the recipient's key, looks up the sender's
+// public key by id and verifies the signature. It returns the plaintext
+// and the sender's key.
+func Open(recipient *ecdsa.PrivateKey, envelope []byte, senders func(ID) *ecdsa.PublicKey) ([]byte, *ecdsa.PublicKey, error) {
+	recipientID, err := KeyID(&recipient.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	inner, err := decrypt(recipient, recipientID, envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	senderID := ID(inner[:idSize])
+	n := int(binary.BigEndian.Uint16(inner[idSize:]))
+	if len(inner) < idSize+2+n {
+		return nil, nil, ErrDecrypt
+	}
+	sig, plaintext := inner[idSize+2:idSize+2+n], inner[idSize+2+n:]
+	sender := senders(senderID)
+	if sender == nil {
+		return nil, nil, fmt.Errorf("%w %s", ErrUnknownSender, senderID)
+	}
+	if id, err := KeyID(sender); err != nil || id != senderID {
+		return nil, nil, ErrUnknownSender
+	}
+	if !ecdsa.VerifyASN1(sender, signedData(senderID, recipientID, plaintext), sig) {
+		return nil, nil, ErrSignature
+	}
+	return plaintext, sender, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Keys are PEM files in the keys/ format: an SEC1 EC key in a
+// "PRIVATE KEY" block and a PKIX "PUBLIC KEY" block.
+
+func loadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("key is not a P-256 key")
+	}
+	return key, nil
+}
+
+func loadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != elliptic.P256() {
+		return nil, errors.New("key is not an ECDSA P-256 key")
+	}
+	return publicKey, nil
+}
+
+func writeKeyPair(name string) error {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	x509Encoded, _ := x509.MarshalECPrivateKey(privateKey)
+	x509EncodedPub, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err := os.WriteFile(name+".pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509Encoded}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(name+".pub.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: x509EncodedPub}), 0644)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: signcrypt [flags] <command> [flags]
+
+commands:
+  genkey <name>   write name.pem and name.pub.pem
+  seal            sign -in with -key and encrypt it to -to
+  open            decrypt -in with -key and verify it against -from
+
+Without a command, a demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	keyPath := flag.String("key", "", "own private key")
+	to := flag.String("to", "", "recipient public key")
+	from := flag.String("from", "", "comma-separated public keys of accepted senders")
+	in := flag.String("in", "", "input file (default stdin)")
+	out := flag.String("out", "", "output file (default stdout)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	if cmd == "genkey" {
+		if flag.NArg() != 1 {
+			usage()
+			os.Exit(2)
+		}
+		if err := writeKeyPair(flag.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	key, err := loadPrivateKey(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var input []byte
+	if *in == "" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var output []byte
+	switch cmd {
+	case "seal":
+		recipient, err := loadPublicKey(*to)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if output, err = Seal(key, recipient, input); err != nil {
+			log.Fatal(err)
+		}
+	case "open":
+		senders := map[ID]*ecdsa.PublicKey{}
+		for _, path := range strings.Split(*from, ",") {
+			pub, err := loadPublicKey(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			id, _ := KeyID(pub)
+			senders[id] = pub
+		}
+		var sender *ecdsa.PublicKey
+		output, sender, err = Open(key, input, func(id ID) *ecdsa.PublicKey { return senders[id] })
+		if err != nil {
+			log.Fatal(err)
+		}
+		id, _ := KeyID(sender)
+		fmt.Fprintln(os.Stderr, "signed by", id)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if *out == "" {
+		os.Stdout.Write(output)
+	} else if err := os.WriteFile(*out, output, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func demo() {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return key
+	}
+	alice, bob, carol, mallory := newKey(), newKey(), newKey(), newKey()
+	directory := map[ID]*ecdsa.PublicKey{}
+	names := map[*ecdsa.PublicKey]string{}
+	for name, k := range map[string]*ecdsa.PrivateKey{"alice": alice, "bob": bob, "carol": carol, "mallory": mallory} {
+		id, _ := KeyID(&k.PublicKey)
+		directory[id] = &k.PublicKey
+		names[&k.PublicKey] = name
+	}
+	lookup := func(id ID) *ecdsa.PublicKey { return directory[id] }
+
+	msg := []byte("Bob, the merger is off. -- Alice")
+	env, err := Seal(alice, &bob.PublicKey, msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("envelope: %d bytes for a %d byte message\n", len(env), len(msg))
+
+	open := func(label string, key *ecdsa.PrivateKey, env []byte, senders func(ID) *ecdsa.PublicKey) {
+		pt, sender, err := Open(key, env, senders)
+		if err != nil {
+			fmt.Printf("%-34s %v\n", label+":", err)
+			return
+		}
+		fmt.Printf("%-34s %q from %s\n", label+":", pt, names[sender])
+	}
+	open("bob opens", bob, env, lookup)
+	open("carol opens bob's envelope", carol, env, lookup)
+
+	tampered := append([]byte(nil), env...)
+	tampered[len(tampered)-20] ^= 1
+	open("bob opens a modified envelope", bob, tampered, lookup)
+	open("bob does not know alice", bob, env, func(ID) *ecdsa.PublicKey { return nil })
+
+	// Bob decrypts and re-encrypts Alice's signed message to Carol, trying
+	// to make Carol believe Alice wrote to her.
+	bobID, _ := KeyID(&bob.PublicKey)
+	carolID, _ := KeyID(&carol.PublicKey)
+	inner, err := decrypt(bob, bobID, env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	forwarded, _ := encrypt(&carol.PublicKey, carolID, inner)
+	open("carol opens bob's forward", carol, forwarded, lookup)
+
+	// Mallory cannot claim Alice's message without decrypting it, and a
+	// message she signs herself is attributed to her.
+	fake, _ := Seal(mallory, &bob.PublicKey, []byte("Bob, the merger is on. -- Alice"))
+	open("bob opens mallory's message", bob, fake, lookup)
+}
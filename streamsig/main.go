@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+)
+
+// frameLog records each frame the signer writes so the demo can tamper
+// with the stream frame by frame.
+type frameLog struct {
+	frames [][]byte
+}
+
+func (l *frameLog) Write(p []byte) (int, error) {
+	l.frames = append(l.frames, bytes.Clone(p))
+	return len(p), nil
+}
+
+func (l *frameLog) stream(frames [][]byte) io.Reader {
+	return bytes.NewReader(slices.Concat(frames...))
+}
+
+func main() {
+	chunks := flag.Int("chunks", 100, "telemetry readings to stream")
+	every := flag.Int("every", 16, "chunks per signature")
+	flag.Parse()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	publicKey := &privateKey.PublicKey
+
+	var sent bytes.Buffer
+	for i := 0; i < *chunks; i++ {
+		fmt.Fprintf(&sent, "t=%d temp=%.1f\n", i, 20+float64(i%7)/2)
+	}
+	record := func() *frameLog {
+		var rec frameLog
+		signer, err := NewSigner(&rec, privateKey, *every, time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, reading := range bytes.SplitAfter(sent.Bytes(), []byte("\n")) {
+			if len(reading) == 0 {
+				continue
+			}
+			if _, err := signer.Write(reading); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := signer.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return &rec
+	}
+	rec := record()
+	// A second stream of the same data under the same key.
+	other := record()
+	sigs := len(rec.frames) - 1 - *chunks
+	fmt.Printf("%d chunks, %d signatures\n", *chunks, sigs)
+
+	verifier := func(r io.Reader, key *ecdsa.PublicKey) *Verifier {
+		v, err := NewVerifier(r, key, 4**every)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return v
+	}
+	got, err := io.ReadAll(verifier(rec.stream(rec.frames), publicKey))
+	fmt.Println("intact stream verified:", err == nil && bytes.Equal(got, sent.Bytes()))
+
+	// Frame 0 is the header; data chunk i is frame 1 + i + signatures before it.
+	tamper := map[string]func([][]byte) [][]byte{
+		"dropped chunk": func(f [][]byte) [][]byte { return slices.Delete(f, 3, 4) },
+		"duplicate chunk": func(f [][]byte) [][]byte {
+			return slices.Insert(f, 4, f[3])
+		},
+		"reordered chunks": func(f [][]byte) [][]byte {
+			f[3], f[4] = f[4], f[3]
+			return f
+		},
+		"modified chunk": func(f [][]byte) [][]byte {
+			f[5] = bytes.Clone(f[5])
+			f[5][len(f[5])-2] ^= 1
+			return f
+		},
+		"truncated stream": func(f [][]byte) [][]byte { return f[:len(f)-1] },
+		"signature from another stream": func(f [][]byte) [][]byte {
+			f[1+*every] = other.frames[1+*every]
+			return f
+		},
+	}
+	for _, name := range []string{"dropped chunk", "duplicate chunk", "reordered chunks", "modified chunk", "truncated stream", "signature from another stream"} {
+		frames := tamper[name](slices.Clone(rec.frames))
+		n, err := io.Copy(io.Discard, verifier(rec.stream(frames), publicKey))
+		fmt.Printf("%s: %d bytes released, then %v\n", name, n, err)
+		if err == nil {
+			log.Fatal("tampering was not detected")
+		}
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = io.Copy(io.Discard, verifier(rec.stream(rec.frames), &otherKey.PublicKey))
+	fmt.Println("wrong key:", errors.Is(err, ErrBadSignature))
+
+	_, err = NewVerifier(rec.stream(rec.frames), publicKey, 0)
+	fmt.Println("no buffer:", err)
+}
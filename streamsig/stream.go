@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A signed stream is a header followed by data and signature frames:
+//
+//	header:    "SSIG" || version || 16-byte stream id
+//	data:      'D' || seq u64 || length u32 || data
+//	signature: 'S' || final u8 || count u64 || head || length u16 || ASN.1 signature
+//
+// Every chunk extends a SHA-256 hash chain that starts from the stream
+// id, so one signature over the chain head authenticates every chunk
+// since the previous signature as well as their order. The signer emits
+// a signature every few chunks or after a time interval, whichever
+// comes first; the final signature marks the end of the stream.
+
+const (
+	magic       = "SSIG"
+	version     = 1
+	streamIDLen = 16
+	MaxChunk    = 1 << 20
+
+	frameData      = 'D'
+	frameSignature = 'S'
+)
+
+var (
+	ErrDropped      = errors.New("stream: chunk missing")
+	ErrDuplicate    = errors.New("stream: duplicate chunk")
+	ErrReordered    = errors.New("stream: chunks out of order")
+	ErrBadSignature = errors.New("stream: invalid signature")
+	ErrTruncated    = errors.New("stream: ended without a final signature")
+	ErrUnsigned     = errors.New("stream: too many unsigned chunks")
+)
+
+func chainStart(id []byte) [32]byte {
+	return sha256.Sum256(append([]byte("streamsig chain"), id...))
+}
+
+func chainNext(head [32]byte, seq uint64, data []byte) [32]byte {
+	h := sha256.New()
+	h.Write(head[:])
+	binary.Write(h, binary.BigEndian, seq)
+	h.Write(data)
+	var out [32]byte
+	h.Sum(out[:0])
+	return out
+}
+
+// signedDigest is what each signature covers.
+func signedDigest(id []byte, final bool, count uint64, head [32]byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("streamsig signature"))
+	h.Write(id)
+	if final {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	binary.Write(h, binary.BigEndian, count)
+	h.Write(head[:])
+	return h.Sum(nil)
+}
+
+// Signer writes a signed stream. Each Write is one chunk.
+type Signer struct {
+	w        io.Writer
+	key      *ecdsa.PrivateKey
+	id       []byte
+	head     [32]byte
+	count    uint64
+	unsigned int
+	every    int
+	interval time.Duration
+	lastSig  time.Time
+	closed   bool
+}
+
+// NewSigner starts a stream on w signed with key, with a signature after
+// every chunks or once interval has passed since the last one.
+func NewSigner(w io.Writer, key *ecdsa.PrivateKey, every int, interval time.Duration) (*Signer, error) {
+	if every < 1 {
+		return nil, errors.New("stream: signature interval must be at least one chunk")
+	}
+	id := make([]byte, streamIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	hdr := append([]byte(magic), version)
+	if _, err := w.Write(append(hdr, id...)); err != nil {
+		return nil, err
+	}
+	return &Signer{w: w, key: key, id: id, head: chainStart(id), every: every, interval: interval, lastSig: time.Now()}, nil
+}
+
+func (s *Signer) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("stream: write after close")
+	}
+	if len(p) > MaxChunk {
+		return 0, fmt.Errorf("stream: chunk larger than %d bytes", MaxChunk)
+	}
+	frame := []byte{frameData}
+	frame = binary.BigEndian.AppendUint64(frame, s.count)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(p)))
+	if _, err := s.w.Write(append(frame, p...)); err != nil {
+		return 0, err
+	}
+	s.head = chainNext(s.head, s.count, p)
+	s.count++
+	s.unsigned++
+	if s.unsigned >= s.every || (s.interval > 0 && time.Since(s.lastSig) >= s.interval) {
+		if err := s.sign(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush signs the chunks written since the last signature, so the
+// verifier can release them without waiting for more data.
+func (s *Signer) Flush() error {
+	if s.unsigned == 0 {
+		return nil
+	}
+	return s.sign(false)
+}
+
+// Close writes the final signature. It does not close the underlying
+// writer.
+func (s *Signer) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.sign(true)
+}
+
+func (s *Signer) sign(final bool) error {
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, signedDigest(s.id, final, s.count, s.head))
+	if err != nil {
+		return err
+	}
+	frame := []byte{frameSignature, 0}
+	if final {
+		frame[1] = 1
+	}
+	frame = binary.BigEndian.AppendUint64(frame, s.count)
+	frame = append(frame, s.head[:]...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(sig)))
+	if _, err := s.w.Write(append(frame, sig...)); err != nil {
+		return err
+	}
+	s.unsigned = 0
+	s.lastSig = time.Now()
+	return nil
+}
+
+// Verifier reads a signed stream and returns chunk data only once a
+// signature covering it has been verified.
+type Verifier struct {
+	r          *bufio.Reader
+	key        *ecdsa.PublicKey
+	id         []byte
+	head       [32]byte // chain head at the last verified signature
+	count      uint64   // chunks received
+	pending    [][]byte // received, not yet covered by a signature
+	maxPending int
+	buf        []byte
+	err        error
+}
+
+type frame struct {
+	typ   byte
+	seq   uint64 // data: sequence number; signature: chunk count
+	data  []byte
+	final bool
+	head  [32]byte
+	sig   []byte
+}
+
+// NewVerifier verifies the stream from r against key. At most
+// maxPending chunks are buffered while waiting for a signature; it must
+// be at least the signer's chunks per signature.
+func NewVerifier(r io.Reader, key *ecdsa.PublicKey, maxPending int) (*Verifier, error) {
+	if maxPending < 1 {
+		return nil, errors.New("stream: verifier must buffer at least one chunk")
+	}
+	return &Verifier{r: bufio.NewReader(r), key: key, maxPending: maxPending}, nil
+}
+
+func (v *Verifier) Read(p []byte) (int, error) {
+	for len(v.buf) == 0 {
+		if v.err != nil {
+			return 0, v.err
+		}
+		v.err = v.next()
+	}
+	n := copy(p, v.buf)
+	v.buf = v.buf[n:]
+	return n, nil
+}
+
+// next reads frames until a signature releases data or the stream ends.
+func (v *Verifier) next() error {
+	if v.id == nil {
+		hdr := make([]byte, len(magic)+1+streamIDLen)
+		if _, err := io.ReadFull(v.r, hdr); err != nil {
+			return unexpected(err)
+		}
+		if string(hdr[:len(magic)]) != magic || hdr[len(magic)] != version {
+			return errors.New("stream: not a signed stream")
+		}
+		v.id = hdr[len(magic)+1:]
+		v.head = chainStart(v.id)
+	}
+	for {
+		f, err := v.readFrame()
+		if err == io.EOF {
+			return ErrTruncated
+		}
+		if err != nil {
+			return err
+		}
+		switch f.typ {
+		case frameData:
+			if err := v.checkSeq(f.seq); err != nil {
+				return err
+			}
+			if len(v.pending) >= v.maxPending {
+				return ErrUnsigned
+			}
+			v.pending = append(v.pending, f.data)
+			v.count++
+		case frameSignature:
+			if f.seq != v.count {
+				return fmt.Errorf("%w: signature covers %d chunks, received %d", ErrDropped, f.seq, v.count)
+			}
+			head := v.head
+			for i, data := range v.pending {
+				head = chainNext(head, v.count-uint64(len(v.pending)-i), data)
+			}
+			if head != f.head || !ecdsa.VerifyASN1(v.key, signedDigest(v.id, f.final, f.seq, f.head), f.sig) {
+				return ErrBadSignature
+			}
+			v.head = head
+			for _, data := range v.pending {
+				v.buf = append(v.buf, data...)
+			}
+			v.pending = v.pending[:0]
+			if f.final {
+				return io.EOF
+			}
+			if len(v.buf) > 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// checkSeq classifies an unexpected sequence number. A gap is a drop
+// unless the missing chunk turns up in the next frame.
+func (v *Verifier) checkSeq(seq uint64) error {
+	switch {
+	case seq == v.count:
+		return nil
+	case seq < v.count:
+		return fmt.Errorf("%w: chunk %d", ErrDuplicate, seq)
+	}
+	f, err := v.readFrame()
+	if err == nil && f.typ == frameData && f.seq >= v.count && f.seq < seq {
+		return fmt.Errorf("%w: chunk %d before chunk %d", ErrReordered, seq, f.seq)
+	}
+	return fmt.Errorf("%w: expected chunk %d, got %d", ErrDropped, v.count, seq)
+}
+
+func (v *Verifier) readFrame() (*frame, error) {
+	typ, err := v.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	f := &frame{typ: typ}
+	switch typ {
+	case frameData:
+		var hdr [12]byte
+		if _, err := io.ReadFull(v.r, hdr[:]); err != nil {
+			return nil, unexpected(err)
+		}
+		f.seq = binary.BigEndian.Uint64(hdr[:])
+		n := binary.BigEndian.Uint32(hdr[8:])
+		if n > MaxChunk {
+			return nil, errors.New("stream: chunk too large")
+		}
+		f.data = make([]byte, n)
+		if _, err := io.ReadFull(v.r, f.data); err != nil {
+			return nil, unexpected(err)
+		}
+	case frameSignature:
+		var hdr [1 + 8 + 32 + 2]byte
+		if _, err := io.ReadFull(v.r, hdr[:]); err != nil {
+			return nil, unexpected(err)
+		}
+		f.final = hdr[0] == 1
+		f.seq = binary.BigEndian.Uint64(hdr[1:])
+		copy(f.head[:], hdr[9:41])
+		f.sig = make([]byte, binary.BigEndian.Uint16(hdr[41:]))
+		if _, err := io.ReadFull(v.r, f.sig); err != nil {
+			return nil, unexpected(err)
+		}
+	default:
+		return nil, fmt.Errorf("stream: unknown frame type %q", typ)
+	}
+	return f, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
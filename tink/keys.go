@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Key types, by the type URL Tink stores in KeyData, and their protobuf
+// messages:
+//
+//	AesGcmKey         version = 1, key_value = 3
+//	AesSivKey         version = 1, key_value = 2 (64 bytes)
+//	EcdsaPublicKey    version = 1, params = 2, x = 3, y = 4
+//	EcdsaParams       hash_type = 1, curve = 2, encoding = 3
+//	EcdsaPrivateKey   version = 1, public_key = 2, key_value = 3
+//	Ed25519PublicKey  version = 1, key_value = 2
+//	Ed25519PrivateKey version = 1, key_value = 2 (seed), public_key = 3
+//
+// ECDSA integers are big-endian and may carry a leading zero byte, as
+// Java's BigInteger writes them.
+
+const typeURLPrefix = "type.googleapis.com/google.crypto.tink."
+
+const (
+	aesGCMTypeURL         = typeURLPrefix + "AesGcmKey"
+	aesSIVTypeURL         = typeURLPrefix + "AesSivKey"
+	ecdsaPrivateTypeURL   = typeURLPrefix + "EcdsaPrivateKey"
+	ecdsaPublicTypeURL    = typeURLPrefix + "EcdsaPublicKey"
+	ed25519PrivateTypeURL = typeURLPrefix + "Ed25519PrivateKey"
+	ed25519PublicTypeURL  = typeURLPrefix + "Ed25519PublicKey"
+)
+
+// EcdsaParams enum values.
+const (
+	hashSHA384 = 2
+	hashSHA256 = 3
+	hashSHA512 = 4
+
+	curveP256 = 2
+	curveP384 = 3
+
+	encodingP1363 = 1
+	encodingDER   = 2
+)
+
+type template struct {
+	typeURL  string
+	material KeyMaterial
+	prefix   OutputPrefix
+	generate func() ([]byte, error)
+}
+
+// templates are named after Tink's predefined key templates.
+var templates = map[string]template{
+	"AES128_GCM":        {aesGCMTypeURL, MaterialSymmetric, PrefixTink, newAESKey(aesGCMTypeURL, 16)},
+	"AES256_GCM":        {aesGCMTypeURL, MaterialSymmetric, PrefixTink, newAESKey(aesGCMTypeURL, 32)},
+	"AES256_GCM_RAW":    {aesGCMTypeURL, MaterialSymmetric, PrefixRaw, newAESKey(aesGCMTypeURL, 32)},
+	"AES256_SIV":        {aesSIVTypeURL, MaterialSymmetric, PrefixTink, newAESKey(aesSIVTypeURL, 64)},
+	"ECDSA_P256":        {ecdsaPrivateTypeURL, MaterialAsymmetricPrivate, PrefixTink, newECDSAKey(curveP256, hashSHA256, encodingDER)},
+	"ECDSA_P256_RAW":    {ecdsaPrivateTypeURL, MaterialAsymmetricPrivate, PrefixRaw, newECDSAKey(curveP256, hashSHA256, encodingP1363)},
+	"ECDSA_P384_SHA384": {ecdsaPrivateTypeURL, MaterialAsymmetricPrivate, PrefixTink, newECDSAKey(curveP384, hashSHA384, encodingDER)},
+	"ECDSA_P384_SHA512": {ecdsaPrivateTypeURL, MaterialAsymmetricPrivate, PrefixTink, newECDSAKey(curveP384, hashSHA512, encodingDER)},
+	"ED25519":           {ed25519PrivateTypeURL, MaterialAsymmetricPrivate, PrefixTink, newEd25519Key},
+	"ED25519_RAW":       {ed25519PrivateTypeURL, MaterialAsymmetricPrivate, PrefixRaw, newEd25519Key},
+}
+
+func newAESKey(typeURL string, size int) func() ([]byte, error) {
+	field := 3
+	if typeURL == aesSIVTypeURL {
+		field = 2
+	}
+	return func() ([]byte, error) {
+		key := make([]byte, size)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return marshalProto(func(w *pbWriter) { w.bytes(field, key) }), nil
+	}
+}
+
+func newECDSAKey(curve, hash, encoding uint64) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		c, _, err := ecdsaParams(curve, hash)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		point, err := key.PublicKey.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		scalar, err := key.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		size := len(scalar)
+		public := marshalProto(func(w *pbWriter) {
+			w.message(2, func(w *pbWriter) {
+				w.uint(1, hash)
+				w.uint(2, curve)
+				w.uint(3, encoding)
+			})
+			w.bytes(3, append([]byte{0}, point[1:1+size]...))
+			w.bytes(4, append([]byte{0}, point[1+size:]...))
+		})
+		return marshalProto(func(w *pbWriter) {
+			w.bytes(2, public)
+			w.bytes(3, append([]byte{0}, scalar...))
+		}), nil
+	}
+}
+
+func newEd25519Key() ([]byte, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return marshalProto(func(w *pbWriter) {
+		w.bytes(2, priv.Seed())
+		w.message(3, func(w *pbWriter) { w.bytes(2, pub) })
+	}), nil
+}
+
+func ecdsaParams(curve, hash uint64) (elliptic.Curve, crypto.Hash, error) {
+	var c elliptic.Curve
+	switch curve {
+	case curveP256:
+		c = elliptic.P256()
+	case curveP384:
+		c = elliptic.P384()
+	default:
+		return nil, 0, fmt.Errorf("tink: unsupported ECDSA curve %d", curve)
+	}
+	var h crypto.Hash
+	switch hash {
+	case hashSHA256:
+		h = crypto.SHA256
+	case hashSHA384:
+		h = crypto.SHA384
+	case hashSHA512:
+		h = crypto.SHA512
+	default:
+		return nil, 0, fmt.Errorf("tink: unsupported ECDSA hash %d", hash)
+	}
+	return c, h, nil
+}
+
+// fixedInt strips the leading zeros of a big-endian integer and pads it
+// to size bytes.
+func fixedInt(b []byte, size int) ([]byte, error) {
+	b = bytes.TrimLeft(b, "\x00")
+	if len(b) > size {
+		return nil, errors.New("tink: ECDSA integer too large")
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out, nil
+}
+
+// parseBytesField returns field num of a key proto, checking the
+// version field is zero.
+func parseBytesField(b []byte, num int) ([]byte, error) {
+	var value []byte
+	err := parseProto(b, func(f pbField) error {
+		switch {
+		case f.num == 1 && f.v != 0:
+			return fmt.Errorf("tink: unsupported key version %d", f.v)
+		case f.num == num:
+			value = f.b
+		}
+		return nil
+	})
+	return value, err
+}
+
+func parseECDSAPublicKey(b []byte) (*ecdsa.PublicKey, crypto.Hash, bool, error) {
+	var curve, hash, encoding uint64
+	var x, y []byte
+	err := parseProto(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			if f.v != 0 {
+				return fmt.Errorf("tink: unsupported key version %d", f.v)
+			}
+		case 2:
+			return parseProto(f.b, func(f pbField) error {
+				switch f.num {
+				case 1:
+					hash = f.v
+				case 2:
+					curve = f.v
+				case 3:
+					encoding = f.v
+				}
+				return nil
+			})
+		case 3:
+			x = f.b
+		case 4:
+			y = f.b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+	c, h, err := ecdsaParams(curve, hash)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if encoding != encodingDER && encoding != encodingP1363 {
+		return nil, 0, false, fmt.Errorf("tink: unsupported ECDSA encoding %d", encoding)
+	}
+	size := (c.Params().BitSize + 7) / 8
+	if x, err = fixedInt(x, size); err != nil {
+		return nil, 0, false, err
+	}
+	if y, err = fixedInt(y, size); err != nil {
+		return nil, 0, false, err
+	}
+	point := append(append([]byte{4}, x...), y...)
+	pub, err := ecdsa.ParseUncompressedPublicKey(c, point)
+	return pub, h, encoding == encodingDER, err
+}
+
+func parseECDSAPrivateKey(b []byte) (*ecdsa.PrivateKey, crypto.Hash, bool, error) {
+	public, err := parseBytesField(b, 2)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	scalar, err := parseBytesField(b, 3)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	pub, h, der, err := parseECDSAPublicKey(public)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if scalar, err = fixedInt(scalar, (pub.Curve.Params().BitSize+7)/8); err != nil {
+		return nil, 0, false, err
+	}
+	key, err := ecdsa.ParseRawPrivateKey(pub.Curve, scalar)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if !key.PublicKey.Equal(pub) {
+		return nil, 0, false, errors.New("tink: ECDSA private key does not match its public key")
+	}
+	return key, h, der, nil
+}
+
+func parseEd25519PrivateKey(b []byte) (ed25519.PrivateKey, error) {
+	seed, err := parseBytesField(b, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("tink: invalid Ed25519 private key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func parseEd25519PublicKey(b []byte) (ed25519.PublicKey, error) {
+	pub, err := parseBytesField(b, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("tink: invalid Ed25519 public key")
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+// publicKeyData returns the public half of a private key.
+func publicKeyData(d *KeyData) (*KeyData, error) {
+	switch d.TypeURL {
+	case ecdsaPrivateTypeURL:
+		pub, err := parseBytesField(d.Value, 2)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyData{TypeURL: ecdsaPublicTypeURL, Value: pub, Material: MaterialAsymmetricPublic}, nil
+	case ed25519PrivateTypeURL:
+		pub, err := parseBytesField(d.Value, 3)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyData{TypeURL: ed25519PublicTypeURL, Value: pub, Material: MaterialAsymmetricPublic}, nil
+	}
+	return nil, fmt.Errorf("tink: %s is not a private key", d.TypeURL)
+}
+
+// Public returns the keyset with every private key replaced by its
+// public key, for distribution to verifiers.
+func (ks *Keyset) Public() (*Keyset, error) {
+	pub := &Keyset{Primary: ks.Primary}
+	for _, k := range ks.Keys {
+		pk := *k
+		if k.Data != nil {
+			data, err := publicKeyData(k.Data)
+			if err != nil {
+				return nil, err
+			}
+			pk.Data = data
+		}
+		pub.Keys = append(pub.Keys, &pk)
+	}
+	return pub, nil
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// A Keyset mirrors google.crypto.tink.Keyset: every key carries its own
+// type, status, id and output prefix, and exactly one is primary. The
+// binary form is the protobuf encoding; the JSON form is what Tink's
+// JsonKeysetWriter produces.
+
+type KeyStatus int32
+
+const (
+	StatusEnabled   KeyStatus = 1
+	StatusDisabled  KeyStatus = 2
+	StatusDestroyed KeyStatus = 3
+)
+
+// OutputPrefix says what a key prepends to its ciphertexts and
+// signatures.
+type OutputPrefix int32
+
+const (
+	PrefixTink    OutputPrefix = 1 // 0x01 || key id
+	PrefixLegacy  OutputPrefix = 2 // 0x00 || key id; signatures cover data || 0x00
+	PrefixRaw     OutputPrefix = 3 // nothing
+	PrefixCrunchy OutputPrefix = 4 // 0x00 || key id
+)
+
+type KeyMaterial int32
+
+const (
+	MaterialSymmetric         KeyMaterial = 1
+	MaterialAsymmetricPrivate KeyMaterial = 2
+	MaterialAsymmetricPublic  KeyMaterial = 3
+	MaterialRemote            KeyMaterial = 4
+)
+
+var (
+	statusNames   = []string{"UNKNOWN_STATUS", "ENABLED", "DISABLED", "DESTROYED"}
+	prefixNames   = []string{"UNKNOWN_PREFIX", "TINK", "LEGACY", "RAW", "CRUNCHY"}
+	materialNames = []string{"UNKNOWN_KEYMATERIAL", "SYMMETRIC", "ASYMMETRIC_PRIVATE", "ASYMMETRIC_PUBLIC", "REMOTE"}
+)
+
+func enumName(names []string, v int32) string {
+	if v >= 0 && int(v) < len(names) {
+		return names[v]
+	}
+	return fmt.Sprint(v)
+}
+
+func enumValue(names []string, s string) (int32, error) {
+	for i, n := range names {
+		if n == s {
+			return int32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("tink: unknown enum value %q", s)
+}
+
+func (s KeyStatus) String() string    { return enumName(statusNames, int32(s)) }
+func (p OutputPrefix) String() string { return enumName(prefixNames, int32(p)) }
+func (m KeyMaterial) String() string  { return enumName(materialNames, int32(m)) }
+
+type KeyData struct {
+	TypeURL  string
+	Value    []byte // serialized key proto
+	Material KeyMaterial
+}
+
+type Key struct {
+	Data   *KeyData // nil once destroyed
+	Status KeyStatus
+	ID     uint32
+	Prefix OutputPrefix
+}
+
+type Keyset struct {
+	Primary uint32
+	Keys    []*Key
+}
+
+var (
+	ErrNoPrimary      = errors.New("tink: keyset has no enabled primary key")
+	ErrUnknownKey     = errors.New("tink: no key with that id")
+	ErrDecryption     = errors.New("tink: decryption failed")
+	ErrVerify         = errors.New("tink: invalid signature")
+	ErrWrongPrimitive = errors.New("tink: key type does not provide this primitive")
+)
+
+// prefix returns the bytes the key prepends to its output.
+func (k *Key) prefix() []byte {
+	switch k.Prefix {
+	case PrefixTink:
+		return binary.BigEndian.AppendUint32([]byte{1}, k.ID)
+	case PrefixLegacy, PrefixCrunchy:
+		return binary.BigEndian.AppendUint32([]byte{0}, k.ID)
+	}
+	return nil
+}
+
+func (ks *Keyset) key(id uint32) *Key {
+	for _, k := range ks.Keys {
+		if k.ID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// Validate applies Tink's keyset rules: unique ids, key material on
+// every live key, and an enabled primary.
+func (ks *Keyset) Validate() error {
+	seen := map[uint32]bool{}
+	for _, k := range ks.Keys {
+		if seen[k.ID] {
+			return fmt.Errorf("tink: duplicate key id %d", k.ID)
+		}
+		seen[k.ID] = true
+		if k.Status != StatusDestroyed && k.Data == nil {
+			return fmt.Errorf("tink: key %d has no key data", k.ID)
+		}
+		if k.Prefix < PrefixTink || k.Prefix > PrefixCrunchy {
+			return fmt.Errorf("tink: key %d has unknown output prefix", k.ID)
+		}
+	}
+	if p := ks.key(ks.Primary); p == nil || p.Status != StatusEnabled {
+		return ErrNoPrimary
+	}
+	return nil
+}
+
+// Info is the KeysetInfo stored next to an encrypted keyset: the same
+// keys without their material.
+func (ks *Keyset) info() *Keyset {
+	info := &Keyset{Primary: ks.Primary}
+	for _, k := range ks.Keys {
+		i := *k
+		if k.Data != nil {
+			i.Data = &KeyData{TypeURL: k.Data.TypeURL}
+		}
+		info.Keys = append(info.Keys, &i)
+	}
+	return info
+}
+
+// NewKeyset returns a keyset with one fresh key from template.
+func NewKeyset(template string) (*Keyset, error) {
+	ks := &Keyset{}
+	id, err := ks.Add(template)
+	if err != nil {
+		return nil, err
+	}
+	ks.Primary = id
+	return ks, nil
+}
+
+// Add generates a key from template under a fresh random id. The new
+// key is enabled but does not become primary until promoted, so that it
+// can be rolled out to every reader first.
+func (ks *Keyset) Add(template string) (uint32, error) {
+	t, ok := templates[template]
+	if !ok {
+		return 0, fmt.Errorf("tink: unknown key template %q", template)
+	}
+	value, err := t.generate()
+	if err != nil {
+		return 0, err
+	}
+	var id uint32
+	for id == 0 || ks.key(id) != nil {
+		var b [4]byte
+		rand.Read(b[:])
+		id = binary.BigEndian.Uint32(b[:])
+	}
+	ks.Keys = append(ks.Keys, &Key{
+		Data:   &KeyData{TypeURL: t.typeURL, Value: value, Material: t.material},
+		Status: StatusEnabled,
+		ID:     id,
+		Prefix: t.prefix,
+	})
+	return id, nil
+}
+
+// Promote makes an enabled key primary.
+func (ks *Keyset) Promote(id uint32) error {
+	k := ks.key(id)
+	if k == nil {
+		return ErrUnknownKey
+	}
+	if k.Status != StatusEnabled {
+		return fmt.Errorf("tink: key %d is %v", id, k.Status)
+	}
+	ks.Primary = id
+	return nil
+}
+
+// SetStatus disables, re-enables or destroys a key. The primary can
+// only be disabled or destroyed after another key is promoted.
+// Destroying drops the key material for good.
+func (ks *Keyset) SetStatus(id uint32, status KeyStatus) error {
+	k := ks.key(id)
+	if k == nil {
+		return ErrUnknownKey
+	}
+	if id == ks.Primary && status != StatusEnabled {
+		return fmt.Errorf("tink: key %d is primary", id)
+	}
+	if k.Status == StatusDestroyed && status != StatusDestroyed {
+		return fmt.Errorf("tink: key %d is destroyed", id)
+	}
+	k.Status = status
+	if status == StatusDestroyed {
+		k.Data = nil
+	}
+	return nil
+}
+
+// Binary form.
+
+func (d *KeyData) marshal(w *pbWriter) {
+	w.string(1, d.TypeURL)
+	w.bytes(2, d.Value)
+	w.uint(3, uint64(d.Material))
+}
+
+func (k *Key) marshal(w *pbWriter, info bool) {
+	if info {
+		// KeyInfo: type_url = 1, status = 2, key_id = 3, output_prefix_type = 4
+		if k.Data != nil {
+			w.string(1, k.Data.TypeURL)
+		}
+	} else if k.Data != nil {
+		w.message(1, k.Data.marshal)
+	}
+	w.uint(2, uint64(k.Status))
+	w.uint(3, uint64(k.ID))
+	w.uint(4, uint64(k.Prefix))
+}
+
+func (ks *Keyset) marshal(w *pbWriter, info bool) {
+	w.uint(1, uint64(ks.Primary))
+	for _, k := range ks.Keys {
+		w.message(2, func(w *pbWriter) { k.marshal(w, info) })
+	}
+}
+
+// MarshalBinary returns the protobuf encoding of the keyset.
+func (ks *Keyset) MarshalBinary() ([]byte, error) {
+	return marshalProto(func(w *pbWriter) { ks.marshal(w, false) }), nil
+}
+
+func parseKeyData(b []byte) (*KeyData, error) {
+	d := &KeyData{}
+	err := parseProto(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			d.TypeURL = string(f.b)
+		case 2:
+			d.Value = f.b
+		case 3:
+			d.Material = KeyMaterial(f.v)
+		}
+		return nil
+	})
+	return d, err
+}
+
+func parseKey(b []byte, info bool) (*Key, error) {
+	k := &Key{}
+	err := parseProto(b, func(f pbField) error {
+		var err error
+		switch f.num {
+		case 1:
+			if info {
+				k.Data = &KeyData{TypeURL: string(f.b)}
+			} else {
+				k.Data, err = parseKeyData(f.b)
+			}
+		case 2:
+			k.Status = KeyStatus(f.v)
+		case 3:
+			k.ID = uint32(f.v)
+		case 4:
+			k.Prefix = OutputPrefix(f.v)
+		}
+		return err
+	})
+	return k, err
+}
+
+func parseKeyset(b []byte, info bool) (*Keyset, error) {
+	ks := &Keyset{}
+	err := parseProto(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			ks.Primary = uint32(f.v)
+		case 2:
+			k, err := parseKey(f.b, info)
+			if err != nil {
+				return err
+			}
+			ks.Keys = append(ks.Keys, k)
+		}
+		return nil
+	})
+	return ks, err
+}
+
+// JSON form. Key ids are written unsigned; older Java writers used
+// signed 32-bit ids, which are accepted too.
+
+type jsonKeyData struct {
+	TypeURL  string `json:"typeUrl"`
+	Value    []byte `json:"value,omitempty"`
+	Material string `json:"keyMaterialType,omitempty"`
+}
+
+type jsonKey struct {
+	Data    *jsonKeyData `json:"keyData,omitempty"`
+	TypeURL string       `json:"typeUrl,omitempty"` // KeyInfo only
+	Status  string       `json:"status"`
+	ID      int64        `json:"keyId"`
+	Prefix  string       `json:"outputPrefixType"`
+}
+
+type jsonKeyset struct {
+	Primary int64      `json:"primaryKeyId"`
+	Keys    []*jsonKey `json:"key,omitempty"`
+	Info    []*jsonKey `json:"keyInfo,omitempty"`
+}
+
+type jsonEncryptedKeyset struct {
+	Encrypted []byte      `json:"encryptedKeyset"`
+	Info      *jsonKeyset `json:"keysetInfo,omitempty"`
+}
+
+func (ks *Keyset) toJSON(info bool) *jsonKeyset {
+	j := &jsonKeyset{Primary: int64(ks.Primary)}
+	for _, k := range ks.Keys {
+		jk := &jsonKey{Status: k.Status.String(), ID: int64(k.ID), Prefix: k.Prefix.String()}
+		switch {
+		case info && k.Data != nil:
+			jk.TypeURL = k.Data.TypeURL
+		case k.Data != nil:
+			jk.Data = &jsonKeyData{TypeURL: k.Data.TypeURL, Value: k.Data.Value, Material: k.Data.Material.String()}
+		}
+		if info {
+			j.Info = append(j.Info, jk)
+		} else {
+			j.Keys = append(j.Keys, jk)
+		}
+	}
+	return j
+}
+
+func (j *jsonKeyset) keyset() (*Keyset, error) {
+	ks := &Keyset{Primary: uint32(j.Primary)}
+	for _, jk := range append(j.Keys, j.Info...) {
+		k := &Key{ID: uint32(jk.ID)}
+		status, err := enumValue(statusNames, jk.Status)
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := enumValue(prefixNames, jk.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		k.Status, k.Prefix = KeyStatus(status), OutputPrefix(prefix)
+		switch {
+		case jk.Data != nil:
+			material, err := enumValue(materialNames, jk.Data.Material)
+			if err != nil {
+				return nil, err
+			}
+			k.Data = &KeyData{TypeURL: jk.Data.TypeURL, Value: jk.Data.Value, Material: KeyMaterial(material)}
+		case jk.TypeURL != "":
+			k.Data = &KeyData{TypeURL: jk.TypeURL}
+		}
+		ks.Keys = append(ks.Keys, k)
+	}
+	return ks, nil
+}
+
+func (ks *Keyset) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ks.toJSON(false))
+}
+
+func (ks *Keyset) UnmarshalJSON(b []byte) error {
+	var j jsonKeyset
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	parsed, err := j.keyset()
+	if err != nil {
+		return err
+	}
+	*ks = *parsed
+	return nil
+}
+
+// Reading and writing, in the clear or encrypted under a key-encryption
+// AEAD. An encrypted keyset is an EncryptedKeyset message: the AEAD
+// encryption of the binary keyset, bound to associatedData, plus the
+// cleartext KeysetInfo.
+
+const (
+	FormatJSON   = "json"
+	FormatBinary = "binary"
+)
+
+// WriteKeyset serializes ks in format. With a non-nil kek the keyset is
+// encrypted; otherwise it is written in the clear.
+func WriteKeyset(ks *Keyset, format string, kek AEAD, associatedData []byte) ([]byte, error) {
+	if err := ks.Validate(); err != nil {
+		return nil, err
+	}
+	if format != FormatJSON && format != FormatBinary {
+		return nil, fmt.Errorf("tink: unknown keyset format %q", format)
+	}
+	if kek == nil {
+		if format == FormatJSON {
+			return json.MarshalIndent(ks.toJSON(false), "", "  ")
+		}
+		return ks.MarshalBinary()
+	}
+	plain, _ := ks.MarshalBinary()
+	encrypted, err := kek.Encrypt(plain, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatJSON {
+		return json.MarshalIndent(&jsonEncryptedKeyset{Encrypted: encrypted, Info: ks.info().toJSON(true)}, "", "  ")
+	}
+	return marshalProto(func(w *pbWriter) {
+		w.bytes(2, encrypted)
+		w.message(3, func(w *pbWriter) { ks.marshal(w, true) })
+	}), nil
+}
+
+// ReadKeyset parses a keyset written by WriteKeyset or by Tink, telling
+// JSON from binary by the leading brace. A kek must be given exactly
+// when the keyset is encrypted: binary encrypted and cleartext keysets
+// cannot be told apart reliably.
+func ReadKeyset(data []byte, kek AEAD, associatedData []byte) (*Keyset, error) {
+	isJSON := bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+	var ks *Keyset
+	var err error
+	switch {
+	case kek == nil && isJSON:
+		var j jsonKeyset
+		if err := json.Unmarshal(data, &j); err != nil {
+			return nil, err
+		}
+		if j.Keys == nil && bytes.Contains(data, []byte(`"encryptedKeyset"`)) {
+			return nil, errors.New("tink: keyset is encrypted")
+		}
+		ks, err = j.keyset()
+	case kek == nil:
+		ks, err = parseKeyset(data, false)
+	default:
+		var encrypted []byte
+		if isJSON {
+			var j jsonEncryptedKeyset
+			if err := json.Unmarshal(data, &j); err != nil {
+				return nil, err
+			}
+			encrypted = j.Encrypted
+		} else {
+			err = parseProto(data, func(f pbField) error {
+				if f.num == 2 {
+					encrypted = f.b
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(encrypted) == 0 {
+			return nil, errors.New("tink: keyset is not encrypted")
+		}
+		var plain []byte
+		if plain, err = kek.Decrypt(encrypted, associatedData); err != nil {
+			return nil, fmt.Errorf("tink: decrypting keyset: %w", err)
+		}
+		ks, err = parseKeyset(plain, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ks, ks.Validate()
+}
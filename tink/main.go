@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The keyset file is JSON or binary, chosen by its extension unless
+// -format is given. With $CRYPTO_TINK_KEK set (hex AES key) keysets are
+// read and written encrypted under it, as Tink's KMS-backed keysets are.
+
+func kekFromEnv() (AEAD, error) {
+	v := os.Getenv("CRYPTO_TINK_KEK")
+	if v == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("CRYPTO_TINK_KEK: %w", err)
+	}
+	return newAESGCM(key)
+}
+
+func formatFor(path, format string) string {
+	if format != "" {
+		return format
+	}
+	if filepath.Ext(path) == ".json" {
+		return FormatJSON
+	}
+	return FormatBinary
+}
+
+func readFile(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func writeFile(path string, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func printKeyset(ks *Keyset) {
+	sort.Slice(ks.Keys, func(i, j int) bool { return ks.Keys[i].ID < ks.Keys[j].ID })
+	for _, k := range ks.Keys {
+		primary := " "
+		if k.ID == ks.Primary {
+			primary = "*"
+		}
+		typ := "(destroyed)"
+		if k.Data != nil {
+			typ = strings.TrimPrefix(k.Data.TypeURL, typeURLPrefix)
+		}
+		fmt.Printf("%s %10d  %-9v %-7v %s\n", primary, k.ID, k.Status, k.Prefix, typ)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: tink [flags] <command> [flags]
+
+commands:
+  generate -template T      create -keyset with one key
+  add -template T           add a key (not yet primary)
+  promote|enable|disable|destroy -id N
+  list                      show the keys of -keyset
+  public -out F             write the public keyset
+  convert -out F            rewrite -keyset, e.g. JSON to binary
+  encrypt|decrypt           AEAD or deterministic AEAD, -in to -out
+  sign|verify -sig F        sign -in, or verify -in against -sig
+
+templates: %s
+
+flags:
+`, strings.Join(templateNames(), ", "))
+	flag.PrintDefaults()
+}
+
+func templateNames() []string {
+	var names []string
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func main() {
+	keysetPath := flag.String("keyset", "keyset.json", "keyset file")
+	format := flag.String("format", "", "keyset format for -out: json or binary (default: by extension)")
+	template := flag.String("template", "AES256_GCM", "key template")
+	id := flag.String("id", "", "key id")
+	ad := flag.String("ad", "", "associated data")
+	in := flag.String("in", "", "input file (default stdin)")
+	out := flag.String("out", "", "output file (default stdout)")
+	sigPath := flag.String("sig", "", "signature file")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+
+	kek, err := kekFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	save := func(ks *Keyset, path string) {
+		data, err := WriteKeyset(ks, formatFor(path, *format), kek, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeFile(path, data); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cmd == "generate" {
+		if _, err := os.Stat(*keysetPath); err == nil {
+			log.Fatalf("%s already exists", *keysetPath)
+		}
+		ks, err := NewKeyset(*template)
+		if err != nil {
+			log.Fatal(err)
+		}
+		save(ks, *keysetPath)
+		printKeyset(ks)
+		return
+	}
+
+	data, err := os.ReadFile(*keysetPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ks, err := ReadKeyset(data, kek, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyID := func() uint32 {
+		n, err := strconv.ParseUint(*id, 10, 32)
+		if err != nil {
+			log.Fatal("-id: ", err)
+		}
+		return uint32(n)
+	}
+	input := func() []byte {
+		b, err := readFile(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return b
+	}
+	output := func(b []byte, err error) {
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeFile(*out, b); err != nil {
+			log.Fatal(err)
+		}
+	}
+	deterministic := ks.key(ks.Primary).Data.TypeURL == aesSIVTypeURL
+
+	switch cmd {
+	case "add":
+		n, err := ks.Add(*template)
+		if err != nil {
+			log.Fatal(err)
+		}
+		save(ks, *keysetPath)
+		fmt.Println(n)
+	case "promote", "enable", "disable", "destroy":
+		switch cmd {
+		case "promote":
+			err = ks.Promote(keyID())
+		case "enable":
+			err = ks.SetStatus(keyID(), StatusEnabled)
+		case "disable":
+			err = ks.SetStatus(keyID(), StatusDisabled)
+		case "destroy":
+			err = ks.SetStatus(keyID(), StatusDestroyed)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		save(ks, *keysetPath)
+		printKeyset(ks)
+	case "list":
+		printKeyset(ks)
+	case "public":
+		pub, err := ks.Public()
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Public keysets are not secret and are always written in the clear.
+		b, err := WriteKeyset(pub, formatFor(*out, *format), nil, nil)
+		output(b, err)
+	case "convert":
+		save(ks, *out)
+	case "encrypt":
+		if deterministic {
+			d, err := NewDeterministicAEAD(ks)
+			if err != nil {
+				log.Fatal(err)
+			}
+			output(d.EncryptDeterministically(input(), []byte(*ad)))
+			return
+		}
+		a, err := NewAEAD(ks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		output(a.Encrypt(input(), []byte(*ad)))
+	case "decrypt":
+		if deterministic {
+			d, err := NewDeterministicAEAD(ks)
+			if err != nil {
+				log.Fatal(err)
+			}
+			output(d.DecryptDeterministically(input(), []byte(*ad)))
+			return
+		}
+		a, err := NewAEAD(ks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		output(a.Decrypt(input(), []byte(*ad)))
+	case "sign":
+		s, err := NewSigner(ks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*out = *sigPath
+		output(s.Sign(input()))
+	case "verify":
+		v, err := NewVerifier(ks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sig, err := os.ReadFile(*sigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := v.Verify(sig, input()); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("signature verified")
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+func demo() {
+	// RFC 5297 appendix A.1.
+	siv := must(newAESSIV(must(hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"))))
+	ct := must(siv.EncryptDeterministically(
+		must(hex.DecodeString("112233445566778899aabbccddee")),
+		must(hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627"))))
+	fmt.Println("AES-SIV RFC 5297 A.1:", hex.EncodeToString(ct) == "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c")
+
+	// AEAD with rotation.
+	ks := must(NewKeyset("AES256_GCM"))
+	a := must(NewAEAD(ks))
+	ad := []byte("orders")
+	old := must(a.Encrypt([]byte("order #1001"), ad))
+	fmt.Printf("\nAES256_GCM ciphertext prefix: %x (key %d)\n", old[:5], ks.Primary)
+	id := must(ks.Add("AES256_GCM_RAW"))
+	if err := ks.Promote(id); err != nil {
+		log.Fatal(err)
+	}
+	js := must(WriteKeyset(ks, FormatJSON, nil, nil))
+	ks = must(ReadKeyset(js, nil, nil))
+	a = must(NewAEAD(ks))
+	fresh := must(a.Encrypt([]byte("order #1002"), ad))
+	pt, err := a.Decrypt(old, ad)
+	fmt.Printf("after rotating to a RAW key: old ciphertext %q %v\n", pt, err)
+	// A RAW AES-GCM ciphertext is nonce || ciphertext || tag, as in aes/.
+	block := must(aes.NewCipher(must(parseBytesField(ks.key(id).Data.Value, 3))))
+	gcm := must(cipher.NewGCM(block))
+	pt, err = gcm.Open(nil, fresh[:gcm.NonceSize()], fresh[gcm.NonceSize():], ad)
+	fmt.Printf("new ciphertext opened as in aes/: %q %v\n", pt, err)
+	printKeyset(ks)
+	_, err = a.Decrypt(old, []byte("invoices"))
+	fmt.Println("wrong associated data:", err)
+
+	fmt.Println()
+	kek := must(newAESGCM(bytesOf(32)))
+	for _, format := range []string{FormatJSON, FormatBinary} {
+		enc := must(WriteKeyset(ks, format, kek, []byte("keyset:orders")))
+		back, err := ReadKeyset(enc, kek, []byte("keyset:orders"))
+		fmt.Printf("encrypted %s keyset, %d bytes: read back %v, %v\n", format, len(enc), err == nil && back.Primary == ks.Primary, err)
+		_, err = ReadKeyset(enc, must(newAESGCM(bytesOf(32))), []byte("keyset:orders"))
+		fmt.Printf("  wrong KEK: %v\n", err)
+		if format == FormatJSON {
+			_, err = ReadKeyset(enc, nil, nil)
+			fmt.Printf("  without KEK: %v\n", err)
+		}
+	}
+
+	// Deterministic AEAD.
+	dks := must(NewKeyset("AES256_SIV"))
+	d := must(NewDeterministicAEAD(dks))
+	c1 := must(d.EncryptDeterministically([]byte("alice@example.com"), []byte("email")))
+	c2 := must(d.EncryptDeterministically([]byte("alice@example.com"), []byte("email")))
+	pt, err = d.DecryptDeterministically(c1, []byte("email"))
+	fmt.Printf("\nAES256_SIV: equal ciphertexts %v, decrypts to %q %v\n", bytes.Equal(c1, c2), pt, err)
+	_, err = NewAEAD(dks)
+	fmt.Println("as AEAD:", err)
+
+	// Signatures, verified with the public keyset after a JSON round trip.
+	fmt.Println()
+	msg := []byte("release v2.4.0")
+	for _, name := range []string{"ECDSA_P256", "ECDSA_P256_RAW", "ECDSA_P384_SHA384", "ECDSA_P384_SHA512", "ED25519", "ED25519_RAW", "ED25519 (LEGACY)"} {
+		sks := must(NewKeyset(strings.TrimSuffix(name, " (LEGACY)")))
+		if strings.HasSuffix(name, "(LEGACY)") {
+			sks.Keys[0].Prefix = PrefixLegacy
+		}
+		sig := must(must(NewSigner(sks)).Sign(msg))
+		pub := must(ReadKeyset(must(WriteKeyset(must(sks.Public()), FormatJSON, nil, nil)), nil, nil))
+		v := must(NewVerifier(pub))
+		tampered := v.Verify(sig, []byte("release v2.4.1"))
+		fmt.Printf("%-18s %3d-byte signature: %v, other message: %v\n", name, len(sig), v.Verify(sig, msg) == nil, tampered)
+	}
+
+	// Keysets, a ciphertext and a signature written by Tink Go itself.
+	fmt.Println()
+	fixture := func(name string) []byte { return must(os.ReadFile(filepath.Join("testdata", name))) }
+	tks := must(ReadKeyset(fixture("aes256gcm.keyset.json"), nil, nil))
+	pt, err = must(NewAEAD(tks)).Decrypt(fixture("aes256gcm.ciphertext"), []byte("tink fixture"))
+	fmt.Printf("Tink AES256_GCM ciphertext: matches plaintext %v, %v\n", bytes.Equal(pt, fixture("aes256gcm.plaintext")), err)
+	tks = must(ReadKeyset(fixture("ecdsa_p256.keyset.json"), nil, nil))
+	tpub := must(ReadKeyset(fixture("ecdsa_p256.public.json"), nil, nil))
+	derived := must(tks.Public())
+	sig, tmsg := fixture("ecdsa_p256.signature"), fixture("ecdsa_p256.message")
+	fmt.Printf("Tink ECDSA_P256 signature: %v, with derived public keyset: %v, other message: %v\n",
+		must(NewVerifier(tpub)).Verify(sig, tmsg) == nil,
+		must(NewVerifier(derived)).Verify(sig, tmsg) == nil,
+		must(NewVerifier(tpub)).Verify(sig, []byte("something else")))
+	fmt.Println("derived public key matches Tink's:", bytes.Equal(derived.Keys[0].Data.Value, tpub.Keys[0].Data.Value))
+}
+
+func bytesOf(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Primitives over a whole keyset. Output from the primary key starts
+// with its prefix; on input, every enabled key whose prefix matches is
+// tried, followed by the RAW keys, so ciphertexts and signatures made
+// by Tink under any key of the keyset are accepted.
+
+type AEAD interface {
+	Encrypt(plaintext, associatedData []byte) ([]byte, error)
+	Decrypt(ciphertext, associatedData []byte) ([]byte, error)
+}
+
+type DeterministicAEAD interface {
+	EncryptDeterministically(plaintext, associatedData []byte) ([]byte, error)
+	DecryptDeterministically(ciphertext, associatedData []byte) ([]byte, error)
+}
+
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+type Verifier interface {
+	Verify(signature, data []byte) error
+}
+
+type entry[P any] struct {
+	key    *Key
+	prefix []byte
+	p      P
+}
+
+type primitiveSet[P any] struct {
+	primary *entry[P]
+	entries []*entry[P]
+}
+
+func newPrimitiveSet[P any](ks *Keyset, newRaw func(*KeyData) (P, error)) (*primitiveSet[P], error) {
+	if err := ks.Validate(); err != nil {
+		return nil, err
+	}
+	ps := &primitiveSet[P]{}
+	for _, k := range ks.Keys {
+		if k.Status != StatusEnabled {
+			continue
+		}
+		p, err := newRaw(k.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%w (key %d)", err, k.ID)
+		}
+		e := &entry[P]{key: k, prefix: k.prefix(), p: p}
+		ps.entries = append(ps.entries, e)
+		if k.ID == ks.Primary {
+			ps.primary = e
+		}
+	}
+	return ps, nil
+}
+
+// candidates lists the entries that may have produced data.
+func (ps *primitiveSet[P]) candidates(data []byte) []*entry[P] {
+	var matched, raw []*entry[P]
+	for _, e := range ps.entries {
+		switch {
+		case len(e.prefix) == 0:
+			raw = append(raw, e)
+		case bytes.HasPrefix(data, e.prefix):
+			matched = append(matched, e)
+		}
+	}
+	return append(matched, raw...)
+}
+
+func wrongType(d *KeyData) error {
+	return fmt.Errorf("%w: %s", ErrWrongPrimitive, d.TypeURL)
+}
+
+// AEAD
+
+type aesGCM struct {
+	gcm cipher.AEAD
+}
+
+// newAESGCM returns AES-GCM with a random 12-byte nonce prepended to
+// the ciphertext, the same layout as aes/.
+func newAESGCM(key []byte) (*aesGCM, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCM{gcm}, nil
+}
+
+func (a *aesGCM) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, a.gcm.NonceSize(), a.gcm.NonceSize()+len(plaintext)+a.gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return a.gcm.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func (a *aesGCM) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	n := a.gcm.NonceSize()
+	if len(ciphertext) < n+a.gcm.Overhead() {
+		return nil, ErrDecryption
+	}
+	return a.gcm.Open(nil, ciphertext[:n], ciphertext[n:], associatedData)
+}
+
+type keysetAEAD struct {
+	ps *primitiveSet[AEAD]
+}
+
+// NewAEAD returns an AEAD over the AesGcmKey keys of ks.
+func NewAEAD(ks *Keyset) (AEAD, error) {
+	ps, err := newPrimitiveSet(ks, func(d *KeyData) (AEAD, error) {
+		if d.TypeURL != aesGCMTypeURL {
+			return nil, wrongType(d)
+		}
+		key, err := parseBytesField(d.Value, 3)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 && len(key) != 32 {
+			return nil, errors.New("tink: AES-GCM key must be 16 or 32 bytes")
+		}
+		return newAESGCM(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &keysetAEAD{ps}, nil
+}
+
+func (a *keysetAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	ct, err := a.ps.primary.p.Encrypt(plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.Clone(a.ps.primary.prefix), ct...), nil
+}
+
+func (a *keysetAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	for _, e := range a.ps.candidates(ciphertext) {
+		if pt, err := e.p.Decrypt(ciphertext[len(e.prefix):], associatedData); err == nil {
+			return pt, nil
+		}
+	}
+	return nil, ErrDecryption
+}
+
+// Deterministic AEAD
+
+type keysetDAEAD struct {
+	ps *primitiveSet[DeterministicAEAD]
+}
+
+// NewDeterministicAEAD returns a deterministic AEAD over the AesSivKey
+// keys of ks.
+func NewDeterministicAEAD(ks *Keyset) (DeterministicAEAD, error) {
+	ps, err := newPrimitiveSet(ks, func(d *KeyData) (DeterministicAEAD, error) {
+		if d.TypeURL != aesSIVTypeURL {
+			return nil, wrongType(d)
+		}
+		key, err := parseBytesField(d.Value, 2)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 64 {
+			return nil, errors.New("tink: AES-SIV key must be 64 bytes")
+		}
+		return newAESSIV(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &keysetDAEAD{ps}, nil
+}
+
+func (a *keysetDAEAD) EncryptDeterministically(plaintext, associatedData []byte) ([]byte, error) {
+	ct, err := a.ps.primary.p.EncryptDeterministically(plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.Clone(a.ps.primary.prefix), ct...), nil
+}
+
+func (a *keysetDAEAD) DecryptDeterministically(ciphertext, associatedData []byte) ([]byte, error) {
+	for _, e := range a.ps.candidates(ciphertext) {
+		if pt, err := e.p.DecryptDeterministically(ciphertext[len(e.prefix):], associatedData); err == nil {
+			return pt, nil
+		}
+	}
+	return nil, ErrDecryption
+}
+
+// Signatures
+
+type ecdsaSigner struct {
+	key  *ecdsa.PrivateKey
+	hash crypto.Hash
+	der  bool
+}
+
+func (s *ecdsaSigner) Sign(data []byte) ([]byte, error) {
+	h := s.hash.New()
+	h.Write(data)
+	if s.der {
+		return ecdsa.SignASN1(rand.Reader, s.key, h.Sum(nil))
+	}
+	r, ss, err := ecdsa.Sign(rand.Reader, s.key, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	size := (s.key.Curve.Params().BitSize + 7) / 8
+	sig := make([]byte, 2*size)
+	r.FillBytes(sig[:size])
+	ss.FillBytes(sig[size:])
+	return sig, nil
+}
+
+type ecdsaVerifier struct {
+	pub  *ecdsa.PublicKey
+	hash crypto.Hash
+	der  bool
+}
+
+func (v *ecdsaVerifier) Verify(signature, data []byte) error {
+	h := v.hash.New()
+	h.Write(data)
+	var ok bool
+	if v.der {
+		ok = ecdsa.VerifyASN1(v.pub, h.Sum(nil), signature)
+	} else {
+		size := (v.pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			ok = ecdsa.Verify(v.pub, h.Sum(nil), r, s)
+		}
+	}
+	if !ok {
+		return ErrVerify
+	}
+	return nil
+}
+
+type ed25519Signer ed25519.PrivateKey
+
+func (s ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), data), nil
+}
+
+type ed25519Verifier ed25519.PublicKey
+
+func (v ed25519Verifier) Verify(signature, data []byte) error {
+	if !ed25519.Verify(ed25519.PublicKey(v), data, signature) {
+		return ErrVerify
+	}
+	return nil
+}
+
+// legacyData appends the zero byte LEGACY keys sign after the data.
+func legacyData(k *Key, data []byte) []byte {
+	if k.Prefix == PrefixLegacy {
+		return append(bytes.Clone(data), 0)
+	}
+	return data
+}
+
+type keysetSigner struct {
+	ps *primitiveSet[Signer]
+}
+
+// NewSigner returns a signer over the private keys of ks.
+func NewSigner(ks *Keyset) (Signer, error) {
+	ps, err := newPrimitiveSet(ks, func(d *KeyData) (Signer, error) {
+		switch d.TypeURL {
+		case ecdsaPrivateTypeURL:
+			key, hash, der, err := parseECDSAPrivateKey(d.Value)
+			if err != nil {
+				return nil, err
+			}
+			return &ecdsaSigner{key, hash, der}, nil
+		case ed25519PrivateTypeURL:
+			key, err := parseEd25519PrivateKey(d.Value)
+			if err != nil {
+				return nil, err
+			}
+			return ed25519Signer(key), nil
+		}
+		return nil, wrongType(d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &keysetSigner{ps}, nil
+}
+
+func (s *keysetSigner) Sign(data []byte) ([]byte, error) {
+	e := s.ps.primary
+	sig, err := e.p.Sign(legacyData(e.key, data))
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.Clone(e.prefix), sig...), nil
+}
+
+type keysetVerifier struct {
+	ps *primitiveSet[Verifier]
+}
+
+// NewVerifier returns a verifier over the public keys of ks. A private
+// keyset is accepted and its public keys used.
+func NewVerifier(ks *Keyset) (Verifier, error) {
+	ps, err := newPrimitiveSet(ks, func(d *KeyData) (Verifier, error) {
+		if d.Material == MaterialAsymmetricPrivate {
+			pub, err := publicKeyData(d)
+			if err != nil {
+				return nil, err
+			}
+			d = pub
+		}
+		switch d.TypeURL {
+		case ecdsaPublicTypeURL:
+			pub, hash, der, err := parseECDSAPublicKey(d.Value)
+			if err != nil {
+				return nil, err
+			}
+			return &ecdsaVerifier{pub, hash, der}, nil
+		case ed25519PublicTypeURL:
+			pub, err := parseEd25519PublicKey(d.Value)
+			if err != nil {
+				return nil, err
+			}
+			return ed25519Verifier(pub), nil
+		}
+		return nil, wrongType(d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &keysetVerifier{ps}, nil
+}
+
+func (v *keysetVerifier) Verify(signature, data []byte) error {
+	for _, e := range v.ps.candidates(signature) {
+		if e.p.Verify(signature[len(e.prefix):], legacyData(e.key, data)) == nil {
+			return nil
+		}
+	}
+	return ErrVerify
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Just enough of the protobuf wire format for Tink's keyset and key
+// messages: varints and length-delimited fields. Unknown fields are
+// skipped, so keysets written by newer Tink versions still parse.
+
+var errProto = errors.New("tink: malformed protobuf")
+
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+type pbWriter struct {
+	b []byte
+}
+
+func (w *pbWriter) tag(field, wire int) {
+	w.b = binary.AppendUvarint(w.b, uint64(field<<3|wire))
+}
+
+// uint writes a varint field; zero values are omitted as in proto3.
+func (w *pbWriter) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.tag(field, wireVarint)
+	w.b = binary.AppendUvarint(w.b, v)
+}
+
+func (w *pbWriter) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	w.tag(field, wireBytes)
+	w.b = binary.AppendUvarint(w.b, uint64(len(b)))
+	w.b = append(w.b, b...)
+}
+
+func (w *pbWriter) string(field int, s string) { w.bytes(field, []byte(s)) }
+
+// message writes the fields written by f as an embedded message.
+func (w *pbWriter) message(field int, f func(w *pbWriter)) {
+	var inner pbWriter
+	f(&inner)
+	w.tag(field, wireBytes)
+	w.b = binary.AppendUvarint(w.b, uint64(len(inner.b)))
+	w.b = append(w.b, inner.b...)
+}
+
+func marshalProto(f func(w *pbWriter)) []byte {
+	var w pbWriter
+	f(&w)
+	return w.b
+}
+
+// pbField is one decoded field; v holds a varint, b a length-delimited
+// value.
+type pbField struct {
+	num int
+	v   uint64
+	b   []byte
+}
+
+// parseProto calls f for every varint and length-delimited field of b.
+func parseProto(b []byte, f func(pbField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 || key>>3 == 0 {
+			return errProto
+		}
+		b = b[n:]
+		field := pbField{num: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			field.v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errProto
+			}
+			b = b[n:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errProto
+			}
+			field.b, b = b[n:n+int(l)], b[n+int(l):]
+		case wireI64:
+			if len(b) < 8 {
+				return errProto
+			}
+			b = b[8:]
+			continue
+		case wireI32:
+			if len(b) < 4 {
+				return errProto
+			}
+			b = b[4:]
+			continue
+		default:
+			return errProto
+		}
+		if err := f(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// AES-SIV (RFC 5297), the deterministic AEAD behind Tink's AesSivKey.
+// The key splits into a CMAC key for S2V and a CTR key; Tink only uses
+// 64-byte keys. The output is the synthetic IV followed by the
+// ciphertext.
+
+func dbl(b *[16]byte) {
+	carry := b[0] >> 7
+	for i := 0; i < 15; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[15] = b[15]<<1 ^ carry*0x87
+}
+
+// cmac is AES-CMAC (RFC 4493).
+func cmac(block cipher.Block, msg []byte) [16]byte {
+	var k1, k2 [16]byte
+	block.Encrypt(k1[:], k1[:])
+	dbl(&k1)
+	k2 = k1
+	dbl(&k2)
+
+	var x [16]byte
+	for len(msg) > 16 {
+		subtle.XORBytes(x[:], x[:], msg[:16])
+		block.Encrypt(x[:], x[:])
+		msg = msg[16:]
+	}
+	var last [16]byte
+	if len(msg) == 16 {
+		subtle.XORBytes(last[:], msg, k1[:])
+	} else {
+		copy(last[:], msg)
+		last[len(msg)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+func s2v(block cipher.Block, strs ...[]byte) [16]byte {
+	var zero [16]byte
+	d := cmac(block, zero[:])
+	for _, s := range strs[:len(strs)-1] {
+		dbl(&d)
+		m := cmac(block, s)
+		subtle.XORBytes(d[:], d[:], m[:])
+	}
+	last := strs[len(strs)-1]
+	if len(last) >= 16 {
+		t := append([]byte(nil), last...)
+		subtle.XORBytes(t[len(t)-16:], t[len(t)-16:], d[:])
+		return cmac(block, t)
+	}
+	dbl(&d)
+	var t [16]byte
+	copy(t[:], last)
+	t[len(last)] = 0x80
+	subtle.XORBytes(d[:], d[:], t[:])
+	return cmac(block, d[:])
+}
+
+type aesSIV struct {
+	mac, ctr cipher.Block
+}
+
+func newAESSIV(key []byte) (*aesSIV, error) {
+	if len(key) != 32 && len(key) != 48 && len(key) != 64 {
+		return nil, errors.New("tink: invalid AES-SIV key size")
+	}
+	mac, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &aesSIV{mac, ctr}, nil
+}
+
+func (s *aesSIV) xorKeyStream(dst, src []byte, v [16]byte) {
+	// Bits 31 and 63 of the counter are cleared so that implementations
+	// can use 32-bit counter arithmetic.
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+func (s *aesSIV) EncryptDeterministically(plaintext, associatedData []byte) ([]byte, error) {
+	v := s2v(s.mac, associatedData, plaintext)
+	out := make([]byte, 16+len(plaintext))
+	copy(out, v[:])
+	s.xorKeyStream(out[16:], plaintext, v)
+	return out, nil
+}
+
+func (s *aesSIV) DecryptDeterministically(ciphertext, associatedData []byte) ([]byte, error) {
+	if len(ciphertext) < 16 {
+		return nil, ErrDecryption
+	}
+	var v [16]byte
+	copy(v[:], ciphertext)
+	plaintext := make([]byte, len(ciphertext)-16)
+	s.xorKeyStream(plaintext, ciphertext[16:], v)
+	want := s2v(s.mac, associatedData, plaintext)
+	if subtle.ConstantTimeCompare(v[:], want[:]) != 1 {
+		clear(plaintext)
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
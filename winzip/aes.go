@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// WinZip AES encryption (https://www.winzip.com/en/support/aes-encryption/).
+// An encrypted entry has compression method 99 and an extra field that
+// records the real method:
+//
+//	0x9901, size 7: vendor version (1 = AE-1, 2 = AE-2), "AE",
+//	strength (1-3 = AES-128/192/256), actual compression method
+//
+// Its data is salt || password verifier (2) || ciphertext || MAC (10).
+// Keys come from PBKDF2-HMAC-SHA1 with 1000 iterations; the data is
+// encrypted with AES in CTR mode using a little-endian counter starting
+// at 1 and authenticated with HMAC-SHA1 over the ciphertext. AE-1 also
+// stores the plaintext CRC-32; AE-2 stores zero because a CRC of a short
+// file can give its contents away.
+const (
+	methodAES   = 99
+	extraAES    = 0x9901
+	vendorAE1   = 1
+	vendorAE2   = 2
+	strength256 = 3
+
+	pbkdf2Iter  = 1000
+	verifierLen = 2
+	macLen      = 10
+)
+
+var (
+	ErrPassword = errors.New("winzip: incorrect password")
+	ErrAuth     = errors.New("winzip: authentication failed, data is corrupt or was modified")
+	ErrChecksum = errors.New("winzip: CRC-32 mismatch")
+)
+
+// aesExtra is the 0x9901 extra field.
+type aesExtra struct {
+	version  uint16
+	strength byte
+	method   uint16
+}
+
+func (e aesExtra) keyLen() int  { return 8 + 8*int(e.strength) }
+func (e aesExtra) saltLen() int { return 4 + 4*int(e.strength) }
+
+func (e aesExtra) marshal() []byte {
+	b := make([]byte, 11)
+	binary.LittleEndian.PutUint16(b, extraAES)
+	binary.LittleEndian.PutUint16(b[2:], 7)
+	binary.LittleEndian.PutUint16(b[4:], e.version)
+	copy(b[6:], "AE")
+	b[8] = e.strength
+	binary.LittleEndian.PutUint16(b[9:], e.method)
+	return b
+}
+
+// findAESExtra looks for the 0x9901 field among an entry's extra fields.
+func findAESExtra(extra []byte) (aesExtra, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		data := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != extraAES {
+			continue
+		}
+		if size != 7 || string(data[2:4]) != "AE" {
+			return aesExtra{}, errors.New("winzip: malformed AES extra field")
+		}
+		e := aesExtra{
+			version:  binary.LittleEndian.Uint16(data),
+			strength: data[4],
+			method:   binary.LittleEndian.Uint16(data[5:]),
+		}
+		if e.version != vendorAE1 && e.version != vendorAE2 {
+			return aesExtra{}, fmt.Errorf("winzip: unknown AE version %d", e.version)
+		}
+		if e.strength < 1 || e.strength > 3 {
+			return aesExtra{}, fmt.Errorf("winzip: unknown AES strength %d", e.strength)
+		}
+		return e, nil
+	}
+	return aesExtra{}, errors.New("winzip: method 99 entry has no AES extra field")
+}
+
+// deriveKeys returns the AES key, the HMAC key and the password
+// verifier for password and salt.
+func deriveKeys(password string, salt []byte, keyLen int) (encKey, macKey, verifier []byte, err error) {
+	dk, err := pbkdf2.Key(sha1.New, password, salt, pbkdf2Iter, 2*keyLen+verifierLen)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return dk[:keyLen], dk[keyLen : 2*keyLen], dk[2*keyLen:], nil
+}
+
+// ctrLE is CTR mode with the little-endian counter WinZip uses, which
+// cipher.NewCTR's big-endian increment cannot express.
+type ctrLE struct {
+	b       cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newCTRLE(key []byte) (*ctrLE, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ctrLE{b: b, used: aes.BlockSize}, nil
+}
+
+func (c *ctrLE) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.b.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// checkMAC compares the truncated HMAC-SHA1 in constant time.
+func checkMAC(sum, stored []byte) error {
+	if !hmac.Equal(sum[:macLen], stored) {
+		return ErrAuth
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: winzip [flags] <command> [flags] archive [files]
+
+commands:
+  create archive files...   write an AES-256 encrypted archive
+  extract archive           decrypt all entries into -dir
+  list archive              print the entries and their encryption
+
+The password is taken from -password or $CRYPTO_ZIP_PASSWORD. Without a
+command, a demo runs.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	password := flag.String("password", os.Getenv("CRYPTO_ZIP_PASSWORD"), "archive password")
+	dir := flag.String("dir", ".", "directory to extract into")
+	store := flag.Bool("store", false, "store entries without compression")
+	ae1 := flag.Bool("ae1", false, "write AE-1 entries, which keep the CRC-32")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		demo()
+		return
+	}
+	// Flags may also follow the command.
+	cmd := flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	switch cmd {
+	case "create":
+		if *password == "" {
+			log.Fatal("a password is required")
+		}
+		method := uint16(zip.Deflate)
+		if *store {
+			method = zip.Store
+		}
+		if err := create(args[0], args[1:], *password, method, *ae1); err != nil {
+			log.Fatal(err)
+		}
+	case "extract":
+		if err := extract(args[0], *dir, *password); err != nil {
+			log.Fatal(err)
+		}
+	case "list":
+		zr, err := zip.OpenReader(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			enc := "-"
+			if Encrypted(f) {
+				if x, err := findAESExtra(f.Extra); err == nil {
+					enc = fmt.Sprintf("AE-%d AES-%d", x.version, 8*x.keyLen())
+				}
+			} else if f.Flags&0x1 != 0 {
+				enc = "ZipCrypto (unsupported)"
+			}
+			fmt.Printf("%10d  %s  %-22s %s\n", f.UncompressedSize64, f.Modified.Format("2006-01-02 15:04"), enc, f.Name)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func create(archive string, files []string, password string, method uint16, ae1 bool) error {
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := NewWriter(out, password)
+	w.AE1 = ae1
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ew, err := w.Create(filepath.ToSlash(filepath.Base(path)), method, info.ModTime())
+		if err != nil {
+			return err
+		}
+		if _, err := ew.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func extract(archive, dir, password string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		// Reject names that would land outside dir.
+		name := filepath.FromSlash(f.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("%s: unsafe path in archive", f.Name)
+		}
+		rc, err := Open(f, password)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
+
+// testdataPath names a file in the testdata directory next to this
+// source file, so the demo finds it from any working directory.
+func testdataPath(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata", name)
+}
+
+func demo() {
+	report := []byte(strings.Repeat("quarterly figures, confidential\n", 200))
+	note := []byte("hi")
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var archive bytes.Buffer
+	w := NewWriter(&archive, "correct horse")
+	for _, e := range []struct {
+		name   string
+		method uint16
+		data   []byte
+	}{{"report.txt", zip.Deflate, report}, {"note.txt", zip.Store, note}} {
+		ew, err := w.Create(e.name, e.method, modified)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ew.Write(e.data)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("archive: %d bytes for %d bytes of content\n", archive.Len(), len(report)+len(note))
+	fmt.Println("plaintext visible in archive:", bytes.Contains(archive.Bytes(), []byte("quarterly")) || bytes.Contains(archive.Bytes(), note))
+
+	read := func(data []byte, password string) error {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			rc, err := Open(f, password)
+			if err != nil {
+				return err
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			x, _ := findAESExtra(f.Extra)
+			fmt.Printf("  %-10s AE-%d method %d, crc %08x, %d bytes\n", f.Name, x.version, x.method, f.CRC32, len(got))
+		}
+		return nil
+	}
+	fmt.Println("read with the right password:")
+	if err := read(archive.Bytes(), "correct horse"); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("wrong password:", read(archive.Bytes(), "battery staple"))
+
+	tampered := bytes.Clone(archive.Bytes())
+	zr, _ := zip.NewReader(bytes.NewReader(tampered), int64(len(tampered)))
+	off, _ := zr.File[0].DataOffset()
+	tampered[off+40] ^= 1
+	err := read(tampered, "correct horse")
+	fmt.Println("flipped ciphertext bit:", err, errors.Is(err, ErrAuth))
+
+	var ae1 bytes.Buffer
+	w = NewWriter(&ae1, "correct horse")
+	w.AE1 = true
+	ew, _ := w.Create("report.txt", zip.Deflate, modified)
+	ew.Write(report)
+	w.Close()
+	fmt.Println("AE-1 archive:")
+	if err := read(ae1.Bytes(), "correct horse"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Archives written by bsdtar 3.7.7 (libarchive), from testdata/. The
+	// AE-2 archives from alexmullins/zip are read in winzip_test.go.
+	//
+	//	bsdtar --format zip --options zip:encryption=aes256 \
+	//		--passphrase 'correct horse' -cf aes256.zip hello.txt notes.txt
+	//	bsdtar --format zip --options zip:encryption=aes128,zip:compression=store \
+	//		--passphrase 'correct horse' -cf aes128-store.zip hello.txt notes.txt
+	fmt.Println("bsdtar archives:")
+	for _, name := range []string{"aes256.zip", "aes128-store.zip"} {
+		data, err := os.ReadFile(testdataPath(name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rc, err := Open(f, "correct horse")
+			if err != nil {
+				log.Fatal(err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				log.Fatalf("%s: %v", f.Name, err)
+			}
+			want, err := os.ReadFile(testdataPath(f.Name))
+			if err != nil {
+				log.Fatal(err)
+			}
+			x, _ := findAESExtra(f.Extra)
+			fmt.Printf("  %-16s %-10s AES-%d method %d, matches: %v\n", name, f.Name, 8*x.keyLen(), x.method, bytes.Equal(got, want))
+		}
+	}
+}
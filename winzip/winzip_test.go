@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// readAll decrypts every entry of the archive in data.
+func readAll(t *testing.T, data []byte, password string) (map[string][]byte, error) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		if !Encrypted(f) {
+			t.Fatalf("%s is not WinZip AES encrypted", f.Name)
+		}
+		rc, err := Open(f, password)
+		if err != nil {
+			return nil, err
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[f.Name] = got
+	}
+	return files, nil
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(testdataPath(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The bsdtar archives; see demo for how they were made.
+func TestBsdtarArchives(t *testing.T) {
+	for _, name := range []string{"aes256.zip", "aes128-store.zip"} {
+		files, err := readAll(t, readTestdata(t, name), "correct horse")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for fname, got := range files {
+			if want := readTestdata(t, fname); !bytes.Equal(got, want) {
+				t.Errorf("%s: %s differs from testdata/%s", name, fname, fname)
+			}
+		}
+	}
+}
+
+// AE-2 archives from the tests of github.com/alexmullins/zip (MIT, see
+// testdata/alexmullins-zip.LICENSE), with password "golang". They were
+// made on Windows: the entries carry NTFS times and version 6.3, as
+// 7-Zip writes them, though upstream does not name the tool. macbeth-act1
+// spans many AES blocks, which checks the little-endian counter.
+func TestAlexmullinsArchives(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string // name to expected content
+	}{
+		{"hello-aes.zip", map[string]string{"hello.txt": "Hello World\r\n"}},
+		{"world-aes.zip", map[string]string{"hello.txt": "hello", "world.txt": "world"}},
+	} {
+		files, err := readAll(t, readTestdata(t, tt.name), "golang")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(files) != len(tt.files) {
+			t.Errorf("%s: %d files, want %d", tt.name, len(files), len(tt.files))
+		}
+		for fname, want := range tt.files {
+			if got := string(files[fname]); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, fname, got, want)
+			}
+		}
+	}
+
+	files, err := readAll(t, readTestdata(t, "macbeth-act1.zip"), "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := files["macbeth-act1.txt"]
+	if len(got) != 23124 || !bytes.Contains(got, []byte("Exeunt")) {
+		t.Errorf("macbeth-act1.txt: %d bytes, want 23124 containing Exeunt", len(got))
+	}
+
+	if _, err := readAll(t, readTestdata(t, "hello-aes.zip"), "gopher"); !errors.Is(err, ErrPassword) {
+		t.Errorf("wrong password: got %v, want %v", err, ErrPassword)
+	}
+}
+
+// asAE1 turns the single-entry AE-2 archive data into AE-1 by setting
+// the vendor version in both AES extra fields and storing crc in both
+// headers, as the alexmullins/zip tests do. No AE-1 archive written by
+// another tool is checked in; this at least runs the AE-1 CRC check on
+// ciphertext this package did not produce.
+func asAE1(t *testing.T, data []byte, crc uint32) []byte {
+	t.Helper()
+	data = bytes.Clone(data)
+	ae2 := []byte{0x01, 0x99, 0x07, 0x00, 0x02, 0x00}
+	if bytes.Count(data, ae2) != 2 {
+		t.Fatal("expected one local and one central AES extra field")
+	}
+	data = bytes.ReplaceAll(data, ae2, []byte{0x01, 0x99, 0x07, 0x00, 0x01, 0x00})
+	local := bytes.Index(data, []byte("PK\x03\x04"))
+	central := bytes.Index(data, []byte("PK\x01\x02"))
+	if local != 0 || central < 0 {
+		t.Fatal("unexpected archive layout")
+	}
+	binary.LittleEndian.PutUint32(data[local+14:], crc)
+	binary.LittleEndian.PutUint32(data[central+16:], crc)
+	return data
+}
+
+func TestAE1FromExternalArchive(t *testing.T) {
+	data := readTestdata(t, "hello-aes.zip")
+	want := []byte("Hello World\r\n")
+
+	files, err := readAll(t, asAE1(t, data, crc32.ChecksumIEEE(want)), "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(files["hello.txt"], want) {
+		t.Errorf("AE-1 hello.txt = %q, want %q", files["hello.txt"], want)
+	}
+	if _, err := readAll(t, asAE1(t, data, crc32.ChecksumIEEE(want)+1), "golang"); !errors.Is(err, ErrChecksum) {
+		t.Errorf("AE-1 with a wrong CRC: got %v, want %v", err, ErrChecksum)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	report := bytes.Repeat([]byte("quarterly figures, confidential\n"), 200)
+	for _, ae1 := range []bool{false, true} {
+		var archive bytes.Buffer
+		w := NewWriter(&archive, "correct horse")
+		w.AE1 = ae1
+		for _, e := range []struct {
+			name   string
+			method uint16
+		}{{"deflate.txt", zip.Deflate}, {"store.txt", zip.Store}} {
+			ew, err := w.Create(e.name, e.method, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ew.Write(report)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(archive.Bytes(), []byte("quarterly")) {
+			t.Fatal("plaintext visible in archive")
+		}
+		files, err := readAll(t, archive.Bytes(), "correct horse")
+		if err != nil {
+			t.Fatalf("AE-1 %v: %v", ae1, err)
+		}
+		for name, got := range files {
+			if !bytes.Equal(got, report) {
+				t.Errorf("AE-1 %v: %s differs", ae1, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Writer writes a ZIP archive whose entries are encrypted with WinZip
+// AES-256. Each entry is compressed and encrypted into memory until it
+// is closed, since the local header needs its sizes.
+type Writer struct {
+	zw       *zip.Writer
+	password string
+	// AE1 selects AE-1, which keeps the CRC-32, for readers that require
+	// it. The default, AE-2, is what WinZip and 7-Zip recommend.
+	AE1  bool
+	last *entryWriter
+}
+
+func NewWriter(w io.Writer, password string) *Writer {
+	return &Writer{zw: zip.NewWriter(w), password: password}
+}
+
+type entryWriter struct {
+	fh        *zip.FileHeader
+	buf       bytes.Buffer
+	comp      io.WriteCloser
+	crc       hash.Hash32
+	size      uint64
+	ctr       *ctrLE
+	mac       hash.Hash
+	encWriter *cipherWriter
+	closed    bool
+}
+
+// cipherWriter encrypts into the entry buffer and feeds the MAC.
+type cipherWriter struct {
+	e *entryWriter
+}
+
+func (c *cipherWriter) Write(p []byte) (int, error) {
+	ct := make([]byte, len(p))
+	c.e.ctr.XORKeyStream(ct, p)
+	c.e.mac.Write(ct)
+	c.e.buf.Write(ct)
+	return len(p), nil
+}
+
+// Create adds an entry; method is zip.Deflate or zip.Store. The entry
+// must be written before the next call to Create or Close.
+func (w *Writer) Create(name string, method uint16, modified time.Time) (io.Writer, error) {
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	if method != zip.Store && method != zip.Deflate {
+		return nil, fmt.Errorf("winzip: unsupported compression method %d", method)
+	}
+	x := aesExtra{version: vendorAE2, strength: strength256, method: method}
+	if w.AE1 {
+		x.version = vendorAE1
+	}
+	salt := make([]byte, x.saltLen())
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	encKey, macKey, verifier, err := deriveKeys(w.password, salt, x.keyLen())
+	if err != nil {
+		return nil, err
+	}
+	e := &entryWriter{crc: crc32.NewIEEE(), mac: hmac.New(sha1.New, macKey)}
+	if e.ctr, err = newCTRLE(encKey); err != nil {
+		return nil, err
+	}
+	e.buf.Write(salt)
+	e.buf.Write(verifier)
+	e.encWriter = &cipherWriter{e}
+	if method == zip.Deflate {
+		e.comp, _ = flate.NewWriter(e.encWriter, flate.DefaultCompression)
+	} else {
+		e.comp = nopCloser{e.encWriter}
+	}
+	e.fh = &zip.FileHeader{
+		Name:           name,
+		Method:         methodAES,
+		Flags:          0x1, // encrypted
+		Modified:       modified,
+		ModifiedDate:   msdosDate(modified),
+		ModifiedTime:   msdosTime(modified),
+		Extra:          x.marshal(),
+		CreatorVersion: 51,
+		ReaderVersion:  51,
+	}
+	w.last = e
+	return e, nil
+}
+
+// CreateRaw, unlike CreateHeader, does not fill in the MS-DOS time
+// fields from Modified.
+func msdosDate(t time.Time) uint16 {
+	return uint16(t.Day() + int(t.Month())<<5 + max(t.Year()-1980, 0)<<9)
+}
+
+func msdosTime(t time.Time) uint16 {
+	return uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func (e *entryWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("winzip: write to closed entry")
+	}
+	e.crc.Write(p)
+	e.size += uint64(len(p))
+	return e.comp.Write(p)
+}
+
+// flush finishes the pending entry and writes it to the archive.
+func (w *Writer) flush() error {
+	e := w.last
+	if e == nil {
+		return nil
+	}
+	w.last = nil
+	e.closed = true
+	if err := e.comp.Close(); err != nil {
+		return err
+	}
+	e.buf.Write(e.mac.Sum(nil)[:macLen])
+	e.fh.UncompressedSize64 = e.size
+	e.fh.CompressedSize64 = uint64(e.buf.Len())
+	if w.AE1 {
+		e.fh.CRC32 = e.crc.Sum32()
+	}
+	out, err := w.zw.CreateRaw(e.fh)
+	if err != nil {
+		return err
+	}
+	_, err = e.buf.WriteTo(out)
+	return err
+}
+
+func (w *Writer) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// Encrypted reports whether f uses WinZip AES.
+func Encrypted(f *zip.File) bool { return f.Method == methodAES }
+
+// Open returns the decrypted, decompressed contents of f. Unencrypted
+// entries are opened normally. The whole entry is authenticated before
+// any plaintext is returned, so a wrong MAC fails Open rather than a
+// later Read.
+func Open(f *zip.File, password string) (io.ReadCloser, error) {
+	if f.Flags&0x1 == 0 {
+		return f.Open()
+	}
+	if !Encrypted(f) {
+		return nil, fmt.Errorf("%s: only WinZip AES encryption is supported", f.Name)
+	}
+	x, err := findAESExtra(f.Extra)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name, err)
+	}
+	overhead := uint64(x.saltLen() + verifierLen + macLen)
+	if f.CompressedSize64 < overhead {
+		return nil, fmt.Errorf("%s: %w", f.Name, ErrAuth)
+	}
+	r, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := r.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("winzip: archive does not support random access")
+	}
+	hdr := make([]byte, x.saltLen()+verifierLen)
+	if _, err := raw.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	encKey, macKey, verifier, err := deriveKeys(password, hdr[:x.saltLen()], x.keyLen())
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(verifier, hdr[x.saltLen():]) {
+		return nil, fmt.Errorf("%s: %w", f.Name, ErrPassword)
+	}
+
+	dataLen := int64(f.CompressedSize64 - overhead)
+	start := int64(len(hdr))
+	mac := hmac.New(sha1.New, macKey)
+	if _, err := io.Copy(mac, io.NewSectionReader(raw, start, dataLen)); err != nil {
+		return nil, err
+	}
+	stored := make([]byte, macLen)
+	if _, err := raw.ReadAt(stored, start+dataLen); err != nil {
+		return nil, err
+	}
+	if err := checkMAC(mac.Sum(nil), stored); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	ctr, err := newCTRLE(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plain := &decryptReader{r: io.NewSectionReader(raw, start, dataLen), ctr: ctr}
+	var rc io.ReadCloser
+	switch x.method {
+	case zip.Store:
+		rc = io.NopCloser(plain)
+	case zip.Deflate:
+		rc = flate.NewReader(plain)
+	default:
+		return nil, fmt.Errorf("%s: unsupported compression method %d", f.Name, x.method)
+	}
+	if x.version == vendorAE1 {
+		rc = &crcReader{rc: rc, crc: crc32.NewIEEE(), want: f.CRC32, size: f.UncompressedSize64}
+	}
+	return rc, nil
+}
+
+type decryptReader struct {
+	r   io.Reader
+	ctr *ctrLE
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.ctr.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// crcReader checks the AE-1 CRC-32 and size at EOF.
+type crcReader struct {
+	rc   io.ReadCloser
+	crc  hash.Hash32
+	want uint32
+	size uint64
+	n    uint64
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.crc.Write(p[:n])
+	c.n += uint64(n)
+	if err == io.EOF && (c.crc.Sum32() != c.want || c.n != c.size) {
+		err = ErrChecksum
+	}
+	return n, err
+}
+
+func (c *crcReader) Close() error { return c.rc.Close() }